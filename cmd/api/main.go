@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
@@ -12,12 +13,14 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"begbot/internal/api"
 	"begbot/internal/auth"
 	"begbot/internal/config"
 	db "begbot/internal/db"
 	"begbot/internal/models"
+	"begbot/internal/ruleexpr"
 	"begbot/internal/services"
 
 	"github.com/joho/godotenv"
@@ -43,6 +46,9 @@ type Server struct {
 	scheduler            *services.Scheduler
 	messagingService     *services.MessagingService
 	valuationService     *services.ValuationService
+	repricingService     *services.RepricingService
+	llmService           *services.LLMService
+	botService           *services.BotService
 }
 
 func main() {
@@ -58,6 +64,8 @@ func main() {
 		logger.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer database.Close()
+	database.UseCostModel(cfg.Costs)
+	database.UseExposureLimits(cfg.Exposure)
 
 	logger.Println("Running database migrations...")
 	if err := database.Migrate(); err != nil {
@@ -67,6 +75,7 @@ func main() {
 	marketplaceService := services.NewMarketplaceService(cfg)
 	cacheService := services.NewCacheService(cfg)
 	llmService := services.NewLLMService(cfg)
+	llmService.UseDatabase(database)
 	valuationService := services.NewValuationService(cfg, database, llmService)
 	botService := services.NewBotService(cfg, marketplaceService, cacheService, llmService, valuationService, database)
 	messagingService := services.NewMessagingService(cfg, database, llmService)
@@ -83,6 +92,9 @@ func main() {
 		scheduler:            scheduler,
 		messagingService:     messagingService,
 		valuationService:     valuationService,
+		repricingService:     services.NewRepricingService(cfg, database, valuationService),
+		llmService:           llmService,
+		botService:           botService,
 	}
 
 	// Initialize auth middleware
@@ -129,11 +141,28 @@ func main() {
 	mux.HandleFunc("/api/fetch-ads/logs/", server.fetchAdsLogsHandler)
 	mux.HandleFunc("/api/fetch-ads/cancel/", server.fetchAdsCancelHandler)
 	mux.HandleFunc("/api/valuation-types", server.valuationTypesHandler)
+	mux.Handle("/api/valuation-types/", authMiddleware.Middleware(http.HandlerFunc(server.valuationTypeItemHandler)))
+	mux.Handle("/api/repricing", authMiddleware.Middleware(http.HandlerFunc(server.repricingHandler)))
+	mux.Handle("/api/repricing/notify", authMiddleware.Middleware(http.HandlerFunc(server.repricingNotifyHandler)))
+	mux.Handle("/api/exposure", authMiddleware.Middleware(http.HandlerFunc(server.exposureHandler)))
+	mux.Handle("/api/valuation-cache", authMiddleware.Middleware(http.HandlerFunc(server.valuationCacheHandler)))
+	mux.Handle("/api/llm-usage", authMiddleware.Middleware(http.HandlerFunc(server.llmUsageHandler)))
+	mux.Handle("/api/llm-cache", authMiddleware.Middleware(http.HandlerFunc(server.llmCacheHandler)))
+	mux.Handle("/api/prompts", authMiddleware.Middleware(http.HandlerFunc(server.promptsHandler)))
+	mux.Handle("/api/prompts/active", authMiddleware.Middleware(http.HandlerFunc(server.promptActiveHandler)))
+	mux.Handle("/api/prompts/preview", authMiddleware.Middleware(http.HandlerFunc(server.promptPreviewHandler)))
+	mux.Handle("/api/review", authMiddleware.Middleware(http.HandlerFunc(server.reviewHandler)))
+	mux.Handle("/api/review/", authMiddleware.Middleware(http.HandlerFunc(server.reviewItemHandler)))
+	mux.Handle("/api/fx-rates", authMiddleware.Middleware(http.HandlerFunc(server.fxRatesHandler)))
 	mux.HandleFunc("/api/valuations", server.valuationsHandler)
 	mux.HandleFunc("/api/valuations/", server.valuationItemHandler)
 	mux.HandleFunc("/api/valuations/collect", server.collectValuationsHandler)
 	mux.HandleFunc("/api/valuations/compiled", server.compiledValuationsHandler)
 	mux.HandleFunc("/api/trading-rules", server.tradingRulesHandler)
+	mux.Handle("/api/trading-rules/test", authMiddleware.Middleware(http.HandlerFunc(server.tradingRulesTestHandler)))
+	mux.Handle("/api/trading-rules/variables", authMiddleware.Middleware(http.HandlerFunc(server.tradingRuleVariablesHandler)))
+	mux.Handle("/api/trading-rule-sets", authMiddleware.Middleware(http.HandlerFunc(server.tradingRuleSetsHandler)))
+	mux.Handle("/api/trading-rule-sets/", authMiddleware.Middleware(http.HandlerFunc(server.tradingRuleSetItemHandler)))
 	mux.HandleFunc("/api/conversations", server.conversationsHandler)
 	mux.HandleFunc("/api/conversations/", server.conversationItemHandler)
 	mux.HandleFunc("/api/messages", server.messagesHandler)
@@ -249,7 +278,7 @@ func (s *Server) getListings(w http.ResponseWriter, r *http.Request) {
 	var err error
 
 	if potentialOnly {
-		listings, err = s.db.GetPotentialListings(ctx)
+		listings, err = s.botService.PotentialListings(ctx)
 	} else {
 		listings, err = s.db.GetListingsWithProfit(ctx)
 	}
@@ -268,6 +297,17 @@ func (s *Server) getListings(w http.ResponseWriter, r *http.Request) {
 		}
 		listings = filtered
 	}
+	// Potential listings come ranked; sort=score ranks any listing by its
+	// opportunity score, unscored listings last.
+	if r.URL.Query().Get("sort") == "score" {
+		sort.SliceStable(listings, func(i, j int) bool {
+			si, sj := listings[i].Listing.OpportunityScore, listings[j].Listing.OpportunityScore
+			if si == nil || sj == nil {
+				return si != nil
+			}
+			return *si > *sj
+		})
+	}
 	logger.Printf("Returning %d listings", len(listings))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(listings)
@@ -311,6 +351,8 @@ func (s *Server) listingItemHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	switch r.Method {
+	case "GET":
+		s.getListing(w, r, id)
 	case "PUT":
 		var listing models.Listing
 		if err := json.NewDecoder(r.Body).Decode(&listing); err != nil {
@@ -328,7 +370,7 @@ func (s *Server) listingItemHandler(w http.ResponseWriter, r *http.Request) {
 	case "DELETE":
 		if err := s.db.DeleteListing(r.Context(), id); err != nil {
 			if err == sql.ErrNoRows {
-				api.WriteNotFound(w, "listing not found")
+				api.WriteNotFound(w, "Listing")
 				return
 			}
 			api.WriteServerError(w, err.Error())
@@ -338,9 +380,79 @@ func (s *Server) listingItemHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// getListing returns a listing together with the valuation snapshot taken
+// when it was scraped and the analysis of its photos.
+func (s *Server) getListing(w http.ResponseWriter, r *http.Request, id int64) {
+	ctx := r.Context()
+	listing, err := s.db.GetListingByID(ctx, id)
+	if err != nil {
+		api.WriteServerError(w, err.Error())
+		return
+	}
+	if listing == nil {
+		api.WriteNotFound(w, "Listing")
+		return
+	}
+
+	snapshot, err := s.db.GetListingValuation(ctx, id)
+	if err != nil {
+		api.WriteServerError(w, err.Error())
+		return
+	}
+
+	images, err := s.db.GetListingImageAnalysis(ctx, id)
+	if err != nil {
+		api.WriteServerError(w, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		*models.Listing
+		ValuationSnapshot *models.ListingValuation     `json:"valuation_snapshot"`
+		ImageAnalysis     *models.ListingImageAnalysis `json:"image_analysis"`
+	}{listing, snapshot, images})
+}
+
+// productMarketTrendHandler returns the market series and depreciation
+// model of a product. The optional days parameter adds a forecast of the
+// latest market value that many days ahead.
+func (s *Server) productMarketTrendHandler(w http.ResponseWriter, r *http.Request, id int64) {
+	if r.Method != "GET" {
+		api.WriteError(w, "Method not allowed", "METHOD_NOT_ALLOWED", 405)
+		return
+	}
+	if s.valuationService == nil {
+		api.WriteServerError(w, "valuation service not initialized")
+		return
+	}
+
+	trend, err := s.valuationService.MarketTrend(r.Context(), id)
+	if err != nil {
+		api.WriteServerError(w, err.Error())
+		return
+	}
+
+	response := map[string]interface{}{"trend": trend}
+	if daysStr := r.URL.Query().Get("days"); daysStr != "" {
+		days, err := strconv.Atoi(daysStr)
+		if err != nil || days < 0 {
+			api.WriteBadRequest(w, "invalid days")
+			return
+		}
+		if len(trend.Series) > 0 {
+			current := trend.Series[len(trend.Series)-1].Value
+			response["current_value"] = current
+			response["forecast_days"] = days
+			response["forecast_value"] = trend.Model.Forecast(current, days)
+		}
+	}
+	api.WriteSuccess(w, response)
+}
+
 func (s *Server) getProducts(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	rows, err := s.db.DB().QueryContext(ctx, `SELECT id, brand, name, category, model_variant, sell_packaging_cost, sell_postage_cost, new_price, enabled, created_at FROM products ORDER BY created_at DESC`)
+	rows, err := s.db.DB().QueryContext(ctx, `SELECT id, brand, name, category, model_variant, sell_packaging_cost, sell_postage_cost, new_price, enabled, valuation_strategy, created_at FROM products ORDER BY created_at DESC`)
 	if err != nil {
 		api.WriteServerError(w, err.Error())
 		return
@@ -350,7 +462,7 @@ func (s *Server) getProducts(w http.ResponseWriter, r *http.Request) {
 	var products []models.Product
 	for rows.Next() {
 		var p models.Product
-		var brand, name, category, modelVariant sql.NullString
+		var brand, name, category, modelVariant, valuationStrategy sql.NullString
 		var sellPackagingCost, sellPostageCost int
 		var newPrice sql.NullInt64
 		var enabled sql.NullBool
@@ -366,6 +478,7 @@ func (s *Server) getProducts(w http.ResponseWriter, r *http.Request) {
 			&sellPostageCost,
 			&newPrice,
 			&enabled,
+			&valuationStrategy,
 			&createdAt,
 		); err != nil {
 			api.WriteServerError(w, err.Error())
@@ -393,6 +506,9 @@ func (s *Server) getProducts(w http.ResponseWriter, r *http.Request) {
 		if enabled.Valid {
 			p.Enabled = &enabled.Bool
 		}
+		if valuationStrategy.Valid {
+			p.ValuationStrategy = &valuationStrategy.String
+		}
 		if createdAt.Valid {
 			p.CreatedAt = &createdAt.Time
 		}
@@ -448,6 +564,18 @@ func (s *Server) productItemHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Route: /api/products/{id}/market-trend
+	if strings.HasSuffix(pathSuffix, "/market-trend") {
+		idStr := strings.TrimSuffix(pathSuffix, "/market-trend")
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			api.WriteBadRequest(w, "Invalid ID")
+			return
+		}
+		s.productMarketTrendHandler(w, r, id)
+		return
+	}
+
 	idStr := pathSuffix
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
@@ -462,6 +590,10 @@ func (s *Server) productItemHandler(w http.ResponseWriter, r *http.Request) {
 			api.WriteValidationError(w, []api.ValidationError{{Field: "body", Message: err.Error()}})
 			return
 		}
+		if product.ValuationStrategy != nil && *product.ValuationStrategy != "" && !services.IsValidCompileStrategy(*product.ValuationStrategy) {
+			api.WriteValidationError(w, []api.ValidationError{{Field: "valuation_strategy", Message: "must be one of weighted, median, trimmed_mean, llm"}})
+			return
+		}
 		product.ID = id
 		if err := s.db.UpdateProduct(r.Context(), &product); err != nil {
 			api.WriteServerError(w, err.Error())
@@ -541,13 +673,8 @@ func (s *Server) tradingRulesHandler(w http.ResponseWriter, r *http.Request) {
 			api.WriteValidationError(w, []api.ValidationError{{Field: "body", Message: err.Error()}})
 			return
 		}
-		// Basic validation: non-negative values
-		if payload.MinProfitSEK != nil && *payload.MinProfitSEK < 0 {
-			api.WriteValidationError(w, []api.ValidationError{{Field: "min_profit_sek", Message: "must be non-negative"}})
-			return
-		}
-		if payload.MinDiscount != nil && *payload.MinDiscount < 0 {
-			api.WriteValidationError(w, []api.ValidationError{{Field: "min_discount", Message: "must be non-negative"}})
+		if errs := validateTradingRules(&payload); len(errs) > 0 {
+			api.WriteValidationError(w, errs)
 			return
 		}
 		if err := s.db.SaveTradingRules(r.Context(), &payload); err != nil {
@@ -567,6 +694,214 @@ func (s *Server) tradingRulesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// validateTradingRules checks the thresholds and the expression of a
+// trading rule set. A blank expression is no expression.
+func validateTradingRules(rules *models.Economics) []api.ValidationError {
+	var errs []api.ValidationError
+	if rules.Expression != nil {
+		if expression := strings.TrimSpace(*rules.Expression); expression == "" {
+			rules.Expression = nil
+		} else if _, err := ruleexpr.Compile(expression); err != nil {
+			errs = append(errs, api.ValidationError{Field: "expression", Message: err.Error()})
+		} else {
+			rules.Expression = &expression
+		}
+	}
+	if rules.MinProfitSEK != nil && *rules.MinProfitSEK < 0 {
+		errs = append(errs, api.ValidationError{Field: "min_profit_sek", Message: "must be non-negative"})
+	}
+	if rules.MinDiscount != nil && *rules.MinDiscount < 0 {
+		errs = append(errs, api.ValidationError{Field: "min_discount", Message: "must be non-negative"})
+	}
+	if rules.ProfitPercentile != nil && (*rules.ProfitPercentile < 1 || *rules.ProfitPercentile > 99) {
+		errs = append(errs, api.ValidationError{Field: "profit_percentile", Message: "must be between 1 and 99"})
+	}
+	return errs
+}
+
+// tradingRuleTestRequest is an expression to try on a stored listing or on
+// facts given in the request.
+type tradingRuleTestRequest struct {
+	Expression string          `json:"expression"`
+	ListingID  int64           `json:"listing_id,omitempty"`
+	Facts      *ruleexpr.Facts `json:"facts,omitempty"`
+}
+
+type tradingRuleTestResult struct {
+	Passed bool           `json:"passed"`
+	Facts  ruleexpr.Facts `json:"facts"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// tradingRulesTestHandler evaluates an expression against a sample
+// listing, so a rule can be tried before it is saved. A stored listing is
+// given the facts the bot would check it with.
+func (s *Server) tradingRulesTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		api.WriteError(w, "Method not allowed", "METHOD_NOT_ALLOWED", 405)
+		return
+	}
+	var req tradingRuleTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteValidationError(w, []api.ValidationError{{Field: "body", Message: err.Error()}})
+		return
+	}
+	program, err := ruleexpr.Compile(req.Expression)
+	if err != nil {
+		api.WriteValidationError(w, []api.ValidationError{{Field: "expression", Message: err.Error()}})
+		return
+	}
+
+	var facts ruleexpr.Facts
+	switch {
+	case req.Facts != nil:
+		facts = *req.Facts
+	case req.ListingID > 0:
+		ctx := r.Context()
+		listing, err := s.db.GetListingByID(ctx, req.ListingID)
+		if err != nil {
+			api.WriteServerError(w, err.Error())
+			return
+		}
+		if listing == nil {
+			api.WriteNotFound(w, "Listing")
+			return
+		}
+		listingFacts, err := s.botService.TradingRuleFacts(ctx, listing)
+		if err != nil {
+			api.WriteValidationError(w, []api.ValidationError{{Field: "listing_id", Message: err.Error()}})
+			return
+		}
+		facts = *listingFacts
+	default:
+		api.WriteValidationError(w, []api.ValidationError{{Field: "listing_id", Message: "listing_id or facts is required"}})
+		return
+	}
+
+	result := tradingRuleTestResult{Facts: facts}
+	if result.Passed, err = program.Eval(facts.Env()); err != nil {
+		result.Error = err.Error()
+	}
+	api.WriteSuccess(w, result)
+}
+
+// tradingRuleVariablesHandler lists the variables expressions can use.
+func (s *Server) tradingRuleVariablesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		api.WriteError(w, "Method not allowed", "METHOD_NOT_ALLOWED", 405)
+		return
+	}
+	api.WriteSuccess(w, ruleexpr.Variables)
+}
+
+// validateTradingRuleSet also checks the scope of a rule set. A blank
+// category is no scope.
+func validateTradingRuleSet(rules *models.Economics) []api.ValidationError {
+	if rules.Category != nil {
+		if category := strings.TrimSpace(*rules.Category); category == "" {
+			rules.Category = nil
+		} else {
+			rules.Category = &category
+		}
+	}
+	errs := validateTradingRules(rules)
+	for field, id := range map[string]*int64{"product_id": rules.ProductID, "marketplace_id": rules.MarketplaceID, "search_term_id": rules.SearchTermID} {
+		if id != nil && *id <= 0 {
+			errs = append(errs, api.ValidationError{Field: field, Message: "must be positive"})
+		}
+	}
+	return errs
+}
+
+// tradingRuleSetsHandler lists and creates trading rule sets. The most
+// specific matching set applies to a listing, priority deciding between
+// equally specific sets; sets without a scope are the global default.
+func (s *Server) tradingRuleSetsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		sets, err := s.db.GetTradingRuleSets(r.Context())
+		if err != nil {
+			api.WriteServerError(w, err.Error())
+			return
+		}
+		if sets == nil {
+			sets = []models.Economics{}
+		}
+		api.WriteSuccess(w, sets)
+	case "POST":
+		var payload models.Economics
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			api.WriteValidationError(w, []api.ValidationError{{Field: "body", Message: err.Error()}})
+			return
+		}
+		if errs := validateTradingRuleSet(&payload); len(errs) > 0 {
+			api.WriteValidationError(w, errs)
+			return
+		}
+		if err := s.db.CreateTradingRuleSet(r.Context(), &payload); err != nil {
+			api.WriteServerError(w, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(payload)
+	default:
+		api.WriteError(w, "Method not allowed", "METHOD_NOT_ALLOWED", 405)
+	}
+}
+
+func (s *Server) tradingRuleSetItemHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/trading-rule-sets/"), 10, 64)
+	if err != nil {
+		api.WriteBadRequest(w, "Invalid ID")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		rules, err := s.db.GetTradingRuleSet(r.Context(), id)
+		if err != nil {
+			api.WriteServerError(w, err.Error())
+			return
+		}
+		if rules == nil {
+			api.WriteNotFound(w, "Trading rule set")
+			return
+		}
+		api.WriteSuccess(w, rules)
+	case "PUT":
+		var payload models.Economics
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			api.WriteValidationError(w, []api.ValidationError{{Field: "body", Message: err.Error()}})
+			return
+		}
+		if errs := validateTradingRuleSet(&payload); len(errs) > 0 {
+			api.WriteValidationError(w, errs)
+			return
+		}
+		payload.ID = id
+		if err := s.db.UpdateTradingRuleSet(r.Context(), &payload); err == sql.ErrNoRows {
+			api.WriteNotFound(w, "Trading rule set")
+			return
+		} else if err != nil {
+			api.WriteServerError(w, err.Error())
+			return
+		}
+		api.WriteSuccess(w, payload)
+	case "DELETE":
+		if err := s.db.DeleteTradingRuleSet(r.Context(), id); err == sql.ErrNoRows {
+			api.WriteNotFound(w, "Trading rule set")
+			return
+		} else if err != nil {
+			api.WriteServerError(w, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		api.WriteError(w, "Method not allowed", "METHOD_NOT_ALLOWED", 405)
+	}
+}
+
 func (s *Server) getTransactions(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	rows, err := s.db.DB().QueryContext(ctx, `SELECT id, date, amount, transaction_type FROM transactions ORDER BY date DESC`)
@@ -648,7 +983,7 @@ func (s *Server) getTransactionTypes(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) getMarketplaces(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	rows, err := s.db.DB().QueryContext(ctx, `SELECT id, name, link FROM marketplaces`)
+	rows, err := s.db.DB().QueryContext(ctx, `SELECT id, name, link, currency FROM marketplaces`)
 	if err != nil {
 		api.WriteServerError(w, err.Error())
 		return
@@ -658,7 +993,7 @@ func (s *Server) getMarketplaces(w http.ResponseWriter, r *http.Request) {
 	var marketplaces []models.Marketplace
 	for rows.Next() {
 		var m models.Marketplace
-		if err := rows.Scan(&m.ID, &m.Name, &m.Link); err != nil {
+		if err := rows.Scan(&m.ID, &m.Name, &m.Link, &m.Currency); err != nil {
 			api.WriteServerError(w, err.Error())
 			return
 		}
@@ -1054,6 +1389,7 @@ func (s *Server) fetchAdsHandlerWithConfig(w http.ResponseWriter, r *http.Reques
 		marketplaceService := services.NewMarketplaceService(cfg)
 		cacheService := services.NewCacheService(cfg)
 		llmService := services.NewLLMService(cfg)
+		llmService.UseDatabase(s.db)
 		valuationService := services.NewValuationService(cfg, s.db, llmService)
 		botService := services.NewBotServiceWithJob(cfg, marketplaceService, cacheService, llmService, valuationService, s.db, s.jobService, jobID)
 
@@ -1231,6 +1567,394 @@ func (s *Server) valuationTypesHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(types)
 }
 
+func (s *Server) valuationTypeItemHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := r.URL.Path[len("/api/valuation-types/"):]
+	id, err := strconv.ParseInt(idStr, 10, 16)
+	if err != nil {
+		api.WriteBadRequest(w, "Invalid ID")
+		return
+	}
+
+	switch r.Method {
+	case "PUT":
+		var req struct {
+			Enabled bool            `json:"enabled"`
+			Config  json.RawMessage `json:"config"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			api.WriteValidationError(w, []api.ValidationError{{Field: "body", Message: err.Error()}})
+			return
+		}
+		if _, err := services.ParseValuationTypeConfig(req.Config); err != nil {
+			api.WriteValidationError(w, []api.ValidationError{{Field: "config", Message: err.Error()}})
+			return
+		}
+		if err := s.db.UpdateValuationType(r.Context(), int16(id), req.Enabled, req.Config); err != nil {
+			if err == sql.ErrNoRows {
+				api.WriteNotFound(w, "Valuation type")
+				return
+			}
+			api.WriteServerError(w, err.Error())
+			return
+		}
+		if s.valuationService != nil {
+			s.valuationService.ReloadRegistry()
+		}
+		api.WriteSuccess(w, map[string]interface{}{"id": id, "enabled": req.Enabled})
+	default:
+		api.WriteError(w, "Method not allowed", "METHOD_NOT_ALLOWED", 405)
+	}
+}
+
+// repricingHandler returns price suggestions for my listings and for items
+// in stock. With due=true only suggestions that change the price are
+// returned.
+func (s *Server) repricingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		api.WriteError(w, "Method not allowed", "METHOD_NOT_ALLOWED", 405)
+		return
+	}
+	suggestions, err := s.repricingService.Suggestions(r.Context())
+	if err != nil {
+		api.WriteServerError(w, err.Error())
+		return
+	}
+	if r.URL.Query().Get("due") == "true" {
+		due := make([]services.PriceSuggestion, 0, len(suggestions))
+		for _, p := range suggestions {
+			if p.NeedsChange() {
+				due = append(due, p)
+			}
+		}
+		suggestions = due
+	}
+	if suggestions == nil {
+		suggestions = []services.PriceSuggestion{}
+	}
+	api.WriteSuccess(w, suggestions)
+}
+
+// repricingNotifyHandler emails the suggestions that are due now.
+func (s *Server) repricingNotifyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		api.WriteError(w, "Method not allowed", "METHOD_NOT_ALLOWED", 405)
+		return
+	}
+	sent, err := s.repricingService.NotifyDue(r.Context())
+	if err != nil {
+		api.WriteServerError(w, err.Error())
+		return
+	}
+	api.WriteSuccess(w, map[string]interface{}{"notified": sent})
+}
+
+// exposureHandler returns the capital and stock tied up in unsold items and
+// today's spend, with the limits and what is left under them.
+func (s *Server) exposureHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		api.WriteError(w, "Method not allowed", "METHOD_NOT_ALLOWED", 405)
+		return
+	}
+	exposure, err := s.db.GetExposure(r.Context())
+	if err != nil {
+		api.WriteServerError(w, err.Error())
+		return
+	}
+	api.WriteSuccess(w, exposure)
+}
+
+// valuationCacheHandler invalidates cached valuation source responses. The
+// optional method and key query parameters narrow what is removed.
+func (s *Server) valuationCacheHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		api.WriteError(w, "Method not allowed", "METHOD_NOT_ALLOWED", 405)
+		return
+	}
+	if s.valuationService == nil {
+		api.WriteServerError(w, "valuation service not initialized")
+		return
+	}
+	methodKey := r.URL.Query().Get("method")
+	key := r.URL.Query().Get("key")
+	removed, err := s.valuationService.InvalidateSourceCache(r.Context(), methodKey, key)
+	if err != nil {
+		api.WriteServerError(w, err.Error())
+		return
+	}
+	api.WriteSuccess(w, map[string]interface{}{"removed": removed})
+}
+
+// llmUsageHandler reports LLM calls and cost grouped by run, day, function
+// or model over the last days (default 30).
+func (s *Server) llmUsageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		api.WriteError(w, "Method not allowed", "METHOD_NOT_ALLOWED", 405)
+		return
+	}
+	group := r.URL.Query().Get("group")
+	switch group {
+	case "":
+		group = "day"
+	case "day", "run", "function", "model":
+	default:
+		api.WriteBadRequest(w, "group must be one of day, run, function or model")
+		return
+	}
+	days := 30
+	if daysStr := r.URL.Query().Get("days"); daysStr != "" {
+		d, err := strconv.Atoi(daysStr)
+		if err != nil || d <= 0 {
+			api.WriteBadRequest(w, "invalid days")
+			return
+		}
+		days = d
+	}
+
+	report, err := s.db.GetLLMUsageReport(r.Context(), group, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		api.WriteServerError(w, err.Error())
+		return
+	}
+	if report == nil {
+		report = []models.LLMUsageSummary{}
+	}
+	api.WriteSuccess(w, map[string]interface{}{
+		"group":  group,
+		"days":   days,
+		"report": report,
+	})
+}
+
+// llmCacheHandler clears cached LLM responses, optionally only those of
+// one function.
+func (s *Server) llmCacheHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		api.WriteError(w, "Method not allowed", "METHOD_NOT_ALLOWED", 405)
+		return
+	}
+	removed, err := s.db.DeleteLLMCache(r.Context(), r.URL.Query().Get("function"))
+	if err != nil {
+		api.WriteServerError(w, err.Error())
+		return
+	}
+	api.WriteSuccess(w, map[string]interface{}{"removed": removed})
+}
+
+// promptsHandler lists every prompt template version and stores new ones.
+// A new version is not used until it is activated.
+func (s *Server) promptsHandler(w http.ResponseWriter, r *http.Request) {
+	prompts := s.llmService.Prompts()
+	switch r.Method {
+	case "GET":
+		api.WriteSuccess(w, prompts.List(r.Context()))
+	case "POST":
+		var payload struct {
+			Name string `json:"name"`
+			Body string `json:"body"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			api.WriteValidationError(w, []api.ValidationError{{Field: "body", Message: err.Error()}})
+			return
+		}
+		if payload.Name == "" || strings.TrimSpace(payload.Body) == "" {
+			api.WriteValidationError(w, []api.ValidationError{{Field: "name", Message: "name and body are required"}})
+			return
+		}
+		created, err := prompts.Create(r.Context(), payload.Name, payload.Body)
+		if err != nil {
+			api.WriteBadRequest(w, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(201)
+		json.NewEncoder(w).Encode(created)
+	default:
+		api.WriteError(w, "Method not allowed", "METHOD_NOT_ALLOWED", 405)
+	}
+}
+
+// promptActiveHandler selects the prompt template version to use. Version 0
+// returns to the latest version shipped as a file.
+func (s *Server) promptActiveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PUT" {
+		api.WriteError(w, "Method not allowed", "METHOD_NOT_ALLOWED", 405)
+		return
+	}
+	var payload struct {
+		Name    string `json:"name"`
+		Version int    `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		api.WriteValidationError(w, []api.ValidationError{{Field: "body", Message: err.Error()}})
+		return
+	}
+	if payload.Name == "" || payload.Version < 0 {
+		api.WriteValidationError(w, []api.ValidationError{{Field: "name", Message: "name and a non-negative version are required"}})
+		return
+	}
+	if err := s.llmService.Prompts().Activate(r.Context(), payload.Name, payload.Version); err != nil {
+		api.WriteBadRequest(w, err.Error())
+		return
+	}
+	api.WriteSuccess(w, map[string]interface{}{"name": payload.Name, "version": payload.Version})
+}
+
+// promptPreviewHandler renders a prompt template version with the given
+// data without calling the LLM.
+func (s *Server) promptPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		api.WriteError(w, "Method not allowed", "METHOD_NOT_ALLOWED", 405)
+		return
+	}
+	var payload struct {
+		Name    string                 `json:"name"`
+		Version int                    `json:"version"`
+		Data    map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		api.WriteValidationError(w, []api.ValidationError{{Field: "body", Message: err.Error()}})
+		return
+	}
+	if payload.Name == "" {
+		api.WriteValidationError(w, []api.ValidationError{{Field: "name", Message: "required"}})
+		return
+	}
+	rendered, err := s.llmService.Prompts().RenderVersion(r.Context(), payload.Name, payload.Version, payload.Data)
+	if err != nil {
+		api.WriteBadRequest(w, err.Error())
+		return
+	}
+	api.WriteSuccess(w, rendered)
+}
+
+// reviewHandler lists the review queue, pending items by default.
+func (s *Server) reviewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		api.WriteError(w, "Method not allowed", "METHOD_NOT_ALLOWED", 405)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = services.ReviewStatusPending
+	} else if status == "all" {
+		status = ""
+	}
+	items, err := s.db.GetReviewItems(r.Context(), status)
+	if err != nil {
+		api.WriteServerError(w, err.Error())
+		return
+	}
+	if items == nil {
+		items = []models.ReviewItem{}
+	}
+	api.WriteSuccess(w, items)
+}
+
+// reviewItemHandler shows an item of the review queue and confirms or
+// rejects it with POST /api/review/{id}/confirm or /reject.
+func (s *Server) reviewItemHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/review/"), "/")
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		api.WriteValidationError(w, []api.ValidationError{{Field: "id", Message: "invalid ID"}})
+		return
+	}
+
+	action := ""
+	if len(parts) > 1 {
+		action = parts[1]
+	}
+	switch {
+	case action == "" && r.Method == "GET":
+		item, err := s.db.GetReviewItem(r.Context(), id)
+		if err != nil {
+			api.WriteServerError(w, err.Error())
+			return
+		}
+		if item == nil {
+			api.WriteNotFound(w, "Review item")
+			return
+		}
+		api.WriteSuccess(w, item)
+	case action == "confirm" && r.Method == "POST":
+		var correction services.ReviewCorrection
+		if err := json.NewDecoder(r.Body).Decode(&correction); err != nil {
+			api.WriteValidationError(w, []api.ValidationError{{Field: "body", Message: err.Error()}})
+			return
+		}
+		if correction.ProductID <= 0 {
+			api.WriteValidationError(w, []api.ValidationError{{Field: "product_id", Message: "product_id is required"}})
+			return
+		}
+		listing, err := s.botService.ConfirmReview(r.Context(), id, correction)
+		if err != nil {
+			writeReviewError(w, err)
+			return
+		}
+		api.WriteSuccess(w, listing)
+	case action == "reject" && r.Method == "POST":
+		if err := s.botService.RejectReview(r.Context(), id); err != nil {
+			writeReviewError(w, err)
+			return
+		}
+		api.WriteSuccess(w, map[string]interface{}{"id": id, "status": services.ReviewStatusRejected})
+	default:
+		api.WriteError(w, "Method not allowed", "METHOD_NOT_ALLOWED", 405)
+	}
+}
+
+func writeReviewError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrReviewNotFound):
+		api.WriteNotFound(w, "Review item")
+	case errors.Is(err, services.ErrReviewResolved):
+		api.WriteError(w, err.Error(), "CONFLICT", http.StatusConflict)
+	case errors.Is(err, services.ErrReviewProduct):
+		api.WriteValidationError(w, []api.ValidationError{{Field: "product_id", Message: err.Error()}})
+	default:
+		api.WriteServerError(w, err.Error())
+	}
+}
+
+// fxRatesHandler lists the current FX rates and stores new ones.
+func (s *Server) fxRatesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		rates, err := s.db.GetFXRates(r.Context())
+		if err != nil {
+			api.WriteServerError(w, err.Error())
+			return
+		}
+		if rates == nil {
+			rates = []models.FXRate{}
+		}
+		api.WriteSuccess(w, rates)
+	case "POST":
+		var payload models.FXRate
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			api.WriteValidationError(w, []api.ValidationError{{Field: "body", Message: err.Error()}})
+			return
+		}
+		payload.Base = models.Currency(strings.ToUpper(string(payload.Base)))
+		payload.Quote = models.Currency(strings.ToUpper(string(payload.Quote)))
+		if len(payload.Base) != 3 || len(payload.Quote) != 3 || payload.Base == payload.Quote {
+			api.WriteValidationError(w, []api.ValidationError{{Field: "base", Message: "base and quote must be two different ISO 4217 codes"}})
+			return
+		}
+		if payload.Rate <= 0 {
+			api.WriteValidationError(w, []api.ValidationError{{Field: "rate", Message: "must be positive"}})
+			return
+		}
+		if err := s.db.SaveFXRate(r.Context(), &payload); err != nil {
+			api.WriteServerError(w, err.Error())
+			return
+		}
+		api.WriteSuccess(w, payload)
+	default:
+		api.WriteError(w, "Method not allowed", "METHOD_NOT_ALLOWED", 405)
+	}
+}
+
 func (s *Server) valuationsHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	switch r.Method {
@@ -1354,7 +2078,7 @@ func (s *Server) collectValuationsHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	productInfo := services.ProductInfo{}
+	productInfo := services.ProductInfo{ProductID: product.ID}
 	if product.Brand != nil {
 		productInfo.Manufacturer = *product.Brand
 	}
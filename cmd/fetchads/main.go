@@ -37,6 +37,8 @@ func main() {
 		log.Fatalf("Failed to connect to database after retries: %v", err)
 	}
 	defer database.Close()
+	database.UseCostModel(cfg.Costs)
+	database.UseExposureLimits(cfg.Exposure)
 
 	log.Println("Running database migrations...")
 	if err := database.Migrate(); err != nil {
@@ -47,6 +49,7 @@ func main() {
 	marketplaceService := services.NewMarketplaceService(cfg)
 	cacheService := services.NewCacheService(cfg)
 	llmService := services.NewLLMService(cfg)
+	llmService.UseDatabase(database)
 	valuationService := services.NewValuationService(cfg, database, llmService)
 	botService := services.NewBotService(cfg, marketplaceService, cacheService, llmService, valuationService, database)
 
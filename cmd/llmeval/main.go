@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"begbot/internal/config"
+	"begbot/internal/db"
+	"begbot/internal/models"
+	"begbot/internal/services"
+
+	"github.com/joho/godotenv"
+)
+
+// llmeval scores the LLM functions against labelled ads, per model.
+//
+//	llmeval -import listings                 label the ads of listings linked to products
+//	llmeval -import golden.jsonl             store labelled ads from a JSON lines file
+//	llmeval -models a,b -new-price           evaluate the stored cases with models a and b
+//	llmeval -dataset golden.jsonl -json      evaluate a file without the database
+func main() {
+	godotenv.Load()
+
+	importFrom := flag.String("import", "", "Import labels: listings, or a JSON lines file")
+	dataset := flag.String("dataset", "", "Evaluate the cases in a JSON lines file instead of the database")
+	modelList := flag.String("models", "", "Comma-separated models to compare (default: configured models)")
+	limit := flag.Int("limit", 0, "Evaluate at most this many cases")
+	newPrice := flag.Bool("new-price", false, "Also evaluate NewPrice on cases with a labelled new price")
+	tolerance := flag.Float64("tolerance", services.DefaultNewPriceTolerance, "Allowed new price error as a fraction")
+	asJSON := flag.Bool("json", false, "Print the results as JSON")
+	mismatches := flag.Bool("mismatches", false, "List the wrong answers")
+	flag.Parse()
+
+	cfg, err := config.Load("config.yaml")
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	ctx := context.Background()
+
+	var database *db.Postgres
+	if *importFrom != "" || *dataset == "" {
+		database, err = db.NewPostgres(cfg.Database)
+		if err != nil {
+			log.Fatalf("Failed to connect to database: %v", err)
+		}
+		defer database.Close()
+		database.UseCostModel(cfg.Costs)
+		database.UseExposureLimits(cfg.Exposure)
+		if err := database.Migrate(); err != nil {
+			log.Fatalf("Failed to run migrations: %v", err)
+		}
+	}
+
+	if *importFrom != "" {
+		if err := importCases(ctx, database, *importFrom); err != nil {
+			log.Fatalf("Import failed: %v", err)
+		}
+		return
+	}
+
+	var cases []models.LLMEvalCase
+	if *dataset != "" {
+		cases, err = readCases(*dataset)
+		if err == nil && *limit > 0 && len(cases) > *limit {
+			cases = cases[:*limit]
+		}
+	} else {
+		cases, err = database.GetLLMEvalCases(ctx, *limit)
+	}
+	if err != nil {
+		log.Fatalf("Failed to load cases: %v", err)
+	}
+
+	llmService := services.NewLLMService(cfg)
+	if database != nil {
+		llmService.UseDatabase(database)
+	}
+
+	opts := services.LLMEvalOptions{NewPrice: *newPrice, NewPriceTolerance: *tolerance}
+	for _, m := range strings.Split(*modelList, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			opts.Models = append(opts.Models, m)
+		}
+	}
+
+	log.Printf("Evaluating %d cases with %d model(s)...", len(cases), max(len(opts.Models), 1))
+	results, err := llmService.Evaluate(ctx, cases, opts)
+	if err != nil {
+		log.Fatalf("Evaluation failed: %v", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+		return
+	}
+	printResults(results, *mismatches)
+}
+
+func importCases(ctx context.Context, database *db.Postgres, from string) error {
+	if from == "listings" {
+		n, err := database.ImportLLMEvalCasesFromListings(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %d cases from listings\n", n)
+		return nil
+	}
+
+	cases, err := readCases(from)
+	if err != nil {
+		return err
+	}
+	for i := range cases {
+		if err := database.SaveLLMEvalCase(ctx, &cases[i]); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Imported %d cases from %s\n", len(cases), from)
+	return nil
+}
+
+func readCases(path string) ([]models.LLMEvalCase, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return services.ReadLLMEvalCases(f, filepath.Base(path))
+}
+
+func printResults(results []services.LLMEvalResult, mismatches bool) {
+	fields := []string{
+		services.EvalFieldManufacturer,
+		services.EvalFieldModel,
+		services.EvalFieldCategory,
+		services.EvalFieldCondition,
+		services.EvalFieldNewPrice,
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(w, "model\tcases\tfailures\t")
+	for _, f := range fields {
+		fmt.Fprintf(w, "%s\t", f)
+	}
+	fmt.Fprintln(w, "calls\ttokens\tcost USD\tavg latency\t")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%d\t%d\t", r.Model, r.Cases, r.Failures)
+		for _, f := range fields {
+			if sc, ok := r.Fields[f]; ok {
+				fmt.Fprintf(w, "%.1f%% (%d/%d)\t", sc.Accuracy()*100, sc.Correct, sc.Total)
+			} else {
+				fmt.Fprint(w, "-\t")
+			}
+		}
+		fmt.Fprintf(w, "%d\t%d\t%.4f\t%d ms\t\n", r.Calls, r.PromptTokens+r.CompletionTokens, r.CostUSD, r.AvgLatencyMs)
+	}
+	w.Flush()
+
+	if !mismatches {
+		return
+	}
+	for _, r := range results {
+		fmt.Printf("\n%s:\n", r.Model)
+		for _, m := range r.Mismatches {
+			fmt.Printf("  %s %s: expected %q, got %q\n", m.Ref, m.Field, m.Expected, m.Got)
+		}
+	}
+}
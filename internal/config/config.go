@@ -5,6 +5,8 @@ import (
 	"os"
 	"time"
 
+	"begbot/internal/models"
+
 	"gopkg.in/yaml.v3"
 )
 
@@ -15,6 +17,15 @@ type Config struct {
 	LLM       LLMConfig       `yaml:"llm"`
 	Valuation ValuationConfig `yaml:"valuation"`
 	Email     EmailConfig     `yaml:"email"`
+	Repricing RepricingConfig `yaml:"repricing"`
+	Cascade   CascadeConfig   `yaml:"cascade"`
+	Review    ReviewConfig    `yaml:"review"`
+	// Costs is the cost model every profit figure is computed with.
+	Costs models.CostModel `yaml:"costs"`
+	// Exposure caps the capital and stock buy recommendations may tie up.
+	Exposure models.ExposureLimits `yaml:"exposure"`
+	// Scoring ranks buy opportunities and gates notifications.
+	Scoring models.ScoringModel `yaml:"scoring"`
 }
 
 type DatabaseConfig struct {
@@ -48,13 +59,82 @@ type BlocketConfig struct {
 }
 
 type LLMConfig struct {
+	// Provider is openrouter (default), openai for any OpenAI-compatible
+	// endpoint at BaseURL, or scripted for offline runs from ScriptFile.
 	Provider     string            `yaml:"provider"`
 	APIKey       string            `yaml:"api_key"`
 	SiteURL      string            `yaml:"site_url"`
 	SiteName     string            `yaml:"site_name"`
+	BaseURL      string            `yaml:"base_url"`
+	ScriptFile   string            `yaml:"script_file"`
 	Timeout      time.Duration     `yaml:"timeout"`
 	DefaultModel string            `yaml:"default_model"`
 	Models       map[string]string `yaml:"models"`
+	// Providers overrides Provider per function, keyed like Models.
+	Providers map[string]string `yaml:"providers"`
+	// MaxTokens is the completion token budget per function, keyed like
+	// Models, falling back to DefaultMaxTokens.
+	MaxTokens        map[string]int `yaml:"max_tokens"`
+	DefaultMaxTokens int            `yaml:"default_max_tokens"`
+	// MaxAttempts is how many times structured output is requested before
+	// giving up, feeding the validation error back on each retry.
+	MaxAttempts int `yaml:"max_attempts"`
+	// StructuredOutput sends the expected JSON schema as response_format.
+	// Defaults to true; disable for providers that reject it.
+	StructuredOutput *bool `yaml:"structured_output"`
+	// CacheTTL is how long responses are cached per function, keyed like
+	// Models. Functions without a TTL are not cached.
+	CacheTTL map[string]time.Duration `yaml:"cache_ttl"`
+	// Prices is the cost per model in USD per million tokens, used when the
+	// provider does not report cost.
+	Prices map[string]LLMPrice `yaml:"prices"`
+	// DailyBudgetUSD caps what is spent per day. Once it is reached every
+	// function uses BudgetModel, or, without one, optional LLM-only
+	// functions are skipped. Zero disables the budget.
+	DailyBudgetUSD float64 `yaml:"daily_budget_usd"`
+	BudgetModel    string  `yaml:"budget_model"`
+	// MaxImages is how many photos per ad are sent to the AnalyzeImages
+	// model, which must be vision-capable. Zero disables image analysis.
+	MaxImages int `yaml:"max_images"`
+	// PromptDir holds prompt templates named <name>.v<version>.tmpl that
+	// add to or replace the ones built into the binary.
+	PromptDir string `yaml:"prompt_dir"`
+	// Fallbacks are the models tried in order when the model of a function
+	// fails, keyed like Models. The "default" list applies to functions
+	// without their own.
+	Fallbacks map[string][]string `yaml:"fallbacks"`
+	// Retries is how many times a call is retried on the same model after a
+	// rate limit, server error or network error, waiting RetryBackoff
+	// first and doubling it each time.
+	Retries      int           `yaml:"retries"`
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// CircuitThreshold is how many failures in a row make a model be
+	// skipped for CircuitCooldown. Zero disables the circuit breaker.
+	CircuitThreshold int           `yaml:"circuit_threshold"`
+	CircuitCooldown  time.Duration `yaml:"circuit_cooldown"`
+	// Concurrency limits the calls in flight per provider, e.g.
+	// openrouter: 4. Providers without a limit are unlimited.
+	Concurrency map[string]int `yaml:"concurrency"`
+	// FewShot adds corrected ads similar to the one being extracted to the
+	// ExtractProductInfo prompt.
+	FewShot FewShotConfig `yaml:"few_shot"`
+}
+
+type FewShotConfig struct {
+	// MaxExamples is how many examples are added. Zero disables them.
+	MaxExamples int `yaml:"max_examples"`
+	// MaxChars caps the ad text of each example.
+	MaxChars int `yaml:"max_chars"`
+	// MinSimilarity is the share of words an example must have in common
+	// with the ad, from 0 to 1.
+	MinSimilarity float64 `yaml:"min_similarity"`
+	// Sources are the labelled example sources to use, review by default.
+	Sources []string `yaml:"sources"`
+}
+
+type LLMPrice struct {
+	Prompt     float64 `yaml:"prompt"`
+	Completion float64 `yaml:"completion"`
 }
 
 type EmailConfig struct {
@@ -70,6 +150,81 @@ type ValuationConfig struct {
 	TargetSellDays  int     `yaml:"target_sell_days"`
 	MinProfitMargin float64 `yaml:"min_profit_margin"`
 	SafetyMargin    float64 `yaml:"safety_margin"`
+	// CompileStrategy is the default strategy for combining valuation inputs:
+	// weighted, median, trimmed_mean or llm. Products may override it.
+	CompileStrategy  string  `yaml:"compile_strategy"`
+	OutlierThreshold float64 `yaml:"outlier_threshold"`
+	TrimFraction     float64 `yaml:"trim_fraction"`
+	MinNewPriceRatio float64 `yaml:"min_new_price_ratio"`
+	MaxNewPriceRatio float64 `yaml:"max_new_price_ratio"`
+	// TrendWindowDays is how far back market observations are used to fit
+	// the depreciation model. MaxDailyDepreciation caps the fitted rate.
+	TrendWindowDays      int     `yaml:"trend_window_days"`
+	MaxDailyDepreciation float64 `yaml:"max_daily_depreciation"`
+	// SupplyWindowDays is how old a competing listing may be and still
+	// count as current supply.
+	SupplyWindowDays int `yaml:"supply_window_days"`
+	// Methods configures valuation methods by their registry key. Settings
+	// stored on the valuation_types row take precedence.
+	Methods map[string]ValuationMethodConfig `yaml:"methods"`
+}
+
+// RepricingConfig controls price suggestions for my own listings and items
+// in stock.
+type RepricingConfig struct {
+	// InitialMarkup is added on top of the market value for the first ask,
+	// leaving room for negotiation.
+	InitialMarkup float64 `yaml:"initial_markup"`
+	// CutAfterDays is how long an item may go unsold before each price cut
+	// of CutPercent of the initial ask.
+	CutAfterDays int     `yaml:"cut_after_days"`
+	CutPercent   float64 `yaml:"cut_percent"`
+	// MinProfitSEK is the profit the floor keeps above total cost. When zero
+	// the min_profit_sek trading rule is used.
+	MinProfitSEK int `yaml:"min_profit_sek"`
+	// NotifyCron schedules an email with due price cuts. Empty disables it.
+	NotifyCron string `yaml:"notify_cron"`
+}
+
+// CascadeConfig controls the cheap checks a new ad must pass before full
+// LLM extraction and valuation.
+type CascadeConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// ExcludeKeywords drop ads whose title or text contains one, e.g.
+	// "köpes" or "defekt".
+	ExcludeKeywords []string `yaml:"exclude_keywords"`
+	// PreFilter asks the PreFilter model, which should be cheap, whether
+	// the ad is for a catalog product when the keywords do not tell.
+	PreFilter bool `yaml:"prefilter"`
+	// MinDiscount is how far below the cached valuation of the matched
+	// product, as a fraction, the asking price must be. Ads without a
+	// matched product or cached valuation always go on.
+	MinDiscount float64 `yaml:"min_discount"`
+}
+
+// ReviewConfig controls which ads are parked for a human to confirm
+// instead of being saved or skipped.
+type ReviewConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MinExtractionConfidence parks ads whose extraction the model was
+	// less sure of, as a fraction.
+	MinExtractionConfidence float64 `yaml:"min_extraction_confidence"`
+	// MinMatchScore is how similar a catalog product must be to the
+	// extracted one to be offered as a candidate when there is no exact
+	// match. Ads without candidates are skipped as before.
+	MinMatchScore float64 `yaml:"min_match_score"`
+	// MaxCandidates is how many candidate products are kept per ad.
+	MaxCandidates int `yaml:"max_candidates"`
+}
+
+type ValuationMethodConfig struct {
+	Enabled  *bool         `yaml:"enabled"`
+	Timeout  time.Duration `yaml:"timeout"`
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// StaleTTL is how long past CacheTTL a cached response may still be
+	// served while it is refreshed in the background.
+	StaleTTL time.Duration          `yaml:"stale_ttl"`
+	Params   map[string]interface{} `yaml:"params"`
 }
 
 func Load(path string) (*Config, error) {
@@ -113,6 +268,12 @@ func applyEnvOverrides(cfg *Config) {
 	if v := os.Getenv("LOG_LEVEL"); v != "" {
 		cfg.App.LogLevel = v
 	}
+	if v := os.Getenv("LLM_PROVIDER"); v != "" {
+		cfg.LLM.Provider = v
+	}
+	if v := os.Getenv("LLM_BASE_URL"); v != "" {
+		cfg.LLM.BaseURL = v
+	}
 	if v := os.Getenv("LLM_API_KEY"); v != "" {
 		cfg.LLM.APIKey = v
 	}
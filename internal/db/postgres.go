@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"begbot/internal/config"
@@ -38,7 +40,9 @@ func parseIntegerArray(raw interface{}) []int64 {
 }
 
 type Postgres struct {
-	db *sql.DB
+	db       *sql.DB
+	costs    models.CostModel
+	exposure models.ExposureLimits
 }
 
 func NewPostgres(cfg config.DatabaseConfig) (*Postgres, error) {
@@ -71,6 +75,24 @@ func (p *Postgres) DB() *sql.DB {
 	return p.db
 }
 
+// UseCostModel sets the cost model profit figures are computed with.
+// Without one, profit is the sell price less buy price, shipping and the
+// product's packaging and postage.
+func (p *Postgres) UseCostModel(costs models.CostModel) {
+	p.costs = costs
+}
+
+// CostModel returns the cost model profit figures are computed with.
+func (p *Postgres) CostModel() models.CostModel {
+	return p.costs
+}
+
+// UseExposureLimits sets the limits potential listings are checked
+// against. Without them nothing is over a limit.
+func (p *Postgres) UseExposureLimits(limits models.ExposureLimits) {
+	p.exposure = limits
+}
+
 func (p *Postgres) Migrate() error {
 	queries := []string{
 		`CREATE TABLE IF NOT EXISTS products (
@@ -276,6 +298,172 @@ func (p *Postgres) Migrate() error {
 			PRIMARY KEY (product_id, valuation_type_id)
 		)`,
 		`ALTER TABLE product_valuation_type_config ADD COLUMN IF NOT EXISTS weight NUMERIC NOT NULL DEFAULT 0`,
+		`ALTER TABLE IF EXISTS products ADD COLUMN IF NOT EXISTS valuation_strategy TEXT`,
+		`ALTER TABLE IF EXISTS trading_rules ADD COLUMN IF NOT EXISTS profit_percentile SMALLINT`,
+		`ALTER TABLE IF EXISTS trading_rules ADD COLUMN IF NOT EXISTS min_percentile_profit_sek INTEGER`,
+		`ALTER TABLE listings ADD COLUMN IF NOT EXISTS valuation INTEGER DEFAULT 0`,
+		`CREATE TABLE IF NOT EXISTS listing_valuations (
+			id SERIAL PRIMARY KEY,
+			listing_id INTEGER NOT NULL REFERENCES listings(id) ON DELETE CASCADE,
+			recommended_price INTEGER NOT NULL DEFAULT 0,
+			confidence NUMERIC NOT NULL DEFAULT 0,
+			strategy TEXT,
+			reasoning TEXT,
+			compiled JSONB DEFAULT '{}',
+			inputs JSONB DEFAULT '[]',
+			adjustments JSONB DEFAULT '[]',
+			verdict JSONB DEFAULT '{}',
+			created_at TIMESTAMPTZ DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_listing_valuations_listing_id ON listing_valuations(listing_id)`,
+		`ALTER TABLE valuation_types ADD COLUMN IF NOT EXISTS key TEXT`,
+		`ALTER TABLE valuation_types ADD COLUMN IF NOT EXISTS config JSONB DEFAULT '{}'`,
+		`UPDATE valuation_types SET key = CASE id
+				WHEN 1 THEN 'database'
+				WHEN 2 THEN 'tradera'
+				WHEN 3 THEN 'marketplace'
+				WHEN 4 THEN 'llm_new_price'
+			END
+			WHERE key IS NULL AND id BETWEEN 1 AND 4`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_valuation_types_key ON valuation_types(key)`,
+		`CREATE TABLE IF NOT EXISTS valuation_cache (
+			cache_key TEXT PRIMARY KEY,
+			method_key TEXT NOT NULL,
+			payload JSONB NOT NULL,
+			fetched_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_valuation_cache_method_key ON valuation_cache(method_key)`,
+		`CREATE INDEX IF NOT EXISTS idx_valuation_cache_expires_at ON valuation_cache(expires_at)`,
+		`ALTER TABLE marketplaces ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'SEK'`,
+		`ALTER TABLE listings ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'SEK'`,
+		`CREATE TABLE IF NOT EXISTS fx_rates (
+			id SERIAL PRIMARY KEY,
+			base CHAR(3) NOT NULL,
+			quote CHAR(3) NOT NULL,
+			rate NUMERIC NOT NULL CHECK (rate > 0),
+			valid_from TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_fx_rates_pair ON fx_rates(base, quote, valid_from DESC)`,
+		`ALTER TABLE scraping_runs ADD COLUMN IF NOT EXISTS extraction_failures INTEGER DEFAULT 0`,
+		`CREATE TABLE IF NOT EXISTS llm_cache (
+			cache_key VARCHAR(64) PRIMARY KEY,
+			function VARCHAR(100) NOT NULL,
+			model VARCHAR(200) NOT NULL,
+			prompt_version VARCHAR(50) NOT NULL,
+			response TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_llm_cache_function ON llm_cache(function)`,
+		`ALTER TABLE scraping_runs ADD COLUMN IF NOT EXISTS llm_cache_hits INTEGER DEFAULT 0`,
+		`ALTER TABLE scraping_runs ADD COLUMN IF NOT EXISTS llm_cache_misses INTEGER DEFAULT 0`,
+		`CREATE TABLE IF NOT EXISTS llm_calls (
+			id SERIAL PRIMARY KEY,
+			scraping_run_id INTEGER REFERENCES scraping_runs(id) ON DELETE SET NULL,
+			function VARCHAR(100) NOT NULL,
+			model VARCHAR(200) NOT NULL,
+			prompt_tokens INTEGER NOT NULL DEFAULT 0,
+			completion_tokens INTEGER NOT NULL DEFAULT 0,
+			latency_ms INTEGER NOT NULL DEFAULT 0,
+			cost_usd NUMERIC(12,6) NOT NULL DEFAULT 0,
+			success BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_llm_calls_created_at ON llm_calls(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_llm_calls_run ON llm_calls(scraping_run_id)`,
+		`CREATE TABLE IF NOT EXISTS prompt_templates (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(100) NOT NULL,
+			version INTEGER NOT NULL,
+			body TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			UNIQUE (name, version)
+		)`,
+		`CREATE TABLE IF NOT EXISTS prompt_template_active (
+			name VARCHAR(100) PRIMARY KEY,
+			version INTEGER NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`ALTER TABLE llm_calls ADD COLUMN IF NOT EXISTS prompt_version VARCHAR(120)`,
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS prompt_version VARCHAR(120)`,
+		`CREATE TABLE IF NOT EXISTS listing_image_analyses (
+			listing_id INTEGER PRIMARY KEY REFERENCES listings(id) ON DELETE CASCADE,
+			condition VARCHAR(20) NOT NULL,
+			model_matches BOOLEAN NOT NULL,
+			stock_photo BOOLEAN NOT NULL,
+			analysis JSONB NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+		`ALTER TABLE scraping_runs ADD COLUMN IF NOT EXISTS cascade_stats JSONB`,
+		`CREATE TABLE IF NOT EXISTS llm_eval_cases (
+			id SERIAL PRIMARY KEY,
+			source VARCHAR(30) NOT NULL,
+			source_ref TEXT NOT NULL,
+			ad_text TEXT NOT NULL,
+			manufacturer TEXT,
+			model TEXT,
+			category TEXT,
+			condition TEXT,
+			new_price INTEGER,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			UNIQUE (source, source_ref)
+		)`,
+		`CREATE TABLE IF NOT EXISTS review_queue (
+			id SERIAL PRIMARY KEY,
+			link TEXT NOT NULL UNIQUE,
+			reason VARCHAR(30) NOT NULL,
+			ad JSONB NOT NULL,
+			extracted JSONB NOT NULL,
+			confidence NUMERIC(4,3) NOT NULL DEFAULT 0,
+			candidates JSONB NOT NULL DEFAULT '[]',
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			product_id INTEGER REFERENCES products(id) ON DELETE SET NULL,
+			listing_id INTEGER REFERENCES listings(id) ON DELETE SET NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			resolved_at TIMESTAMPTZ
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_review_queue_status ON review_queue(status)`,
+		`ALTER TABLE trading_rules ADD COLUMN IF NOT EXISTS name VARCHAR(100)`,
+		`ALTER TABLE trading_rules ADD COLUMN IF NOT EXISTS category TEXT`,
+		`ALTER TABLE trading_rules ADD COLUMN IF NOT EXISTS product_id INTEGER REFERENCES products(id) ON DELETE CASCADE`,
+		`ALTER TABLE trading_rules ADD COLUMN IF NOT EXISTS marketplace_id INTEGER REFERENCES marketplaces(id) ON DELETE CASCADE`,
+		`ALTER TABLE trading_rules ADD COLUMN IF NOT EXISTS search_term_id INTEGER REFERENCES search_terms(id) ON DELETE CASCADE`,
+		`ALTER TABLE trading_rules ADD COLUMN IF NOT EXISTS priority INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE listings ADD COLUMN IF NOT EXISTS search_term_id INTEGER REFERENCES search_terms(id) ON DELETE SET NULL`,
+		`ALTER TABLE trading_rules ADD COLUMN IF NOT EXISTS expression TEXT`,
+		`ALTER TABLE listings ADD COLUMN IF NOT EXISTS opportunity_score DOUBLE PRECISION`,
+		`COMMENT ON COLUMN listings.price IS 'whole units of listings.currency'`,
+		`COMMENT ON COLUMN listings.shipping_cost IS 'whole units of listings.currency'`,
+		`COMMENT ON COLUMN listings.valuation IS 'whole SEK'`,
+		`COMMENT ON COLUMN valuations.valuation IS 'whole SEK'`,
+		`COMMENT ON COLUMN traded_items.buy_price IS 'öre'`,
+		`COMMENT ON COLUMN traded_items.buy_shipping_cost IS 'öre'`,
+		`COMMENT ON COLUMN traded_items.sell_price IS 'öre'`,
+		`COMMENT ON COLUMN traded_items.sell_packaging_cost IS 'öre'`,
+		`COMMENT ON COLUMN traded_items.sell_postage_cost IS 'öre'`,
+		`COMMENT ON COLUMN traded_items.sell_shipping_collected IS 'öre'`,
+		`CREATE INDEX IF NOT EXISTS idx_listings_opportunity_score ON listings(opportunity_score DESC NULLS LAST)`,
+		`ALTER TABLE review_queue ADD COLUMN IF NOT EXISTS prompt_version VARCHAR(120)`,
+		`ALTER TABLE review_queue ADD COLUMN IF NOT EXISTS llm_model VARCHAR(200)`,
+		`CREATE OR REPLACE FUNCTION fx_rate(base_currency TEXT, quote_currency TEXT) RETURNS NUMERIC AS $$
+			SELECT CASE WHEN base_currency = quote_currency THEN 1 ELSE COALESCE(
+				(SELECT rate FROM fx_rates
+				WHERE base = base_currency AND quote = quote_currency AND valid_from <= NOW()
+				ORDER BY valid_from DESC LIMIT 1),
+				(SELECT 1 / rate FROM fx_rates
+				WHERE base = quote_currency AND quote = base_currency AND valid_from <= NOW()
+				ORDER BY valid_from DESC LIMIT 1)
+			) END
+		$$ LANGUAGE SQL STABLE`,
+		`CREATE TABLE IF NOT EXISTS repricing_notifications (
+			kind VARCHAR(20) NOT NULL,
+			item_id INTEGER NOT NULL,
+			suggested_price INTEGER NOT NULL,
+			notified_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (kind, item_id)
+		)`,
 	}
 
 	for i, query := range queries {
@@ -356,6 +544,69 @@ func (p *Postgres) GetActiveTradedItems(ctx context.Context) ([]models.TradedIte
 	return p.scanTradedItems(rows)
 }
 
+// GetExposure returns the money and stock tied up in purchased, in-stock
+// and listed items, and what was bought today, under the exposure limits.
+func (p *Postgres) GetExposure(ctx context.Context) (*models.Exposure, error) {
+	query := `
+		SELECT t.product_id, COALESCE(pr.category, ''),
+			COALESCE(t.buy_price, 0) + COALESCE(t.buy_shipping_cost, 0),
+			t.status_id IN (2, 3, 4),
+			COALESCE(t.buy_date, t.created_at) >= date_trunc('day', NOW())
+		FROM traded_items t
+		LEFT JOIN products pr ON pr.id = t.product_id
+		WHERE t.status_id IN (2, 3, 4)
+			OR (t.status_id = 5 AND COALESCE(t.buy_date, t.created_at) >= date_trunc('day', NOW()))
+	`
+	rows, err := p.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exposure := models.NewExposure(p.exposure)
+	for rows.Next() {
+		var productID sql.NullInt64
+		var category string
+		var cost int
+		var unsold, boughtToday bool
+		if err := rows.Scan(&productID, &category, &cost, &unsold, &boughtToday); err != nil {
+			return nil, err
+		}
+		// Traded item amounts are stored in öre
+		costSEK := models.Ore(cost).MajorInt()
+		if unsold {
+			var id *int64
+			if productID.Valid {
+				id = &productID.Int64
+			}
+			exposure.Add(id, category, costSEK)
+		}
+		if boughtToday {
+			exposure.Spend(costSEK)
+		}
+	}
+	return exposure, rows.Err()
+}
+
+// GetUnsoldTradedItems returns items that are in stock or listed for sale.
+func (p *Postgres) GetUnsoldTradedItems(ctx context.Context) ([]models.TradedItem, error) {
+	query := `
+		SELECT id, product_id, storage, color_id,
+			buy_price, buy_shipping_cost, buy_transaction_id, buy_date,
+			sell_price, sell_packaging_cost, sell_postage_cost, sell_shipping_collected,
+			sell_transaction_id, sell_date, status_id, source_link, created_at, listing_id
+		FROM traded_items WHERE status_id IN (3, 4)
+		ORDER BY created_at
+	`
+	rows, err := p.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return p.scanTradedItems(rows)
+}
+
 func (p *Postgres) GetAllTradedItems(ctx context.Context) ([]models.TradedItem, error) {
 	query := `
 		SELECT id, product_id, storage, color_id,
@@ -395,15 +646,17 @@ func (p *Postgres) GetSoldTradedItems(ctx context.Context, limit int) ([]models.
 
 func (p *Postgres) SaveListing(ctx context.Context, listing *models.Listing) error {
 	query := `
-		INSERT INTO listings (product_id, price, link, condition_id, shipping_cost, title, description, marketplace_id, status, publication_date, sold_date, is_my_listing, eligible_for_shipping, seller_pays_shipping, buy_now)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
-		RETURNING id
+		INSERT INTO listings (product_id, price, currency, valuation, link, condition_id, shipping_cost, title, description, marketplace_id, status, publication_date, sold_date, is_my_listing, eligible_for_shipping, seller_pays_shipping, buy_now, search_term_id)
+		VALUES ($1, $2, COALESCE(NULLIF($3, ''), (SELECT currency FROM marketplaces WHERE id = $10), 'SEK'), $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+		RETURNING id, currency
 	`
+	// Listings without an explicit currency are priced in the currency of
+	// their marketplace.
 	return p.db.QueryRowContext(ctx, query,
-		listing.ProductID, listing.Price, listing.Link, listing.ConditionID, listing.ShippingCost,
+		listing.ProductID, listing.Price, string(listing.Currency), listing.Valuation, listing.Link, listing.ConditionID, listing.ShippingCost,
 		listing.Title, listToNullString(listing.Description), listing.MarketplaceID, listing.Status, listing.PublicationDate, listing.SoldDate, listing.IsMyListing,
-		listing.EligibleForShipping, listing.SellerPaysShipping, listing.BuyNow,
-	).Scan(&listing.ID)
+		listing.EligibleForShipping, listing.SellerPaysShipping, listing.BuyNow, listing.SearchTermID,
+	).Scan(&listing.ID, &listing.Currency)
 }
 
 func listToNullString(s *string) sql.NullString {
@@ -451,6 +704,12 @@ func (p *Postgres) UpdateListingStatus(ctx context.Context, id int64, status str
 	return err
 }
 
+// SaveOpportunityScore stores the opportunity score of a listing.
+func (p *Postgres) SaveOpportunityScore(ctx context.Context, id int64, score float64) error {
+	_, err := p.db.ExecContext(ctx, `UPDATE listings SET opportunity_score = $1 WHERE id = $2`, score, id)
+	return err
+}
+
 func (p *Postgres) DeleteListing(ctx context.Context, id int64) error {
 	result, err := p.db.ExecContext(ctx, `DELETE FROM listings WHERE id = $1`, id)
 	if err != nil {
@@ -468,17 +727,17 @@ func (p *Postgres) DeleteListing(ctx context.Context, id int64) error {
 
 func (p *Postgres) GetListingByProductID(ctx context.Context, productID int64) (*models.Listing, error) {
 	query := `
-		SELECT id, product_id, price, valuation, link, condition_id, shipping_cost, title, description,
+		SELECT id, product_id, price, currency, valuation, link, condition_id, shipping_cost, title, description,
 			marketplace_id, status, publication_date, sold_date, created_at, is_my_listing,
-			eligible_for_shipping, seller_pays_shipping, buy_now
+			eligible_for_shipping, seller_pays_shipping, buy_now, search_term_id, opportunity_score
 		FROM listings WHERE product_id = $1 AND status = 'active'
 	`
 	var listing models.Listing
 	err := p.db.QueryRowContext(ctx, query, productID).Scan(
-		&listing.ID, &listing.ProductID, &listing.Price, &listing.Valuation, &listing.Link, &listing.ConditionID,
+		&listing.ID, &listing.ProductID, &listing.Price, &listing.Currency, &listing.Valuation, &listing.Link, &listing.ConditionID,
 		&listing.ShippingCost, &listing.Title, &listing.Description, &listing.MarketplaceID, &listing.Status,
 		&listing.PublicationDate, &listing.SoldDate, &listing.CreatedAt, &listing.IsMyListing,
-		&listing.EligibleForShipping, &listing.SellerPaysShipping, &listing.BuyNow,
+		&listing.EligibleForShipping, &listing.SellerPaysShipping, &listing.BuyNow, &listing.SearchTermID, &listing.OpportunityScore,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -500,21 +759,22 @@ func (p *Postgres) SaveTransaction(ctx context.Context, transaction *models.Tran
 
 func (p *Postgres) SaveProduct(ctx context.Context, product *models.Product) error {
 	query := `
-		INSERT INTO products (brand, name, category, model_variant, sell_packaging_cost, sell_postage_cost, new_price, enabled)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		INSERT INTO products (brand, name, category, model_variant, sell_packaging_cost, sell_postage_cost, new_price, enabled, valuation_strategy)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		RETURNING id
 	`
-	return p.db.QueryRowContext(ctx, query, product.Brand, product.Name, product.Category, product.ModelVariant, product.SellPackagingCost, product.SellPostageCost, product.NewPrice, product.Enabled).Scan(&product.ID)
+	return p.db.QueryRowContext(ctx, query, product.Brand, product.Name, product.Category, product.ModelVariant, product.SellPackagingCost, product.SellPostageCost, product.NewPrice, product.Enabled, product.ValuationStrategy).Scan(&product.ID)
 }
 
 func (p *Postgres) UpdateProduct(ctx context.Context, product *models.Product) error {
 	query := `
 		UPDATE products 
 		SET brand = $1, name = $2, category = $3, model_variant = $4, 
-		    sell_packaging_cost = $5, sell_postage_cost = $6, new_price = $7, enabled = $8
-		WHERE id = $9
+		    sell_packaging_cost = $5, sell_postage_cost = $6, new_price = $7, enabled = $8,
+		    valuation_strategy = $9
+		WHERE id = $10
 	`
-	_, err := p.db.ExecContext(ctx, query, product.Brand, product.Name, product.Category, product.ModelVariant, product.SellPackagingCost, product.SellPostageCost, product.NewPrice, product.Enabled, product.ID)
+	_, err := p.db.ExecContext(ctx, query, product.Brand, product.Name, product.Category, product.ModelVariant, product.SellPackagingCost, product.SellPostageCost, product.NewPrice, product.Enabled, product.ValuationStrategy, product.ID)
 	return err
 }
 
@@ -535,12 +795,12 @@ func (p *Postgres) DeleteProduct(ctx context.Context, id int64) error {
 
 func (p *Postgres) GetProductByName(ctx context.Context, brand, name string) (*models.Product, error) {
 	query := `
-		SELECT id, brand, name, category, model_variant, sell_packaging_cost, sell_postage_cost, new_price, enabled, created_at
+		SELECT id, brand, name, category, model_variant, sell_packaging_cost, sell_postage_cost, new_price, enabled, valuation_strategy, created_at
 		FROM products WHERE brand = $1 AND name = $2
 	`
 	var product models.Product
 	err := p.db.QueryRowContext(ctx, query, brand, name).Scan(
-		&product.ID, &product.Brand, &product.Name, &product.Category, &product.ModelVariant, &product.SellPackagingCost, &product.SellPostageCost, &product.NewPrice, &product.Enabled, &product.CreatedAt,
+		&product.ID, &product.Brand, &product.Name, &product.Category, &product.ModelVariant, &product.SellPackagingCost, &product.SellPostageCost, &product.NewPrice, &product.Enabled, &product.ValuationStrategy, &product.CreatedAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -553,12 +813,12 @@ func (p *Postgres) GetProductByName(ctx context.Context, brand, name string) (*m
 
 func (p *Postgres) GetProductByID(ctx context.Context, id int64) (*models.Product, error) {
 	query := `
-		SELECT id, brand, name, category, model_variant, sell_packaging_cost, sell_postage_cost, new_price, enabled, created_at
+		SELECT id, brand, name, category, model_variant, sell_packaging_cost, sell_postage_cost, new_price, enabled, valuation_strategy, created_at
 		FROM products WHERE id = $1
 	`
 	var product models.Product
 	err := p.db.QueryRowContext(ctx, query, id).Scan(
-		&product.ID, &product.Brand, &product.Name, &product.Category, &product.ModelVariant, &product.SellPackagingCost, &product.SellPostageCost, &product.NewPrice, &product.Enabled, &product.CreatedAt,
+		&product.ID, &product.Brand, &product.Name, &product.Category, &product.ModelVariant, &product.SellPackagingCost, &product.SellPostageCost, &product.NewPrice, &product.Enabled, &product.ValuationStrategy, &product.CreatedAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -569,14 +829,40 @@ func (p *Postgres) GetProductByID(ctx context.Context, id int64) (*models.Produc
 	return &product, nil
 }
 
+// GetEnabledProducts returns the catalog products the bot buys.
+func (p *Postgres) GetEnabledProducts(ctx context.Context) ([]models.Product, error) {
+	query := `
+		SELECT id, brand, name, category, model_variant, sell_packaging_cost, sell_postage_cost, new_price, enabled, valuation_strategy, created_at
+		FROM products WHERE enabled = TRUE
+		ORDER BY id
+	`
+	rows, err := p.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var products []models.Product
+	for rows.Next() {
+		var product models.Product
+		if err := rows.Scan(
+			&product.ID, &product.Brand, &product.Name, &product.Category, &product.ModelVariant, &product.SellPackagingCost, &product.SellPostageCost, &product.NewPrice, &product.Enabled, &product.ValuationStrategy, &product.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	return products, rows.Err()
+}
+
 func (p *Postgres) FindProduct(ctx context.Context, brand, name, category string) (*models.Product, error) {
 	query := `
-		SELECT id, brand, name, category, model_variant, sell_packaging_cost, sell_postage_cost, new_price, enabled, created_at
+		SELECT id, brand, name, category, model_variant, sell_packaging_cost, sell_postage_cost, new_price, enabled, valuation_strategy, created_at
 		FROM products WHERE brand = $1 AND name = $2 AND category = $3
 	`
 	var product models.Product
 	err := p.db.QueryRowContext(ctx, query, brand, name, category).Scan(
-		&product.ID, &product.Brand, &product.Name, &product.Category, &product.ModelVariant, &product.SellPackagingCost, &product.SellPostageCost, &product.NewPrice, &product.Enabled, &product.CreatedAt,
+		&product.ID, &product.Brand, &product.Name, &product.Category, &product.ModelVariant, &product.SellPackagingCost, &product.SellPostageCost, &product.NewPrice, &product.Enabled, &product.ValuationStrategy, &product.CreatedAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -610,25 +896,10 @@ func (p *Postgres) GetOrCreateProduct(ctx context.Context, brand, name, category
 	return &newProduct, nil
 }
 
+// CalculateProfit returns the net profit of a traded item in SEK, with
+// the fees of the marketplace it is sold on.
 func (p *Postgres) CalculateProfit(item *models.TradedItem) int {
-	sellTotal := 0
-	if item.SellPrice != nil {
-		sellTotal += *item.SellPrice
-	}
-	if item.SellShippingCollected != nil {
-		sellTotal += *item.SellShippingCollected
-	}
-
-	buyTotal := item.BuyPrice + item.BuyShippingCost
-	sellCost := 0
-	if item.SellPackagingCost != nil {
-		sellCost += *item.SellPackagingCost
-	}
-	if item.SellPostageCost != nil {
-		sellCost += *item.SellPostageCost
-	}
-
-	return sellTotal - (buyTotal + sellCost)
+	return p.costs.Calculate(models.TradedItemInput(item, 0)).NetProfit
 }
 
 func (p *Postgres) scanTradedItems(rows *sql.Rows) ([]models.TradedItem, error) {
@@ -885,9 +1156,9 @@ func (p *Postgres) ListingExistsByLink(ctx context.Context, link string) (bool,
 
 func (p *Postgres) GetAllListings(ctx context.Context) ([]models.Listing, error) {
 	query := `
-		SELECT id, product_id, price, link, condition_id, shipping_cost, title, description,
+		SELECT id, product_id, price, currency, COALESCE(valuation, 0), link, condition_id, shipping_cost, title, description,
 			marketplace_id, status, publication_date, sold_date, created_at, is_my_listing,
-			eligible_for_shipping, seller_pays_shipping, buy_now
+			eligible_for_shipping, seller_pays_shipping, buy_now, search_term_id, opportunity_score
 		FROM listings
 		ORDER BY created_at DESC
 	`
@@ -902,10 +1173,10 @@ func (p *Postgres) GetAllListings(ctx context.Context) ([]models.Listing, error)
 		var listing models.Listing
 		var title, description sql.NullString
 		err := rows.Scan(
-			&listing.ID, &listing.ProductID, &listing.Price, &listing.Link, &listing.ConditionID,
+			&listing.ID, &listing.ProductID, &listing.Price, &listing.Currency, &listing.Valuation, &listing.Link, &listing.ConditionID,
 			&listing.ShippingCost, &title, &description, &listing.MarketplaceID, &listing.Status,
 			&listing.PublicationDate, &listing.SoldDate, &listing.CreatedAt, &listing.IsMyListing,
-			&listing.EligibleForShipping, &listing.SellerPaysShipping, &listing.BuyNow,
+			&listing.EligibleForShipping, &listing.SellerPaysShipping, &listing.BuyNow, &listing.SearchTermID, &listing.OpportunityScore,
 		)
 		if err != nil {
 			return nil, err
@@ -940,8 +1211,24 @@ func (p *Postgres) GetMarketplaceByID(ctx context.Context, id int64) (*models.Ma
 	return &m, nil
 }
 
+const valuationTypeColumns = `id, name, enabled, key, COALESCE(config, '{}'::jsonb)`
+
+func scanValuationType(row interface{ Scan(...any) error }) (*models.ValuationType, error) {
+	var vt models.ValuationType
+	var key sql.NullString
+	var config []byte
+	if err := row.Scan(&vt.ID, &vt.Name, &vt.Enabled, &key, &config); err != nil {
+		return nil, err
+	}
+	if key.Valid {
+		vt.Key = &key.String
+	}
+	vt.Config = json.RawMessage(config)
+	return &vt, nil
+}
+
 func (p *Postgres) GetValuationTypes(ctx context.Context) ([]models.ValuationType, error) {
-	query := `SELECT id, name, enabled FROM valuation_types ORDER BY id`
+	query := `SELECT ` + valuationTypeColumns + ` FROM valuation_types ORDER BY id`
 	rows, err := p.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
@@ -950,15 +1237,45 @@ func (p *Postgres) GetValuationTypes(ctx context.Context) ([]models.ValuationTyp
 
 	var types []models.ValuationType
 	for rows.Next() {
-		var vt models.ValuationType
-		if err := rows.Scan(&vt.ID, &vt.Name, &vt.Enabled); err != nil {
+		vt, err := scanValuationType(rows)
+		if err != nil {
 			return nil, err
 		}
-		types = append(types, vt)
+		types = append(types, *vt)
 	}
 	return types, rows.Err()
 }
 
+// RegisterValuationType returns the valuation type with the given key,
+// creating it with the next free ID if it does not exist yet. Existing rows
+// keep their name, enabled flag and config.
+func (p *Postgres) RegisterValuationType(ctx context.Context, key, name string) (*models.ValuationType, error) {
+	query := `
+		INSERT INTO valuation_types (id, key, name)
+		SELECT COALESCE(MAX(id), 0) + 1, $1, $2 FROM valuation_types
+		ON CONFLICT (key) DO UPDATE SET key = EXCLUDED.key
+		RETURNING ` + valuationTypeColumns
+	return scanValuationType(p.db.QueryRowContext(ctx, query, key, name))
+}
+
+func (p *Postgres) UpdateValuationType(ctx context.Context, id int16, enabled bool, config json.RawMessage) error {
+	result, err := p.db.ExecContext(ctx,
+		`UPDATE valuation_types SET enabled = $1, config = $2 WHERE id = $3`,
+		enabled, jsonOrDefault(config, "{}"), id,
+	)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 func (p *Postgres) GetProductValuationTypeConfigs(ctx context.Context, productID int64) ([]models.ProductValuationTypeConfig, error) {
 	query := `SELECT product_id, valuation_type_id, is_active, weight FROM product_valuation_type_config WHERE product_id = $1`
 	rows, err := p.db.QueryContext(ctx, query, productID)
@@ -1068,17 +1385,17 @@ func (p *Postgres) GetValuationsForListing(ctx context.Context, listingID int64)
 
 func (p *Postgres) GetListingByID(ctx context.Context, id int64) (*models.Listing, error) {
 	query := `
-		SELECT id, product_id, price, link, condition_id, shipping_cost, title, description,
+		SELECT id, product_id, price, currency, COALESCE(valuation, 0), link, condition_id, shipping_cost, title, description,
 			marketplace_id, status, publication_date, sold_date, created_at, is_my_listing,
-			eligible_for_shipping, seller_pays_shipping, buy_now
+			eligible_for_shipping, seller_pays_shipping, buy_now, search_term_id, opportunity_score
 		FROM listings WHERE id = $1
 	`
 	var listing models.Listing
 	err := p.db.QueryRowContext(ctx, query, id).Scan(
-		&listing.ID, &listing.ProductID, &listing.Price, &listing.Link, &listing.ConditionID,
+		&listing.ID, &listing.ProductID, &listing.Price, &listing.Currency, &listing.Valuation, &listing.Link, &listing.ConditionID,
 		&listing.ShippingCost, &listing.Title, &listing.Description, &listing.MarketplaceID, &listing.Status,
 		&listing.PublicationDate, &listing.SoldDate, &listing.CreatedAt, &listing.IsMyListing,
-		&listing.EligibleForShipping, &listing.SellerPaysShipping, &listing.BuyNow,
+		&listing.EligibleForShipping, &listing.SellerPaysShipping, &listing.BuyNow, &listing.SearchTermID, &listing.OpportunityScore,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -1105,115 +1422,689 @@ func (p *Postgres) CreateValuation(ctx context.Context, v *models.Valuation) err
 	return err
 }
 
-func (p *Postgres) UpdateValuation(ctx context.Context, id int64, valuation int) (int64, error) {
-	query := `UPDATE valuations SET valuation = $1 WHERE id = $2`
-	res, err := p.db.ExecContext(ctx, query, valuation, id)
+func (p *Postgres) SaveListingValuation(ctx context.Context, lv *models.ListingValuation) error {
+	query := `
+		INSERT INTO listing_valuations (listing_id, recommended_price, confidence, strategy, reasoning, compiled, inputs, adjustments, verdict)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at
+	`
+	return p.db.QueryRowContext(ctx, query,
+		lv.ListingID, lv.RecommendedPrice, lv.Confidence, lv.Strategy, lv.Reasoning,
+		jsonOrDefault(lv.Compiled, "{}"), jsonOrDefault(lv.Inputs, "[]"), jsonOrDefault(lv.Adjustments, "[]"), jsonOrDefault(lv.Verdict, "{}"),
+	).Scan(&lv.ID, &lv.CreatedAt)
+}
+
+// GetListingValuation returns the most recent valuation snapshot for a
+// listing, or nil if none has been stored.
+func (p *Postgres) GetListingValuation(ctx context.Context, listingID int64) (*models.ListingValuation, error) {
+	query := `
+		SELECT id, listing_id, recommended_price, confidence, COALESCE(strategy, ''), COALESCE(reasoning, ''),
+			COALESCE(compiled, '{}'::jsonb), COALESCE(inputs, '[]'::jsonb), COALESCE(adjustments, '[]'::jsonb), COALESCE(verdict, '{}'::jsonb), created_at
+		FROM listing_valuations
+		WHERE listing_id = $1
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+	var lv models.ListingValuation
+	var compiled, inputs, adjustments, verdict []byte
+	err := p.db.QueryRowContext(ctx, query, listingID).Scan(
+		&lv.ID, &lv.ListingID, &lv.RecommendedPrice, &lv.Confidence, &lv.Strategy, &lv.Reasoning,
+		&compiled, &inputs, &adjustments, &verdict, &lv.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	rows, err := res.RowsAffected()
+	lv.Compiled = json.RawMessage(compiled)
+	lv.Inputs = json.RawMessage(inputs)
+	lv.Adjustments = json.RawMessage(adjustments)
+	lv.Verdict = json.RawMessage(verdict)
+	return &lv, nil
+}
+
+// GetValuationCacheEntry returns a cached valuation source response, or nil
+// if the key is not cached or the entry is past its expiry.
+func (p *Postgres) GetValuationCacheEntry(ctx context.Context, cacheKey string) (*models.ValuationCacheEntry, error) {
+	query := `
+		SELECT cache_key, method_key, payload, fetched_at, expires_at
+		FROM valuation_cache
+		WHERE cache_key = $1 AND expires_at > NOW()
+	`
+	var e models.ValuationCacheEntry
+	var payload []byte
+	err := p.db.QueryRowContext(ctx, query, cacheKey).Scan(&e.CacheKey, &e.MethodKey, &payload, &e.FetchedAt, &e.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.Payload = json.RawMessage(payload)
+	return &e, nil
+}
+
+func (p *Postgres) SetValuationCacheEntry(ctx context.Context, e *models.ValuationCacheEntry) error {
+	query := `
+		INSERT INTO valuation_cache (cache_key, method_key, payload, fetched_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (cache_key) DO UPDATE SET
+			method_key = EXCLUDED.method_key,
+			payload = EXCLUDED.payload,
+			fetched_at = EXCLUDED.fetched_at,
+			expires_at = EXCLUDED.expires_at
+	`
+	_, err := p.db.ExecContext(ctx, query, e.CacheKey, e.MethodKey, jsonOrDefault(e.Payload, "{}"), e.FetchedAt, e.ExpiresAt)
+	return err
+}
+
+// DeleteValuationCache removes cached entries. An empty methodKey matches
+// every method and an empty cacheKey every key of the method. Expired rows
+// are always purged.
+func (p *Postgres) DeleteValuationCache(ctx context.Context, methodKey, cacheKey string) (int64, error) {
+	query := `
+		DELETE FROM valuation_cache
+		WHERE ($1 = '' OR method_key = $1) AND ($2 = '' OR cache_key = $2)
+			OR expires_at <= NOW()
+	`
+	result, err := p.db.ExecContext(ctx, query, methodKey, cacheKey)
 	if err != nil {
 		return 0, err
 	}
-	return rows, nil
+	return result.RowsAffected()
 }
 
-type ListingWithValuations struct {
-	Listing    models.Listing
-	Product    *models.Product
-	Valuations []ValuationWithType
+func (p *Postgres) SaveLLMCall(ctx context.Context, c *models.LLMCall) error {
+	query := `
+		INSERT INTO llm_calls (scraping_run_id, function, model, prompt_version, prompt_tokens, completion_tokens, latency_ms, cost_usd, success)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $9)
+		RETURNING id, created_at
+	`
+	return p.db.QueryRowContext(ctx, query,
+		c.ScrapingRunID, c.Function, c.Model, c.PromptVersion, c.PromptTokens, c.CompletionTokens, c.LatencyMs, c.CostUSD, c.Success,
+	).Scan(&c.ID, &c.CreatedAt)
 }
 
-func (p *Postgres) GetListingsWithValuations(ctx context.Context) ([]ListingWithValuations, error) {
-	listings, err := p.GetAllListings(ctx)
+// GetLLMSpend returns the total LLM cost in USD since the given time.
+func (p *Postgres) GetLLMSpend(ctx context.Context, since time.Time) (float64, error) {
+	var spend float64
+	err := p.db.QueryRowContext(ctx, `SELECT COALESCE(SUM(cost_usd), 0)::float8 FROM llm_calls WHERE created_at >= $1`, since).Scan(&spend)
+	return spend, err
+}
+
+// llmUsageGroups maps report groupings to the column they group by.
+var llmUsageGroups = map[string]string{
+	"day":      "to_char(c.created_at, 'YYYY-MM-DD')",
+	"function": "c.function",
+	"model":    "c.model",
+	"run":      "COALESCE(c.scraping_run_id::text, '')",
+}
+
+// GetLLMUsageReport aggregates LLM calls since the given time by run, day,
+// function or model, most expensive first for functions and models and
+// newest first for runs and days.
+func (p *Postgres) GetLLMUsageReport(ctx context.Context, groupBy string, since time.Time) ([]models.LLMUsageSummary, error) {
+	key, ok := llmUsageGroups[groupBy]
+	if !ok {
+		return nil, fmt.Errorf("unknown grouping %q", groupBy)
+	}
+	order := "cost_usd DESC"
+	if groupBy == "day" || groupBy == "run" {
+		order = "MAX(c.created_at) DESC"
+	}
+	query := `
+		SELECT ` + key + ` AS key,
+			COUNT(*),
+			COUNT(*) FILTER (WHERE NOT c.success),
+			COALESCE(SUM(c.prompt_tokens), 0),
+			COALESCE(SUM(c.completion_tokens), 0),
+			COALESCE(SUM(c.cost_usd), 0)::float8 AS cost_usd,
+			COALESCE(AVG(c.latency_ms), 0)::int,
+			MAX(r.total_listings_saved)
+		FROM llm_calls c
+		LEFT JOIN scraping_runs r ON r.id = c.scraping_run_id
+		WHERE c.created_at >= $1
+		GROUP BY 1
+		ORDER BY ` + order
+	rows, err := p.db.QueryContext(ctx, query, since)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	result := make([]ListingWithValuations, 0, len(listings))
-	for _, l := range listings {
-		listingWithV := ListingWithValuations{Listing: l}
-		if l.ProductID != nil {
-			vals, err := p.GetValuationsForListing(ctx, l.ID)
-			if err != nil {
-				return nil, err
-			}
-			listingWithV.Valuations = vals
-
-			product, err := p.GetProductByID(ctx, *l.ProductID)
-			if err != nil {
-				return nil, err
+	var report []models.LLMUsageSummary
+	for rows.Next() {
+		var s models.LLMUsageSummary
+		var listings sql.NullInt64
+		if err := rows.Scan(&s.Key, &s.Calls, &s.Failures, &s.PromptTokens, &s.CompletionTokens, &s.CostUSD, &s.AvgLatencyMs, &listings); err != nil {
+			return nil, err
+		}
+		if groupBy == "run" && s.Key != "" && listings.Valid {
+			n := int(listings.Int64)
+			s.ListingsSaved = &n
+			if n > 0 {
+				perListing := s.CostUSD / float64(n)
+				s.CostPerListingUSD = &perListing
 			}
-			listingWithV.Product = product
 		}
-		result = append(result, listingWithV)
+		report = append(report, s)
 	}
-	return result, nil
+	return report, rows.Err()
 }
 
-func (p *Postgres) GetLatestValuationByTypeForProduct(ctx context.Context, productID int64) ([]ValuationWithType, error) {
+// SaveListingImageAnalysis stores the analysis of a listing's photos,
+// replacing an earlier one.
+func (p *Postgres) SaveListingImageAnalysis(ctx context.Context, a *models.ListingImageAnalysis) error {
 	query := `
-		SELECT DISTINCT ON (v.valuation_type_id)
-			v.id, v.valuation_type_id, vt.name, v.valuation
-		FROM valuations v
-		JOIN valuation_types vt ON v.valuation_type_id = vt.id
-		WHERE v.product_id = $1
-		ORDER BY v.valuation_type_id, v.created_at DESC
+		INSERT INTO listing_image_analyses (listing_id, condition, model_matches, stock_photo, analysis)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (listing_id) DO UPDATE SET
+			condition = EXCLUDED.condition,
+			model_matches = EXCLUDED.model_matches,
+			stock_photo = EXCLUDED.stock_photo,
+			analysis = EXCLUDED.analysis,
+			created_at = NOW()
+		RETURNING created_at
 	`
-	rows, err := p.db.QueryContext(ctx, query, productID)
+	return p.db.QueryRowContext(ctx, query, a.ListingID, a.Condition, a.ModelMatches, a.StockPhoto, []byte(a.Analysis)).Scan(&a.CreatedAt)
+}
+
+// GetListingImageAnalysis returns the analysis of a listing's photos, or
+// nil when they have not been analysed.
+func (p *Postgres) GetListingImageAnalysis(ctx context.Context, listingID int64) (*models.ListingImageAnalysis, error) {
+	query := `
+		SELECT listing_id, condition, model_matches, stock_photo, analysis, created_at
+		FROM listing_image_analyses
+		WHERE listing_id = $1
+	`
+	var a models.ListingImageAnalysis
+	var analysis []byte
+	err := p.db.QueryRowContext(ctx, query, listingID).Scan(&a.ListingID, &a.Condition, &a.ModelMatches, &a.StockPhoto, &analysis, &a.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.Analysis = analysis
+	return &a, nil
+}
+
+// GetLLMEvalCases returns the labelled ads used to evaluate the LLM
+// functions, oldest first. A limit of zero returns all of them.
+func (p *Postgres) GetLLMEvalCases(ctx context.Context, limit int) ([]models.LLMEvalCase, error) {
+	query := `
+		SELECT id, source, source_ref, ad_text, COALESCE(manufacturer, ''), COALESCE(model, ''),
+			COALESCE(category, ''), COALESCE(condition, ''), new_price, created_at
+		FROM llm_eval_cases
+		ORDER BY id
+	`
+	args := []interface{}{}
+	if limit > 0 {
+		query += ` LIMIT $1`
+		args = append(args, limit)
+	}
+	rows, err := p.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var valuations []ValuationWithType
+	var cases []models.LLMEvalCase
 	for rows.Next() {
-		var v ValuationWithType
-		if err := rows.Scan(&v.ID, &v.ValuationTypeID, &v.ValuationType, &v.Valuation); err != nil {
+		var c models.LLMEvalCase
+		if err := rows.Scan(&c.ID, &c.Source, &c.SourceRef, &c.AdText, &c.Manufacturer, &c.Model,
+			&c.Category, &c.Condition, &c.NewPrice, &c.CreatedAt); err != nil {
 			return nil, err
 		}
-		valuations = append(valuations, v)
+		cases = append(cases, c)
 	}
-	return valuations, rows.Err()
+	return cases, rows.Err()
 }
 
-func (p *Postgres) ComputeWeightedValuationForProduct(ctx context.Context, productID int64) (int, error) {
-	valuations, err := p.GetLatestValuationByTypeForProduct(ctx, productID)
+// SaveLLMEvalCase stores a labelled ad. A case with the same source and
+// reference is relabelled, so importing corrected data again updates it.
+func (p *Postgres) SaveLLMEvalCase(ctx context.Context, c *models.LLMEvalCase) error {
+	query := `
+		INSERT INTO llm_eval_cases (source, source_ref, ad_text, manufacturer, model, category, condition, new_price)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), $8)
+		ON CONFLICT (source, source_ref) DO UPDATE SET
+			ad_text = EXCLUDED.ad_text,
+			manufacturer = EXCLUDED.manufacturer,
+			model = EXCLUDED.model,
+			category = EXCLUDED.category,
+			condition = EXCLUDED.condition,
+			new_price = EXCLUDED.new_price,
+			updated_at = NOW()
+		RETURNING id, created_at
+	`
+	return p.db.QueryRowContext(ctx, query, c.Source, c.SourceRef, c.AdText, c.Manufacturer, c.Model,
+		c.Category, c.Condition, c.NewPrice).Scan(&c.ID, &c.CreatedAt)
+}
+
+// ImportLLMEvalCasesFromListings labels the ads of listings linked to a
+// catalog product with that product, including listings whose product was
+// corrected by hand. It returns how many cases were added or updated.
+func (p *Postgres) ImportLLMEvalCasesFromListings(ctx context.Context) (int64, error) {
+	query := `
+		INSERT INTO llm_eval_cases (source, source_ref, ad_text, manufacturer, model, category, condition, new_price)
+		SELECT 'listing', l.id::TEXT, l.description, p.brand, p.name, p.category, c.title, p.new_price
+		FROM listings l
+		JOIN products p ON p.id = l.product_id
+		LEFT JOIN conditions c ON c.id = l.condition_id
+		WHERE l.description IS NOT NULL AND l.description <> '' AND NOT l.is_my_listing
+		ON CONFLICT (source, source_ref) DO UPDATE SET
+			ad_text = EXCLUDED.ad_text,
+			manufacturer = EXCLUDED.manufacturer,
+			model = EXCLUDED.model,
+			category = EXCLUDED.category,
+			condition = EXCLUDED.condition,
+			new_price = EXCLUDED.new_price,
+			updated_at = NOW()
+	`
+	result, err := p.db.ExecContext(ctx, query)
 	if err != nil {
 		return 0, err
 	}
-	if len(valuations) == 0 {
-		return 0, nil
-	}
+	return result.RowsAffected()
+}
 
-	configs, err := p.GetProductValuationTypeConfigs(ctx, productID)
+// GetPromptTemplates returns every prompt template version stored in the
+// database, with the version selected as active per name.
+func (p *Postgres) GetPromptTemplates(ctx context.Context) ([]models.PromptTemplate, map[string]int, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT name, version, body, created_at FROM prompt_templates ORDER BY name, version`)
 	if err != nil {
-		return 0, err
+		return nil, nil, err
 	}
-	activeMap := make(map[int16]bool)
-	weightMap := make(map[int16]float64)
-	for _, c := range configs {
-		activeMap[c.ValuationTypeID] = c.IsActive
-		weightMap[c.ValuationTypeID] = c.Weight
+	defer rows.Close()
+
+	var templates []models.PromptTemplate
+	for rows.Next() {
+		t := models.PromptTemplate{Source: "db"}
+		if err := rows.Scan(&t.Name, &t.Version, &t.Body, &t.CreatedAt); err != nil {
+			return nil, nil, err
+		}
+		templates = append(templates, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
 	}
 
-	enabledTypes, err := p.GetValuationTypes(ctx)
+	activeRows, err := p.db.QueryContext(ctx, `SELECT name, version FROM prompt_template_active`)
 	if err != nil {
-		return 0, err
+		return nil, nil, err
 	}
-	enabledMap := make(map[int16]bool)
-	for _, t := range enabledTypes {
-		if t.Enabled {
-			enabledMap[t.ID] = true
+	defer activeRows.Close()
+
+	active := make(map[string]int)
+	for activeRows.Next() {
+		var name string
+		var version int
+		if err := activeRows.Scan(&name, &version); err != nil {
+			return nil, nil, err
 		}
+		active[name] = version
 	}
+	return templates, active, activeRows.Err()
+}
 
-	activeCount := 0
-	for _, v := range valuations {
-		if !enabledMap[v.ValuationTypeID] {
-			continue
-		}
-		if isActive, hasConfig := activeMap[v.ValuationTypeID]; hasConfig && !isActive {
+func (p *Postgres) SavePromptTemplate(ctx context.Context, t *models.PromptTemplate) error {
+	query := `
+		INSERT INTO prompt_templates (name, version, body)
+		VALUES ($1, $2, $3)
+		RETURNING created_at
+	`
+	return p.db.QueryRowContext(ctx, query, t.Name, t.Version, t.Body).Scan(&t.CreatedAt)
+}
+
+// SetActivePromptVersion selects the version of a prompt template to use.
+// A zero version clears the selection, so the latest file version is used.
+func (p *Postgres) SetActivePromptVersion(ctx context.Context, name string, version int) error {
+	if version == 0 {
+		_, err := p.db.ExecContext(ctx, `DELETE FROM prompt_template_active WHERE name = $1`, name)
+		return err
+	}
+	query := `
+		INSERT INTO prompt_template_active (name, version, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (name) DO UPDATE SET version = EXCLUDED.version, updated_at = NOW()
+	`
+	_, err := p.db.ExecContext(ctx, query, name, version)
+	return err
+}
+
+func (p *Postgres) GetLLMCacheEntry(ctx context.Context, cacheKey string) (*models.LLMCacheEntry, error) {
+	query := `
+		SELECT cache_key, function, model, prompt_version, response, created_at, expires_at
+		FROM llm_cache
+		WHERE cache_key = $1 AND expires_at > NOW()
+	`
+	var e models.LLMCacheEntry
+	err := p.db.QueryRowContext(ctx, query, cacheKey).Scan(&e.CacheKey, &e.Function, &e.Model, &e.PromptVersion, &e.Response, &e.CreatedAt, &e.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (p *Postgres) SetLLMCacheEntry(ctx context.Context, e *models.LLMCacheEntry) error {
+	query := `
+		INSERT INTO llm_cache (cache_key, function, model, prompt_version, response, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (cache_key) DO UPDATE SET
+			response = EXCLUDED.response,
+			created_at = EXCLUDED.created_at,
+			expires_at = EXCLUDED.expires_at
+	`
+	_, err := p.db.ExecContext(ctx, query, e.CacheKey, e.Function, e.Model, e.PromptVersion, e.Response, e.CreatedAt, e.ExpiresAt)
+	return err
+}
+
+// DeleteLLMCache removes cached responses of a function, or of every
+// function when function is empty. Expired rows are always purged.
+func (p *Postgres) DeleteLLMCache(ctx context.Context, function string) (int64, error) {
+	query := `DELETE FROM llm_cache WHERE $1 = '' OR function = $1 OR expires_at <= NOW()`
+	result, err := p.db.ExecContext(ctx, query, function)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetMarketObservations returns the price points known for a product since
+// the given time: stored valuations from sold prices, asking prices of other
+// sellers' listings and realized sales, oldest first. New-price estimates and
+// supply valuations are left out as they are not market prices. Sale prices
+// are stored in öre and are converted to kronor.
+func (p *Postgres) GetMarketObservations(ctx context.Context, productID int64, since time.Time) ([]models.MarketObservation, error) {
+	query := `
+		SELECT observed_at, price, source FROM (
+			SELECT v.created_at AS observed_at, v.valuation AS price, 'valuation' AS source
+			FROM valuations v
+			JOIN valuation_types vt ON vt.id = v.valuation_type_id
+			WHERE v.product_id = $1 AND v.valuation > 0 AND vt.key IN ` + marketValuationKeys + `
+			UNION ALL
+			SELECT COALESCE(publication_date, created_at), ROUND(` + listingPriceSEK + `)::int, 'ask'
+			FROM listings
+			WHERE product_id = $1 AND price > 0 AND is_my_listing = FALSE AND ` + listingPriceSEK + ` IS NOT NULL
+			UNION ALL
+			SELECT sell_date, sell_price, 'sale'
+			FROM traded_items
+			WHERE product_id = $1 AND sell_price > 0 AND sell_date IS NOT NULL
+		) o
+		WHERE observed_at >= $2
+		ORDER BY observed_at
+	`
+	rows, err := p.db.QueryContext(ctx, query, productID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var observations []models.MarketObservation
+	for rows.Next() {
+		var o models.MarketObservation
+		if err := rows.Scan(&o.ObservedAt, &o.Price, &o.Source); err != nil {
+			return nil, err
+		}
+		if o.Source == "sale" {
+			o.Price = models.Ore(o.Price).MajorInt()
+		}
+		observations = append(observations, o)
+	}
+	return observations, rows.Err()
+}
+
+// marketValuationKeys are the valuation types based on sold prices: our own
+// sales, Tradera and sold ads.
+const marketValuationKeys = `('database', 'tradera', 'marketplace')`
+
+// listingRateSEK is the latest FX rate from a listing's currency to SEK,
+// looked up by the fx_rate function like every other conversion. It is NULL
+// for listings in a currency without a stored rate either way.
+const listingRateSEK = `fx_rate(listings.currency, 'SEK')`
+
+// listingPriceSEK converts a listing's price to SEK with the latest FX rate.
+// It is NULL for listings in a currency without a stored rate.
+const listingPriceSEK = `(listings.price * ` + listingRateSEK + `)`
+
+// ListingInSEK returns a stored listing with its price and shipping cost
+// converted to SEK at the rate listingPriceSEK uses, so profit figures
+// compare SEK with SEK. It is nil when the listing's currency has no rate.
+func (p *Postgres) ListingInSEK(ctx context.Context, l *models.Listing) (*models.Listing, error) {
+	if l.Currency == "" || l.Currency == models.CurrencySEK {
+		return l, nil
+	}
+	var rate sql.NullFloat64
+	err := p.db.QueryRowContext(ctx, `SELECT `+listingRateSEK+` FROM listings WHERE id = $1`, l.ID).Scan(&rate)
+	if err == sql.ErrNoRows || (err == nil && !rate.Valid) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sek := *l
+	sek.Currency = models.CurrencySEK
+	convert := func(v *int) *int {
+		if v == nil {
+			return nil
+		}
+		converted := models.FromMajor(float64(*v), l.Currency).Mul(rate.Float64).MajorInt()
+		return &converted
+	}
+	sek.Price = convert(l.Price)
+	sek.ShippingCost = convert(l.ShippingCost)
+	return &sek, nil
+}
+
+// GetSupplySnapshot summarizes other sellers' active listings of a product
+// published since the given time, with prices in SEK. BelowSell counts
+// listings priced under sellPrice; it is zero when sellPrice is zero. The
+// listing excludeListingID, the one being evaluated, is not its own
+// competitor; 0 excludes nothing.
+func (p *Postgres) GetSupplySnapshot(ctx context.Context, productID int64, sellPrice int, since time.Time, excludeListingID int64) (*models.SupplySnapshot, error) {
+	query := `
+		SELECT
+			COUNT(*),
+			COUNT(DISTINCT marketplace_id),
+			COALESCE(MIN(price_sek), 0),
+			COALESCE(percentile_cont(0.25) WITHIN GROUP (ORDER BY price_sek), 0),
+			COALESCE(percentile_cont(0.50) WITHIN GROUP (ORDER BY price_sek), 0),
+			COALESCE(percentile_cont(0.75) WITHIN GROUP (ORDER BY price_sek), 0),
+			COALESCE(MAX(price_sek), 0),
+			COUNT(*) FILTER (WHERE price_sek < $2)
+		FROM (
+			SELECT marketplace_id, ` + listingPriceSEK + ` AS price_sek
+			FROM listings
+			WHERE product_id = $1
+				AND status = 'active'
+				AND is_my_listing = FALSE
+				AND price > 0
+				AND COALESCE(publication_date, created_at) >= $3
+				AND id <> $4
+		) l
+		WHERE price_sek IS NOT NULL
+	`
+	snapshot := models.SupplySnapshot{ProductID: productID, SellPrice: sellPrice, ComputedAt: time.Now()}
+	err := p.db.QueryRowContext(ctx, query, productID, sellPrice, since, excludeListingID).Scan(
+		&snapshot.ActiveCount, &snapshot.Marketplaces, &snapshot.MinPrice,
+		&snapshot.P25, &snapshot.P50, &snapshot.P75, &snapshot.MaxPrice, &snapshot.BelowSell,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// GetFXRate returns the price of one unit of base in quote: the most recent
+// base/quote rate, or the inverse of the most recent quote/base rate. It is
+// 0 when neither is stored. The fx_rate function does the lookup, so the
+// rate is the same one listing queries convert with.
+func (p *Postgres) GetFXRate(ctx context.Context, base, quote models.Currency) (float64, error) {
+	var rate sql.NullFloat64
+	if err := p.db.QueryRowContext(ctx, `SELECT fx_rate($1, $2)`, base, quote).Scan(&rate); err != nil {
+		return 0, err
+	}
+	return rate.Float64, nil
+}
+
+// GetFXRates returns the current rate of every stored currency pair.
+func (p *Postgres) GetFXRates(ctx context.Context) ([]models.FXRate, error) {
+	query := `
+		SELECT DISTINCT ON (base, quote) id, base, quote, rate, valid_from
+		FROM fx_rates
+		WHERE valid_from <= NOW()
+		ORDER BY base, quote, valid_from DESC
+	`
+	rows, err := p.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []models.FXRate
+	for rows.Next() {
+		var r models.FXRate
+		if err := rows.Scan(&r.ID, &r.Base, &r.Quote, &r.Rate, &r.ValidFrom); err != nil {
+			return nil, err
+		}
+		rates = append(rates, r)
+	}
+	return rates, rows.Err()
+}
+
+func (p *Postgres) SaveFXRate(ctx context.Context, r *models.FXRate) error {
+	if r.ValidFrom.IsZero() {
+		r.ValidFrom = time.Now()
+	}
+	query := `
+		INSERT INTO fx_rates (base, quote, rate, valid_from)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+	return p.db.QueryRowContext(ctx, query, r.Base, r.Quote, r.Rate, r.ValidFrom).Scan(&r.ID)
+}
+
+func jsonOrDefault(raw json.RawMessage, def string) []byte {
+	if len(raw) == 0 {
+		return []byte(def)
+	}
+	return raw
+}
+
+func (p *Postgres) UpdateValuation(ctx context.Context, id int64, valuation int) (int64, error) {
+	query := `UPDATE valuations SET valuation = $1 WHERE id = $2`
+	res, err := p.db.ExecContext(ctx, query, valuation, id)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return rows, nil
+}
+
+type ListingWithValuations struct {
+	Listing    models.Listing
+	Product    *models.Product
+	Valuations []ValuationWithType
+}
+
+func (p *Postgres) GetListingsWithValuations(ctx context.Context) ([]ListingWithValuations, error) {
+	listings, err := p.GetAllListings(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ListingWithValuations, 0, len(listings))
+	for _, l := range listings {
+		listingWithV := ListingWithValuations{Listing: l}
+		if l.ProductID != nil {
+			vals, err := p.GetValuationsForListing(ctx, l.ID)
+			if err != nil {
+				return nil, err
+			}
+			listingWithV.Valuations = vals
+
+			product, err := p.GetProductByID(ctx, *l.ProductID)
+			if err != nil {
+				return nil, err
+			}
+			listingWithV.Product = product
+		}
+		result = append(result, listingWithV)
+	}
+	return result, nil
+}
+
+func (p *Postgres) GetLatestValuationByTypeForProduct(ctx context.Context, productID int64) ([]ValuationWithType, error) {
+	query := `
+		SELECT DISTINCT ON (v.valuation_type_id)
+			v.id, v.valuation_type_id, vt.name, v.valuation
+		FROM valuations v
+		JOIN valuation_types vt ON v.valuation_type_id = vt.id
+		WHERE v.product_id = $1
+		ORDER BY v.valuation_type_id, v.created_at DESC
+	`
+	rows, err := p.db.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var valuations []ValuationWithType
+	for rows.Next() {
+		var v ValuationWithType
+		if err := rows.Scan(&v.ID, &v.ValuationTypeID, &v.ValuationType, &v.Valuation); err != nil {
+			return nil, err
+		}
+		valuations = append(valuations, v)
+	}
+	return valuations, rows.Err()
+}
+
+func (p *Postgres) ComputeWeightedValuationForProduct(ctx context.Context, productID int64) (int, error) {
+	valuations, err := p.GetLatestValuationByTypeForProduct(ctx, productID)
+	if err != nil {
+		return 0, err
+	}
+	if len(valuations) == 0 {
+		return 0, nil
+	}
+
+	configs, err := p.GetProductValuationTypeConfigs(ctx, productID)
+	if err != nil {
+		return 0, err
+	}
+	activeMap := make(map[int16]bool)
+	weightMap := make(map[int16]float64)
+	for _, c := range configs {
+		activeMap[c.ValuationTypeID] = c.IsActive
+		weightMap[c.ValuationTypeID] = c.Weight
+	}
+
+	enabledTypes, err := p.GetValuationTypes(ctx)
+	if err != nil {
+		return 0, err
+	}
+	enabledMap := make(map[int16]bool)
+	for _, t := range enabledTypes {
+		if t.Enabled {
+			enabledMap[t.ID] = true
+		}
+	}
+
+	activeCount := 0
+	for _, v := range valuations {
+		if !enabledMap[v.ValuationTypeID] {
+			continue
+		}
+		if isActive, hasConfig := activeMap[v.ValuationTypeID]; hasConfig && !isActive {
 			continue
 		}
 		activeCount++
@@ -1246,10 +2137,28 @@ func (p *Postgres) ComputeWeightedValuationForProduct(ctx context.Context, produ
 	return int(total / totalWeight), nil
 }
 
-func (p *Postgres) GetTradingRules(ctx context.Context) (*models.Economics, error) {
-	query := `SELECT id, min_profit_sek, min_discount FROM trading_rules LIMIT 1`
+// tradingRuleColumns are the columns scanned by scanTradingRule.
+const tradingRuleColumns = `id, COALESCE(name, ''), min_profit_sek, min_discount, profit_percentile, min_percentile_profit_sek,
+	category, product_id, marketplace_id, search_term_id, priority, expression`
+
+// tradingRuleGlobal matches the rule sets without a scope.
+const tradingRuleGlobal = `category IS NULL AND product_id IS NULL AND marketplace_id IS NULL AND search_term_id IS NULL`
+
+func scanTradingRule(row interface{ Scan(...interface{}) error }) (*models.Economics, error) {
 	var rules models.Economics
-	err := p.db.QueryRowContext(ctx, query).Scan(&rules.ID, &rules.MinProfitSEK, &rules.MinDiscount)
+	err := row.Scan(&rules.ID, &rules.Name, &rules.MinProfitSEK, &rules.MinDiscount, &rules.ProfitPercentile, &rules.MinPercentileProfitSEK,
+		&rules.Category, &rules.ProductID, &rules.MarketplaceID, &rules.SearchTermID, &rules.Priority, &rules.Expression)
+	if err != nil {
+		return nil, err
+	}
+	return &rules, nil
+}
+
+// GetTradingRules returns the global trading rule set, the fallback for
+// listings no scoped rule set matches.
+func (p *Postgres) GetTradingRules(ctx context.Context) (*models.Economics, error) {
+	query := `SELECT ` + tradingRuleColumns + ` FROM trading_rules WHERE ` + tradingRuleGlobal + ` ORDER BY id LIMIT 1`
+	rules, err := scanTradingRule(p.db.QueryRowContext(ctx, query))
 	if err == sql.ErrNoRows {
 		fmt.Println("GetTradingRules: No rules found in database, using defaults")
 		return &models.Economics{
@@ -1261,25 +2170,44 @@ func (p *Postgres) GetTradingRules(ctx context.Context) (*models.Economics, erro
 		return nil, err
 	}
 	fmt.Printf("GetTradingRules: id=%d, min_profit_sek=%v, min_discount=%v\n", rules.ID, rules.MinProfitSEK, rules.MinDiscount)
-	return &rules, nil
+	return rules, nil
 }
 
 func intPtr(i int) *int {
 	return &i
 }
 
+// ptrFloat returns *f, or 0 when f is nil.
+func ptrFloat(f *float64) float64 {
+	if f == nil {
+		return 0
+	}
+	return *f
+}
+
+// SaveTradingRules saves the global trading rule set. Scoped rule sets are
+// saved with CreateTradingRuleSet and UpdateTradingRuleSet.
 func (p *Postgres) SaveTradingRules(ctx context.Context, rules *models.Economics) error {
 	var minProfit interface{} = nil
 	var minDiscount interface{} = nil
+	var profitPercentile interface{} = nil
+	var minPercentileProfit interface{} = nil
 	if rules.MinProfitSEK != nil {
 		minProfit = *rules.MinProfitSEK
 	}
 	if rules.MinDiscount != nil {
 		minDiscount = *rules.MinDiscount
 	}
+	if rules.ProfitPercentile != nil {
+		profitPercentile = *rules.ProfitPercentile
+	}
+	if rules.MinPercentileProfitSEK != nil {
+		minPercentileProfit = *rules.MinPercentileProfitSEK
+	}
 
 	// Try update first
-	res, err := p.db.ExecContext(ctx, `UPDATE trading_rules SET min_profit_sek = $1, min_discount = $2`, minProfit, minDiscount)
+	res, err := p.db.ExecContext(ctx, `UPDATE trading_rules SET min_profit_sek = $1, min_discount = $2, profit_percentile = $3, min_percentile_profit_sek = $4, expression = $5 WHERE `+tradingRuleGlobal,
+		minProfit, minDiscount, profitPercentile, minPercentileProfit, rules.Expression)
 	if err != nil {
 		return err
 	}
@@ -1289,7 +2217,8 @@ func (p *Postgres) SaveTradingRules(ctx context.Context, rules *models.Economics
 
 	// No rows updated -> insert a new row
 	var id int64
-	err = p.db.QueryRowContext(ctx, `INSERT INTO trading_rules (min_profit_sek, min_discount) VALUES ($1, $2) RETURNING id`, minProfit, minDiscount).Scan(&id)
+	err = p.db.QueryRowContext(ctx, `INSERT INTO trading_rules (min_profit_sek, min_discount, profit_percentile, min_percentile_profit_sek, expression) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		minProfit, minDiscount, profitPercentile, minPercentileProfit, rules.Expression).Scan(&id)
 	if err != nil {
 		return err
 	}
@@ -1297,6 +2226,105 @@ func (p *Postgres) SaveTradingRules(ctx context.Context, rules *models.Economics
 	return nil
 }
 
+// GetTradingRuleSets returns every trading rule set, global and scoped,
+// highest priority first.
+func (p *Postgres) GetTradingRuleSets(ctx context.Context) ([]models.Economics, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT `+tradingRuleColumns+` FROM trading_rules ORDER BY priority DESC, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sets []models.Economics
+	for rows.Next() {
+		rules, err := scanTradingRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, *rules)
+	}
+	return sets, rows.Err()
+}
+
+func (p *Postgres) GetTradingRuleSet(ctx context.Context, id int64) (*models.Economics, error) {
+	rules, err := scanTradingRule(p.db.QueryRowContext(ctx, `SELECT `+tradingRuleColumns+` FROM trading_rules WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rules, err
+}
+
+func (p *Postgres) CreateTradingRuleSet(ctx context.Context, rules *models.Economics) error {
+	query := `
+		INSERT INTO trading_rules (name, min_profit_sek, min_discount, profit_percentile, min_percentile_profit_sek,
+			category, product_id, marketplace_id, search_term_id, priority, expression)
+		VALUES (NULLIF($1, ''), $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id
+	`
+	return p.db.QueryRowContext(ctx, query,
+		rules.Name, rules.MinProfitSEK, rules.MinDiscount, rules.ProfitPercentile, rules.MinPercentileProfitSEK,
+		rules.Category, rules.ProductID, rules.MarketplaceID, rules.SearchTermID, rules.Priority, rules.Expression,
+	).Scan(&rules.ID)
+}
+
+func (p *Postgres) UpdateTradingRuleSet(ctx context.Context, rules *models.Economics) error {
+	query := `
+		UPDATE trading_rules
+		SET name = NULLIF($1, ''), min_profit_sek = $2, min_discount = $3, profit_percentile = $4, min_percentile_profit_sek = $5,
+			category = $6, product_id = $7, marketplace_id = $8, search_term_id = $9, priority = $10, expression = $11
+		WHERE id = $12
+	`
+	result, err := p.db.ExecContext(ctx, query,
+		rules.Name, rules.MinProfitSEK, rules.MinDiscount, rules.ProfitPercentile, rules.MinPercentileProfitSEK,
+		rules.Category, rules.ProductID, rules.MarketplaceID, rules.SearchTermID, rules.Priority, rules.Expression, rules.ID,
+	)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (p *Postgres) DeleteTradingRuleSet(ctx context.Context, id int64) error {
+	result, err := p.db.ExecContext(ctx, `DELETE FROM trading_rules WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TradingRuleScopeFor describes a listing for choosing its trading rule
+// set. product may be nil when the listing has no product.
+func TradingRuleScopeFor(listing *models.Listing, product *models.Product) models.TradingRuleScope {
+	var scope models.TradingRuleScope
+	if listing.ProductID != nil {
+		scope.ProductID = *listing.ProductID
+	}
+	if listing.MarketplaceID != nil {
+		scope.MarketplaceID = *listing.MarketplaceID
+	}
+	if listing.SearchTermID != nil {
+		scope.SearchTermID = *listing.SearchTermID
+	}
+	if product != nil && product.Category != nil {
+		scope.Category = *product.Category
+	}
+	return scope
+}
+
 type ListingWithProfit struct {
 	Listing           models.Listing
 	Product           *models.Product
@@ -1304,7 +2332,21 @@ type ListingWithProfit struct {
 	PotentialProfit   int
 	DiscountPercent   float64
 	ComputedValuation int
-}
+	Supply            *models.SupplySnapshot
+	// Profit is how PotentialProfit was computed, fees and costs included.
+	Profit *models.ProfitBreakdown
+	// ExposureViolations are the exposure limits buying the listing would
+	// break, after the better ranked potential listings. Rank orders
+	// potential listings: those within the limits first, then by
+	// opportunity score and profit.
+	ExposureViolations []string
+	Rank               int
+	// MatchedRule is the trading rule set the listing was evaluated by.
+	MatchedRule *models.Economics
+}
+
+// SupplyWindow is how far back listings count as current supply.
+const SupplyWindow = 30 * 24 * time.Hour
 
 func (p *Postgres) GetListingsWithProfit(ctx context.Context) ([]ListingWithProfit, error) {
 	listings, err := p.GetAllListings(ctx)
@@ -1313,31 +2355,46 @@ func (p *Postgres) GetListingsWithProfit(ctx context.Context) ([]ListingWithProf
 	}
 
 	result := make([]ListingWithProfit, 0, len(listings))
+	// Listings of the same product share its valuation and product row.
+	computedVals := make(map[int64]int)
+	products := make(map[int64]*models.Product)
 
 	for _, l := range listings {
 		listingWithP := ListingWithProfit{Listing: l}
 
 		computedVal := 0
+		var product *models.Product
 		if l.ProductID != nil {
-			var cvErr error
-			computedVal, cvErr = p.ComputeWeightedValuationForProduct(ctx, *l.ProductID)
-			if cvErr != nil {
-				log.Printf("GetListingsWithProfit: failed to compute valuation for product %d: %v", *l.ProductID, cvErr)
+			var ok bool
+			if computedVal, ok = computedVals[*l.ProductID]; !ok {
+				var cvErr error
+				computedVal, cvErr = p.ComputeWeightedValuationForProduct(ctx, *l.ProductID)
+				if cvErr != nil {
+					log.Printf("GetListingsWithProfit: failed to compute valuation for product %d: %v", *l.ProductID, cvErr)
+				}
+				computedVals[*l.ProductID] = computedVal
+			}
+			if product, ok = products[*l.ProductID]; !ok {
+				product, err = p.GetProductByID(ctx, *l.ProductID)
+				if err != nil {
+					return nil, err
+				}
+				products[*l.ProductID] = product
 			}
 		}
 		listingWithP.ComputedValuation = computedVal
 
-		if l.Price != nil {
-			shippingCost := 0
-			if l.ShippingCost != nil {
-				shippingCost = *l.ShippingCost
-			}
-			profit := computedVal - *l.Price - shippingCost
-			listingWithP.PotentialProfit = profit
-
-			if computedVal > 0 {
-				listingWithP.DiscountPercent = float64(profit) / float64(computedVal) * 100
-			}
+		sek, err := p.ListingInSEK(ctx, &l)
+		if err != nil {
+			return nil, err
+		}
+		if sek == nil {
+			log.Printf("GetListingsWithProfit: no FX rate for listing %d in %s", l.ID, l.Currency)
+		} else if sek.Price != nil {
+			profit := p.costs.ListingProfit(sek, product, computedVal)
+			listingWithP.PotentialProfit = profit.NetProfit
+			listingWithP.DiscountPercent = profit.ProfitPercent()
+			listingWithP.Profit = &profit
 		}
 
 		if l.ProductID != nil {
@@ -1346,89 +2403,58 @@ func (p *Postgres) GetListingsWithProfit(ctx context.Context) ([]ListingWithProf
 				return nil, err
 			}
 			listingWithP.Valuations = vals
+			listingWithP.Product = product
 
-			product, err := p.GetProductByID(ctx, *l.ProductID)
+			supply, err := p.GetSupplySnapshot(ctx, *l.ProductID, computedVal, time.Now().Add(-SupplyWindow), l.ID)
 			if err != nil {
-				return nil, err
+				log.Printf("failed to compute supply for product %d: %v", *l.ProductID, err)
 			}
-			listingWithP.Product = product
+			listingWithP.Supply = supply
 		}
 		result = append(result, listingWithP)
 	}
 	return result, nil
 }
 
-func (p *Postgres) GetPotentialListings(ctx context.Context) ([]ListingWithProfit, error) {
-	listings, err := p.GetAllListings(ctx)
+// RankByExposure ranks potential listings by opportunity score, then
+// profit, and takes them in that order against the exposure limits, as if
+// each were bought. Listings that would break a limit are flagged and
+// ranked after those that fit.
+func (p *Postgres) RankByExposure(ctx context.Context, listings []ListingWithProfit) error {
+	exposure, err := p.GetExposure(ctx)
 	if err != nil {
-		return nil, err
-	}
-
-	result := make([]ListingWithProfit, 0, len(listings))
-
-	rules, err := p.GetTradingRules(ctx)
-	if err != nil {
-		return nil, err
-	}
-	minProfit := 0
-	if rules.MinProfitSEK != nil {
-		minProfit = *rules.MinProfitSEK
-	}
-	minDiscount := 0
-	if rules.MinDiscount != nil {
-		minDiscount = *rules.MinDiscount
+		return err
 	}
-	_ = minProfit
-	_ = minDiscount
-
-	for _, l := range listings {
-		listingWithP := ListingWithProfit{Listing: l}
-
-		computedVal := 0
-		if l.ProductID != nil {
-			var cvErr error
-			computedVal, cvErr = p.ComputeWeightedValuationForProduct(ctx, *l.ProductID)
-			if cvErr != nil {
-				log.Printf("GetPotentialListings: failed to compute valuation for product %d: %v", *l.ProductID, cvErr)
-			}
+	sort.SliceStable(listings, func(i, j int) bool {
+		si, sj := ptrFloat(listings[i].Listing.OpportunityScore), ptrFloat(listings[j].Listing.OpportunityScore)
+		if si != sj {
+			return si > sj
 		}
-
-		if l.Price != nil && computedVal > 0 {
-			shippingCost := 0
-			if l.ShippingCost != nil {
-				shippingCost = *l.ShippingCost
-			}
-			profit := computedVal - *l.Price - shippingCost
-			discountPercent := float64(profit) / float64(computedVal) * 100
-
-			if profit >= minProfit && discountPercent >= float64(minDiscount) {
-				listingWithP.PotentialProfit = profit
-				listingWithP.DiscountPercent = discountPercent
-				listingWithP.ComputedValuation = computedVal
-				fmt.Printf("Listing %d passes: profit=%d, discount=%.1f%%\n", l.ID, profit, discountPercent)
-			} else {
-				continue
-			}
-		} else {
-			continue
+		return listings[i].PotentialProfit > listings[j].PotentialProfit
+	})
+	for i := range listings {
+		l := &listings[i]
+		category := ""
+		if l.Product != nil && l.Product.Category != nil {
+			category = *l.Product.Category
 		}
-
-		if l.ProductID != nil {
-			vals, err := p.GetValuationsForListing(ctx, l.ID)
-			if err != nil {
-				return nil, err
-			}
-			listingWithP.Valuations = vals
-
-			product, err := p.GetProductByID(ctx, *l.ProductID)
-			if err != nil {
-				return nil, err
-			}
-			listingWithP.Product = product
+		cost := 0
+		if l.Profit != nil {
+			cost = l.Profit.BuyPrice + l.Profit.BuyShipping
+		}
+		l.ExposureViolations = exposure.Violations(l.Listing.ProductID, category, cost)
+		if len(l.ExposureViolations) == 0 {
+			exposure.Add(l.Listing.ProductID, category, cost)
+			exposure.Spend(cost)
 		}
-		result = append(result, listingWithP)
 	}
-	return result, nil
+	sort.SliceStable(listings, func(i, j int) bool {
+		return len(listings[i].ExposureViolations) == 0 && len(listings[j].ExposureViolations) > 0
+	})
+	for i := range listings {
+		listings[i].Rank = i + 1
+	}
+	return nil
 }
 
 func (p *Postgres) SaveScrapingRun(ctx context.Context, run *models.ScrapingRun) error {
@@ -1445,18 +2471,24 @@ func (p *Postgres) SaveScrapingRun(ctx context.Context, run *models.ScrapingRun)
 func (p *Postgres) UpdateScrapingRun(ctx context.Context, run *models.ScrapingRun) error {
 	query := `
 		UPDATE scraping_runs 
-		SET completed_at = $1, status = $2, total_ads_found = $3, total_listings_saved = $4, error_message = $5
-		WHERE id = $6
+		SET completed_at = $1, status = $2, total_ads_found = $3, total_listings_saved = $4, error_message = $5, extraction_failures = $6,
+			llm_cache_hits = $7, llm_cache_misses = $8, cascade_stats = $9
+		WHERE id = $10
 	`
+	var cascadeStats interface{}
+	if len(run.CascadeStats) > 0 {
+		cascadeStats = []byte(run.CascadeStats)
+	}
 	_, err := p.db.ExecContext(ctx, query,
-		run.CompletedAt, run.Status, run.TotalAdsFound, run.TotalListingsSaved, run.ErrorMessage, run.ID,
+		run.CompletedAt, run.Status, run.TotalAdsFound, run.TotalListingsSaved, run.ErrorMessage, run.ExtractionFailures,
+		run.LLMCacheHits, run.LLMCacheMisses, cascadeStats, run.ID,
 	)
 	return err
 }
 
 func (p *Postgres) GetScrapingRuns(ctx context.Context, limit, offset int) ([]models.ScrapingRun, error) {
 	query := `
-		SELECT id, started_at, completed_at, status, total_ads_found, total_listings_saved, total_good_buys, error_message, created_at
+		SELECT id, started_at, completed_at, status, total_ads_found, total_listings_saved, total_good_buys, COALESCE(extraction_failures, 0), COALESCE(llm_cache_hits, 0), COALESCE(llm_cache_misses, 0), cascade_stats, error_message, created_at
 		FROM scraping_runs
 		ORDER BY started_at DESC
 		LIMIT $1 OFFSET $2
@@ -1470,9 +2502,11 @@ func (p *Postgres) GetScrapingRuns(ctx context.Context, limit, offset int) ([]mo
 	var runs []models.ScrapingRun
 	for rows.Next() {
 		var run models.ScrapingRun
-		if err := rows.Scan(&run.ID, &run.StartedAt, &run.CompletedAt, &run.Status, &run.TotalAdsFound, &run.TotalListingsSaved, &run.TotalGoodBuys, &run.ErrorMessage, &run.CreatedAt); err != nil {
+		var cascadeStats []byte
+		if err := rows.Scan(&run.ID, &run.StartedAt, &run.CompletedAt, &run.Status, &run.TotalAdsFound, &run.TotalListingsSaved, &run.TotalGoodBuys, &run.ExtractionFailures, &run.LLMCacheHits, &run.LLMCacheMisses, &cascadeStats, &run.ErrorMessage, &run.CreatedAt); err != nil {
 			return nil, err
 		}
+		run.CascadeStats = cascadeStats
 		runs = append(runs, run)
 	}
 	return runs, rows.Err()
@@ -1619,24 +2653,24 @@ func (p *Postgres) UpdateConversationStatus(ctx context.Context, id int64, statu
 // Message methods
 func (p *Postgres) CreateMessage(ctx context.Context, msg *models.Message) error {
 	query := `
-		INSERT INTO messages (conversation_id, direction, content, status)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO messages (conversation_id, direction, content, status, prompt_version)
+		VALUES ($1, $2, $3, $4, $5)
 		RETURNING id, created_at, updated_at
 	`
-	return p.db.QueryRowContext(ctx, query, msg.ConversationID, msg.Direction, msg.Content, msg.Status).
+	return p.db.QueryRowContext(ctx, query, msg.ConversationID, msg.Direction, msg.Content, msg.Status, msg.PromptVersion).
 		Scan(&msg.ID, &msg.CreatedAt, &msg.UpdatedAt)
 }
 
 func (p *Postgres) GetMessageByID(ctx context.Context, id int64) (*models.Message, error) {
 	query := `
-		SELECT id, conversation_id, direction, content, status, approved_at, sent_at, created_at, updated_at
+		SELECT id, conversation_id, direction, content, status, prompt_version, approved_at, sent_at, created_at, updated_at
 		FROM messages
 		WHERE id = $1
 	`
 	var msg models.Message
 	err := p.db.QueryRowContext(ctx, query, id).Scan(
 		&msg.ID, &msg.ConversationID, &msg.Direction, &msg.Content, &msg.Status,
-		&msg.ApprovedAt, &msg.SentAt, &msg.CreatedAt, &msg.UpdatedAt,
+		&msg.PromptVersion, &msg.ApprovedAt, &msg.SentAt, &msg.CreatedAt, &msg.UpdatedAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -1649,7 +2683,7 @@ func (p *Postgres) GetMessageByID(ctx context.Context, id int64) (*models.Messag
 
 func (p *Postgres) GetMessagesByConversationID(ctx context.Context, conversationID int64) ([]models.Message, error) {
 	query := `
-		SELECT id, conversation_id, direction, content, status, approved_at, sent_at, created_at, updated_at
+		SELECT id, conversation_id, direction, content, status, prompt_version, approved_at, sent_at, created_at, updated_at
 		FROM messages
 		WHERE conversation_id = $1
 		ORDER BY created_at ASC
@@ -1665,7 +2699,7 @@ func (p *Postgres) GetMessagesByConversationID(ctx context.Context, conversation
 		var msg models.Message
 		err := rows.Scan(
 			&msg.ID, &msg.ConversationID, &msg.Direction, &msg.Content, &msg.Status,
-			&msg.ApprovedAt, &msg.SentAt, &msg.CreatedAt, &msg.UpdatedAt,
+			&msg.PromptVersion, &msg.ApprovedAt, &msg.SentAt, &msg.CreatedAt, &msg.UpdatedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -1698,3 +2732,165 @@ func (p *Postgres) UpdateMessageContent(ctx context.Context, id int64, content s
 	_, err := p.db.ExecContext(ctx, query, content, id)
 	return err
 }
+
+// SaveReviewItem parks an ad for review. An ad already in the queue is left
+// as it is.
+func (p *Postgres) SaveReviewItem(ctx context.Context, item *models.ReviewItem) error {
+	candidates, err := json.Marshal(item.Candidates)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO review_queue (link, reason, ad, extracted, confidence, candidates, prompt_version, llm_model)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (link) DO NOTHING
+		RETURNING id, status, created_at
+	`
+	err = p.db.QueryRowContext(ctx, query, item.Link, item.Reason, []byte(item.Ad), []byte(item.Extracted),
+		item.Confidence, candidates, item.PromptVersion, item.LLMModel).Scan(&item.ID, &item.Status, &item.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	return err
+}
+
+// ReviewItemExistsByLink reports whether an ad has been parked for review,
+// whatever the outcome.
+func (p *Postgres) ReviewItemExistsByLink(ctx context.Context, link string) (bool, error) {
+	var exists bool
+	err := p.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM review_queue WHERE link = $1)`, link).Scan(&exists)
+	return exists, err
+}
+
+const reviewItemColumns = `id, link, reason, ad, extracted, confidence, candidates, prompt_version, llm_model, status, product_id, listing_id, created_at, resolved_at`
+
+func scanReviewItem(scan func(dest ...interface{}) error) (*models.ReviewItem, error) {
+	var item models.ReviewItem
+	var ad, extracted, candidates []byte
+	if err := scan(&item.ID, &item.Link, &item.Reason, &ad, &extracted, &item.Confidence, &candidates,
+		&item.PromptVersion, &item.LLMModel, &item.Status, &item.ProductID, &item.ListingID, &item.CreatedAt, &item.ResolvedAt); err != nil {
+		return nil, err
+	}
+	item.Ad = ad
+	item.Extracted = extracted
+	if err := json.Unmarshal(candidates, &item.Candidates); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// GetReviewItems returns the queue, oldest first. An empty status returns
+// every item.
+func (p *Postgres) GetReviewItems(ctx context.Context, status string) ([]models.ReviewItem, error) {
+	query := `SELECT ` + reviewItemColumns + ` FROM review_queue WHERE ($1 = '' OR status = $1) ORDER BY created_at, id`
+	rows, err := p.db.QueryContext(ctx, query, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []models.ReviewItem
+	for rows.Next() {
+		item, err := scanReviewItem(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *item)
+	}
+	return items, rows.Err()
+}
+
+// GetReviewItem returns an item of the queue, or nil if there is none.
+func (p *Postgres) GetReviewItem(ctx context.Context, id int64) (*models.ReviewItem, error) {
+	row := p.db.QueryRowContext(ctx, `SELECT `+reviewItemColumns+` FROM review_queue WHERE id = $1`, id)
+	item, err := scanReviewItem(row.Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return item, err
+}
+
+// ResolveReviewItem records the outcome of a review. It returns false when
+// the item is no longer pending.
+func (p *Postgres) ResolveReviewItem(ctx context.Context, id int64, status string, productID, listingID *int64) (bool, error) {
+	query := `
+		UPDATE review_queue
+		SET status = $2, product_id = $3, listing_id = $4, resolved_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+	`
+	result, err := p.db.ExecContext(ctx, query, id, status, productID, listingID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetLLMEvalCasesBySource returns the labelled ads from the given sources,
+// newest first.
+func (p *Postgres) GetLLMEvalCasesBySource(ctx context.Context, sources []string) ([]models.LLMEvalCase, error) {
+	if len(sources) == 0 {
+		return nil, nil
+	}
+	placeholders := make([]string, len(sources))
+	args := make([]interface{}, len(sources))
+	for i, source := range sources {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = source
+	}
+	query := `
+		SELECT id, source, source_ref, ad_text, COALESCE(manufacturer, ''), COALESCE(model, ''),
+			COALESCE(category, ''), COALESCE(condition, ''), new_price, created_at
+		FROM llm_eval_cases
+		WHERE source IN (` + strings.Join(placeholders, ", ") + `)
+		ORDER BY updated_at DESC, id DESC
+	`
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cases []models.LLMEvalCase
+	for rows.Next() {
+		var c models.LLMEvalCase
+		if err := rows.Scan(&c.ID, &c.Source, &c.SourceRef, &c.AdText, &c.Manufacturer, &c.Model,
+			&c.Category, &c.Condition, &c.NewPrice, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		cases = append(cases, c)
+	}
+	return cases, rows.Err()
+}
+
+// GetRepricingNotifications returns the last price suggestion emailed for
+// each listing and item.
+func (p *Postgres) GetRepricingNotifications(ctx context.Context) ([]models.RepricingNotification, error) {
+	rows, err := p.db.QueryContext(ctx, `SELECT kind, item_id, suggested_price, notified_at FROM repricing_notifications`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []models.RepricingNotification
+	for rows.Next() {
+		var n models.RepricingNotification
+		if err := rows.Scan(&n.Kind, &n.ItemID, &n.SuggestedPrice, &n.NotifiedAt); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// SaveRepricingNotification records that a price suggestion was emailed,
+// replacing the previous one for the same listing or item.
+func (p *Postgres) SaveRepricingNotification(ctx context.Context, n models.RepricingNotification) error {
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO repricing_notifications (kind, item_id, suggested_price, notified_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (kind, item_id) DO UPDATE
+		SET suggested_price = EXCLUDED.suggested_price, notified_at = EXCLUDED.notified_at
+	`, n.Kind, n.ItemID, n.SuggestedPrice)
+	return err
+}
@@ -0,0 +1,109 @@
+package models
+
+import "encoding/json"
+
+// Amounts are stored as plain integers with a unit fixed per column:
+// listing prices and shipping in whole units of the listing's currency,
+// valuations in whole SEK and traded item amounts in öre. The methods
+// below turn them into Money, and the JSON of each model carries the same
+// amounts as Money next to the plain fields.
+
+// PriceMoney returns the asking price in the listing's currency.
+func (l Listing) PriceMoney() (Money, bool) {
+	if l.Price == nil {
+		return Money{}, false
+	}
+	return FromMajor(float64(*l.Price), l.Currency), true
+}
+
+// ShippingMoney returns the shipping cost in the listing's currency.
+func (l Listing) ShippingMoney() (Money, bool) {
+	if l.ShippingCost == nil {
+		return Money{}, false
+	}
+	return FromMajor(float64(*l.ShippingCost), l.Currency), true
+}
+
+// ValuationMoney returns the valuation stored with the listing.
+func (l Listing) ValuationMoney() Money {
+	return SEK(l.Valuation)
+}
+
+// MarshalJSON adds the price, shipping cost and valuation as money.
+func (l Listing) MarshalJSON() ([]byte, error) {
+	type plain Listing
+	out := struct {
+		plain
+		PriceMoney     *Money `json:"price_money,omitempty"`
+		ShippingMoney  *Money `json:"shipping_cost_money,omitempty"`
+		ValuationMoney Money  `json:"valuation_money"`
+	}{plain: plain(l), ValuationMoney: l.ValuationMoney()}
+	if m, ok := l.PriceMoney(); ok {
+		out.PriceMoney = &m
+	}
+	if m, ok := l.ShippingMoney(); ok {
+		out.ShippingMoney = &m
+	}
+	return json.Marshal(out)
+}
+
+// BuyPriceMoney returns what the item was bought for.
+func (t TradedItem) BuyPriceMoney() Money {
+	return Ore(t.BuyPrice)
+}
+
+// BuyShippingMoney returns what shipping the item to us cost.
+func (t TradedItem) BuyShippingMoney() Money {
+	return Ore(t.BuyShippingCost)
+}
+
+// SellPriceMoney returns what the item sold for, when it has sold.
+func (t TradedItem) SellPriceMoney() (Money, bool) {
+	if t.SellPrice == nil {
+		return Money{}, false
+	}
+	return Ore(*t.SellPrice), true
+}
+
+// MarshalJSON adds the traded item amounts as money.
+func (t TradedItem) MarshalJSON() ([]byte, error) {
+	type plain TradedItem
+	optional := func(v *int) *Money {
+		if v == nil {
+			return nil
+		}
+		m := Ore(*v)
+		return &m
+	}
+	return json.Marshal(struct {
+		plain
+		BuyPriceMoney              Money  `json:"buy_price_money"`
+		BuyShippingMoney           Money  `json:"buy_shipping_cost_money"`
+		SellPriceMoney             *Money `json:"sell_price_money,omitempty"`
+		SellPackagingMoney         *Money `json:"sell_packaging_cost_money,omitempty"`
+		SellPostageMoney           *Money `json:"sell_postage_cost_money,omitempty"`
+		SellShippingCollectedMoney *Money `json:"sell_shipping_collected_money,omitempty"`
+	}{
+		plain:                      plain(t),
+		BuyPriceMoney:              t.BuyPriceMoney(),
+		BuyShippingMoney:           t.BuyShippingMoney(),
+		SellPriceMoney:             optional(t.SellPrice),
+		SellPackagingMoney:         optional(t.SellPackagingCost),
+		SellPostageMoney:           optional(t.SellPostageCost),
+		SellShippingCollectedMoney: optional(t.SellShippingCollected),
+	})
+}
+
+// ValueMoney returns the valuation.
+func (v Valuation) ValueMoney() Money {
+	return SEK(v.Valuation)
+}
+
+// MarshalJSON adds the valuation as money.
+func (v Valuation) MarshalJSON() ([]byte, error) {
+	type plain Valuation
+	return json.Marshal(struct {
+		plain
+		ValuationMoney Money `json:"valuation_money"`
+	}{plain(v), v.ValueMoney()})
+}
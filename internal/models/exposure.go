@@ -0,0 +1,105 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExposureLimits cap how much money and stock the bot may recommend tying
+// up. Amounts are in SEK. Zero means no limit.
+type ExposureLimits struct {
+	// MaxCapitalSEK is the most that may be tied up in unsold items.
+	MaxCapitalSEK int `json:"max_capital_sek" yaml:"max_capital_sek"`
+	// MaxUnitsPerProduct and MaxUnitsPerCategory cap the unsold items of
+	// one product or one category.
+	MaxUnitsPerProduct  int `json:"max_units_per_product" yaml:"max_units_per_product"`
+	MaxUnitsPerCategory int `json:"max_units_per_category" yaml:"max_units_per_category"`
+	// DailySpendSEK caps what is bought in one day.
+	DailySpendSEK int `json:"daily_spend_sek" yaml:"daily_spend_sek"`
+}
+
+// Exposure is the money and stock tied up in unsold items, and what has
+// been bought today. Amounts are in SEK; categories are lower case.
+type Exposure struct {
+	CapitalSEK      int              `json:"capital_sek"`
+	Units           int              `json:"units"`
+	UnitsByProduct  map[int64]int    `json:"units_by_product"`
+	UnitsByCategory map[string]int   `json:"units_by_category"`
+	SpentTodaySEK   int              `json:"spent_today_sek"`
+	Limits          ExposureLimits   `json:"limits"`
+	Headroom        ExposureHeadroom `json:"headroom"`
+}
+
+// ExposureHeadroom is what is left under the limits. Fields are nil for
+// limits that are not set.
+type ExposureHeadroom struct {
+	CapitalSEK    *int `json:"capital_sek,omitempty"`
+	DailySpendSEK *int `json:"daily_spend_sek,omitempty"`
+}
+
+// NewExposure returns an empty exposure under limits.
+func NewExposure(limits ExposureLimits) *Exposure {
+	e := &Exposure{
+		UnitsByProduct:  make(map[int64]int),
+		UnitsByCategory: make(map[string]int),
+		Limits:          limits,
+	}
+	e.updateHeadroom()
+	return e
+}
+
+// Add counts one more unsold item of a product and category that cost
+// cost SEK.
+func (e *Exposure) Add(productID *int64, category string, cost int) {
+	e.CapitalSEK += cost
+	e.Units++
+	if productID != nil {
+		e.UnitsByProduct[*productID]++
+	}
+	if category = normalizeCategory(category); category != "" {
+		e.UnitsByCategory[category]++
+	}
+	e.updateHeadroom()
+}
+
+// Spend counts cost SEK against today's spend.
+func (e *Exposure) Spend(cost int) {
+	e.SpentTodaySEK += cost
+	e.updateHeadroom()
+}
+
+// Violations returns the limits that buying one item of a product and
+// category for cost SEK today would break, in Swedish, or nil when it fits.
+func (e *Exposure) Violations(productID *int64, category string, cost int) []string {
+	var violations []string
+	l := e.Limits
+	if l.MaxCapitalSEK > 0 && e.CapitalSEK+cost > l.MaxCapitalSEK {
+		violations = append(violations, fmt.Sprintf("bundet kapital blir %d kr (max %d kr)", e.CapitalSEK+cost, l.MaxCapitalSEK))
+	}
+	if l.MaxUnitsPerProduct > 0 && productID != nil && e.UnitsByProduct[*productID] >= l.MaxUnitsPerProduct {
+		violations = append(violations, fmt.Sprintf("%d st av produkten i lager (max %d)", e.UnitsByProduct[*productID], l.MaxUnitsPerProduct))
+	}
+	if category = normalizeCategory(category); l.MaxUnitsPerCategory > 0 && category != "" && e.UnitsByCategory[category] >= l.MaxUnitsPerCategory {
+		violations = append(violations, fmt.Sprintf("%d st i kategorin %s i lager (max %d)", e.UnitsByCategory[category], category, l.MaxUnitsPerCategory))
+	}
+	if l.DailySpendSEK > 0 && e.SpentTodaySEK+cost > l.DailySpendSEK {
+		violations = append(violations, fmt.Sprintf("dagens inköp blir %d kr (max %d kr)", e.SpentTodaySEK+cost, l.DailySpendSEK))
+	}
+	return violations
+}
+
+func (e *Exposure) updateHeadroom() {
+	e.Headroom = ExposureHeadroom{}
+	if e.Limits.MaxCapitalSEK > 0 {
+		left := max(e.Limits.MaxCapitalSEK-e.CapitalSEK, 0)
+		e.Headroom.CapitalSEK = &left
+	}
+	if e.Limits.DailySpendSEK > 0 {
+		left := max(e.Limits.DailySpendSEK-e.SpentTodaySEK, 0)
+		e.Headroom.DailySpendSEK = &left
+	}
+}
+
+func normalizeCategory(category string) string {
+	return strings.ToLower(strings.TrimSpace(category))
+}
@@ -0,0 +1,51 @@
+package models
+
+import "testing"
+
+func TestExposureViolations(t *testing.T) {
+	phone, other := int64(1), int64(2)
+	e := NewExposure(ExposureLimits{MaxCapitalSEK: 10000, MaxUnitsPerProduct: 2, MaxUnitsPerCategory: 3, DailySpendSEK: 5000})
+	e.Add(&phone, "Phone", 3000)
+	e.Add(&phone, "phone", 3000)
+	e.Spend(3000)
+
+	if got := e.Violations(&other, "laptop", 2000); got != nil {
+		t.Errorf("an item within every limit got %v", got)
+	}
+	if got := e.Violations(&phone, "laptop", 1000); len(got) != 1 {
+		t.Errorf("a third unit of the product should break one limit, got %v", got)
+	}
+	if got := e.Violations(&other, "laptop", 2500); len(got) != 1 {
+		t.Errorf("spending over the daily cap should break one limit, got %v", got)
+	}
+	if got := e.Violations(&other, "laptop", 4500); len(got) != 2 {
+		t.Errorf("tying up over the capital limit today should break two limits, got %v", got)
+	}
+
+	e.Add(&other, "PHONE ", 1000)
+	if got := e.Violations(nil, "Phone", 100); len(got) != 1 {
+		t.Errorf("categories should be compared case-insensitively, got %v", got)
+	}
+
+	if e.Headroom.CapitalSEK == nil || *e.Headroom.CapitalSEK != 3000 {
+		t.Errorf("capital headroom = %v, want 3000", e.Headroom.CapitalSEK)
+	}
+	if e.Headroom.DailySpendSEK == nil || *e.Headroom.DailySpendSEK != 2000 {
+		t.Errorf("daily spend headroom = %v, want 2000", e.Headroom.DailySpendSEK)
+	}
+}
+
+func TestExposureWithoutLimits(t *testing.T) {
+	product := int64(1)
+	e := NewExposure(ExposureLimits{})
+	for i := 0; i < 10; i++ {
+		e.Add(&product, "phone", 5000)
+		e.Spend(5000)
+	}
+	if got := e.Violations(&product, "phone", 5000); got != nil {
+		t.Errorf("without limits nothing should be over, got %v", got)
+	}
+	if e.Headroom.CapitalSEK != nil || e.Headroom.DailySpendSEK != nil {
+		t.Errorf("without limits there should be no headroom, got %+v", e.Headroom)
+	}
+}
@@ -15,6 +15,7 @@ type Product struct {
 	SellPostageCost   int        `json:"sell_postage_cost" db:"sell_postage_cost"`
 	NewPrice          *int       `json:"new_price,omitempty" db:"new_price"`
 	Enabled           *bool      `json:"enabled,omitempty" db:"enabled"`
+	ValuationStrategy *string    `json:"valuation_strategy,omitempty" db:"valuation_strategy"`
 	CreatedAt         *time.Time `json:"created_at,omitempty" db:"created_at"`
 }
 
@@ -48,6 +49,7 @@ type Listing struct {
 	ID                  int64      `json:"id" db:"id"`
 	ProductID           *int64     `json:"product_id,omitempty" db:"product_id"`
 	Price               *int       `json:"price,omitempty" db:"price"`
+	Currency            Currency   `json:"currency,omitempty" db:"currency"`
 	Valuation           int        `json:"valuation" db:"valuation"`
 	Link                string     `json:"link" db:"link"`
 	ConditionID         *int64     `json:"condition_id,omitempty" db:"condition_id"`
@@ -63,6 +65,11 @@ type Listing struct {
 	EligibleForShipping *bool      `json:"eligible_for_shipping,omitempty" db:"eligible_for_shipping"`
 	SellerPaysShipping  *bool      `json:"seller_pays_shipping,omitempty" db:"seller_pays_shipping"`
 	BuyNow              *bool      `json:"buy_now,omitempty" db:"buy_now"`
+	// SearchTermID is the search term the listing was found with.
+	SearchTermID *int64 `json:"search_term_id,omitempty" db:"search_term_id"`
+	// OpportunityScore ranks the listing as a buy, 0 to 100. Nil until
+	// the listing has been scored.
+	OpportunityScore *float64 `json:"opportunity_score,omitempty" db:"opportunity_score"`
 }
 
 type Transaction struct {
@@ -78,9 +85,10 @@ type TransactionType struct {
 }
 
 type Marketplace struct {
-	ID   int64  `json:"id" db:"id"`
-	Name string `json:"name" db:"name"`
-	Link string `json:"link" db:"link"`
+	ID       int64    `json:"id" db:"id"`
+	Name     string   `json:"name" db:"name"`
+	Link     string   `json:"link" db:"link"`
+	Currency Currency `json:"currency" db:"currency"`
 }
 
 type Condition struct {
@@ -99,10 +107,26 @@ type ImageLink struct {
 	ListingID int64  `json:"listing_id" db:"listing_id"`
 }
 
+// Economics is a trading rule set. A rule set without a scope is the global
+// default; scoped ones apply to listings matching every scope field set.
 type Economics struct {
-	ID           int64 `json:"id" db:"id"`
-	MinProfitSEK *int  `json:"min_profit_sek,omitempty" db:"min_profit_sek"`
-	MinDiscount  *int  `json:"min_discount,omitempty" db:"min_discount"`
+	ID           int64  `json:"id" db:"id"`
+	Name         string `json:"name,omitempty" db:"name"`
+	MinProfitSEK *int   `json:"min_profit_sek,omitempty" db:"min_profit_sek"`
+	MinDiscount  *int   `json:"min_discount,omitempty" db:"min_discount"`
+	// ProfitPercentile, when set, requires that selling at this percentile of
+	// the valuation's price distribution still gives MinPercentileProfitSEK.
+	ProfitPercentile       *int    `json:"profit_percentile,omitempty" db:"profit_percentile"`
+	MinPercentileProfitSEK *int    `json:"min_percentile_profit_sek,omitempty" db:"min_percentile_profit_sek"`
+	Category               *string `json:"category,omitempty" db:"category"`
+	ProductID              *int64  `json:"product_id,omitempty" db:"product_id"`
+	MarketplaceID          *int64  `json:"marketplace_id,omitempty" db:"marketplace_id"`
+	SearchTermID           *int64  `json:"search_term_id,omitempty" db:"search_term_id"`
+	// Priority orders matching rule sets that are equally specific.
+	Priority int `json:"priority" db:"priority"`
+	// Expression is a ruleexpr expression a listing must also satisfy,
+	// e.g. "profit >= 500 and risk < 0.3".
+	Expression *string `json:"expression,omitempty" db:"expression"`
 }
 
 type TradedItemCandidate struct {
@@ -110,7 +134,9 @@ type TradedItemCandidate struct {
 	EstimatedSell int
 	ShippingCost  int
 	TotalCost     int
-	ShouldBuy     bool
+	// NetProfit is the profit at EstimatedSell after fees and costs.
+	NetProfit int
+	ShouldBuy bool
 }
 
 type SearchTerm struct {
@@ -149,9 +175,156 @@ type SearchTermWithCriteria struct {
 }
 
 type ValuationType struct {
-	ID      int16  `json:"id" db:"id"`
-	Name    string `json:"name" db:"name"`
-	Enabled bool   `json:"enabled" db:"enabled"`
+	ID      int16   `json:"id" db:"id"`
+	Name    string  `json:"name" db:"name"`
+	Enabled bool    `json:"enabled" db:"enabled"`
+	Key     *string `json:"key,omitempty" db:"key"`
+	// Config holds method settings such as timeout, cache_ttl and params.
+	Config json.RawMessage `json:"config,omitempty" db:"config"`
+}
+
+// SupplySnapshot describes the competing supply of a product: other
+// sellers' active listings across marketplaces and their asking prices.
+type SupplySnapshot struct {
+	ProductID    int64     `json:"product_id"`
+	ActiveCount  int       `json:"active_count"`
+	Marketplaces int       `json:"marketplaces"`
+	MinPrice     float64   `json:"min_price"`
+	P25          float64   `json:"p25"`
+	P50          float64   `json:"p50"`
+	P75          float64   `json:"p75"`
+	MaxPrice     float64   `json:"max_price"`
+	SellPrice    int       `json:"sell_price,omitempty"`
+	BelowSell    int       `json:"below_sell_price"`
+	ComputedAt   time.Time `json:"computed_at"`
+}
+
+// MarketObservation is a single price point for a product: a stored
+// valuation, another seller's asking price or a realized sale.
+type MarketObservation struct {
+	ObservedAt time.Time `json:"observed_at" db:"observed_at"`
+	Price      int       `json:"price" db:"price"`
+	Source     string    `json:"source" db:"source"`
+}
+
+// ValuationCacheEntry is a cached response from a valuation source, shared
+// between the API server, scheduled runs and the command line tools.
+type ValuationCacheEntry struct {
+	CacheKey  string          `json:"cache_key" db:"cache_key"`
+	MethodKey string          `json:"method_key" db:"method_key"`
+	Payload   json.RawMessage `json:"payload" db:"payload"`
+	FetchedAt time.Time       `json:"fetched_at" db:"fetched_at"`
+	ExpiresAt time.Time       `json:"expires_at" db:"expires_at"`
+}
+
+// LLMCall is one call to an LLM, recorded for cost accounting.
+type LLMCall struct {
+	ID               int64     `json:"id" db:"id"`
+	ScrapingRunID    *int64    `json:"scraping_run_id,omitempty" db:"scraping_run_id"`
+	Function         string    `json:"function" db:"function"`
+	Model            string    `json:"model" db:"model"`
+	PromptVersion    string    `json:"prompt_version,omitempty" db:"prompt_version"`
+	PromptTokens     int       `json:"prompt_tokens" db:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens" db:"completion_tokens"`
+	LatencyMs        int       `json:"latency_ms" db:"latency_ms"`
+	CostUSD          float64   `json:"cost_usd" db:"cost_usd"`
+	Success          bool      `json:"success" db:"success"`
+	CreatedAt        time.Time `json:"created_at" db:"created_at"`
+}
+
+// LLMUsageSummary aggregates LLM calls by run, day, function or model.
+// ListingsSaved and CostPerListingUSD are only set per run.
+type LLMUsageSummary struct {
+	Key               string   `json:"key"`
+	Calls             int      `json:"calls"`
+	Failures          int      `json:"failures"`
+	PromptTokens      int      `json:"prompt_tokens"`
+	CompletionTokens  int      `json:"completion_tokens"`
+	CostUSD           float64  `json:"cost_usd"`
+	AvgLatencyMs      int      `json:"avg_latency_ms"`
+	ListingsSaved     *int     `json:"listings_saved,omitempty"`
+	CostPerListingUSD *float64 `json:"cost_per_listing_usd,omitempty"`
+}
+
+// ListingImageAnalysis is the stored analysis of a listing's photos. The
+// full analysis is kept as JSON; the fields used for filtering are columns.
+type ListingImageAnalysis struct {
+	ListingID    int64           `json:"listing_id" db:"listing_id"`
+	Condition    string          `json:"condition" db:"condition"`
+	ModelMatches bool            `json:"model_matches" db:"model_matches"`
+	StockPhoto   bool            `json:"stock_photo" db:"stock_photo"`
+	Analysis     json.RawMessage `json:"analysis" db:"analysis"`
+	CreatedAt    time.Time       `json:"created_at" db:"created_at"`
+}
+
+// ReviewItem is an ad parked for a human to confirm because its extraction
+// or product match was uncertain. Ad and Extracted hold the raw ad and the
+// extracted fields as JSON.
+type ReviewItem struct {
+	ID   int64  `json:"id"`
+	Link string `json:"link"`
+	// Reason is low_confidence or uncertain_match.
+	Reason     string            `json:"reason"`
+	Ad         json.RawMessage   `json:"ad"`
+	Extracted  json.RawMessage   `json:"extracted"`
+	Confidence float64           `json:"confidence"`
+	Candidates []ReviewCandidate `json:"candidates"`
+	// PromptVersion and LLMModel are the prompt template and the model
+	// that made the extraction.
+	PromptVersion *string `json:"prompt_version,omitempty"`
+	LLMModel      *string `json:"llm_model,omitempty"`
+	// Status is pending, confirmed or rejected.
+	Status     string     `json:"status"`
+	ProductID  *int64     `json:"product_id,omitempty"`
+	ListingID  *int64     `json:"listing_id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// ReviewCandidate is a catalog product an ad under review may be for.
+type ReviewCandidate struct {
+	ProductID int64   `json:"product_id"`
+	Name      string  `json:"name"`
+	Score     float64 `json:"score"`
+}
+
+// LLMEvalCase is an ad labelled with the expected output of the LLM
+// functions. Empty labels are not scored. Source and SourceRef identify
+// where the label came from, e.g. a listing id or a line in a dataset file.
+type LLMEvalCase struct {
+	ID           int64     `json:"id"`
+	Source       string    `json:"source"`
+	SourceRef    string    `json:"source_ref"`
+	AdText       string    `json:"ad_text"`
+	Manufacturer string    `json:"manufacturer,omitempty"`
+	Model        string    `json:"model,omitempty"`
+	Category     string    `json:"category,omitempty"`
+	Condition    string    `json:"condition,omitempty"`
+	NewPrice     *int      `json:"new_price,omitempty"`
+	CreatedAt    time.Time `json:"created_at,omitempty"`
+}
+
+// PromptTemplate is one version of a named LLM prompt template. Source is
+// where it was loaded from: embedded, file or db.
+type PromptTemplate struct {
+	Name      string    `json:"name"`
+	Version   int       `json:"version"`
+	Body      string    `json:"body"`
+	Source    string    `json:"source"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+// LLMCacheEntry is a cached LLM response, keyed by a hash of the function,
+// model, prompt version and prompt.
+type LLMCacheEntry struct {
+	CacheKey      string    `json:"cache_key" db:"cache_key"`
+	Function      string    `json:"function" db:"function"`
+	Model         string    `json:"model" db:"model"`
+	PromptVersion string    `json:"prompt_version" db:"prompt_version"`
+	Response      string    `json:"response" db:"response"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at" db:"expires_at"`
 }
 
 type ProductValuationTypeConfig struct {
@@ -170,6 +343,23 @@ type Valuation struct {
 	CreatedAt       time.Time       `json:"created_at" db:"created_at"`
 }
 
+// ListingValuation is a snapshot of how a listing was valued when it was
+// scraped: the compiled output, the individual inputs, any condition or
+// variant adjustments and the trading rule verdict.
+type ListingValuation struct {
+	ID               int64           `json:"id" db:"id"`
+	ListingID        int64           `json:"listing_id" db:"listing_id"`
+	RecommendedPrice int             `json:"recommended_price" db:"recommended_price"`
+	Confidence       float64         `json:"confidence" db:"confidence"`
+	Strategy         string          `json:"strategy" db:"strategy"`
+	Reasoning        string          `json:"reasoning" db:"reasoning"`
+	Compiled         json.RawMessage `json:"compiled,omitempty" db:"compiled"`
+	Inputs           json.RawMessage `json:"inputs,omitempty" db:"inputs"`
+	Adjustments      json.RawMessage `json:"adjustments,omitempty" db:"adjustments"`
+	Verdict          json.RawMessage `json:"verdict,omitempty" db:"verdict"`
+	CreatedAt        time.Time       `json:"created_at" db:"created_at"`
+}
+
 type ValuationWithProduct struct {
 	Valuation
 	ProductName string `json:"product_name"`
@@ -196,8 +386,14 @@ type ScrapingRun struct {
 	TotalAdsFound      int        `json:"total_ads_found" db:"total_ads_found"`
 	TotalListingsSaved int        `json:"total_listings_saved" db:"total_listings_saved"`
 	TotalGoodBuys      int        `json:"total_good_buys" db:"total_good_buys"`
-	ErrorMessage       *string    `json:"error_message,omitempty" db:"error_message"`
-	CreatedAt          time.Time  `json:"created_at" db:"created_at"`
+	// ExtractionFailures counts ads the LLM gave no valid product info for.
+	ExtractionFailures int `json:"extraction_failures" db:"extraction_failures"`
+	LLMCacheHits       int `json:"llm_cache_hits" db:"llm_cache_hits"`
+	LLMCacheMisses     int `json:"llm_cache_misses" db:"llm_cache_misses"`
+	// CascadeStats is how many ads entered and passed each pre-filter stage.
+	CascadeStats json.RawMessage `json:"cascade_stats,omitempty" db:"cascade_stats"`
+	ErrorMessage *string         `json:"error_message,omitempty" db:"error_message"`
+	CreatedAt    time.Time       `json:"created_at" db:"created_at"`
 }
 
 type Conversation struct {
@@ -210,15 +406,17 @@ type Conversation struct {
 }
 
 type Message struct {
-	ID             int64      `json:"id" db:"id"`
-	ConversationID int64      `json:"conversation_id" db:"conversation_id"`
-	Direction      string     `json:"direction" db:"direction"`
-	Content        string     `json:"content" db:"content"`
-	Status         string     `json:"status" db:"status"`
-	ApprovedAt     *time.Time `json:"approved_at,omitempty" db:"approved_at"`
-	SentAt         *time.Time `json:"sent_at,omitempty" db:"sent_at"`
-	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
-	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
+	ID             int64  `json:"id" db:"id"`
+	ConversationID int64  `json:"conversation_id" db:"conversation_id"`
+	Direction      string `json:"direction" db:"direction"`
+	Content        string `json:"content" db:"content"`
+	Status         string `json:"status" db:"status"`
+	// PromptVersion is the prompt template that generated the message.
+	PromptVersion *string    `json:"prompt_version,omitempty" db:"prompt_version"`
+	ApprovedAt    *time.Time `json:"approved_at,omitempty" db:"approved_at"`
+	SentAt        *time.Time `json:"sent_at,omitempty" db:"sent_at"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
 }
 
 type ConversationWithDetails struct {
@@ -228,3 +426,12 @@ type ConversationWithDetails struct {
 	MarketplaceName string `json:"marketplace_name"`
 	PendingCount    int    `json:"pending_count"`
 }
+
+// RepricingNotification is the last price suggestion emailed for one of my
+// listings or an item in stock. Kind is listing or item.
+type RepricingNotification struct {
+	Kind           string    `json:"kind" db:"kind"`
+	ItemID         int64     `json:"item_id" db:"item_id"`
+	SuggestedPrice int       `json:"suggested_price" db:"suggested_price"`
+	NotifiedAt     time.Time `json:"notified_at" db:"notified_at"`
+}
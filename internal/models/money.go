@@ -0,0 +1,133 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Currency is an ISO 4217 currency code.
+type Currency string
+
+const (
+	CurrencySEK Currency = "SEK"
+	CurrencyEUR Currency = "EUR"
+
+	// DefaultCurrency is the currency amounts are stored in unless a column
+	// or field says otherwise.
+	DefaultCurrency = CurrencySEK
+)
+
+// minorUnits is the number of minor units (öre, cent) per major unit.
+const minorUnits = 100
+
+// Money is an amount in minor units of a currency, e.g. öre for SEK.
+type Money struct {
+	Amount   int64    `json:"amount"`
+	Currency Currency `json:"currency"`
+}
+
+// NewMoney returns an amount given in minor units.
+func NewMoney(minor int64, currency Currency) Money {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+	return Money{Amount: minor, Currency: currency}
+}
+
+// FromMajor returns an amount given in major units (kronor, euro), rounded
+// to the nearest minor unit.
+func FromMajor(major float64, currency Currency) Money {
+	return NewMoney(int64(math.Round(major*minorUnits)), currency)
+}
+
+// SEK returns an amount in whole kronor.
+func SEK(kronor int) Money {
+	return NewMoney(int64(kronor)*minorUnits, CurrencySEK)
+}
+
+// Ore returns an amount in öre.
+func Ore(ore int) Money {
+	return NewMoney(int64(ore), CurrencySEK)
+}
+
+// Major returns the amount in major units.
+func (m Money) Major() float64 {
+	return float64(m.Amount) / minorUnits
+}
+
+// MajorInt returns the amount rounded to whole major units. Most prices in
+// the database are stored this way.
+func (m Money) MajorInt() int {
+	return int(math.Round(m.Major()))
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Add returns m+o. Both amounts must be in the same currency.
+func (m Money) Add(o Money) (Money, error) {
+	if err := m.sameCurrency(o); err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+// Sub returns m-o. Both amounts must be in the same currency.
+func (m Money) Sub(o Money) (Money, error) {
+	if err := m.sameCurrency(o); err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: m.Amount - o.Amount, Currency: m.Currency}, nil
+}
+
+// Mul returns m scaled by f, rounded to the nearest minor unit.
+func (m Money) Mul(f float64) Money {
+	return Money{Amount: int64(math.Round(float64(m.Amount) * f)), Currency: m.Currency}
+}
+
+func (m Money) sameCurrency(o Money) error {
+	if m.Currency != o.Currency {
+		return fmt.Errorf("currency mismatch: %s and %s", m.Currency, o.Currency)
+	}
+	return nil
+}
+
+// String formats the amount for people, e.g. "1 250 kr" or "99.50 EUR".
+func (m Money) String() string {
+	if m.Currency == CurrencySEK || m.Currency == "" {
+		if m.Amount%minorUnits == 0 {
+			return groupThousands(m.Amount/minorUnits) + " kr"
+		}
+		return fmt.Sprintf("%.2f kr", m.Major())
+	}
+	return fmt.Sprintf("%.2f %s", m.Major(), m.Currency)
+}
+
+func groupThousands(n int64) string {
+	sign := ""
+	if n < 0 {
+		sign = "-"
+		n = -n
+	}
+	s := fmt.Sprintf("%d", n)
+	var b strings.Builder
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(c)
+	}
+	return sign + b.String()
+}
+
+// FXRate is the price of one unit of Base in Quote.
+type FXRate struct {
+	ID        int64     `json:"id" db:"id"`
+	Base      Currency  `json:"base" db:"base"`
+	Quote     Currency  `json:"quote" db:"quote"`
+	Rate      float64   `json:"rate" db:"rate"`
+	ValidFrom time.Time `json:"valid_from" db:"valid_from"`
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMoneyUnits(t *testing.T) {
+	if got := Ore(12550).Major(); got != 125.5 {
+		t.Errorf("Ore(12550).Major() = %v, want 125.5", got)
+	}
+	if got := SEK(125).Amount; got != 12500 {
+		t.Errorf("SEK(125).Amount = %d, want 12500", got)
+	}
+	if got := Ore(12550).MajorInt(); got != 126 {
+		t.Errorf("Ore(12550).MajorInt() = %d, want 126", got)
+	}
+	if got := SEK(1250).String(); got != "1 250 kr" {
+		t.Errorf("SEK(1250).String() = %q, want %q", got, "1 250 kr")
+	}
+	if got := FromMajor(99.5, CurrencyEUR).String(); got != "99.50 EUR" {
+		t.Errorf("String() = %q, want %q", got, "99.50 EUR")
+	}
+}
+
+func TestMoneyAddRejectsCurrencyMismatch(t *testing.T) {
+	sum, err := SEK(100).Add(Ore(50))
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if sum.Amount != 10050 {
+		t.Errorf("Add() = %d, want 10050", sum.Amount)
+	}
+	if _, err := SEK(100).Add(FromMajor(10, CurrencyEUR)); err == nil {
+		t.Error("Add() across currencies should fail")
+	}
+}
+
+func TestListingPriceMoney(t *testing.T) {
+	if _, ok := (Listing{}).PriceMoney(); ok {
+		t.Error("PriceMoney() without price should not be ok")
+	}
+	price, shipping := 250, 10
+	got, ok := Listing{Price: &price, Currency: CurrencyEUR}.PriceMoney()
+	if !ok || got.Amount != 25000 || got.Currency != CurrencyEUR {
+		t.Errorf("PriceMoney() = %+v, %v", got, ok)
+	}
+	if got, _ := (Listing{Price: &price}).PriceMoney(); got.Currency != CurrencySEK {
+		t.Errorf("PriceMoney() currency = %q, want SEK", got.Currency)
+	}
+
+	data, err := json.Marshal(Listing{Price: &price, ShippingCost: &shipping, Currency: CurrencyEUR, Valuation: 3000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"price":250`,
+		`"price_money":{"amount":25000,"currency":"EUR"}`,
+		`"shipping_cost_money":{"amount":1000,"currency":"EUR"}`,
+		`"valuation_money":{"amount":300000,"currency":"SEK"}`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("listing JSON %s lacks %s", data, want)
+		}
+	}
+}
+
+func TestTradedItemMoney(t *testing.T) {
+	sold := 250000
+	data, err := json.Marshal(TradedItem{BuyPrice: 100000, BuyShippingCost: 4900, SellPrice: &sold})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"buy_price":100000`,
+		`"buy_price_money":{"amount":100000,"currency":"SEK"}`,
+		`"sell_price_money":{"amount":250000,"currency":"SEK"}`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("traded item JSON %s lacks %s", data, want)
+		}
+	}
+	if strings.Contains(string(data), "sell_postage_cost_money") {
+		t.Errorf("unset amounts should be left out: %s", data)
+	}
+}
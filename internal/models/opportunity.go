@@ -0,0 +1,114 @@
+package models
+
+import "math"
+
+// ScoreWeights are how much each part of an opportunity score counts.
+// Only their proportions matter.
+type ScoreWeights struct {
+	Profit     float64 `json:"profit" yaml:"profit"`
+	Confidence float64 `json:"confidence" yaml:"confidence"`
+	Return     float64 `json:"return" yaml:"return"`
+	Risk       float64 `json:"risk" yaml:"risk"`
+}
+
+// DefaultScoreWeights are used when no weight is set.
+var DefaultScoreWeights = ScoreWeights{
+	Profit:     0.3,
+	Confidence: 0.2,
+	Return:     0.25,
+	Risk:       0.15,
+}
+
+// ScoringModel turns the figures of an opportunity into a score from 0 to
+// 100. Zero values take the defaults.
+type ScoringModel struct {
+	Weights ScoreWeights `json:"weights" yaml:"weights"`
+	// ProfitScaleSEK is the net profit that scores about two thirds of the
+	// profit part. Defaults to 1000.
+	ProfitScaleSEK int `json:"profit_scale_sek" yaml:"profit_scale_sek"`
+	// ReturnScale is the annualized return on capital, 1 being 100%, that
+	// scores about two thirds of the return part. Defaults to 2.
+	ReturnScale float64 `json:"return_scale" yaml:"return_scale"`
+	// DefaultDaysToSell is assumed when no valuation source says how long
+	// a sale takes. Defaults to 30.
+	DefaultDaysToSell int `json:"default_days_to_sell" yaml:"default_days_to_sell"`
+	// MinNotifyScore is the score a listing needs for a notification.
+	MinNotifyScore float64 `json:"min_notify_score" yaml:"min_notify_score"`
+}
+
+// ScoreInput is what an opportunity is scored on. Amounts are in SEK.
+type ScoreInput struct {
+	NetProfit int
+	// Capital is what the buy ties up: price plus shipping.
+	Capital    int
+	Confidence float64
+	DaysToSell int
+	Risk       float64
+}
+
+// OpportunityScore is the score of an opportunity and its parts, each from
+// 0 to 1.
+type OpportunityScore struct {
+	Score        float64 `json:"score"`
+	Profit       float64 `json:"profit"`
+	Confidence   float64 `json:"confidence"`
+	Return       float64 `json:"return"`
+	Risk         float64 `json:"risk"`
+	AnnualReturn float64 `json:"annual_return"`
+	DaysToSell   int     `json:"days_to_sell"`
+}
+
+// Score returns the opportunity score of in. A loss scores nothing for
+// profit and return.
+func (m ScoringModel) Score(in ScoreInput) OpportunityScore {
+	weights := m.Weights
+	if weights == (ScoreWeights{}) {
+		weights = DefaultScoreWeights
+	}
+	profitScale := float64(orDefault(m.ProfitScaleSEK, 1000))
+	returnScale := m.ReturnScale
+	if returnScale <= 0 {
+		returnScale = 2
+	}
+
+	s := OpportunityScore{DaysToSell: in.DaysToSell}
+	if s.DaysToSell <= 0 {
+		s.DaysToSell = orDefault(m.DefaultDaysToSell, 30)
+	}
+	if in.Capital > 0 {
+		s.AnnualReturn = float64(in.NetProfit) / float64(in.Capital) * 365 / float64(s.DaysToSell)
+	}
+	s.Profit = saturate(float64(in.NetProfit), profitScale)
+	s.Return = saturate(s.AnnualReturn, returnScale)
+	s.Confidence = clamp01(in.Confidence)
+	s.Risk = 1 - clamp01(in.Risk)
+
+	total := weights.Profit + weights.Confidence + weights.Return + weights.Risk
+	if total <= 0 {
+		return s
+	}
+	sum := weights.Profit*s.Profit + weights.Confidence*s.Confidence + weights.Return*s.Return +
+		weights.Risk*s.Risk
+	s.Score = math.Round(sum/total*1000) / 10
+	return s
+}
+
+// saturate maps x from 0 to 1, rising quickly and then levelling off past
+// scale. Nothing scores 0.
+func saturate(x, scale float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	return 1 - math.Exp(-x/scale)
+}
+
+func clamp01(x float64) float64 {
+	return math.Min(math.Max(x, 0), 1)
+}
+
+func orDefault(v, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
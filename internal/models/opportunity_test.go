@@ -0,0 +1,44 @@
+package models
+
+import "testing"
+
+func TestScoringModelScore(t *testing.T) {
+	var m ScoringModel
+
+	good := m.Score(ScoreInput{NetProfit: 1500, Capital: 2000, Confidence: 0.9, DaysToSell: 7, Risk: 0.1})
+	if good.Score < 70 || good.Score > 100 {
+		t.Errorf("a quick, safe, profitable deal scores %.1f", good.Score)
+	}
+
+	slow := m.Score(ScoreInput{NetProfit: 1500, Capital: 2000, Confidence: 0.9, DaysToSell: 90, Risk: 0.1})
+	if slow.Score >= good.Score || slow.AnnualReturn >= good.AnnualReturn {
+		t.Errorf("a slower sale should score lower: %.1f vs %.1f", slow.Score, good.Score)
+	}
+
+	risky := m.Score(ScoreInput{NetProfit: 1500, Capital: 2000, Confidence: 0.9, DaysToSell: 7, Risk: 0.9})
+	if risky.Score >= good.Score {
+		t.Errorf("a risky deal should score lower: %.1f vs %.1f", risky.Score, good.Score)
+	}
+
+	loss := m.Score(ScoreInput{NetProfit: -200, Capital: 2000, Confidence: 1, DaysToSell: 7})
+	if loss.Profit != 0 || loss.Return != 0 {
+		t.Errorf("a loss should score nothing for profit and return, got %+v", loss)
+	}
+
+	if got := m.Score(ScoreInput{NetProfit: 500, Capital: 1000}); got.DaysToSell != 30 {
+		t.Errorf("days to sell should default to 30, got %d", got.DaysToSell)
+	}
+}
+
+func TestScoringModelWeights(t *testing.T) {
+	in := ScoreInput{NetProfit: 100, Capital: 1000, Confidence: 1, DaysToSell: 30}
+
+	confidenceOnly := ScoringModel{Weights: ScoreWeights{Confidence: 1}}
+	if got := confidenceOnly.Score(in).Score; got != 100 {
+		t.Errorf("with only confidence weighted a certain valuation scores %.1f, want 100", got)
+	}
+	profitOnly := ScoringModel{Weights: ScoreWeights{Profit: 2}}
+	if got := profitOnly.Score(in).Score; got >= 20 {
+		t.Errorf("with only profit weighted a small profit scores %.1f", got)
+	}
+}
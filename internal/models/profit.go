@@ -0,0 +1,184 @@
+package models
+
+import (
+	"math"
+	"strings"
+)
+
+// FeeSchedule is a fee of Percent of an amount plus Fixed SEK.
+type FeeSchedule struct {
+	Percent float64 `json:"percent" yaml:"percent"`
+	Fixed   int     `json:"fixed" yaml:"fixed"`
+}
+
+// Fee returns the fee on amount SEK. Nothing is charged on nothing.
+func (f FeeSchedule) Fee(amount int) int {
+	if amount <= 0 {
+		return 0
+	}
+	return int(math.Round(float64(amount)*f.Percent/100)) + f.Fixed
+}
+
+// MarketplaceFees are what a sale on a marketplace costs the seller: the
+// marketplace's selling fee on the price and the payment fee on what the
+// buyer pays, shipping included.
+type MarketplaceFees struct {
+	Selling FeeSchedule `json:"selling" yaml:"selling"`
+	Payment FeeSchedule `json:"payment" yaml:"payment"`
+}
+
+// CostModel is how every profit figure is computed. Amounts are in SEK.
+type CostModel struct {
+	// SellMarketplace is where items are resold unless a sale says
+	// otherwise; its fees apply.
+	SellMarketplace string `json:"sell_marketplace" yaml:"sell_marketplace"`
+	// Marketplaces holds the fee schedules by marketplace name, e.g.
+	// tradera. Marketplaces without one charge nothing.
+	Marketplaces map[string]MarketplaceFees `json:"marketplaces" yaml:"marketplaces"`
+	// HandlingMinutes of work per item, e.g. photos, listing and packing,
+	// cost HourlyRate SEK an hour. Zero leaves time out.
+	HandlingMinutes int `json:"handling_minutes" yaml:"handling_minutes"`
+	HourlyRate      int `json:"hourly_rate" yaml:"hourly_rate"`
+}
+
+// ProfitInput is one buy and resale to compute the profit of. Amounts are
+// in SEK.
+type ProfitInput struct {
+	BuyPrice    int
+	BuyShipping int
+	SellPrice   int
+	// ShippingCollected is what the buyer pays for shipping on top of
+	// SellPrice.
+	ShippingCollected int
+	PackagingCost     int
+	PostageCost       int
+	// SellMarketplace is where the item is sold. Empty uses the model's.
+	SellMarketplace string
+}
+
+// ProfitBreakdown is the net profit of a buy and resale and what went into
+// it. Amounts are in SEK.
+type ProfitBreakdown struct {
+	SellPrice   int `json:"sell_price"`
+	Revenue     int `json:"revenue"`
+	BuyPrice    int `json:"buy_price"`
+	BuyShipping int `json:"buy_shipping"`
+	SellingFee  int `json:"selling_fee"`
+	PaymentFee  int `json:"payment_fee"`
+	Packaging   int `json:"packaging"`
+	Postage     int `json:"postage"`
+	Handling    int `json:"handling"`
+	TotalCost   int `json:"total_cost"`
+	NetProfit   int `json:"net_profit"`
+}
+
+// ProfitPercent is the net profit as a percentage of the sell price.
+func (b ProfitBreakdown) ProfitPercent() float64 {
+	if b.SellPrice <= 0 {
+		return 0
+	}
+	return float64(b.NetProfit) / float64(b.SellPrice) * 100
+}
+
+// Fees returns the fee schedules of a marketplace.
+func (m CostModel) Fees(marketplace string) MarketplaceFees {
+	if marketplace == "" {
+		marketplace = m.SellMarketplace
+	}
+	return m.Marketplaces[strings.ToLower(strings.TrimSpace(marketplace))]
+}
+
+// HandlingCost is the cost of the time spent on an item.
+func (m CostModel) HandlingCost() int {
+	return int(math.Round(float64(m.HandlingMinutes) * float64(m.HourlyRate) / 60))
+}
+
+// Calculate returns the net profit of a buy and resale.
+func (m CostModel) Calculate(in ProfitInput) ProfitBreakdown {
+	fees := m.Fees(in.SellMarketplace)
+	b := ProfitBreakdown{
+		SellPrice:   in.SellPrice,
+		Revenue:     in.SellPrice + in.ShippingCollected,
+		BuyPrice:    in.BuyPrice,
+		BuyShipping: in.BuyShipping,
+		Packaging:   in.PackagingCost,
+		Postage:     in.PostageCost,
+		Handling:    m.HandlingCost(),
+	}
+	b.SellingFee = fees.Selling.Fee(in.SellPrice)
+	b.PaymentFee = fees.Payment.Fee(b.Revenue)
+	b.TotalCost = b.BuyPrice + b.BuyShipping + b.SellingFee + b.PaymentFee + b.Packaging + b.Postage + b.Handling
+	b.NetProfit = b.Revenue - b.TotalCost
+	return b
+}
+
+// MinSellPrice is the lowest sell price that leaves minProfit. in.SellPrice
+// is ignored.
+func (m CostModel) MinSellPrice(in ProfitInput, minProfit int) int {
+	fees := m.Fees(in.SellMarketplace)
+	share := 1 - fees.Selling.Percent/100 - fees.Payment.Percent/100
+	if share <= 0 {
+		return 0
+	}
+	costs := in.BuyPrice + in.BuyShipping + in.PackagingCost + in.PostageCost + m.HandlingCost() + fees.Selling.Fixed + fees.Payment.Fixed
+	shipping := float64(in.ShippingCollected) * (1 - fees.Payment.Percent/100)
+	price := int(math.Ceil((float64(costs+minProfit) - shipping) / share))
+	if price < 1 {
+		price = 1
+	}
+	// Fees are rounded per sale; step up until the profit holds.
+	for i := 0; i < 10; i++ {
+		in.SellPrice = price
+		if m.Calculate(in).NetProfit >= minProfit {
+			break
+		}
+		price++
+	}
+	return price
+}
+
+// ListingProfit is the profit of buying a listing at its price plus
+// shipping and reselling it at sellPrice. product, when known, gives the
+// packaging and postage costs.
+func (m CostModel) ListingProfit(l *Listing, product *Product, sellPrice int) ProfitBreakdown {
+	return m.Calculate(ListingProfitInput(l, product, sellPrice))
+}
+
+// ListingProfitInput is the buy of a listing and its resale at sellPrice.
+func ListingProfitInput(l *Listing, product *Product, sellPrice int) ProfitInput {
+	in := ProfitInput{SellPrice: sellPrice}
+	if l.Price != nil {
+		in.BuyPrice = *l.Price
+	}
+	if l.ShippingCost != nil {
+		in.BuyShipping = *l.ShippingCost
+	}
+	if product != nil {
+		in.PackagingCost = product.SellPackagingCost
+		in.PostageCost = product.SellPostageCost
+	}
+	return in
+}
+
+// TradedItemInput is the buy and resale of a traded item. sellPrice in SEK
+// is used when the item is not sold; traded item amounts are in öre.
+func TradedItemInput(item *TradedItem, sellPrice int) ProfitInput {
+	ore := func(v *int) int {
+		if v == nil {
+			return 0
+		}
+		return Ore(*v).MajorInt()
+	}
+	in := ProfitInput{
+		BuyPrice:          item.BuyPriceMoney().MajorInt(),
+		BuyShipping:       item.BuyShippingMoney().MajorInt(),
+		SellPrice:         sellPrice,
+		ShippingCollected: ore(item.SellShippingCollected),
+		PackagingCost:     ore(item.SellPackagingCost),
+		PostageCost:       ore(item.SellPostageCost),
+	}
+	if sold, ok := item.SellPriceMoney(); ok {
+		in.SellPrice = sold.MajorInt()
+	}
+	return in
+}
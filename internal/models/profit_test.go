@@ -0,0 +1,94 @@
+package models
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCostModelCalculate(t *testing.T) {
+	costs := CostModel{
+		SellMarketplace: "tradera",
+		Marketplaces: map[string]MarketplaceFees{
+			"tradera": {
+				Selling: FeeSchedule{Percent: 10},
+				Payment: FeeSchedule{Percent: 2, Fixed: 3},
+			},
+		},
+		HandlingMinutes: 30,
+		HourlyRate:      200,
+	}
+
+	got := costs.Calculate(ProfitInput{
+		BuyPrice:          1000,
+		BuyShipping:       50,
+		SellPrice:         2000,
+		ShippingCollected: 100,
+		PackagingCost:     20,
+		PostageCost:       80,
+	})
+	want := ProfitBreakdown{
+		SellPrice:   2000,
+		Revenue:     2100,
+		BuyPrice:    1000,
+		BuyShipping: 50,
+		SellingFee:  200,
+		PaymentFee:  45,
+		Packaging:   20,
+		Postage:     80,
+		Handling:    100,
+		TotalCost:   1495,
+		NetProfit:   605,
+	}
+	if got != want {
+		t.Errorf("Calculate() = %+v, want %+v", got, want)
+	}
+
+	if got := costs.Calculate(ProfitInput{BuyPrice: 1000, SellPrice: 2000, SellMarketplace: "Blocket"}); got.NetProfit != 900 {
+		t.Errorf("a marketplace without fees should only cost handling, net profit = %d", got.NetProfit)
+	}
+	if got := (CostModel{}).Calculate(ProfitInput{BuyPrice: 1000, SellPrice: 1500}); got.NetProfit != 500 || math.Round(got.ProfitPercent()) != 33 {
+		t.Errorf("without a cost model profit = %d (%.2f%%), want 500", got.NetProfit, got.ProfitPercent())
+	}
+}
+
+func TestCostModelMinSellPrice(t *testing.T) {
+	costs := CostModel{
+		SellMarketplace: "tradera",
+		Marketplaces: map[string]MarketplaceFees{
+			"tradera": {
+				Selling: FeeSchedule{Percent: 10, Fixed: 5},
+				Payment: FeeSchedule{Percent: 2.5},
+			},
+		},
+	}
+	in := ProfitInput{BuyPrice: 1000, BuyShipping: 49, PostageCost: 66}
+
+	price := costs.MinSellPrice(in, 200)
+	in.SellPrice = price
+	if profit := costs.Calculate(in).NetProfit; profit < 200 {
+		t.Fatalf("MinSellPrice() = %d leaves %d kr, want at least 200", price, profit)
+	}
+	in.SellPrice = price - 1
+	if profit := costs.Calculate(in).NetProfit; profit >= 200 {
+		t.Errorf("MinSellPrice() = %d is not the lowest, %d leaves %d kr", price, price-1, profit)
+	}
+
+	if got := (CostModel{}).MinSellPrice(ProfitInput{BuyPrice: 1000}, 200); got != 1200 {
+		t.Errorf("without fees MinSellPrice() = %d, want 1200", got)
+	}
+}
+
+func TestTradedItemInput(t *testing.T) {
+	sold, postage := 250000, 6600
+	item := &TradedItem{BuyPrice: 100000, BuyShippingCost: 4900, SellPostageCost: &postage}
+
+	in := TradedItemInput(item, 2000)
+	if in.BuyPrice != 1000 || in.BuyShipping != 49 || in.PostageCost != 66 || in.SellPrice != 2000 {
+		t.Errorf("unsold item = %+v", in)
+	}
+
+	item.SellPrice = &sold
+	if in := TradedItemInput(item, 2000); in.SellPrice != 2500 {
+		t.Errorf("sold item should use its sell price, got %d", in.SellPrice)
+	}
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TradingRuleScope is what a listing is, for choosing its trading rules.
+// Zero fields are unknown and match no scoped rule set on that field.
+type TradingRuleScope struct {
+	Category      string
+	ProductID     int64
+	MarketplaceID int64
+	SearchTermID  int64
+}
+
+// IsGlobal reports whether the rule set has no scope.
+func (e *Economics) IsGlobal() bool {
+	return e.Category == nil && e.ProductID == nil && e.MarketplaceID == nil && e.SearchTermID == nil
+}
+
+// Matches reports whether every scope field set on the rule set matches.
+func (e *Economics) Matches(scope TradingRuleScope) bool {
+	if e.Category != nil && !strings.EqualFold(*e.Category, scope.Category) {
+		return false
+	}
+	if e.ProductID != nil && *e.ProductID != scope.ProductID {
+		return false
+	}
+	if e.MarketplaceID != nil && *e.MarketplaceID != scope.MarketplaceID {
+		return false
+	}
+	if e.SearchTermID != nil && *e.SearchTermID != scope.SearchTermID {
+		return false
+	}
+	return true
+}
+
+// Specificity ranks how narrow the scope is. A product is narrower than a
+// search term, which is narrower than a category, then a marketplace.
+func (e *Economics) Specificity() int {
+	n := 0
+	if e.ProductID != nil {
+		n += 8
+	}
+	if e.SearchTermID != nil {
+		n += 4
+	}
+	if e.Category != nil {
+		n += 2
+	}
+	if e.MarketplaceID != nil {
+		n++
+	}
+	return n
+}
+
+// SelectTradingRule returns the rule set for scope: the most specific
+// matching one, the one with the highest priority among equally specific
+// sets, then the oldest. It returns nil when nothing matches, not even a
+// global rule set.
+func SelectTradingRule(rules []Economics, scope TradingRuleScope) *Economics {
+	var matching []*Economics
+	for i := range rules {
+		if rules[i].Matches(scope) {
+			matching = append(matching, &rules[i])
+		}
+	}
+	if len(matching) == 0 {
+		return nil
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		a, b := matching[i], matching[j]
+		if a.Specificity() != b.Specificity() {
+			return a.Specificity() > b.Specificity()
+		}
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		return a.ID < b.ID
+	})
+	return matching[0]
+}
+
+// HasExpression reports whether the rule set has an expression to check.
+func (e *Economics) HasExpression() bool {
+	return e.Expression != nil && strings.TrimSpace(*e.Expression) != ""
+}
+
+// ImageRisk scores what the photos of an ad say about the deal, from 0 for
+// no concerns to 1. Another model or photos that seem stolen rule the deal
+// out; stock photos are a warning.
+func ImageRisk(modelMatches, stockPhoto bool, suspiciousSigns int) float64 {
+	risk := 0.0
+	if !modelMatches || suspiciousSigns > 0 {
+		risk = 1
+	}
+	if stockPhoto {
+		risk += 0.3
+	}
+	return math.Min(risk, 1)
+}
+
+// Describe names the rule set for verdicts and emails.
+func (e *Economics) Describe() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	if e.IsGlobal() {
+		return "standard"
+	}
+	return "regel " + strconv.FormatInt(e.ID, 10)
+}
@@ -0,0 +1,59 @@
+package models
+
+import "testing"
+
+func TestSelectTradingRule(t *testing.T) {
+	phone := "Phone"
+	product, marketplace, term := int64(7), int64(2), int64(3)
+	rules := []Economics{
+		{ID: 1, Name: "global"},
+		{ID: 2, Name: "phones", Category: &phone},
+		{ID: 3, Name: "product", ProductID: &product},
+		{ID: 4, Name: "tradera phones", Category: &phone, MarketplaceID: &marketplace},
+		{ID: 5, Name: "search term", SearchTermID: &term},
+	}
+
+	tests := []struct {
+		name  string
+		scope TradingRuleScope
+		want  string
+	}{
+		{"nothing scoped matches", TradingRuleScope{Category: "laptop", ProductID: 1}, "global"},
+		{"category is case-insensitive", TradingRuleScope{Category: "phone", ProductID: 1}, "phones"},
+		{"more scope fields win", TradingRuleScope{Category: "phone", ProductID: 1, MarketplaceID: 2}, "tradera phones"},
+		{"product beats category", TradingRuleScope{Category: "phone", ProductID: 7, MarketplaceID: 2}, "product"},
+		{"product beats search term", TradingRuleScope{ProductID: 7, SearchTermID: 3}, "product"},
+		{"search term beats category", TradingRuleScope{Category: "phone", SearchTermID: 3}, "search term"},
+	}
+	for _, tt := range tests {
+		if got := SelectTradingRule(rules, tt.scope); got == nil || got.Name != tt.want {
+			t.Errorf("%s: got %+v, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	rules[0].Priority = 10
+	if got := SelectTradingRule(rules, TradingRuleScope{ProductID: 7}); got.Name != "product" {
+		t.Errorf("specificity should beat priority, got %s", got.Name)
+	}
+	preferred := append(rules, Economics{ID: 6, Name: "preferred phones", Category: &phone, Priority: 5})
+	if got := SelectTradingRule(preferred, TradingRuleScope{Category: "phone", ProductID: 1}); got.Name != "preferred phones" {
+		t.Errorf("priority should decide between equally specific sets, got %s", got.Name)
+	}
+
+	if got := SelectTradingRule(rules[1:], TradingRuleScope{Category: "laptop"}); got != nil {
+		t.Errorf("without a global rule set nothing should match, got %+v", got)
+	}
+}
+
+func TestEconomicsDescribe(t *testing.T) {
+	product := int64(7)
+	if got := (&Economics{}).Describe(); got != "standard" {
+		t.Errorf("global = %q", got)
+	}
+	if got := (&Economics{ID: 4, ProductID: &product}).Describe(); got != "regel 4" {
+		t.Errorf("unnamed scoped = %q", got)
+	}
+	if got := (&Economics{ID: 4, Name: "iPhones"}).Describe(); got != "iPhones" {
+		t.Errorf("named = %q", got)
+	}
+}
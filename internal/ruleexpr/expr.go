@@ -0,0 +1,647 @@
+// Package ruleexpr is a small expression language for trading rules, e.g.
+//
+//	profit >= 500 and (discount >= 25% or days_to_sell <= 7) and risk < 0.3 and shipping
+//
+// Expressions are checked against the variables in Variables when they are
+// compiled, so a rule that compiles cannot fail on an unknown variable or a
+// type error when it is evaluated.
+//
+// The language has numbers, strings in single or double quotes, true and
+// false; arithmetic with + - * /; comparisons with == != < <= > >= (or
+// ≤ ≥ ≠); "in" against a list such as category in ["phone", "tablet"];
+// and, or and not (or && || !); and parentheses. A number followed by % is
+// a percentage, so discount >= 25% is discount >= 25. Strings compare
+// without regard to case.
+package ruleexpr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Type is the type of a value in an expression.
+type Type string
+
+const (
+	TypeNumber Type = "number"
+	TypeString Type = "string"
+	TypeBool   Type = "bool"
+)
+
+// MaxLength is the longest expression Compile accepts.
+const MaxLength = 2000
+
+// ErrDivisionByZero is returned by Eval when an expression divides by zero.
+var ErrDivisionByZero = errors.New("division by zero")
+
+// SyntaxError is an expression that does not compile. Pos is the byte
+// offset in the expression.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Pos+1, e.Msg)
+}
+
+// Env holds the values of the variables: float64 for numbers, string and
+// bool.
+type Env map[string]interface{}
+
+// Program is a compiled expression.
+type Program struct {
+	source string
+	root   node
+}
+
+// String returns the expression the program was compiled from.
+func (p *Program) String() string {
+	return p.source
+}
+
+// Compile parses an expression over the trading rule variables. The
+// expression must be true or false.
+func Compile(source string) (*Program, error) {
+	return compile(source, variableTypes)
+}
+
+func compile(source string, vars map[string]Type) (*Program, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, &SyntaxError{Pos: 0, Msg: "empty expression"}
+	}
+	if len(source) > MaxLength {
+		return nil, &SyntaxError{Pos: MaxLength, Msg: fmt.Sprintf("longer than %d characters", MaxLength)}
+	}
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, vars: vars}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("unexpected %q", tok.text)}
+	}
+	if root.typ() != TypeBool {
+		return nil, &SyntaxError{Pos: 0, Msg: fmt.Sprintf("expression is a %s, not true or false", root.typ())}
+	}
+	return &Program{source: source, root: root}, nil
+}
+
+// Eval evaluates the program. Variables missing from env have their zero
+// value: 0, "" or false.
+func (p *Program) Eval(env Env) (bool, error) {
+	v, err := p.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+	return v.(bool), nil
+}
+
+// Lexer
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+	num  float64
+}
+
+// operatorAliases maps the spellings of operators to one form.
+var operatorAliases = map[string]string{
+	"&&": "and", "||": "or", "!": "not",
+	"≤": "<=", "≥": ">=", "≠": "!=",
+}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		r, size := utf8.DecodeRuneInString(src[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9'):
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.' || src[i] == '_') {
+				i++
+			}
+			text := src[start:i]
+			num, err := strconv.ParseFloat(strings.ReplaceAll(text, "_", ""), 64)
+			if err != nil {
+				return nil, &SyntaxError{Pos: start, Msg: fmt.Sprintf("invalid number %q", text)}
+			}
+			if i < len(src) && src[i] == '%' {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[start:i], pos: start, num: num})
+		case r == '"' || r == '\'':
+			start := i
+			i += size
+			var b strings.Builder
+			for {
+				if i >= len(src) {
+					return nil, &SyntaxError{Pos: start, Msg: "unterminated string"}
+				}
+				c, n := utf8.DecodeRuneInString(src[i:])
+				i += n
+				if c == r {
+					break
+				}
+				if c == '\\' && i < len(src) {
+					c, n = utf8.DecodeRuneInString(src[i:])
+					i += n
+				}
+				b.WriteRune(c)
+			}
+			tokens = append(tokens, token{kind: tokString, text: b.String(), pos: start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(src) {
+				c, n := utf8.DecodeRuneInString(src[i:])
+				if !unicode.IsLetter(c) && !unicode.IsDigit(c) && c != '_' {
+					break
+				}
+				i += n
+			}
+			word := src[start:i]
+			switch lower := strings.ToLower(word); lower {
+			case "and", "or", "not", "in", "true", "false":
+				tokens = append(tokens, token{kind: tokOp, text: lower, pos: start})
+			default:
+				tokens = append(tokens, token{kind: tokIdent, text: word, pos: start})
+			}
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "<=", ">=", "≤", "≥", "≠", "<", ">", "!", "+", "-", "*", "/", "(", ")", "[", "]", ","} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, &SyntaxError{Pos: i, Msg: fmt.Sprintf("unexpected character %q", r)}
+			}
+			text := op
+			if alias, ok := operatorAliases[op]; ok {
+				text = alias
+			}
+			tokens = append(tokens, token{kind: tokOp, text: text, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, text: "end of expression", pos: len(src)}), nil
+}
+
+// Parser
+
+type parser struct {
+	tokens []token
+	pos    int
+	vars   map[string]Type
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is one of the operators.
+func (p *parser) accept(ops ...string) (token, bool) {
+	tok := p.peek()
+	if tok.kind != tokOp {
+		return tok, false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			return p.next(), true
+		}
+	}
+	return tok, false
+}
+
+func (p *parser) expect(op string) error {
+	if tok, ok := p.accept(op); !ok {
+		return &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf("expected %q, got %q", op, tok.text)}
+	}
+	return nil
+}
+
+func typeError(tok token, msg string, args ...interface{}) error {
+	return &SyntaxError{Pos: tok.pos, Msg: fmt.Sprintf(msg, args...)}
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.accept("or")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if left.typ() != TypeBool || right.typ() != TypeBool {
+			return nil, typeError(tok, "or needs true or false on both sides")
+		}
+		left = &logicalNode{op: "or", left: left, right: right}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.accept("and")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if left.typ() != TypeBool || right.typ() != TypeBool {
+			return nil, typeError(tok, "and needs true or false on both sides")
+		}
+		left = &logicalNode{op: "and", left: left, right: right}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	if tok, ok := p.accept("not"); ok {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if operand.typ() != TypeBool {
+			return nil, typeError(tok, "not needs true or false")
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if tok, ok := p.accept("in"); ok {
+		if left.typ() == TypeBool {
+			return nil, typeError(tok, "in needs a number or a string")
+		}
+		list, err := p.parseList(left.typ())
+		if err != nil {
+			return nil, err
+		}
+		return &inNode{value: left, list: list}, nil
+	}
+	tok, ok := p.accept("==", "!=", "<", "<=", ">", ">=")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if left.typ() != right.typ() {
+		return nil, typeError(tok, "cannot compare %s with %s", left.typ(), right.typ())
+	}
+	if left.typ() != TypeNumber && tok.text != "==" && tok.text != "!=" {
+		return nil, typeError(tok, "%s only works on numbers", tok.text)
+	}
+	return &compareNode{op: tok.text, left: left, right: right}, nil
+}
+
+func (p *parser) parseList(elem Type) ([]interface{}, error) {
+	if err := p.expect("["); err != nil {
+		return nil, err
+	}
+	var list []interface{}
+	for {
+		if _, ok := p.accept("]"); ok {
+			return list, nil
+		}
+		if len(list) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		tok := p.next()
+		switch {
+		case tok.kind == tokNumber && elem == TypeNumber:
+			list = append(list, tok.num)
+		case tok.kind == tokString && elem == TypeString:
+			list = append(list, tok.text)
+		default:
+			return nil, typeError(tok, "expected a %s in the list, got %q", elem, tok.text)
+		}
+	}
+}
+
+func (p *parser) parseSum() (node, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.accept("+", "-")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		if left.typ() != TypeNumber || right.typ() != TypeNumber {
+			return nil, typeError(tok, "%s only works on numbers", tok.text)
+		}
+		left = &arithmeticNode{op: tok.text, left: left, right: right}
+	}
+}
+
+func (p *parser) parseProduct() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.accept("*", "/")
+		if !ok {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if left.typ() != TypeNumber || right.typ() != TypeNumber {
+			return nil, typeError(tok, "%s only works on numbers", tok.text)
+		}
+		left = &arithmeticNode{op: tok.text, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if tok, ok := p.accept("-"); ok {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if operand.typ() != TypeNumber {
+			return nil, typeError(tok, "- only works on numbers")
+		}
+		return &arithmeticNode{op: "-", left: &literalNode{value: 0.0, t: TypeNumber}, right: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		return &literalNode{value: tok.num, t: TypeNumber}, nil
+	case tokString:
+		return &literalNode{value: tok.text, t: TypeString}, nil
+	case tokIdent:
+		name := strings.ToLower(tok.text)
+		t, ok := p.vars[name]
+		if !ok {
+			return nil, typeError(tok, "unknown variable %q", tok.text)
+		}
+		return &variableNode{name: name, t: t}, nil
+	case tokOp:
+		switch tok.text {
+		case "true", "false":
+			return &literalNode{value: tok.text == "true", t: TypeBool}, nil
+		case "(":
+			inner, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+		}
+	}
+	return nil, typeError(tok, "unexpected %q", tok.text)
+}
+
+// Evaluation
+
+type node interface {
+	typ() Type
+	eval(env Env) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+	t     Type
+}
+
+func (n *literalNode) typ() Type                     { return n.t }
+func (n *literalNode) eval(Env) (interface{}, error) { return n.value, nil }
+
+type variableNode struct {
+	name string
+	t    Type
+}
+
+func (n *variableNode) typ() Type { return n.t }
+
+func (n *variableNode) eval(env Env) (interface{}, error) {
+	v, ok := env[n.name]
+	if !ok || v == nil {
+		return zeroValue(n.t), nil
+	}
+	switch n.t {
+	case TypeNumber:
+		switch x := v.(type) {
+		case float64:
+			return x, nil
+		case int:
+			return float64(x), nil
+		case int64:
+			return float64(x), nil
+		}
+	case TypeString:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case TypeBool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("variable %s is %T, want %s", n.name, v, n.t)
+}
+
+func zeroValue(t Type) interface{} {
+	switch t {
+	case TypeNumber:
+		return 0.0
+	case TypeString:
+		return ""
+	default:
+		return false
+	}
+}
+
+type logicalNode struct {
+	op          string
+	left, right node
+}
+
+func (n *logicalNode) typ() Type { return TypeBool }
+
+func (n *logicalNode) eval(env Env) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "and" && !left.(bool) || n.op == "or" && left.(bool) {
+		return left, nil
+	}
+	return n.right.eval(env)
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n *notNode) typ() Type { return TypeBool }
+
+func (n *notNode) eval(env Env) (interface{}, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return !v.(bool), nil
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n *compareNode) typ() Type { return TypeBool }
+
+func (n *compareNode) eval(env Env) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	}
+	a, b := left.(float64), right.(float64)
+	switch n.op {
+	case "<":
+		return a < b, nil
+	case "<=":
+		return a <= b, nil
+	case ">":
+		return a > b, nil
+	default:
+		return a >= b, nil
+	}
+}
+
+func equal(a, b interface{}) bool {
+	if s, ok := a.(string); ok {
+		return strings.EqualFold(strings.TrimSpace(s), strings.TrimSpace(b.(string)))
+	}
+	return a == b
+}
+
+type inNode struct {
+	value node
+	list  []interface{}
+}
+
+func (n *inNode) typ() Type { return TypeBool }
+
+func (n *inNode) eval(env Env) (interface{}, error) {
+	v, err := n.value.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range n.list {
+		if equal(v, item) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type arithmeticNode struct {
+	op          string
+	left, right node
+}
+
+func (n *arithmeticNode) typ() Type { return TypeNumber }
+
+func (n *arithmeticNode) eval(env Env) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	a, b := left.(float64), right.(float64)
+	switch n.op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	default:
+		if b == 0 {
+			return nil, ErrDivisionByZero
+		}
+		if r := a / b; !math.IsInf(r, 0) {
+			return r, nil
+		}
+		return nil, ErrDivisionByZero
+	}
+}
@@ -0,0 +1,114 @@
+package ruleexpr
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	facts := Facts{
+		Price:       3000,
+		Valuation:   4000,
+		Profit:      1000,
+		Discount:    25,
+		Confidence:  0.8,
+		Condition:   "good",
+		Category:    "Phone",
+		Risk:        0.2,
+		Marketplace: "Blocket",
+		DaysToSell:  10,
+		Shipping:    true,
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"profit ≥ 500 and (discount ≥ 25% or days_to_sell ≤ 7) and risk < 0.3 and shipping", true},
+		{"profit >= 500 && (discount > 25 || days_to_sell <= 7)", false},
+		{"category == 'phone'", true},
+		{`category in ["laptop", "PHONE"] and marketplace != "tradera"`, true},
+		{"condition in ['fair', 'poor']", false},
+		{"not shipping or price < 1000", false},
+		{"!(risk > 0.5)", true},
+		{"valuation - price == profit", true},
+		{"price * 1.5 >= valuation", true},
+		{"profit / valuation * 100 >= 25", true},
+		{"-profit < 0", true},
+		{"confidence >= 0.75", true},
+		{"days_to_sell in [7, 10, 14]", true},
+		{"1_000 <= profit", true},
+		{"true", true},
+	}
+	for _, tt := range tests {
+		got, err := Check(tt.expr, facts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"", "empty expression"},
+		{"profit >=", "unexpected"},
+		{"profit >= 500 and", "unexpected"},
+		{"profti > 5", `unknown variable "profti"`},
+		{"profit", "not true or false"},
+		{"category > 'a'", "only works on numbers"},
+		{"category == 5", "cannot compare string with number"},
+		{"shipping + 1 > 0", "only works on numbers"},
+		{"profit > 5 and 7", "and needs true or false"},
+		{"(profit > 5", `expected ")"`},
+		{"category == 'phone", "unterminated string"},
+		{"profit > 5 ; drop", "unexpected character"},
+		{"category in [1, 2]", "expected a string in the list"},
+		{"shipping in [true]", "in needs a number or a string"},
+		{"profit > 5 price", `unexpected "price"`},
+		{strings.Repeat("x", MaxLength+1), "longer than"},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.expr)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%q: err = %v, want a syntax error", tt.expr, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: err = %v, want %q", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestEvalDivisionByZero(t *testing.T) {
+	program, err := Compile("profit / valuation > 0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := program.Eval(Facts{Profit: 100}.Env()); !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("err = %v, want division by zero", err)
+	}
+	if _, err := program.Eval(Env{}); !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("missing variables should be zero, err = %v", err)
+	}
+}
+
+func TestVariablesMatchFacts(t *testing.T) {
+	env := Facts{}.Env()
+	if len(env) != len(Variables) {
+		t.Fatalf("facts have %d variables, want %d", len(env), len(Variables))
+	}
+	for _, v := range Variables {
+		if _, ok := env[v.Name]; !ok {
+			t.Errorf("facts lack %s", v.Name)
+		}
+	}
+}
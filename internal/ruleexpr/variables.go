@@ -0,0 +1,73 @@
+package ruleexpr
+
+// Variable is a value trading rule expressions can use.
+type Variable struct {
+	Name        string `json:"name"`
+	Type        Type   `json:"type"`
+	Description string `json:"description"`
+}
+
+// Variables are the values a trading rule expression is evaluated with.
+// Values that are not known for a listing are 0, "" or false.
+var Variables = []Variable{
+	{"price", TypeNumber, "Asking price in SEK."},
+	{"valuation", TypeNumber, "Valuation in SEK, adjusted for the condition in the photos."},
+	{"profit", TypeNumber, "Net profit in SEK of selling at the valuation, after shipping, fees and costs."},
+	{"discount", TypeNumber, "Net profit as a percentage of the valuation, e.g. 25 or 25%."},
+	{"confidence", TypeNumber, "Confidence of the valuation, 0 to 1."},
+	{"condition", TypeString, "Condition seen in the photos: new, like_new, good, fair, poor or unknown."},
+	{"category", TypeString, "Product category."},
+	{"risk", TypeNumber, "Risk from the photo analysis, 0 (none) to 1 (other model or stolen photos)."},
+	{"marketplace", TypeString, "Marketplace name, e.g. Blocket or Tradera."},
+	{"days_to_sell", TypeNumber, "Expected days to sell, from the valuation sources that report it."},
+	{"shipping", TypeBool, "Whether the item can be shipped."},
+}
+
+var variableTypes = func() map[string]Type {
+	types := make(map[string]Type, len(Variables))
+	for _, v := range Variables {
+		types[v.Name] = v.Type
+	}
+	return types
+}()
+
+// Facts are the values of Variables for one listing.
+type Facts struct {
+	Price       int     `json:"price"`
+	Valuation   int     `json:"valuation"`
+	Profit      int     `json:"profit"`
+	Discount    float64 `json:"discount"`
+	Confidence  float64 `json:"confidence"`
+	Condition   string  `json:"condition"`
+	Category    string  `json:"category"`
+	Risk        float64 `json:"risk"`
+	Marketplace string  `json:"marketplace"`
+	DaysToSell  int     `json:"days_to_sell"`
+	Shipping    bool    `json:"shipping"`
+}
+
+// Env returns the facts as variables for Eval.
+func (f Facts) Env() Env {
+	return Env{
+		"price":        f.Price,
+		"valuation":    f.Valuation,
+		"profit":       f.Profit,
+		"discount":     f.Discount,
+		"confidence":   f.Confidence,
+		"condition":    f.Condition,
+		"category":     f.Category,
+		"risk":         f.Risk,
+		"marketplace":  f.Marketplace,
+		"days_to_sell": f.DaysToSell,
+		"shipping":     f.Shipping,
+	}
+}
+
+// Check compiles and evaluates an expression with facts.
+func Check(expression string, facts Facts) (bool, error) {
+	program, err := Compile(expression)
+	if err != nil {
+		return false, err
+	}
+	return program.Eval(facts.Env())
+}
@@ -2,11 +2,14 @@ package services
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"begbot/internal/config"
@@ -37,6 +40,8 @@ type BotService struct {
 	jobID               string
 	scrapingRunID       int64
 	searchTermsOverride []models.SearchTerm
+	cascadeStats        *CascadeStats
+	cascadeProducts     []models.Product
 }
 
 func NewBotService(cfg *config.Config, marketplaceService *MarketplaceService, cacheService *CacheService, llmService *LLMService, valuationService *ValuationService, database *db.Postgres) *BotService {
@@ -82,6 +87,9 @@ func (s *BotService) Run() error {
 
 	s.log(LogLevelInfo, "=== STARTING BEGBOT ===")
 
+	cacheStats := &LLMCacheStats{}
+	ctx = WithLLMCacheStats(ctx, cacheStats)
+
 	scrapingRun := &models.ScrapingRun{
 		StartedAt: time.Now(),
 		Status:    "running",
@@ -90,6 +98,7 @@ func (s *BotService) Run() error {
 		s.log(LogLevelWarning, "Failed to create scraping run: %v", err)
 	} else {
 		s.scrapingRunID = scrapingRun.ID
+		ctx = WithLLMRunID(ctx, scrapingRun.ID)
 	}
 
 	searchTerms, err := s.database.GetActiveSearchTerms(ctx)
@@ -117,7 +126,15 @@ func (s *BotService) Run() error {
 			MinDiscount:  intPtr(0),
 		}
 	}
-	s.log(LogLevelInfo, "Trading rules: min_profit_sek=%d, min_discount=%d", ptrVal(tradingRules.MinProfitSEK), ptrVal(tradingRules.MinDiscount))
+	s.log(LogLevelInfo, "Default trading rules: min_profit_sek=%d, min_discount=%d", ptrVal(tradingRules.MinProfitSEK), ptrVal(tradingRules.MinDiscount))
+
+	s.cascadeStats = &CascadeStats{}
+	if s.cfg.Cascade.Enabled {
+		s.cascadeProducts, err = s.database.GetEnabledProducts(ctx)
+		if err != nil {
+			s.log(LogLevelWarning, "Failed to get products for the cascade: %v", err)
+		}
+	}
 
 	if s.jobService != nil && s.jobID != "" {
 		s.jobService.StartJob(s.jobID)
@@ -126,6 +143,7 @@ func (s *BotService) Run() error {
 
 	totalAdsFound := 0
 	totalListingsSaved := 0
+	extractionFailures := 0
 	for i, term := range searchTerms {
 		// Check for cancellation before each search term
 		if s.jobService != nil && s.jobID != "" {
@@ -161,10 +179,30 @@ func (s *BotService) Run() error {
 				s.log(LogLevelInfo, "Skipping duplicate: %s", ad.Link)
 				continue
 			}
+			inReview, err := s.database.ReviewItemExistsByLink(ctx, ad.Link)
+			if err != nil {
+				s.log(LogLevelError, "Error checking review queue: %v", err)
+				continue
+			}
+			if inReview {
+				s.log(LogLevelInfo, "Skipping ad in review queue: %s", ad.Link)
+				continue
+			}
 			newAdsCount++
+			ad.SearchTermID = term.ID
+			if term.MarketplaceID != nil {
+				ad.MarketplaceID = *term.MarketplaceID
+			}
+			if !s.passesCascade(ctx, ad) {
+				continue
+			}
 			s.log(LogLevelInfo, "Processing new ad: %s (price: %.0f SEK)", ad.Link, ad.Price)
 			if err := s.processAd(ctx, ad); err != nil {
 				s.log(LogLevelError, "Error processing ad %s: %v", ad.Link, err)
+				var outputErr *LLMOutputError
+				if errors.As(err, &outputErr) {
+					extractionFailures++
+				}
 			} else {
 				totalListingsSaved++
 			}
@@ -206,13 +244,26 @@ func (s *BotService) Run() error {
 			Status:             "completed",
 			TotalAdsFound:      totalAdsFound,
 			TotalListingsSaved: totalListingsSaved,
+			ExtractionFailures: extractionFailures,
+			LLMCacheHits:       cacheStats.Hits(),
+			LLMCacheMisses:     cacheStats.Misses(),
+		}
+		if stages := s.cascadeStats.Stages(); len(stages) > 0 {
+			if encoded, err := json.Marshal(stages); err == nil {
+				run.CascadeStats = encoded
+			}
+		}
+		if extractionFailures > 0 {
+			msg := fmt.Sprintf("%d ads could not be extracted by the LLM", extractionFailures)
+			run.ErrorMessage = &msg
 		}
 		if err := s.database.UpdateScrapingRun(ctx, run); err != nil {
 			s.log(LogLevelWarning, "Failed to update scraping run: %v", err)
 		}
 	}
 
-	s.log(LogLevelInfo, "=== BEGBOT FINISHED: Total ads found: %d, Listings saved: %d ===", totalAdsFound, totalListingsSaved)
+	s.log(LogLevelInfo, "=== BEGBOT FINISHED: Total ads found: %d, Listings saved: %d, Extraction failures: %d, LLM cache hits: %d, misses: %d, cascade: %s ===",
+		totalAdsFound, totalListingsSaved, extractionFailures, cacheStats.Hits(), cacheStats.Misses(), s.cascadeStats)
 	return nil
 }
 
@@ -263,6 +314,10 @@ func intPtr(i int) *int {
 	return &i
 }
 
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
 func ptrVal(p *int) int {
 	if p == nil {
 		return 0
@@ -296,12 +351,27 @@ func (s *BotService) processAd(ctx context.Context, ad RawAd) error {
 		return err
 	}
 
+	parked, err := s.parkForReview(ctx, ad, productInfo, validatedProduct)
+	if err != nil {
+		s.log(LogLevelWarning, "Failed to park ad for review: %v", err)
+	} else if parked {
+		return nil
+	}
+
 	if validatedProduct == nil {
 		return nil
 	}
 
 	s.log(LogLevelInfo, "Product identified: %s %s (%s)", productInfo.Manufacturer, productInfo.Model, productInfo.Category)
 
+	_, err = s.valueAndSaveListing(ctx, ad, item, productInfo, validatedProduct)
+	return err
+}
+
+// valueAndSaveListing values an ad for a known product, saves it as a
+// listing with its valuations and image analysis, and checks the trading
+// rules.
+func (s *BotService) valueAndSaveListing(ctx context.Context, ad RawAd, item *models.TradedItem, productInfo *ProductInfo, validatedProduct *models.Product) (*models.Listing, error) {
 	item.ProductID = &validatedProduct.ID
 	if item.SellPackagingCost == nil {
 		packagingCost := validatedProduct.SellPackagingCost
@@ -315,13 +385,22 @@ func (s *BotService) processAd(ctx context.Context, ad RawAd) error {
 	candidate, err := s.evaluateItem(ctx, item, productInfo)
 	if err != nil {
 		s.log(LogLevelError, "Failed to evaluate item: %v", err)
-		return err
+		return nil, err
+	}
+
+	images, err := s.llmService.AnalyzeImages(ctx, productInfo, ad.ImageURLs)
+	if err != nil {
+		s.log(LogLevelWarning, "Failed to analyze images: %v", err)
 	}
 
 	// Save listing for validated product
 	productID := validatedProduct.ID
 	price := item.BuyPrice
-	marketplaceID := int64(1) // Blocket
+	// Ads of search terms without a marketplace come from Blocket
+	marketplaceID := int64(1)
+	if ad.MarketplaceID != 0 {
+		marketplaceID = ad.MarketplaceID
+	}
 	now := time.Now()
 
 	// Collect all valuations from different methods
@@ -333,8 +412,9 @@ func (s *BotService) processAd(ctx context.Context, ad RawAd) error {
 
 	// Compile valuations into a final recommendation
 	var compiledValuation int
+	var output *ValuationOutput
 	if len(valInputs) > 0 {
-		output, err := s.valuationService.Compile(ctx, valInputs)
+		output, err = s.valuationService.CompileForProduct(ctx, validatedProduct, valInputs)
 		if err != nil {
 			s.log(LogLevelWarning, "Failed to compile valuations: %v", err)
 			compiledValuation = candidate.EstimatedSell
@@ -345,9 +425,16 @@ func (s *BotService) processAd(ctx context.Context, ad RawAd) error {
 		compiledValuation = candidate.EstimatedSell
 	}
 
+	adjustments := listingAdjustments(productInfo)
+	if adjusted, adjustment := images.AdjustValuation(compiledValuation); adjustment != nil {
+		compiledValuation = adjusted
+		adjustments = append(adjustments, *adjustment)
+	}
+
 	listing := &models.Listing{
 		ProductID:       &productID,
 		Price:           &price,
+		Valuation:       compiledValuation,
 		Link:            ad.Link,
 		Title:           ad.Title,
 		Description:     &ad.AdText,
@@ -356,13 +443,29 @@ func (s *BotService) processAd(ctx context.Context, ad RawAd) error {
 		PublicationDate: &now,
 		IsMyListing:     false,
 	}
+	if ad.SearchTermID != 0 {
+		listing.SearchTermID = &ad.SearchTermID
+	}
 
 	if err := s.database.SaveListing(ctx, listing); err != nil {
 		s.log(LogLevelError, "Failed to save listing: %v", err)
-		return err
+		return nil, err
 	}
 	s.log(LogLevelInfo, "Saved listing for %s at %d SEK (valuation: %d SEK)", *validatedProduct.Name, item.BuyPrice, compiledValuation)
 
+	if len(ad.ImageURLs) > 0 {
+		if err := s.database.SaveImageLinks(ctx, listing.ID, ad.ImageURLs); err != nil {
+			s.log(LogLevelWarning, "Failed to save image links: %v", err)
+		}
+	}
+	if images != nil {
+		if record, err := images.Record(listing.ID); err != nil {
+			s.log(LogLevelWarning, "Failed to encode image analysis: %v", err)
+		} else if err := s.database.SaveListingImageAnalysis(ctx, record); err != nil {
+			s.log(LogLevelWarning, "Failed to save image analysis: %v", err)
+		}
+	}
+
 	// Save individual valuations to the database
 	if len(valInputs) > 0 {
 		productIDStr := fmt.Sprintf("%d", productID)
@@ -371,19 +474,35 @@ func (s *BotService) processAd(ctx context.Context, ad RawAd) error {
 		}
 	}
 
-	// Check trading rules and send email if listing passes
+	// Check trading rules and keep a snapshot of how the listing was valued
+	verdict := s.evaluateTradingRules(ctx, s.newTradingRuleEnv(ctx), listing, output, images)
+	snapshot, err := BuildListingValuation(listing.ID, output, valInputs, adjustments, verdict)
+	if err != nil {
+		s.log(LogLevelWarning, "Failed to build valuation snapshot: %v", err)
+	} else if err := s.valuationService.SaveListingValuation(ctx, snapshot); err != nil {
+		s.log(LogLevelWarning, "Failed to save valuation snapshot: %v", err)
+	}
+	if verdict.Score != nil {
+		if err := s.database.SaveOpportunityScore(ctx, listing.ID, verdict.Score.Score); err != nil {
+			s.log(LogLevelWarning, "Failed to save opportunity score: %v", err)
+		} else {
+			listing.OpportunityScore = &verdict.Score.Score
+		}
+	}
+
+	notifyCtx := context.WithoutCancel(ctx)
 	go func() {
-		err := s.SendTradingRuleEmail(ctx, listing, validatedProduct)
+		err := s.notifyTradingRuleMatch(notifyCtx, listing, validatedProduct, verdict)
 		if err != nil {
 			s.log(LogLevelWarning, "Failed to send trading rule email: %v", err)
 		}
 	}()
 
 	if candidate.ShouldBuy {
-		s.log(LogLevelInfo, "RECOMMENDATION: Buy %s for %d SEK (profit: %d SEK)", item.SourceLink, candidate.TotalCost, candidate.EstimatedSell-candidate.TotalCost)
+		s.log(LogLevelInfo, "RECOMMENDATION: Buy %s for %d SEK (profit: %d SEK)", item.SourceLink, candidate.TotalCost, candidate.NetProfit)
 	}
 
-	return nil
+	return listing, nil
 }
 
 func (s *BotService) evaluateItem(ctx context.Context, item *models.TradedItem, productInfo *ProductInfo) (*models.TradedItemCandidate, error) {
@@ -394,9 +513,18 @@ func (s *BotService) evaluateItem(ctx context.Context, item *models.TradedItem,
 
 	estimatedSellPrice := s.valuationService.CalculatePriceForDays(s.cfg.Valuation.TargetSellDays, historicalValuation)
 
+	// Value the item at the expected sell date rather than today
+	if item.ProductID != nil {
+		estimatedSellPrice = s.valuationService.ForecastValue(ctx, *item.ProductID, estimatedSellPrice, s.cfg.Valuation.TargetSellDays)
+	}
+
 	totalCost := item.BuyPrice + item.BuyShippingCost
-	estimatedProfit := int(estimatedSellPrice) - totalCost
-	profitMargin := float64(estimatedProfit) / float64(totalCost)
+	profit := s.costModel().Calculate(models.ProfitInput{
+		BuyPrice:    item.BuyPrice,
+		BuyShipping: item.BuyShippingCost,
+		SellPrice:   int(estimatedSellPrice),
+	})
+	profitMargin := s.valuationService.CalculateProfitMargin(float64(profit.NetProfit), float64(item.BuyPrice), float64(item.BuyShippingCost))
 	shouldBuy := s.valuationService.ShouldBuy(profitMargin)
 
 	return &models.TradedItemCandidate{
@@ -404,10 +532,19 @@ func (s *BotService) evaluateItem(ctx context.Context, item *models.TradedItem,
 		EstimatedSell: int(estimatedSellPrice),
 		ShippingCost:  item.BuyShippingCost,
 		TotalCost:     totalCost,
+		NetProfit:     profit.NetProfit,
 		ShouldBuy:     shouldBuy,
 	}, nil
 }
 
+// costModel returns the configured cost model, or one without fees.
+func (s *BotService) costModel() models.CostModel {
+	if s.cfg == nil {
+		return models.CostModel{}
+	}
+	return s.cfg.Costs
+}
+
 func (s *BotService) ValidateListing(ctx context.Context, ad RawAd) (*models.Product, error) {
 	productInfo, err := s.llmService.ExtractProductInfo(ctx, ad.AdText, ad.Link)
 	if err != nil {
@@ -440,52 +577,67 @@ func (s *BotService) ValidateListing(ctx context.Context, ad RawAd) (*models.Pro
 }
 
 func (s *BotService) SendTradingRuleEmail(ctx context.Context, listing *models.Listing, product *models.Product) error {
-	var tradingRules *models.Economics
-	var err error
+	verdict := s.evaluateTradingRules(ctx, s.newTradingRuleEnv(ctx), listing, s.storedValuationOutput(ctx, listing.ID), s.storedImageAnalysis(ctx, listing.ID))
+	return s.notifyTradingRuleMatch(ctx, listing, product, verdict)
+}
 
-	if s.database != nil {
-		tradingRules, err = s.database.GetTradingRules(ctx)
-		if err != nil {
-			s.log(LogLevelWarning, "Failed to get trading rules: %v", err)
-		}
+// storedImageAnalysis reads the image analysis saved with a listing, or nil
+// when there is none.
+func (s *BotService) storedImageAnalysis(ctx context.Context, listingID int64) *ImageAnalysis {
+	if s.database == nil || listingID <= 0 {
+		return nil
 	}
-
-	if tradingRules == nil {
-		defaultProfit := 0
-		defaultDiscount := 0
-		tradingRules = &models.Economics{
-			MinProfitSEK: &defaultProfit,
-			MinDiscount:  &defaultDiscount,
-		}
+	record, err := s.database.GetListingImageAnalysis(ctx, listingID)
+	if err != nil {
+		s.log(LogLevelWarning, "Failed to load image analysis: %v", err)
+		return nil
 	}
-
-	minProfitSEK := 0
-	if tradingRules.MinProfitSEK != nil {
-		minProfitSEK = *tradingRules.MinProfitSEK
+	images, err := imageAnalysisFromRecord(record)
+	if err != nil {
+		s.log(LogLevelWarning, "Failed to load image analysis: %v", err)
+		return nil
 	}
+	return images
+}
 
-	minDiscount := 0
-	if tradingRules.MinDiscount != nil {
-		minDiscount = *tradingRules.MinDiscount
+// storedValuationOutput reads the valuation saved with a listing, or nil
+// when there is none.
+func (s *BotService) storedValuationOutput(ctx context.Context, listingID int64) *ValuationOutput {
+	if s.database == nil || listingID <= 0 {
+		return nil
 	}
-
-	// Use computed product-level valuation; fall back to listing.Valuation when DB is unavailable
-	computedValuation := listing.Valuation
-	if listing.ProductID != nil && s.database != nil {
-		if cv, cvErr := s.database.ComputeWeightedValuationForProduct(ctx, *listing.ProductID); cvErr == nil && cv > 0 {
-			computedValuation = cv
-		}
+	snapshot, err := s.database.GetListingValuation(ctx, listingID)
+	if err != nil {
+		s.log(LogLevelWarning, "Failed to load valuation snapshot: %v", err)
+		return nil
 	}
+	if snapshot == nil || len(snapshot.Compiled) == 0 {
+		return nil
+	}
+	var output ValuationOutput
+	if err := json.Unmarshal(snapshot.Compiled, &output); err != nil {
+		s.log(LogLevelWarning, "Failed to decode valuation snapshot: %v", err)
+		return nil
+	}
+	return &output
+}
 
-	profit := computedValuation - *listing.Price
-	discountPercent := float64(profit) / float64(computedValuation) * 100
-
-	if profit <= minProfitSEK || discountPercent <= float64(minDiscount) {
-		s.log(LogLevelInfo, "Listing does not pass trading rules: profit=%d (>%d), discount=%.2f%% (>%d%%)",
-			profit, minProfitSEK, discountPercent, minDiscount)
+// notifyTradingRuleMatch sends the trading rule email for a listing whose
+// verdict passed with an opportunity score of at least the notification
+// threshold. Other listings are only logged.
+func (s *BotService) notifyTradingRuleMatch(ctx context.Context, listing *models.Listing, product *models.Product, verdict *TradingRuleVerdict) error {
+	if !verdict.Passed {
+		s.log(LogLevelInfo, "Listing does not pass trading rules: %s", strings.Join(verdict.Reasons, "; "))
+		return nil
+	}
+	if minScore := s.scoringModel().MinNotifyScore; verdict.Score != nil && verdict.Score.Score < minScore {
+		s.log(LogLevelInfo, "Listing scores %.1f, below the notification threshold %.1f", verdict.Score.Score, minScore)
 		return nil
 	}
 
+	computedValuation := verdict.Valuation
+	discountPercent := verdict.DiscountPercent
+
 	go func() {
 		emailCfg := EmailConfig{
 			SMTPHost:     s.cfg.Email.SMTPHost,
@@ -503,11 +655,11 @@ func (s *BotService) SendTradingRuleEmail(ctx context.Context, listing *models.L
 		if listing.Price != nil {
 			priceStr = fmt.Sprintf("%d kr", *listing.Price)
 		}
-		emailProfit := computedValuation
-		if listing.Price != nil {
-			emailProfit = computedValuation - *listing.Price
+		profitStr := fmt.Sprintf("%d kr", verdict.Profit)
+		scoreStr := ""
+		if verdict.Score != nil {
+			scoreStr = fmt.Sprintf("%.0f av 100", verdict.Score.Score)
 		}
-		profitStr := fmt.Sprintf("%d kr", emailProfit)
 		discountStr := fmt.Sprintf("%.0f%%", discountPercent)
 
 		desc := ""
@@ -556,6 +708,9 @@ func (s *BotService) SendTradingRuleEmail(ctx context.Context, listing *models.L
 			"NewPrice":    newPrice,
 			"Brand":       brand,
 			"Name":        name,
+			"Rule":        verdict.RuleName,
+			"Exposure":    strings.Join(verdict.ExposureViolations, ", "),
+			"Score":       scoreStr,
 		}
 
 		err := SendMailHTMLWithData(emailCfg, s.cfg.Email.Recipients, subject, "mail.html", mailData)
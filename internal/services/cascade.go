@@ -0,0 +1,242 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"begbot/internal/models"
+)
+
+// Cascade stages, in the order an ad meets them.
+const (
+	CascadeStageRules     = "rules"
+	CascadeStagePreFilter = "prefilter"
+	CascadeStagePrice     = "price"
+)
+
+var cascadeStages = []string{CascadeStageRules, CascadeStagePreFilter, CascadeStagePrice}
+
+// preFilterMaxAdText is how much of the ad text is sent to the PreFilter
+// model. The start of an ad says what it is for.
+const preFilterMaxAdText = 500
+
+var preFilterSchema = &OutputSchema{
+	Name: "prefilter",
+	Schema: map[string]interface{}{
+		"type":     "object",
+		"required": []string{"relevant", "product_id"},
+		"properties": map[string]interface{}{
+			"relevant":   map[string]interface{}{"type": "boolean"},
+			"product_id": map[string]interface{}{"type": "integer", "minimum": 0},
+		},
+	},
+}
+
+// CascadeStageStats is how many ads entered and passed a stage.
+type CascadeStageStats struct {
+	Stage   string `json:"stage"`
+	Entered int    `json:"entered"`
+	Passed  int    `json:"passed"`
+}
+
+func (st CascadeStageStats) PassRate() float64 {
+	if st.Entered == 0 {
+		return 0
+	}
+	return float64(st.Passed) / float64(st.Entered)
+}
+
+// CascadeStats counts ads per cascade stage. It is safe for concurrent use.
+type CascadeStats struct {
+	mu     sync.Mutex
+	stages map[string]*CascadeStageStats
+}
+
+func (cs *CascadeStats) record(stage string, passed bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.stages == nil {
+		cs.stages = make(map[string]*CascadeStageStats)
+	}
+	st, ok := cs.stages[stage]
+	if !ok {
+		st = &CascadeStageStats{Stage: stage}
+		cs.stages[stage] = st
+	}
+	st.Entered++
+	if passed {
+		st.Passed++
+	}
+}
+
+// Stages returns the counts of the stages ads entered, in cascade order.
+func (cs *CascadeStats) Stages() []CascadeStageStats {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	var stages []CascadeStageStats
+	for _, name := range cascadeStages {
+		if st, ok := cs.stages[name]; ok {
+			stages = append(stages, *st)
+		}
+	}
+	return stages
+}
+
+func (cs *CascadeStats) String() string {
+	var parts []string
+	for _, st := range cs.Stages() {
+		parts = append(parts, fmt.Sprintf("%s %d/%d (%.0f%%)", st.Stage, st.Passed, st.Entered, st.PassRate()*100))
+	}
+	if len(parts) == 0 {
+		return "no ads"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// PreFilterResult is the cheap model's verdict on an ad.
+type PreFilterResult struct {
+	Relevant  bool  `json:"relevant"`
+	ProductID int64 `json:"product_id"`
+}
+
+// PreFilterAd asks the PreFilter model whether an ad is for one of
+// products. Only the start of the ad text is sent to keep the call cheap.
+func (s *LLMService) PreFilterAd(ctx context.Context, title, adText string, products []models.Product) (*PreFilterResult, error) {
+	if len(adText) > preFilterMaxAdText {
+		adText = strings.ToValidUTF8(adText[:preFilterMaxAdText], "")
+	}
+	type catalogEntry struct {
+		ID   int64
+		Name string
+	}
+	catalog := make([]catalogEntry, 0, len(products))
+	for _, p := range products {
+		catalog = append(catalog, catalogEntry{ID: p.ID, Name: productDisplayName(p)})
+	}
+
+	prompt, err := s.prompts.Render(ctx, PromptPreFilter, map[string]interface{}{
+		"Products": catalog,
+		"Title":    title,
+		"AdText":   adText,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result PreFilterResult
+	if _, err := s.chatJSON(ctx, "PreFilter", prompt, preFilterSchema, &result); err != nil {
+		return nil, err
+	}
+	for _, p := range products {
+		if p.ID == result.ProductID {
+			return &result, nil
+		}
+	}
+	result.ProductID = 0
+	return &result, nil
+}
+
+// matchCatalogProduct is the keyword stage of the cascade. It drops ads
+// containing an excluded keyword and returns the catalog product whose name
+// appears in the ad, preferring the longest name so "iPhone 13 Pro" wins
+// over "iPhone 13". A nil product means no name matched.
+func matchCatalogProduct(ad RawAd, excludeKeywords []string, products []models.Product) (*models.Product, bool) {
+	text := strings.ToLower(ad.Title + " " + ad.AdText)
+	for _, keyword := range excludeKeywords {
+		if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" && strings.Contains(text, keyword) {
+			return nil, false
+		}
+	}
+
+	normalized := normalizeEvalText(text)
+	var best *models.Product
+	bestLen := 0
+	for i := range products {
+		name := ""
+		if products[i].Name != nil {
+			name = normalizeEvalText(*products[i].Name)
+		}
+		if name != "" && len(name) > bestLen && strings.Contains(normalized, name) {
+			best = &products[i]
+			bestLen = len(name)
+		}
+	}
+	return best, true
+}
+
+// passesPriceGate reports whether price is at least minDiscount below
+// valuation. Without a valuation nothing is known and the ad goes on.
+func passesPriceGate(price float64, valuation int, minDiscount float64) bool {
+	if valuation <= 0 {
+		return true
+	}
+	return price <= float64(valuation)*(1-minDiscount)
+}
+
+func productDisplayName(p models.Product) string {
+	var parts []string
+	for _, part := range []*string{p.Brand, p.Name} {
+		if part != nil && *part != "" {
+			parts = append(parts, *part)
+		}
+	}
+	name := strings.Join(parts, " ")
+	if p.Category != nil && *p.Category != "" {
+		name += " (" + *p.Category + ")"
+	}
+	return name
+}
+
+// passesCascade runs the cheap checks of the cascade on a new ad: excluded
+// keywords, a catalog match by name or by the PreFilter model, and the
+// asking price against the cached valuation of the matched product. Only
+// ads that pass go on to full extraction and valuation. Checks that fail
+// with an error let the ad through rather than lose a deal.
+func (s *BotService) passesCascade(ctx context.Context, ad RawAd) bool {
+	if s.cfg == nil || !s.cfg.Cascade.Enabled {
+		return true
+	}
+	cfg := s.cfg.Cascade
+
+	product, ok := matchCatalogProduct(ad, cfg.ExcludeKeywords, s.cascadeProducts)
+	s.cascadeStats.record(CascadeStageRules, ok)
+	if !ok {
+		s.log(LogLevelInfo, "Cascade: excluded keyword in %s - skipping", ad.Link)
+		return false
+	}
+
+	if product == nil && cfg.PreFilter && len(s.cascadeProducts) > 0 {
+		result, err := s.llmService.PreFilterAd(ctx, ad.Title, ad.AdText, s.cascadeProducts)
+		if err != nil {
+			s.log(LogLevelWarning, "Cascade: pre-filter failed for %s: %v", ad.Link, err)
+			return true
+		}
+		s.cascadeStats.record(CascadeStagePreFilter, result.Relevant)
+		if !result.Relevant {
+			s.log(LogLevelInfo, "Cascade: not a catalog product: %s - skipping", ad.Link)
+			return false
+		}
+		for i := range s.cascadeProducts {
+			if s.cascadeProducts[i].ID == result.ProductID {
+				product = &s.cascadeProducts[i]
+			}
+		}
+	}
+
+	if product == nil || s.database == nil {
+		return true
+	}
+	valuation, err := s.database.ComputeWeightedValuationForProduct(ctx, product.ID)
+	if err != nil {
+		s.log(LogLevelWarning, "Cascade: failed to get valuation for product %d: %v", product.ID, err)
+		return true
+	}
+	passed := passesPriceGate(ad.Price, valuation, cfg.MinDiscount)
+	s.cascadeStats.record(CascadeStagePrice, passed)
+	if !passed {
+		s.log(LogLevelInfo, "Cascade: %s at %.0f SEK is not below the valuation %d SEK of %s - skipping", ad.Link, ad.Price, valuation, productDisplayName(*product))
+	}
+	return passed
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"begbot/internal/config"
+	"begbot/internal/models"
+)
+
+func cascadeProducts() []models.Product {
+	apple := "Apple"
+	name13, name13Pro := "iPhone 13", "iPhone 13 Pro"
+	phone := "phone"
+	return []models.Product{
+		{ID: 1, Brand: &apple, Name: &name13, Category: &phone},
+		{ID: 2, Brand: &apple, Name: &name13Pro, Category: &phone},
+	}
+}
+
+func TestMatchCatalogProduct(t *testing.T) {
+	products := cascadeProducts()
+	exclude := []string{"Köpes", " defekt "}
+
+	tests := []struct {
+		name   string
+		ad     RawAd
+		wantID int64
+		wantOK bool
+	}{
+		{"longest name wins", RawAd{Title: "Säljer iphone-13 pro 128GB"}, 2, true},
+		{"name in text", RawAd{Title: "Mobil", AdText: "En iPhone 13 i fint skick"}, 1, true},
+		{"no match", RawAd{Title: "Samsung Galaxy S22"}, 0, true},
+		{"excluded keyword", RawAd{Title: "iPhone 13 KÖPES"}, 0, false},
+		{"excluded in text", RawAd{Title: "iPhone 13", AdText: "Skärmen är defekt"}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product, ok := matchCatalogProduct(tt.ad, exclude, products)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			var id int64
+			if product != nil {
+				id = product.ID
+			}
+			if id != tt.wantID {
+				t.Errorf("product = %d, want %d", id, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestPassesPriceGate(t *testing.T) {
+	if !passesPriceGate(9000, 10000, 0.1) {
+		t.Error("10% below the valuation should pass")
+	}
+	if passesPriceGate(9500, 10000, 0.1) {
+		t.Error("5% below the valuation should not pass")
+	}
+	if !passesPriceGate(20000, 0, 0.1) {
+		t.Error("without a valuation the ad should pass")
+	}
+}
+
+func TestCascadeStats(t *testing.T) {
+	stats := &CascadeStats{}
+	if stats.String() != "no ads" {
+		t.Errorf("empty stats = %q", stats.String())
+	}
+	stats.record(CascadeStagePrice, false)
+	stats.record(CascadeStageRules, true)
+	stats.record(CascadeStageRules, false)
+	stats.record(CascadeStagePrice, true)
+
+	stages := stats.Stages()
+	if len(stages) != 2 || stages[0].Stage != CascadeStageRules || stages[1].Stage != CascadeStagePrice {
+		t.Fatalf("stages = %+v, want rules then price", stages)
+	}
+	if stages[0].Entered != 2 || stages[0].Passed != 1 || stages[0].PassRate() != 0.5 {
+		t.Errorf("rules = %+v", stages[0])
+	}
+	if got := stats.String(); got != "rules 1/2 (50%), price 1/2 (50%)" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestPreFilterAd(t *testing.T) {
+	var got ChatRequest
+	answer := `{"relevant": true, "product_id": 2}`
+	client := LLMClientFunc(func(ctx context.Context, req ChatRequest) (string, error) {
+		got = req
+		return answer, nil
+	})
+	cfg := &config.Config{LLM: config.LLMConfig{
+		DefaultModel: "big-model",
+		Models:       map[string]string{"PreFilter": "small-model"},
+	}}
+	s := NewLLMServiceWithClient(cfg, client)
+	products := cascadeProducts()
+
+	result, err := s.PreFilterAd(context.Background(), "Telefon", strings.Repeat("x", 2000), products)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Relevant || result.ProductID != 2 {
+		t.Errorf("result = %+v", result)
+	}
+	if got.Model != "small-model" {
+		t.Errorf("model = %q, want small-model", got.Model)
+	}
+	prompt := got.Prompt()
+	if !strings.Contains(prompt, "Apple iPhone 13 Pro (phone)") || strings.Contains(prompt, strings.Repeat("x", preFilterMaxAdText+1)) {
+		t.Errorf("prompt should list the catalog and truncate the ad text: %q", prompt)
+	}
+
+	answer = `{"relevant": true, "product_id": 99}`
+	result, err = s.PreFilterAd(context.Background(), "Telefon", "iPhone", products)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ProductID != 0 {
+		t.Errorf("unknown product id should be dropped, got %d", result.ProductID)
+	}
+}
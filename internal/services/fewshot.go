@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"begbot/internal/models"
+)
+
+const (
+	DefaultFewShotMaxChars = 400
+
+	// fewShotRefreshInterval is how often the examples are re-read from
+	// the database, so new corrections are used without a restart.
+	fewShotRefreshInterval = time.Minute
+)
+
+// defaultFewShotSources are the human corrections from the review queue.
+var defaultFewShotSources = []string{reviewEvalSource}
+
+// fewShotStore provides labelled ads. *db.Postgres implements it.
+type fewShotStore interface {
+	GetLLMEvalCasesBySource(ctx context.Context, sources []string) ([]models.LLMEvalCase, error)
+}
+
+// FewShotExample is a corrected ad and the answer it should have got.
+type FewShotExample struct {
+	AdText string
+	// Output is the expected JSON answer.
+	Output     string
+	Similarity float64
+}
+
+// fewShotExamples keeps the labelled ads in memory for retrieval by word
+// overlap.
+type fewShotExamples struct {
+	mu       sync.Mutex
+	store    fewShotStore
+	cases    []fewShotCase
+	loadedAt time.Time
+}
+
+type fewShotCase struct {
+	models.LLMEvalCase
+	words map[string]bool
+	text  string
+}
+
+func (x *fewShotExamples) useStore(store fewShotStore) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.store = store
+	x.loadedAt = time.Time{}
+}
+
+// load returns the cases, re-reading the store when they are older than
+// the refresh interval. Failures are logged and the previous cases kept.
+func (x *fewShotExamples) load(ctx context.Context, sources []string) []fewShotCase {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.store == nil || time.Since(x.loadedAt) < fewShotRefreshInterval {
+		return x.cases
+	}
+	x.loadedAt = time.Now()
+	labelled, err := x.store.GetLLMEvalCasesBySource(ctx, sources)
+	if err != nil {
+		log.Printf("Failed to load few-shot examples: %v", err)
+		return x.cases
+	}
+	cases := make([]fewShotCase, 0, len(labelled))
+	for _, c := range labelled {
+		cases = append(cases, fewShotCase{LLMEvalCase: c, words: matchWords(c.AdText), text: normalizeEvalText(c.AdText)})
+	}
+	x.cases = cases
+	return cases
+}
+
+// wordOverlap is the Jaccard similarity of two sets of words.
+func wordOverlap(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for w := range a {
+		if b[w] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
+
+// selectFewShotExamples picks the n cases most similar to adText with at
+// least minSimilarity. The ad itself is never an example of itself, so
+// evaluating on the corrected ads stays honest.
+func selectFewShotExamples(cases []fewShotCase, adText string, n int, minSimilarity float64, maxChars int) []FewShotExample {
+	words := matchWords(adText)
+	text := normalizeEvalText(adText)
+
+	type scored struct {
+		c          *fewShotCase
+		similarity float64
+	}
+	var matches []scored
+	for i := range cases {
+		if cases[i].text == text {
+			continue
+		}
+		if sim := wordOverlap(words, cases[i].words); sim > 0 && sim >= minSimilarity {
+			matches = append(matches, scored{&cases[i], sim})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].similarity > matches[j].similarity })
+	if len(matches) > n {
+		matches = matches[:n]
+	}
+
+	examples := make([]FewShotExample, 0, len(matches))
+	for _, m := range matches {
+		output, err := json.Marshal(struct {
+			Manufacturer string `json:"manufacturer"`
+			Model        string `json:"model"`
+			Category     string `json:"category,omitempty"`
+			Condition    string `json:"condition,omitempty"`
+		}{m.c.Manufacturer, m.c.Model, m.c.Category, m.c.Condition})
+		if err != nil {
+			continue
+		}
+		examples = append(examples, FewShotExample{
+			AdText:     truncateRunes(m.c.AdText, maxChars),
+			Output:     string(output),
+			Similarity: m.similarity,
+		})
+	}
+	return examples
+}
+
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if n <= 0 || len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "…"
+}
+
+// fewShotExamplesFor returns the corrected ads most like adText for the
+// ExtractProductInfo prompt, or nil when few-shot examples are disabled.
+func (s *LLMService) fewShotExamplesFor(ctx context.Context, adText string) []FewShotExample {
+	if s.cfg == nil || s.cfg.LLM.FewShot.MaxExamples <= 0 {
+		return nil
+	}
+	cfg := s.cfg.LLM.FewShot
+	sources := cfg.Sources
+	if len(sources) == 0 {
+		sources = defaultFewShotSources
+	}
+	maxChars := cfg.MaxChars
+	if maxChars <= 0 {
+		maxChars = DefaultFewShotMaxChars
+	}
+	return selectFewShotExamples(s.examples.load(ctx, sources), adText, cfg.MaxExamples, cfg.MinSimilarity, maxChars)
+}
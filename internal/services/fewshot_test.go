@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"begbot/internal/config"
+	"begbot/internal/models"
+)
+
+type memoryFewShotStore struct {
+	cases   []models.LLMEvalCase
+	sources []string
+}
+
+func (m *memoryFewShotStore) GetLLMEvalCasesBySource(ctx context.Context, sources []string) ([]models.LLMEvalCase, error) {
+	m.sources = sources
+	return m.cases, nil
+}
+
+func fewShotCases() []models.LLMEvalCase {
+	return []models.LLMEvalCase{
+		{ID: 1, Source: "review", AdText: "Laddare till iPhone 13 USB-C 20W", Manufacturer: "Anker", Model: "PowerPort 20W", Category: "other"},
+		{ID: 2, Source: "review", AdText: "iPhone 13 128GB blå, fint skick", Manufacturer: "Apple", Model: "iPhone 13", Category: "phone"},
+		{ID: 3, Source: "review", AdText: "Samsung Galaxy S22 svart", Manufacturer: "Samsung", Model: "Galaxy S22", Category: "phone"},
+	}
+}
+
+func TestSelectFewShotExamples(t *testing.T) {
+	x := &fewShotExamples{}
+	x.useStore(&memoryFewShotStore{cases: fewShotCases()})
+	cases := x.load(context.Background(), defaultFewShotSources)
+
+	examples := selectFewShotExamples(cases, "iPhone 13 128GB röd", 2, 0.1, 12)
+	if len(examples) != 2 {
+		t.Fatalf("examples = %+v, want the two iPhone ads", examples)
+	}
+	if !strings.Contains(examples[0].Output, `"model":"iPhone 13"`) || examples[0].Similarity <= examples[1].Similarity {
+		t.Errorf("most similar example should come first: %+v", examples)
+	}
+	if examples[0].AdText != "iPhone 13 12…" {
+		t.Errorf("ad text should be capped, got %q", examples[0].AdText)
+	}
+
+	if examples := selectFewShotExamples(cases, "iPhone 13 128GB blå, fint skick", 3, 0, 400); len(examples) != 1 || strings.Contains(examples[0].Output, "Apple") {
+		t.Errorf("an ad should not be its own example: %+v", examples)
+	}
+	if examples := selectFewShotExamples(cases, "Cykel 28 tum", 3, 0.1, 400); len(examples) != 0 {
+		t.Errorf("unrelated ad got examples: %+v", examples)
+	}
+}
+
+func TestExtractProductInfoWithFewShotExamples(t *testing.T) {
+	var prompt string
+	client := LLMClientFunc(func(ctx context.Context, req ChatRequest) (string, error) {
+		prompt = req.Prompt()
+		return `{"manufacturer": "Anker", "model": "PowerPort", "category": "other"}`, nil
+	})
+	cfg := &config.Config{LLM: config.LLMConfig{FewShot: config.FewShotConfig{MaxExamples: 1}}}
+	s := NewLLMServiceWithClient(cfg, client)
+	store := &memoryFewShotStore{cases: fewShotCases()}
+	s.examples.useStore(store)
+
+	if _, err := s.ExtractProductInfo(context.Background(), "Laddare för iPhone 13", ""); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(prompt, `Answer: {"manufacturer":"Anker","model":"PowerPort 20W","category":"other"}`) {
+		t.Errorf("prompt should include the closest correction:\n%s", prompt)
+	}
+	if strings.Count(prompt, "Answer:") != 1 {
+		t.Errorf("max examples not applied:\n%s", prompt)
+	}
+	if len(store.sources) != 1 || store.sources[0] != "review" {
+		t.Errorf("sources = %v, want review", store.sources)
+	}
+
+	cfg.LLM.FewShot.MaxExamples = 0
+	if _, err := s.ExtractProductInfo(context.Background(), "Laddare för iPhone 13", ""); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(prompt, "corrected by hand") {
+		t.Errorf("disabled few-shot examples still in the prompt:\n%s", prompt)
+	}
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"begbot/internal/db"
+	"begbot/internal/models"
+)
+
+// FXService converts money between currencies with the rates in the local
+// fx_rates table. Each rate is looked up once for the life of the service.
+type FXService struct {
+	database *db.Postgres
+
+	mu    sync.Mutex
+	rates map[[2]models.Currency]float64
+}
+
+func NewFXService(database *db.Postgres) *FXService {
+	return &FXService{database: database, rates: make(map[[2]models.Currency]float64)}
+}
+
+// Rate returns the price of one unit of base in quote. A stored base/quote
+// rate is preferred; the inverse of a quote/base rate is used otherwise.
+func (s *FXService) Rate(ctx context.Context, base, quote models.Currency) (float64, error) {
+	if base == quote {
+		return 1, nil
+	}
+	if s == nil || s.database == nil {
+		return 0, fmt.Errorf("no FX rate for %s/%s", base, quote)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pair := [2]models.Currency{base, quote}
+	rate, ok := s.rates[pair]
+	if !ok {
+		var err error
+		if rate, err = s.database.GetFXRate(ctx, base, quote); err != nil {
+			return 0, err
+		}
+		s.rates[pair] = rate
+	}
+	if rate <= 0 {
+		return 0, fmt.Errorf("no FX rate for %s/%s", base, quote)
+	}
+	return rate, nil
+}
+
+// Convert returns m in the given currency.
+func (s *FXService) Convert(ctx context.Context, m models.Money, to models.Currency) (models.Money, error) {
+	if m.Currency == "" {
+		m.Currency = models.DefaultCurrency
+	}
+	rate, err := s.Rate(ctx, m.Currency, to)
+	if err != nil {
+		return models.Money{}, err
+	}
+	return ConvertMoney(m, to, rate), nil
+}
+
+// ConvertMoney converts m with rate, the price of one unit of m's currency
+// in the target currency.
+func ConvertMoney(m models.Money, to models.Currency, rate float64) models.Money {
+	if m.Currency == to {
+		return m
+	}
+	converted := m.Mul(rate)
+	converted.Currency = to
+	return converted
+}
@@ -0,0 +1,31 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"begbot/internal/models"
+)
+
+func TestConvertMoney(t *testing.T) {
+	eur := models.FromMajor(100, models.CurrencyEUR)
+	sek := ConvertMoney(eur, models.CurrencySEK, 11.45)
+	if sek.Currency != models.CurrencySEK || sek.Amount != 114500 {
+		t.Errorf("ConvertMoney() = %+v, want 114500 SEK", sek)
+	}
+	same := ConvertMoney(sek, models.CurrencySEK, 2)
+	if same != sek {
+		t.Errorf("ConvertMoney() to same currency = %+v, want %+v", same, sek)
+	}
+}
+
+func TestFXServiceConvertWithoutRate(t *testing.T) {
+	fx := NewFXService(nil)
+	got, err := fx.Convert(context.Background(), models.SEK(100), models.CurrencySEK)
+	if err != nil || got.Amount != 10000 {
+		t.Errorf("Convert() identity = %+v, %v", got, err)
+	}
+	if _, err := fx.Convert(context.Background(), models.FromMajor(10, models.CurrencyEUR), models.CurrencySEK); err == nil {
+		t.Error("Convert() without a stored rate should fail")
+	}
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"begbot/internal/models"
+)
+
+// imageConditions are the conditions AnalyzeImages may return, best first,
+// with the share of the valuation an item in that condition is worth.
+var imageConditions = []struct {
+	Name   string
+	Factor float64
+}{
+	{"new", 1},
+	{"like_new", 1},
+	{"good", 0.95},
+	{"fair", 0.85},
+	{"poor", 0.7},
+	{"unknown", 1},
+}
+
+var imageAnalysisSchema = &OutputSchema{
+	Name: "image_analysis",
+	Schema: map[string]interface{}{
+		"type":     "object",
+		"required": []string{"model_matches", "stock_photo", "condition"},
+		"properties": map[string]interface{}{
+			"visible_damage":   map[string]interface{}{"type": "array"},
+			"identified_model": map[string]interface{}{"type": "string"},
+			"model_matches":    map[string]interface{}{"type": "boolean"},
+			"stock_photo":      map[string]interface{}{"type": "boolean"},
+			"suspicious_signs": map[string]interface{}{"type": "array"},
+			"condition":        map[string]interface{}{"type": "string", "enum": imageConditionNames()},
+			"confidence":       map[string]interface{}{"type": "number", "minimum": 0, "maximum": 100},
+		},
+	},
+}
+
+// ImageAnalysis is what a vision model saw in the photos of an ad.
+type ImageAnalysis struct {
+	VisibleDamage   []string `json:"visible_damage"`
+	IdentifiedModel string   `json:"identified_model"`
+	// ModelMatches is false when the photos show another model than the
+	// ad text claims.
+	ModelMatches bool `json:"model_matches"`
+	StockPhoto   bool `json:"stock_photo"`
+	// SuspiciousSigns suggest the photos are not the seller's own.
+	SuspiciousSigns []string `json:"suspicious_signs"`
+	Condition       string   `json:"condition"`
+	Confidence      float64  `json:"confidence"`
+	ImagesAnalyzed  int      `json:"images_analyzed"`
+	PromptVersion   string   `json:"prompt_version,omitempty"`
+	// LLMModel is the model that answered.
+	LLMModel string `json:"llm_model,omitempty"`
+}
+
+// AnalyzeImages sends up to MaxImages of an ad's photos to the model
+// configured for AnalyzeImages, which must be vision-capable. It returns
+// nil without images or when image analysis is disabled.
+func (s *LLMService) AnalyzeImages(ctx context.Context, info *ProductInfo, imageURLs []string) (*ImageAnalysis, error) {
+	limit := s.maxImages()
+	if limit == 0 || info == nil {
+		return nil, nil
+	}
+	var images []string
+	for _, url := range imageURLs {
+		if url = strings.TrimSpace(url); url != "" && len(images) < limit {
+			images = append(images, url)
+		}
+	}
+	if len(images) == 0 {
+		return nil, nil
+	}
+
+	prompt, err := s.prompts.Render(ctx, PromptImageAnalysis, map[string]interface{}{
+		"Manufacturer": info.Manufacturer,
+		"Model":        info.Model,
+		"Category":     info.Category,
+		"AdText":       info.AdText,
+		"Conditions":   describeImageConditions(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	prompt.Images = images
+
+	var analysis ImageAnalysis
+	model, err := s.chatJSON(ctx, "AnalyzeImages", prompt, imageAnalysisSchema, &analysis)
+	if err != nil {
+		return nil, err
+	}
+	analysis.ImagesAnalyzed = len(images)
+	analysis.PromptVersion = prompt.ID()
+	analysis.LLMModel = model
+	return &analysis, nil
+}
+
+func (s *LLMService) maxImages() int {
+	if s.cfg == nil || s.cfg.LLM.MaxImages < 0 {
+		return 0
+	}
+	return s.cfg.LLM.MaxImages
+}
+
+// ConditionFactor is the share of the valuation an item in the analysed
+// condition is worth.
+func (a *ImageAnalysis) ConditionFactor() float64 {
+	if a == nil {
+		return 1
+	}
+	for _, c := range imageConditions {
+		if c.Name == a.Condition {
+			return c.Factor
+		}
+	}
+	return 1
+}
+
+// AdjustValuation lowers valuation for the condition seen in the photos.
+// The adjustment is nil when the condition, or a nil analysis, does not
+// change the value.
+func (a *ImageAnalysis) AdjustValuation(valuation int) (int, *ValuationAdjustment) {
+	factor := a.ConditionFactor()
+	if factor >= 1 || valuation <= 0 {
+		return valuation, nil
+	}
+	amount := -int(math.Round(float64(valuation) * (1 - factor)))
+	description := "skick enligt bilder: " + a.Condition
+	if len(a.VisibleDamage) > 0 {
+		description += " (" + strings.Join(a.VisibleDamage, ", ") + ")"
+	}
+	return valuation + amount, &ValuationAdjustment{Kind: "image_condition", Description: description, Amount: amount}
+}
+
+// Risks returns the warnings from the photos for the trading rule verdict.
+func (a *ImageAnalysis) Risks() []string {
+	if a == nil {
+		return nil
+	}
+	var risks []string
+	if !a.ModelMatches {
+		model := a.IdentifiedModel
+		if model == "" {
+			model = "okänd"
+		}
+		risks = append(risks, fmt.Sprintf("bilderna visar en annan modell än annonsen (%s)", model))
+	}
+	if len(a.SuspiciousSigns) > 0 {
+		risks = append(risks, "bilderna kan vara stulna: "+strings.Join(a.SuspiciousSigns, ", "))
+	}
+	if a.StockPhoto {
+		risks = append(risks, "annonsen använder lagerbilder")
+	}
+	return risks
+}
+
+// Blocking reports whether the photos rule out a deal: they show another
+// model or do not seem to be the seller's own. Stock photos alone are only
+// a warning.
+func (a *ImageAnalysis) Blocking() bool {
+	return a != nil && (!a.ModelMatches || len(a.SuspiciousSigns) > 0)
+}
+
+// RiskScore is the risk of the photos for trading rule expressions, 0 when
+// there is no analysis.
+func (a *ImageAnalysis) RiskScore() float64 {
+	if a == nil {
+		return 0
+	}
+	return models.ImageRisk(a.ModelMatches, a.StockPhoto, len(a.SuspiciousSigns))
+}
+
+func imageConditionNames() []interface{} {
+	names := make([]interface{}, len(imageConditions))
+	for i, c := range imageConditions {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// describeImageConditions lists the allowed conditions for prompts.
+func describeImageConditions() string {
+	names := make([]string, len(imageConditions))
+	for i, c := range imageConditions {
+		names[i] = c.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// Record converts the analysis for storage with a listing.
+func (a *ImageAnalysis) Record(listingID int64) (*models.ListingImageAnalysis, error) {
+	analysis, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ListingImageAnalysis{
+		ListingID:    listingID,
+		Condition:    a.Condition,
+		ModelMatches: a.ModelMatches,
+		StockPhoto:   a.StockPhoto,
+		Analysis:     analysis,
+	}, nil
+}
+
+// imageAnalysisFromRecord reads a stored analysis. A nil record gives nil.
+func imageAnalysisFromRecord(r *models.ListingImageAnalysis) (*ImageAnalysis, error) {
+	if r == nil {
+		return nil, nil
+	}
+	var a ImageAnalysis
+	if err := json.Unmarshal(r.Analysis, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
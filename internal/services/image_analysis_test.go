@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"begbot/internal/config"
+)
+
+func TestChatMessageWithImagesJSON(t *testing.T) {
+	plain, err := json.Marshal(ChatMessage{Role: "user", Content: "hej"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plain) != `{"role":"user","content":"hej"}` {
+		t.Errorf("plain message = %s", plain)
+	}
+
+	withImages, err := json.Marshal(ChatMessage{Role: "user", Content: "titta", Images: []string{"https://img/1.jpg"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"role":"user","content":[{"type":"text","text":"titta"},{"type":"image_url","image_url":{"url":"https://img/1.jpg"}}]}`
+	if string(withImages) != want {
+		t.Errorf("message with images = %s, want %s", withImages, want)
+	}
+}
+
+func TestAnalyzeImages(t *testing.T) {
+	var got ChatRequest
+	client := LLMClientFunc(func(ctx context.Context, req ChatRequest) (string, error) {
+		got = req
+		return `{"visible_damage": ["sprucken skärm"], "identified_model": "iPhone 12", "model_matches": false, "stock_photo": false, "suspicious_signs": [], "condition": "poor", "confidence": 80}`, nil
+	})
+	cfg := &config.Config{LLM: config.LLMConfig{
+		DefaultModel: "text-model",
+		Models:       map[string]string{"AnalyzeImages": "vision-model"},
+		MaxImages:    2,
+	}}
+	s := NewLLMServiceWithClient(cfg, client)
+	info := &ProductInfo{Manufacturer: "Apple", Model: "iPhone 13", Category: "phone", AdText: "Säljer iPhone 13"}
+
+	analysis, err := s.AnalyzeImages(context.Background(), info, []string{"https://img/1.jpg", "", "https://img/2.jpg", "https://img/3.jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Model != "vision-model" {
+		t.Errorf("model = %q, want vision-model", got.Model)
+	}
+	if images := got.Messages[0].Images; len(images) != 2 || images[1] != "https://img/2.jpg" {
+		t.Errorf("images = %v, want the first two", images)
+	}
+	if !strings.Contains(got.Prompt(), "Model: iPhone 13") {
+		t.Errorf("prompt = %q", got.Prompt())
+	}
+	if analysis.ImagesAnalyzed != 2 || analysis.PromptVersion != "image_analysis@v1" || analysis.Condition != "poor" {
+		t.Errorf("analysis = %+v", analysis)
+	}
+	if !analysis.Blocking() || len(analysis.Risks()) != 1 {
+		t.Errorf("a different model should block, risks = %v", analysis.Risks())
+	}
+
+	cfg.LLM.MaxImages = 0
+	if analysis, err := s.AnalyzeImages(context.Background(), info, []string{"https://img/1.jpg"}); analysis != nil || err != nil {
+		t.Errorf("disabled analysis = %+v, %v", analysis, err)
+	}
+}
+
+func TestImageAnalysisAdjustValuation(t *testing.T) {
+	var none *ImageAnalysis
+	if v, adj := none.AdjustValuation(4000); v != 4000 || adj != nil {
+		t.Errorf("nil analysis = %d, %+v", v, adj)
+	}
+
+	good := &ImageAnalysis{Condition: "like_new", ModelMatches: true}
+	if v, adj := good.AdjustValuation(4000); v != 4000 || adj != nil {
+		t.Errorf("like_new = %d, %+v", v, adj)
+	}
+
+	fair := &ImageAnalysis{Condition: "fair", ModelMatches: true, VisibleDamage: []string{"repor"}}
+	v, adj := fair.AdjustValuation(4000)
+	if v != 3400 || adj == nil || adj.Amount != -600 || adj.Kind != "image_condition" || !strings.Contains(adj.Description, "repor") {
+		t.Errorf("fair = %d, %+v", v, adj)
+	}
+	if fair.Blocking() || len(fair.Risks()) != 0 {
+		t.Errorf("fair analysis should not be a risk, got %v", fair.Risks())
+	}
+
+	stock := &ImageAnalysis{Condition: "unknown", ModelMatches: true, StockPhoto: true}
+	if stock.Blocking() || len(stock.Risks()) != 1 {
+		t.Errorf("stock photos should warn without blocking, risks = %v", stock.Risks())
+	}
+}
+
+func TestImageAnalysisRecord(t *testing.T) {
+	a := &ImageAnalysis{Condition: "good", ModelMatches: true, StockPhoto: true, VisibleDamage: []string{"repa"}}
+	record, err := a.Record(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.ListingID != 7 || record.Condition != "good" || !record.StockPhoto {
+		t.Errorf("record = %+v", record)
+	}
+	back, err := imageAnalysisFromRecord(record)
+	if err != nil || back.Condition != "good" || len(back.VisibleDamage) != 1 {
+		t.Errorf("imageAnalysisFromRecord() = %+v, %v", back, err)
+	}
+}
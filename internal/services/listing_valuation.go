@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+
+	"begbot/internal/models"
+)
+
+// ValuationAdjustment records a condition or variant factor that was taken
+// into account when valuing a specific listing. Amount is the change in SEK
+// applied to the compiled valuation; zero means the factor was only noted.
+type ValuationAdjustment struct {
+	Kind        string `json:"kind"`
+	Description string `json:"description"`
+	Amount      int    `json:"amount"`
+}
+
+// listingAdjustments returns the condition and variant details extracted
+// from the ad.
+func listingAdjustments(info *ProductInfo) []ValuationAdjustment {
+	if info == nil {
+		return nil
+	}
+	var adjustments []ValuationAdjustment
+	if info.Condition != "" {
+		adjustments = append(adjustments, ValuationAdjustment{Kind: "condition", Description: info.Condition})
+	}
+	if info.Storage != "" {
+		adjustments = append(adjustments, ValuationAdjustment{Kind: "variant", Description: info.Storage})
+	}
+	return adjustments
+}
+
+// BuildListingValuation creates the snapshot of a listing's valuation as it
+// looked at scrape time. output may be nil when compilation failed.
+func BuildListingValuation(listingID int64, output *ValuationOutput, inputs []ValuationInput, adjustments []ValuationAdjustment, verdict *TradingRuleVerdict) (*models.ListingValuation, error) {
+	lv := &models.ListingValuation{ListingID: listingID}
+
+	if output != nil {
+		lv.RecommendedPrice = int(output.RecommendedPrice)
+		lv.Confidence = output.Confidence
+		lv.Strategy = output.Strategy
+		lv.Reasoning = output.Reasoning
+		compiled, err := json.Marshal(output)
+		if err != nil {
+			return nil, err
+		}
+		lv.Compiled = compiled
+	}
+
+	if inputs == nil {
+		inputs = []ValuationInput{}
+	}
+	inputsJSON, err := json.Marshal(inputs)
+	if err != nil {
+		return nil, err
+	}
+	lv.Inputs = inputsJSON
+
+	if adjustments == nil {
+		adjustments = []ValuationAdjustment{}
+	}
+	adjustmentsJSON, err := json.Marshal(adjustments)
+	if err != nil {
+		return nil, err
+	}
+	lv.Adjustments = adjustmentsJSON
+
+	if verdict != nil {
+		verdictJSON, err := json.Marshal(verdict)
+		if err != nil {
+			return nil, err
+		}
+		lv.Verdict = verdictJSON
+	}
+
+	return lv, nil
+}
+
+// SaveListingValuation persists a valuation snapshot for a listing.
+func (s *ValuationService) SaveListingValuation(ctx context.Context, lv *models.ListingValuation) error {
+	return s.database.SaveListingValuation(ctx, lv)
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"begbot/internal/models"
+)
+
+func TestBuildListingValuation(t *testing.T) {
+	output := &ValuationOutput{RecommendedPrice: 1500, Confidence: 0.7, Strategy: "median", Reasoning: "test"}
+	inputs := []ValuationInput{{Type: ValuationTypeTradera, Value: 1500, Confidence: 0.7}}
+	adjustments := listingAdjustments(&ProductInfo{Condition: "Bra skick", Storage: "128GB"})
+	verdict := &TradingRuleVerdict{Passed: true, Valuation: 1500, Price: 1000, Profit: 500}
+
+	lv, err := BuildListingValuation(42, output, inputs, adjustments, verdict)
+	if err != nil {
+		t.Fatalf("BuildListingValuation failed: %v", err)
+	}
+
+	if lv.ListingID != 42 || lv.RecommendedPrice != 1500 || lv.Strategy != "median" {
+		t.Errorf("Unexpected snapshot header: %+v", lv)
+	}
+
+	var gotAdj []ValuationAdjustment
+	if err := json.Unmarshal(lv.Adjustments, &gotAdj); err != nil {
+		t.Fatalf("Failed to decode adjustments: %v", err)
+	}
+	if len(gotAdj) != 2 || gotAdj[0].Kind != "condition" || gotAdj[1].Kind != "variant" {
+		t.Errorf("Expected condition and variant adjustments, got %+v", gotAdj)
+	}
+
+	var gotVerdict TradingRuleVerdict
+	if err := json.Unmarshal(lv.Verdict, &gotVerdict); err != nil {
+		t.Fatalf("Failed to decode verdict: %v", err)
+	}
+	if !gotVerdict.Passed || gotVerdict.Profit != 500 {
+		t.Errorf("Unexpected verdict: %+v", gotVerdict)
+	}
+}
+
+func TestBuildListingValuation_NoOutput(t *testing.T) {
+	lv, err := BuildListingValuation(1, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("BuildListingValuation failed: %v", err)
+	}
+	if string(lv.Inputs) != "[]" || string(lv.Adjustments) != "[]" {
+		t.Errorf("Expected empty arrays, got inputs=%s adjustments=%s", lv.Inputs, lv.Adjustments)
+	}
+	if lv.Compiled != nil || lv.Verdict != nil {
+		t.Errorf("Expected no compiled output or verdict")
+	}
+}
+
+func TestListingValuationPrefersSnapshot(t *testing.T) {
+	s := &BotService{}
+	productID := int64(7)
+	listing := &models.Listing{ProductID: &productID, Valuation: 1200}
+	output := &ValuationOutput{RecommendedPrice: 2000}
+
+	if got := s.listingValuation(context.Background(), s.newTradingRuleEnv(context.Background()), listing, output, nil); got != 2000 {
+		t.Errorf("Expected the snapshot valuation 2000, got %d", got)
+	}
+	if got := s.listingValuation(context.Background(), s.newTradingRuleEnv(context.Background()), listing, output, &ImageAnalysis{Condition: "fair"}); got != 1700 {
+		t.Errorf("Expected the snapshot lowered for the photos to 1700, got %d", got)
+	}
+	if got := s.listingValuation(context.Background(), s.newTradingRuleEnv(context.Background()), listing, nil, &ImageAnalysis{Condition: "fair"}); got != 1200 {
+		t.Errorf("Expected listing.Valuation without a snapshot, got %d", got)
+	}
+}
@@ -2,39 +2,139 @@ package services
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"log"
+	"strings"
 
 	"begbot/internal/config"
 )
 
 type LLMService struct {
 	cfg          *config.Config
-	client       *OpenRouterClient
+	client       LLMClient
+	clients      map[string]LLMClient
 	defaultModel string
 	models       map[string]string
+	providers    map[string]string
+	cache        llmCacheStore
+	usage        llmUsageStore
+	budget       llmBudget
+	prompts      *PromptRegistry
+	breaker      *llmCircuitBreaker
+	limiter      *llmLimiter
+	examples     *fewShotExamples
 }
 
+// NewLLMService creates the service with the client of the configured
+// provider and one client per provider named in the per-function overrides.
+// A provider that cannot be set up fails every call made through it.
 func NewLLMService(cfg *config.Config) *LLMService {
-	var apiKey, siteURL, siteName string
-	var defaultModel string
-	var models map[string]string
+	var llmCfg config.LLMConfig
+	if cfg != nil {
+		llmCfg = cfg.LLM
+	}
+
+	newClient := func(provider string) LLMClient {
+		client, err := NewLLMClient(provider, llmCfg)
+		if err != nil {
+			log.Printf("Failed to create LLM client: %v", err)
+			return errorClient{err: err}
+		}
+		return client
+	}
+
+	s := NewLLMServiceWithClient(cfg, newClient(llmCfg.Provider))
+	for function, provider := range llmCfg.Providers {
+		if provider == "" || strings.EqualFold(provider, llmCfg.Provider) {
+			continue
+		}
+		if _, ok := s.clients[provider]; !ok {
+			s.clients[provider] = newClient(provider)
+		}
+		s.providers[function] = provider
+	}
+	return s
+}
 
+// NewLLMServiceWithClient creates the service with client for every
+// function, e.g. a ScriptedClient in tests.
+func NewLLMServiceWithClient(cfg *config.Config, client LLMClient) *LLMService {
+	s := &LLMService{
+		cfg:       cfg,
+		client:    client,
+		clients:   make(map[string]LLMClient),
+		providers: make(map[string]string),
+		examples:  &fewShotExamples{},
+	}
+	var promptDir string
 	if cfg != nil {
-		apiKey = cfg.LLM.APIKey
-		siteURL = cfg.LLM.SiteURL
-		siteName = cfg.LLM.SiteName
-		defaultModel = cfg.LLM.DefaultModel
-		models = cfg.LLM.Models
+		s.defaultModel = cfg.LLM.DefaultModel
+		s.models = cfg.LLM.Models
+		promptDir = cfg.LLM.PromptDir
+		s.breaker = newLLMCircuitBreaker(cfg.LLM.CircuitThreshold, cfg.LLM.CircuitCooldown)
+		s.limiter = newLLMLimiter(cfg.LLM.Concurrency)
+	}
+	s.prompts = defaultPromptRegistry(promptDir)
+	return s
+}
+
+// Prompts returns the prompt templates used by the service.
+func (s *LLMService) Prompts() *PromptRegistry {
+	return s.prompts
+}
+
+// chat sends prompt with the client, model and token budget configured for
+// function. Responses are cached for functions with a cache TTL.
+func (s *LLMService) chat(ctx context.Context, function string, prompt RenderedPrompt) (string, error) {
+	req := s.request(function, prompt)
+	if err := s.applyBudget(ctx, function, &req); err != nil {
+		return "", err
+	}
+	key, cached := s.cachedResponse(ctx, function, prompt, req)
+	if cached != nil {
+		return cached.Response, nil
+	}
+	content, model, err := s.send(ctx, function, prompt, req)
+	if err != nil {
+		return "", err
+	}
+	s.storeResponse(ctx, key, function, prompt, model, content)
+	return content, nil
+}
+
+func (s *LLMService) clientFor(function string) LLMClient {
+	if provider, ok := s.providers[function]; ok {
+		return s.clients[provider]
+	}
+	return s.client
+}
+
+func (s *LLMService) request(function string, prompt RenderedPrompt) ChatRequest {
+	return ChatRequest{
+		Model:     GetModel(function, s.defaultModel, s.models),
+		Messages:  []ChatMessage{{Role: "user", Content: prompt.Text, Images: prompt.Images}},
+		MaxTokens: s.maxTokens(function),
+	}
+}
+
+func (s *LLMService) maxTokens(function string) int {
+	if s.cfg == nil {
+		return DefaultLLMMaxTokens
+	}
+	if n := s.cfg.LLM.MaxTokens[function]; n > 0 {
+		return n
+	}
+	if s.cfg.LLM.DefaultMaxTokens > 0 {
+		return s.cfg.LLM.DefaultMaxTokens
 	}
+	return DefaultLLMMaxTokens
+}
 
-	client := NewOpenRouterClient(apiKey, siteURL, siteName)
-	return &LLMService{
-		cfg:          cfg,
-		client:       client,
-		defaultModel: defaultModel,
-		models:       models,
+func (s *LLMService) maxAttempts() int {
+	if s.cfg != nil && s.cfg.LLM.MaxAttempts > 0 {
+		return s.cfg.LLM.MaxAttempts
 	}
+	return DefaultLLMMaxAttempts
 }
 
 type ProductInfo struct {
@@ -46,79 +146,105 @@ type ProductInfo struct {
 	ShippingCost float64
 	AdText       string
 	NewPrice     float64
+	// ProductID is the catalog product the info was matched to, if any.
+	ProductID int64
+	// Confidence is how sure the model was of the product, from 0 to 1. It
+	// is nil when the prompt version does not ask for one.
+	Confidence *float64
+	// PromptVersion is the prompt template the extraction was made with.
+	PromptVersion string
+	// LLMModel is the model that answered.
+	LLMModel string
 }
 
-func (s *LLMService) ExtractProductInfo(ctx context.Context, adText, link string) (*ProductInfo, error) {
-	prompt := fmt.Sprintf(`Analyze this marketplace ad and extract product information. Return ONLY a JSON object with these exact fields:
-{
-  "manufacturer": "brand name",
-  "model": "product model",
-  "category": "one of: phone, tablet, watch, headphones, case, charger, accessory, computer, component, other",
-  "storage": "storage capacity if applicable",
-  "condition": "product condition",
-  "shipping_cost": 0
+// extractedProduct is the JSON returned by ExtractProductInfo.
+type extractedProduct struct {
+	Manufacturer  string   `json:"manufacturer"`
+	Model         string   `json:"model"`
+	Category      string   `json:"category"`
+	Storage       string   `json:"storage"`
+	Condition     string   `json:"condition"`
+	ShippingCost  float64  `json:"shipping_cost"`
+	Confidence    *float64 `json:"confidence,omitempty"`
+	PromptVersion string   `json:"prompt_version,omitempty"`
+	LLMModel      string   `json:"llm_model,omitempty"`
 }
 
-Ad text: %s
-
-JSON output:`, adText)
-
-	model := s.client.GetModel("ExtractProductInfo", s.defaultModel, s.models)
+// ExtractProductInfo asks the LLM what product an ad is for. An
+// *LLMOutputError is returned when no valid answer was given.
+func (s *LLMService) ExtractProductInfo(ctx context.Context, adText, link string) (*ProductInfo, error) {
+	prompt, err := s.prompts.Render(ctx, PromptExtractProductInfo, map[string]interface{}{
+		"Categories": describeCategories(),
+		"AdText":     adText,
+		"Examples":   s.fewShotExamplesFor(ctx, adText),
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	content, err := s.client.Chat(ctx, model, prompt)
+	var extracted extractedProduct
+	model, err := s.chatJSON(ctx, "ExtractProductInfo", prompt, productInfoSchema, &extracted)
 	if err != nil {
-		return nil, fmt.Errorf("LLM API error: %w", err)
+		return nil, err
 	}
+	extracted.PromptVersion = prompt.ID()
+	extracted.LLMModel = model
 
-	content = cleanupMarkdownJSON(content)
+	return extracted.productInfo(adText), nil
+}
 
-	var info ProductInfo
-	if err := json.Unmarshal([]byte(content), &info); err != nil {
-		info = ProductInfo{}
+// productInfo turns the answer into a ProductInfo. Prompts that ask for a
+// confidence ask for it from 0 to 100.
+func (e extractedProduct) productInfo(adText string) *ProductInfo {
+	var confidence *float64
+	if e.Confidence != nil {
+		confidence = floatPtr(percentConfidence(*e.Confidence))
+	}
+	return &ProductInfo{
+		Manufacturer:  e.Manufacturer,
+		Model:         e.Model,
+		Category:      e.Category,
+		Storage:       e.Storage,
+		Condition:     e.Condition,
+		ShippingCost:  e.ShippingCost,
+		AdText:        adText,
+		Confidence:    confidence,
+		PromptVersion: e.PromptVersion,
+		LLMModel:      e.LLMModel,
 	}
-
-	info.AdText = adText
-	return &info, nil
 }
 
 func (s *LLMService) CompileValuations(ctx context.Context, valuations []ValuationInput, productName string) (*ValuationOutput, error) {
-	prompt := fmt.Sprintf(`Given these valuations for "%s", suggest a selling price and safety margin.
-
-Valuations:
-%s
-
-Return ONLY a JSON object with:
-- recommended_price: Suggested selling price in öre (NOT SEK - multiply SEK price by 100)
-- safety_margin: Safety margin percentage (0-100)
-- reasoning: Brief explanation for the recommendation
-
-JSON output:`, productName, formatValuationsForPrompt(valuations))
-
-	model := s.client.GetModel("CompileValuations", s.defaultModel, s.models)
-
-	content, err := s.client.Chat(ctx, model, prompt)
+	prompt, err := s.prompts.Render(ctx, PromptCompileValuations, map[string]interface{}{
+		"ProductName": productName,
+		"Valuations":  formatValuationsForPrompt(valuations),
+	})
 	if err != nil {
-		return nil, fmt.Errorf("LLM API error: %w", err)
+		return nil, err
 	}
 
-	content = cleanupMarkdownJSON(content)
-
 	var output ValuationOutput
-	if err := json.Unmarshal([]byte(content), &output); err != nil {
-		return nil, fmt.Errorf("failed to parse LLM response: %w", err)
+	model, err := s.chatJSON(ctx, "CompileValuations", prompt, compiledValuationSchema, &output)
+	if err != nil {
+		return nil, err
 	}
 
 	output.Valuations = valuations
+	output.PromptVersion = prompt.ID()
+	output.LLMModel = model
 	return &output, nil
 }
 
 func formatValuationsForPrompt(vals []ValuationInput) string {
 	var result string
 	for _, v := range vals {
-		result += fmt.Sprintf("- %s: %d öre", v.Type, v.Value)
+		result += fmt.Sprintf("- %s: %s", v.Type, v.Price())
 		if v.SoldCount > 0 {
 			result += fmt.Sprintf(" (baserat på %d sålda)", v.SoldCount)
 		}
+		if v.Distribution != nil {
+			result += fmt.Sprintf(" (p10-p90: %.0f-%.0f, n=%d)", v.Distribution.Percentile(10), v.Distribution.Percentile(90), v.Distribution.SampleSize)
+		}
 		result += "\n"
 	}
 	return result
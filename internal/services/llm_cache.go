@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"begbot/internal/db"
+	"begbot/internal/models"
+)
+
+// llmCacheStore persists cached LLM responses. *db.Postgres implements it.
+type llmCacheStore interface {
+	GetLLMCacheEntry(ctx context.Context, cacheKey string) (*models.LLMCacheEntry, error)
+	SetLLMCacheEntry(ctx context.Context, e *models.LLMCacheEntry) error
+}
+
+// LLMCacheStats counts cache lookups made with a context from
+// WithLLMCacheStats. It is safe for concurrent use.
+type LLMCacheStats struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func (st *LLMCacheStats) Hits() int {
+	return int(st.hits.Load())
+}
+
+func (st *LLMCacheStats) Misses() int {
+	return int(st.misses.Load())
+}
+
+type llmCacheContextKey int
+
+const (
+	llmCacheBypassKey llmCacheContextKey = iota
+	llmCacheStatsKey
+)
+
+// WithoutLLMCache returns a context whose LLM calls neither read nor write
+// the response cache.
+func WithoutLLMCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, llmCacheBypassKey, true)
+}
+
+// WithLLMCacheStats returns a context whose LLM cache lookups are counted in
+// stats.
+func WithLLMCacheStats(ctx context.Context, stats *LLMCacheStats) context.Context {
+	return context.WithValue(ctx, llmCacheStatsKey, stats)
+}
+
+// UseDatabase caches responses in the llm_cache table for functions with a
+// cache TTL, records every call in llm_calls for cost accounting and the
+// daily budget, reads prompt template versions from prompt_templates and
+// few-shot examples from llm_eval_cases.
+func (s *LLMService) UseDatabase(database *db.Postgres) {
+	if database != nil {
+		s.cache = database
+		s.usage = database
+		s.prompts.UseStore(database)
+		s.examples.useStore(database)
+	}
+}
+
+func (s *LLMService) cacheTTL(function string) time.Duration {
+	if s.cfg == nil {
+		return 0
+	}
+	return s.cfg.LLM.CacheTTL[function]
+}
+
+// llmCacheKey hashes everything that determines a response: the function,
+// its prompt template version, the model and the prompt itself, including
+// any images.
+func llmCacheKey(function string, prompt RenderedPrompt, req ChatRequest) string {
+	h := sha256.New()
+	for _, part := range []string{function, prompt.ID(), req.Model} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	for _, m := range req.Messages {
+		h.Write([]byte(m.Role))
+		h.Write([]byte{0})
+		h.Write([]byte(m.Content))
+		h.Write([]byte{0})
+		for _, url := range m.Images {
+			h.Write([]byte(url))
+			h.Write([]byte{0})
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedResponse looks up the response to req. The returned key is empty
+// when the call is not cached at all.
+func (s *LLMService) cachedResponse(ctx context.Context, function string, prompt RenderedPrompt, req ChatRequest) (string, *models.LLMCacheEntry) {
+	if s.cache == nil || s.cacheTTL(function) <= 0 {
+		return "", nil
+	}
+	if bypass, _ := ctx.Value(llmCacheBypassKey).(bool); bypass {
+		return "", nil
+	}
+
+	key := llmCacheKey(function, prompt, req)
+	stats, _ := ctx.Value(llmCacheStatsKey).(*LLMCacheStats)
+	entry, err := s.cache.GetLLMCacheEntry(ctx, key)
+	if err != nil {
+		log.Printf("Failed to read LLM cache for %s: %v", function, err)
+	}
+	if entry == nil {
+		if stats != nil {
+			stats.misses.Add(1)
+		}
+		return key, nil
+	}
+	if stats != nil {
+		stats.hits.Add(1)
+	}
+	return key, entry
+}
+
+// storeResponse caches response under key with the model that gave it,
+// which after a fallback is not the model in the key. Failures are logged
+// only; the response is still good.
+func (s *LLMService) storeResponse(ctx context.Context, key, function string, prompt RenderedPrompt, model, response string) {
+	if key == "" {
+		return
+	}
+	now := time.Now()
+	entry := &models.LLMCacheEntry{
+		CacheKey:      key,
+		Function:      function,
+		Model:         model,
+		PromptVersion: prompt.ID(),
+		Response:      response,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(s.cacheTTL(function)),
+	}
+	if err := s.cache.SetLLMCacheEntry(ctx, entry); err != nil {
+		log.Printf("Failed to write LLM cache for %s: %v", function, err)
+	}
+}
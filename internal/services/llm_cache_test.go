@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"begbot/internal/config"
+	"begbot/internal/models"
+)
+
+type memoryLLMCache struct {
+	entries map[string]*models.LLMCacheEntry
+}
+
+func (m *memoryLLMCache) GetLLMCacheEntry(ctx context.Context, cacheKey string) (*models.LLMCacheEntry, error) {
+	e, ok := m.entries[cacheKey]
+	if !ok || !e.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+	return e, nil
+}
+
+func (m *memoryLLMCache) SetLLMCacheEntry(ctx context.Context, e *models.LLMCacheEntry) error {
+	m.entries[e.CacheKey] = e
+	return nil
+}
+
+func newCachedLLMService(client LLMClient) (*LLMService, *memoryLLMCache) {
+	cfg := &config.Config{LLM: config.LLMConfig{
+		DefaultModel: "model-a",
+		CacheTTL:     map[string]time.Duration{"ExtractProductInfo": time.Hour},
+	}}
+	s := NewLLMServiceWithClient(cfg, client)
+	store := &memoryLLMCache{entries: make(map[string]*models.LLMCacheEntry)}
+	s.cache = store
+	return s, store
+}
+
+func TestLLMCacheReusesResponses(t *testing.T) {
+	calls := 0
+	client := LLMClientFunc(func(ctx context.Context, req ChatRequest) (string, error) {
+		calls++
+		return `{"manufacturer": "Apple", "model": "iPhone 13", "category": "phone"}`, nil
+	})
+	s, store := newCachedLLMService(client)
+
+	stats := &LLMCacheStats{}
+	ctx := WithLLMCacheStats(context.Background(), stats)
+	for i := 0; i < 2; i++ {
+		info, err := s.ExtractProductInfo(ctx, "Säljer iPhone 13", "")
+		if err != nil || info.Model != "iPhone 13" {
+			t.Fatalf("ExtractProductInfo() = %+v, %v", info, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("client calls = %d, want 1", calls)
+	}
+	if stats.Hits() != 1 || stats.Misses() != 1 {
+		t.Errorf("stats = %d hits, %d misses, want 1 and 1", stats.Hits(), stats.Misses())
+	}
+	for _, e := range store.entries {
+		if e.Function != "ExtractProductInfo" || e.Model != "model-a" || e.PromptVersion != "extract_product_info@v3" {
+			t.Errorf("entry = %+v", e)
+		}
+	}
+
+	if _, err := s.ExtractProductInfo(ctx, "Säljer iPhone 14", ""); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("a different ad should miss the cache, calls = %d", calls)
+	}
+
+	if _, err := s.ExtractProductInfo(WithoutLLMCache(ctx), "Säljer iPhone 13", ""); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("WithoutLLMCache should bypass the cache, calls = %d", calls)
+	}
+}
+
+func TestLLMCacheSkipsInvalidAndUncachedFunctions(t *testing.T) {
+	calls := 0
+	client := LLMClientFunc(func(ctx context.Context, req ChatRequest) (string, error) {
+		calls++
+		return "inte json", nil
+	})
+	s, store := newCachedLLMService(client)
+	s.cfg.LLM.MaxAttempts = 1
+
+	if _, err := s.ExtractProductInfo(context.Background(), "Säljer något", ""); err == nil {
+		t.Fatal("expected an output error")
+	}
+	if len(store.entries) != 0 {
+		t.Errorf("invalid output should not be cached, got %d entries", len(store.entries))
+	}
+
+	if _, err := s.chat(context.Background(), "GenerateMessage", textPrompt("Hej")); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.entries) != 0 {
+		t.Error("functions without a cache TTL should not be cached")
+	}
+}
+
+func TestLLMCacheKey(t *testing.T) {
+	req := ChatRequest{Model: "model-a", Messages: []ChatMessage{{Role: "user", Content: "hej"}}}
+	prompt := RenderedPrompt{Name: PromptExtractProductInfo, Version: 1, Text: "hej"}
+	key := llmCacheKey("ExtractProductInfo", prompt, req)
+	if key != llmCacheKey("ExtractProductInfo", prompt, req) {
+		t.Error("key should be deterministic")
+	}
+	other := req
+	other.Model = "model-b"
+	if key == llmCacheKey("ExtractProductInfo", prompt, other) {
+		t.Error("key should depend on the model")
+	}
+	if key == llmCacheKey("NewPrice", prompt, req) {
+		t.Error("key should depend on the function")
+	}
+	next := prompt
+	next.Version = 2
+	if key == llmCacheKey("ExtractProductInfo", next, req) {
+		t.Error("key should depend on the prompt version")
+	}
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"begbot/internal/config"
+)
+
+const (
+	ProviderOpenRouter       = "openrouter"
+	ProviderOpenAICompatible = "openai"
+	ProviderScripted         = "scripted"
+
+	defaultLLMTimeout = 60 * time.Second
+)
+
+// ChatMessage is one turn of a chat with a model. Images are URLs of
+// images sent along with the text to vision-capable models.
+type ChatMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"-"`
+}
+
+type chatContentPart struct {
+	Type     string        `json:"type"`
+	Text     string        `json:"text,omitempty"`
+	ImageURL *chatImageURL `json:"image_url,omitempty"`
+}
+
+type chatImageURL struct {
+	URL string `json:"url"`
+}
+
+// MarshalJSON encodes a message with images as OpenAI-style content parts
+// and one without as plain text content.
+func (m ChatMessage) MarshalJSON() ([]byte, error) {
+	if len(m.Images) == 0 {
+		type plain ChatMessage
+		return json.Marshal(plain(m))
+	}
+	parts := []chatContentPart{{Type: "text", Text: m.Content}}
+	for _, url := range m.Images {
+		parts = append(parts, chatContentPart{Type: "image_url", ImageURL: &chatImageURL{URL: url}})
+	}
+	return json.Marshal(struct {
+		Role    string            `json:"role"`
+		Content []chatContentPart `json:"content"`
+	}{m.Role, parts})
+}
+
+// ChatRequest is a chat with a model. Schema asks for JSON output matching
+// it where the provider supports structured output; clients that do not
+// ignore it and the reply is validated by the caller either way.
+type ChatRequest struct {
+	Model     string
+	Messages  []ChatMessage
+	MaxTokens int
+	Schema    *OutputSchema
+}
+
+// Prompt returns the content of the last message.
+func (r ChatRequest) Prompt() string {
+	if len(r.Messages) == 0 {
+		return ""
+	}
+	return r.Messages[len(r.Messages)-1].Content
+}
+
+// LLMUsage is what a call used, as reported by the provider. CostUSD is
+// zero when the provider does not report cost.
+type LLMUsage struct {
+	PromptTokens     int
+	CompletionTokens int
+	CostUSD          float64
+}
+
+// ChatResponse is the reply of a model.
+type ChatResponse struct {
+	Content string
+	Usage   LLMUsage
+}
+
+// LLMClient sends a chat to a model and returns its reply.
+type LLMClient interface {
+	Chat(ctx context.Context, req ChatRequest) (ChatResponse, error)
+}
+
+// LLMClientFunc adapts a function returning only the content to LLMClient.
+type LLMClientFunc func(ctx context.Context, req ChatRequest) (string, error)
+
+func (f LLMClientFunc) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	content, err := f(ctx, req)
+	return ChatResponse{Content: content}, err
+}
+
+// NewLLMClient creates the client for a provider. An empty provider means
+// OpenRouter. "ollama" and "llamacpp" are aliases for the OpenAI-compatible
+// client, which talks to cfg.BaseURL.
+func NewLLMClient(provider string, cfg config.LLMConfig) (LLMClient, error) {
+	switch strings.ToLower(provider) {
+	case "", ProviderOpenRouter:
+		client := NewOpenRouterClient(cfg.APIKey, cfg.SiteURL, cfg.SiteName)
+		client.structuredOutput = structuredOutput(cfg)
+		return client, nil
+	case ProviderOpenAICompatible, "openai_compatible", "ollama", "llamacpp":
+		if cfg.BaseURL == "" {
+			return nil, fmt.Errorf("llm provider %q requires base_url", provider)
+		}
+		client := NewOpenAICompatibleClient(cfg.BaseURL, cfg.APIKey, cfg.Timeout)
+		client.structuredOutput = structuredOutput(cfg)
+		return client, nil
+	case ProviderScripted:
+		if cfg.ScriptFile == "" {
+			return NewScriptedClient(nil, ""), nil
+		}
+		return LoadScriptedClient(cfg.ScriptFile)
+	default:
+		return nil, fmt.Errorf("unknown llm provider %q", provider)
+	}
+}
+
+func structuredOutput(cfg config.LLMConfig) bool {
+	return cfg.StructuredOutput == nil || *cfg.StructuredOutput
+}
+
+// errorClient fails every call. It stands in for a provider that could not
+// be configured so the error surfaces where the LLM is used.
+type errorClient struct {
+	err error
+}
+
+func (c errorClient) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	return ChatResponse{}, c.err
+}
+
+// OpenAICompatibleClient talks to any server implementing the OpenAI chat
+// completions API, such as a local llama.cpp or Ollama server.
+type OpenAICompatibleClient struct {
+	baseURL          string
+	apiKey           string
+	client           *http.Client
+	structuredOutput bool
+}
+
+// NewOpenAICompatibleClient creates a client for baseURL, e.g.
+// "http://localhost:11434/v1". The API key is optional.
+func NewOpenAICompatibleClient(baseURL, apiKey string, timeout time.Duration) *OpenAICompatibleClient {
+	if timeout <= 0 {
+		timeout = defaultLLMTimeout
+	}
+	return &OpenAICompatibleClient{
+		baseURL:          strings.TrimRight(baseURL, "/"),
+		apiKey:           apiKey,
+		client:           &http.Client{Timeout: timeout},
+		structuredOutput: true,
+	}
+}
+
+func (c *OpenAICompatibleClient) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	body, err := json.Marshal(newChatCompletionRequest(req, c.structuredOutput))
+	if err != nil {
+		return ChatResponse{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := c.baseURL
+	if !strings.HasSuffix(url, "/chat/completions") {
+		url += "/chat/completions"
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return ChatResponse{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return ChatResponse{}, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		if len(respBody) > 500 {
+			respBody = respBody[:500]
+		}
+		return ChatResponse{}, newLLMStatusError(resp, respBody)
+	}
+
+	var result openRouterResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return ChatResponse{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if result.Error != nil {
+		return ChatResponse{}, fmt.Errorf("LLM error: %s", result.Error.Message)
+	}
+	if len(result.Choices) == 0 {
+		return ChatResponse{}, fmt.Errorf("no response from LLM")
+	}
+	return result.chatResponse(), nil
+}
+
+// ScriptedResponse is the reply when the last message contains Contains.
+// An empty Model or Contains matches anything.
+type ScriptedResponse struct {
+	Model    string `yaml:"model" json:"model"`
+	Contains string `yaml:"contains" json:"contains"`
+	Response string `yaml:"response" json:"response"`
+}
+
+// ScriptedClient answers from a fixed script without calling any model. The
+// first matching response wins, so runs are deterministic. It is meant for
+// offline runs and tests.
+type ScriptedClient struct {
+	responses []ScriptedResponse
+	fallback  string
+
+	mu    sync.Mutex
+	calls []ScriptedCall
+}
+
+// ScriptedCall is a prompt the scripted client has answered.
+type ScriptedCall struct {
+	Model  string
+	Prompt string
+}
+
+// NewScriptedClient creates a client answering with responses, or fallback
+// when none matches. Without a fallback unmatched prompts are an error.
+func NewScriptedClient(responses []ScriptedResponse, fallback string) *ScriptedClient {
+	return &ScriptedClient{responses: responses, fallback: fallback}
+}
+
+// LoadScriptedClient reads a script from a YAML file:
+//
+//	default: '{}'
+//	responses:
+//	  - contains: "Analyze this marketplace ad"
+//	    response: '{"manufacturer": "Apple"}'
+func LoadScriptedClient(path string) (*ScriptedClient, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read llm script: %w", err)
+	}
+	var script struct {
+		Default   string             `yaml:"default"`
+		Responses []ScriptedResponse `yaml:"responses"`
+	}
+	if err := yaml.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("failed to parse llm script: %w", err)
+	}
+	return NewScriptedClient(script.Responses, script.Default), nil
+}
+
+func (c *ScriptedClient) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	model, prompt := req.Model, req.Prompt()
+	c.mu.Lock()
+	c.calls = append(c.calls, ScriptedCall{Model: model, Prompt: prompt})
+	c.mu.Unlock()
+
+	for _, r := range c.responses {
+		if r.Model != "" && r.Model != model {
+			continue
+		}
+		if strings.Contains(prompt, r.Contains) {
+			return ChatResponse{Content: r.Response}, nil
+		}
+	}
+	if c.fallback != "" {
+		return ChatResponse{Content: c.fallback}, nil
+	}
+	return ChatResponse{}, fmt.Errorf("no scripted response for prompt")
+}
+
+// Calls returns the prompts answered so far.
+func (c *ScriptedClient) Calls() []ScriptedCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]ScriptedCall(nil), c.calls...)
+}
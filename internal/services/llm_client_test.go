@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"begbot/internal/config"
+)
+
+func TestScriptedClient(t *testing.T) {
+	client := NewScriptedClient([]ScriptedResponse{
+		{Model: "small", Contains: "iPhone", Response: "small-iphone"},
+		{Contains: "iPhone", Response: "iphone"},
+	}, "")
+
+	got, err := client.Chat(context.Background(), userChat("small", "sell iPhone 13"))
+	if err != nil || got.Content != "small-iphone" {
+		t.Errorf("Chat() = %q, %v, want small-iphone", got.Content, err)
+	}
+	got, err = client.Chat(context.Background(), userChat("large", "sell iPhone 13"))
+	if err != nil || got.Content != "iphone" {
+		t.Errorf("Chat() = %q, %v, want iphone", got.Content, err)
+	}
+	if _, err := client.Chat(context.Background(), userChat("large", "sell Pixel 8")); err == nil {
+		t.Error("Chat() without a match or fallback should fail")
+	}
+	if n := len(client.Calls()); n != 3 {
+		t.Errorf("Calls() = %d, want 3", n)
+	}
+}
+
+func TestLoadScriptedClient(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.yaml")
+	script := "default: '{}'\nresponses:\n  - contains: Analyze\n    response: '{\"manufacturer\": \"Apple\"}'\n"
+	if err := os.WriteFile(path, []byte(script), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewLLMClient(ProviderScripted, config.LLMConfig{ScriptFile: path})
+	if err != nil {
+		t.Fatalf("NewLLMClient() error = %v", err)
+	}
+	got, _ := client.Chat(context.Background(), userChat("", "Analyze this ad"))
+	if got.Content != `{"manufacturer": "Apple"}` {
+		t.Errorf("Chat() = %q", got.Content)
+	}
+	got, _ = client.Chat(context.Background(), userChat("", "something else"))
+	if got.Content != "{}" {
+		t.Errorf("Chat() fallback = %q, want {}", got.Content)
+	}
+}
+
+func TestNewLLMClientProviders(t *testing.T) {
+	if _, ok := mustClient(t, "", config.LLMConfig{}).(*OpenRouterClient); !ok {
+		t.Error("empty provider should be OpenRouter")
+	}
+	if _, ok := mustClient(t, "ollama", config.LLMConfig{BaseURL: "http://localhost:11434/v1"}).(*OpenAICompatibleClient); !ok {
+		t.Error("ollama should use the OpenAI-compatible client")
+	}
+	if _, err := NewLLMClient(ProviderOpenAICompatible, config.LLMConfig{}); err == nil {
+		t.Error("OpenAI-compatible provider without base_url should fail")
+	}
+	if _, err := NewLLMClient("nope", config.LLMConfig{}); err == nil {
+		t.Error("unknown provider should fail")
+	}
+}
+
+func userChat(model, prompt string) ChatRequest {
+	return ChatRequest{Model: model, Messages: []ChatMessage{{Role: "user", Content: prompt}}}
+}
+
+func mustClient(t *testing.T, provider string, cfg config.LLMConfig) LLMClient {
+	t.Helper()
+	client, err := NewLLMClient(provider, cfg)
+	if err != nil {
+		t.Fatalf("NewLLMClient(%q) error = %v", provider, err)
+	}
+	return client
+}
+
+func TestOpenAICompatibleClient(t *testing.T) {
+	var gotModel string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		var req openRouterRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		gotModel = req.Model
+		w.Write([]byte(`{"choices":[{"message":{"content":"hej"}}]}`))
+	}))
+	defer server.Close()
+
+	client := NewOpenAICompatibleClient(server.URL+"/v1/", "", 0)
+	got, err := client.Chat(context.Background(), userChat("llama3", "hello"))
+	if err != nil || got.Content != "hej" {
+		t.Errorf("Chat() = %q, %v", got.Content, err)
+	}
+	if gotModel != "llama3" {
+		t.Errorf("model = %q, want llama3", gotModel)
+	}
+}
+
+func TestLLMServicePerFunctionProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "script.yaml")
+	if err := os.WriteFile(path, []byte("default: scripted\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{LLM: config.LLMConfig{
+		Provider:     "nope",
+		ScriptFile:   path,
+		DefaultModel: "default-model",
+		Models:       map[string]string{"GenerateMessage": "message-model"},
+		Providers:    map[string]string{"GenerateMessage": ProviderScripted},
+	}}
+	s := NewLLMService(cfg)
+
+	got, err := s.chat(context.Background(), "GenerateMessage", textPrompt("hi"))
+	if err != nil || got != "scripted" {
+		t.Errorf("chat(GenerateMessage) = %q, %v", got, err)
+	}
+	if _, err := s.chat(context.Background(), "ExtractProductInfo", textPrompt("hi")); err == nil {
+		t.Error("chat() with an unknown default provider should fail")
+	}
+
+	scripted := s.clients[ProviderScripted].(*ScriptedClient)
+	if calls := scripted.Calls(); len(calls) != 1 || calls[0].Model != "message-model" {
+		t.Errorf("Calls() = %+v, want one call with message-model", calls)
+	}
+}
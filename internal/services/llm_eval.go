@@ -0,0 +1,250 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"begbot/internal/models"
+)
+
+// DefaultNewPriceTolerance is how far from the labelled new price an
+// estimate may be, as a fraction of it, and still count as correct.
+const DefaultNewPriceTolerance = 0.15
+
+// Fields scored by Evaluate.
+const (
+	EvalFieldManufacturer = "manufacturer"
+	EvalFieldModel        = "model"
+	EvalFieldCategory     = "category"
+	EvalFieldCondition    = "condition"
+	EvalFieldNewPrice     = "new_price"
+)
+
+// LLMEvalOptions configures Evaluate.
+type LLMEvalOptions struct {
+	// Models to compare. Empty runs the configured models once.
+	Models []string
+	// NewPrice also evaluates the NewPrice function on cases with a
+	// labelled new price.
+	NewPrice          bool
+	NewPriceTolerance float64
+}
+
+// LLMEvalScore counts the correct answers for one field among the cases
+// labelled with it.
+type LLMEvalScore struct {
+	Correct int `json:"correct"`
+	Total   int `json:"total"`
+}
+
+func (sc LLMEvalScore) Accuracy() float64 {
+	if sc.Total == 0 {
+		return 0
+	}
+	return float64(sc.Correct) / float64(sc.Total)
+}
+
+// LLMEvalMismatch is a wrong answer, kept so the cases can be inspected.
+type LLMEvalMismatch struct {
+	CaseID int64 `json:"case_id,omitempty"`
+	// Ref is where the case came from, e.g. listing:42.
+	Ref      string `json:"ref"`
+	Field    string `json:"field"`
+	Expected string `json:"expected"`
+	Got      string `json:"got"`
+}
+
+// LLMEvalResult is the outcome of running the dataset with one model.
+type LLMEvalResult struct {
+	Model string `json:"model"`
+	Cases int    `json:"cases"`
+	// Failures counts calls that returned no valid output.
+	Failures         int                      `json:"failures"`
+	Fields           map[string]*LLMEvalScore `json:"fields"`
+	Calls            int                      `json:"calls"`
+	PromptTokens     int                      `json:"prompt_tokens"`
+	CompletionTokens int                      `json:"completion_tokens"`
+	CostUSD          float64                  `json:"cost_usd"`
+	AvgLatencyMs     int                      `json:"avg_latency_ms"`
+	Mismatches       []LLMEvalMismatch        `json:"mismatches,omitempty"`
+}
+
+// Evaluate runs the LLM functions over the labelled cases with each model
+// and scores the answers. Responses are never taken from the cache, so the
+// cost and latency are those of real calls.
+func (s *LLMService) Evaluate(ctx context.Context, cases []models.LLMEvalCase, opts LLMEvalOptions) ([]LLMEvalResult, error) {
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("no evaluation cases")
+	}
+	if opts.NewPriceTolerance <= 0 {
+		opts.NewPriceTolerance = DefaultNewPriceTolerance
+	}
+	modelNames := opts.Models
+	if len(modelNames) == 0 {
+		modelNames = []string{""}
+	}
+
+	var results []LLMEvalResult
+	for _, model := range modelNames {
+		svc := s
+		if model != "" {
+			svc = s.withModel(model)
+		}
+		result, err := svc.evaluateModel(ctx, cases, opts)
+		if err != nil {
+			return results, err
+		}
+		result.Model = model
+		if model == "" {
+			result.Model = s.defaultModel
+		}
+		results = append(results, *result)
+	}
+	return results, nil
+}
+
+func (s *LLMService) evaluateModel(ctx context.Context, cases []models.LLMEvalCase, opts LLMEvalOptions) (*LLMEvalResult, error) {
+	stats := &LLMCallStats{}
+	ctx = WithLLMCallStats(WithoutLLMCache(ctx), stats)
+	result := &LLMEvalResult{Cases: len(cases), Fields: make(map[string]*LLMEvalScore)}
+	newPrice := &LLMNewPriceMethod{svc: &ValuationService{llmSvc: s}}
+
+	for _, c := range cases {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		info, err := s.ExtractProductInfo(ctx, c.AdText, "")
+		if err != nil {
+			var outputErr *LLMOutputError
+			if !errors.As(err, &outputErr) {
+				return nil, err
+			}
+			result.Failures++
+			info = &ProductInfo{AdText: c.AdText}
+		}
+		result.score(c, EvalFieldManufacturer, c.Manufacturer, info.Manufacturer)
+		result.score(c, EvalFieldModel, c.Model, info.Model)
+		result.score(c, EvalFieldCategory, c.Category, info.Category)
+		result.score(c, EvalFieldCondition, c.Condition, info.Condition)
+
+		if !opts.NewPrice || c.NewPrice == nil {
+			continue
+		}
+		estimate, err := newPrice.Valuate(ctx, *info)
+		if err != nil {
+			var outputErr *LLMOutputError
+			if !errors.As(err, &outputErr) {
+				return nil, err
+			}
+		}
+		got := 0
+		if estimate != nil {
+			got = estimate.Value
+		} else {
+			result.Failures++
+		}
+		correct := math.Abs(float64(got-*c.NewPrice)) <= opts.NewPriceTolerance*float64(*c.NewPrice)
+		result.record(c, EvalFieldNewPrice, fmt.Sprint(*c.NewPrice), fmt.Sprint(got), correct)
+	}
+
+	result.Calls = stats.Calls()
+	result.PromptTokens, result.CompletionTokens = stats.Tokens()
+	result.CostUSD = stats.CostUSD()
+	if result.Calls > 0 {
+		result.AvgLatencyMs = int(stats.Latency().Milliseconds()) / result.Calls
+	}
+	return result, nil
+}
+
+// score compares a text field. Unlabelled fields are skipped.
+func (r *LLMEvalResult) score(c models.LLMEvalCase, field, expected, got string) {
+	if expected == "" {
+		return
+	}
+	r.record(c, field, expected, got, normalizeEvalText(expected) == normalizeEvalText(got))
+}
+
+func (r *LLMEvalResult) record(c models.LLMEvalCase, field, expected, got string, correct bool) {
+	sc, ok := r.Fields[field]
+	if !ok {
+		sc = &LLMEvalScore{}
+		r.Fields[field] = sc
+	}
+	sc.Total++
+	if correct {
+		sc.Correct++
+		return
+	}
+	r.Mismatches = append(r.Mismatches, LLMEvalMismatch{CaseID: c.ID, Ref: c.Source + ":" + c.SourceRef, Field: field, Expected: expected, Got: got})
+}
+
+// normalizeEvalText ignores case, spacing and punctuation, so "iPhone 13"
+// and "iphone-13" are the same answer.
+func normalizeEvalText(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// withModel returns a service that uses model for every function, sharing
+// the clients, stores and prompts of s.
+func (s *LLMService) withModel(model string) *LLMService {
+	return &LLMService{
+		cfg:          s.cfg,
+		client:       s.client,
+		clients:      s.clients,
+		defaultModel: model,
+		providers:    s.providers,
+		cache:        s.cache,
+		usage:        s.usage,
+		prompts:      s.prompts,
+		breaker:      s.breaker,
+		limiter:      s.limiter,
+		examples:     s.examples,
+	}
+}
+
+// ReadLLMEvalCases reads labelled ads as JSON lines, one
+// models.LLMEvalCase per line. Cases without a source are attributed to
+// source with the line number as reference.
+func ReadLLMEvalCases(r io.Reader, source string) ([]models.LLMEvalCase, error) {
+	var cases []models.LLMEvalCase
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var c models.LLMEvalCase
+		if err := json.Unmarshal([]byte(text), &c); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if strings.TrimSpace(c.AdText) == "" {
+			return nil, fmt.Errorf("line %d: ad_text is required", line)
+		}
+		if c.Source == "" {
+			c.Source = source
+		}
+		if c.SourceRef == "" {
+			c.SourceRef = strconv.Itoa(line)
+		}
+		cases = append(cases, c)
+	}
+	return cases, scanner.Err()
+}
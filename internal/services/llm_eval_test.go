@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"begbot/internal/config"
+	"begbot/internal/models"
+)
+
+// evalClient answers like a good and a sloppy model.
+type evalClient struct{}
+
+func (evalClient) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	usage := LLMUsage{PromptTokens: 100, CompletionTokens: 20, CostUSD: 0.001}
+	if strings.Contains(req.Prompt(), "NEW retail price") {
+		return ChatResponse{Content: `{"price": 9000, "confidence": 70}`, Usage: usage}, nil
+	}
+	if req.Model == "sloppy" {
+		return ChatResponse{Content: `{"manufacturer": "Apple", "model": "iPhone", "category": "other"}`, Usage: usage}, nil
+	}
+	return ChatResponse{Content: `{"manufacturer": "apple", "model": "iPhone-13", "category": "phone", "condition": "Mycket bra"}`, Usage: usage}, nil
+}
+
+func TestLLMEvaluate(t *testing.T) {
+	s := NewLLMServiceWithClient(&config.Config{LLM: config.LLMConfig{DefaultModel: "good"}}, evalClient{})
+	newPrice := 10000
+	cases := []models.LLMEvalCase{
+		{ID: 1, Source: "listing", SourceRef: "1", AdText: "Säljer iPhone 13", Manufacturer: "Apple", Model: "iPhone 13", Category: "phone", Condition: "Mycket bra", NewPrice: &newPrice},
+		{ID: 2, Source: "listing", SourceRef: "2", AdText: "iPhone 13 128GB", Manufacturer: "Apple", Model: "iPhone 13", Category: "phone"},
+	}
+
+	results, err := s.Evaluate(context.Background(), cases, LLMEvalOptions{Models: []string{"good", "sloppy"}, NewPrice: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("results = %d, want one per model", len(results))
+	}
+
+	good, sloppy := results[0], results[1]
+	if good.Model != "good" || sloppy.Model != "sloppy" {
+		t.Errorf("models = %s, %s", good.Model, sloppy.Model)
+	}
+	for _, field := range []string{EvalFieldManufacturer, EvalFieldModel, EvalFieldCategory, EvalFieldCondition, EvalFieldNewPrice} {
+		if sc := good.Fields[field]; sc == nil || sc.Accuracy() != 1 {
+			t.Errorf("good %s = %+v, want all correct", field, sc)
+		}
+	}
+	if sc := good.Fields[EvalFieldCondition]; sc.Total != 1 {
+		t.Errorf("unlabelled conditions should not be scored, total = %d", sc.Total)
+	}
+	if sc := sloppy.Fields[EvalFieldCategory]; sc.Correct != 0 || sc.Total != 2 {
+		t.Errorf("sloppy category = %+v, want 0/2", sc)
+	}
+	if sc := sloppy.Fields[EvalFieldManufacturer]; sc.Accuracy() != 1 {
+		t.Errorf("sloppy manufacturer = %+v, want all correct", sc)
+	}
+	if len(sloppy.Mismatches) == 0 || sloppy.Mismatches[0].Ref != "listing:1" {
+		t.Errorf("mismatches = %+v", sloppy.Mismatches)
+	}
+
+	if good.Calls != 3 || good.PromptTokens != 300 || math.Abs(good.CostUSD-0.003) > 1e-9 {
+		t.Errorf("good usage = %d calls, %d prompt tokens, %v USD", good.Calls, good.PromptTokens, good.CostUSD)
+	}
+}
+
+func TestReadLLMEvalCases(t *testing.T) {
+	input := `# golden ads
+{"ad_text": "Säljer iPhone 13", "manufacturer": "Apple", "model": "iPhone 13", "category": "phone", "new_price": 9990}
+
+{"ad_text": "AirPods Pro", "source": "review", "source_ref": "7", "category": "headphones"}
+`
+	cases, err := ReadLLMEvalCases(strings.NewReader(input), "golden.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) != 2 {
+		t.Fatalf("cases = %d, want 2", len(cases))
+	}
+	if cases[0].Source != "golden.jsonl" || cases[0].SourceRef != "2" || cases[0].NewPrice == nil || *cases[0].NewPrice != 9990 {
+		t.Errorf("case 0 = %+v", cases[0])
+	}
+	if cases[1].Source != "review" || cases[1].SourceRef != "7" {
+		t.Errorf("case 1 = %+v", cases[1])
+	}
+
+	if _, err := ReadLLMEvalCases(strings.NewReader(`{"manufacturer": "Apple"}`), "x"); err == nil {
+		t.Error("a case without ad text should be rejected")
+	}
+}
@@ -0,0 +1,307 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultLLMRetryBackoff    = time.Second
+	DefaultLLMCircuitCooldown = 5 * time.Minute
+
+	// fallbackDefaultKey holds the fallback models of functions without
+	// their own list.
+	fallbackDefaultKey = "default"
+)
+
+// ErrLLMCircuitOpen is returned for a model skipped because it failed too
+// often recently.
+var ErrLLMCircuitOpen = errors.New("circuit open")
+
+// LLMStatusError is an HTTP error from an LLM provider.
+type LLMStatusError struct {
+	StatusCode int
+	Body       string
+	// RetryAfter is how long the provider asked us to wait, if it did.
+	RetryAfter time.Duration
+}
+
+func (e *LLMStatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("API request failed with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("API request failed with status %d: %s", e.StatusCode, e.Body)
+}
+
+func newLLMStatusError(resp *http.Response, body []byte) *LLMStatusError {
+	err := &LLMStatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	if seconds, convErr := strconv.Atoi(strings.TrimSpace(resp.Header.Get("Retry-After"))); convErr == nil && seconds > 0 {
+		err.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return err
+}
+
+// retryableLLMError reports whether err is worth retrying on the same
+// model: a rate limit, a server error or a network failure.
+func retryableLLMError(err error) bool {
+	var statusErr *LLMStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// llmCircuitBreaker skips a model for a cooldown once it has failed
+// threshold times in a row. After the cooldown the circuit is half open: a
+// single probe call is let through and the others are still skipped. A
+// success closes the circuit and a failure opens it again. A probe that
+// never reports back frees the circuit for a new probe after another
+// cooldown.
+type llmCircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+	models    map[string]*llmCircuit
+}
+
+type llmCircuit struct {
+	failures  int
+	openUntil time.Time
+}
+
+func newLLMCircuitBreaker(threshold int, cooldown time.Duration) *llmCircuitBreaker {
+	if cooldown <= 0 {
+		cooldown = DefaultLLMCircuitCooldown
+	}
+	return &llmCircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		models:    make(map[string]*llmCircuit),
+	}
+}
+
+// allow reports whether model may be called. Letting the probe of a half
+// open circuit through holds off other calls until it reports back.
+func (b *llmCircuitBreaker) allow(model string) bool {
+	if b == nil || b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.models[model]
+	if !ok {
+		return true
+	}
+	now := b.now()
+	if now.Before(c.openUntil) {
+		return false
+	}
+	if c.failures >= b.threshold {
+		c.openUntil = now.Add(b.cooldown)
+	}
+	return true
+}
+
+func (b *llmCircuitBreaker) success(model string) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.models, model)
+}
+
+// failure counts a failed call and reports whether it opened the circuit.
+func (b *llmCircuitBreaker) failure(model string) bool {
+	if b == nil || b.threshold <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.models[model]
+	if !ok {
+		c = &llmCircuit{}
+		b.models[model] = c
+	}
+	c.failures++
+	if c.failures < b.threshold {
+		return false
+	}
+	c.openUntil = b.now().Add(b.cooldown)
+	return true
+}
+
+// llmLimiter caps the calls in flight per provider.
+type llmLimiter struct {
+	mu     sync.Mutex
+	limits map[string]int
+	slots  map[string]chan struct{}
+}
+
+func newLLMLimiter(limits map[string]int) *llmLimiter {
+	return &llmLimiter{limits: limits, slots: make(map[string]chan struct{})}
+}
+
+// acquire waits for a free slot for provider. The returned function frees
+// it. Providers without a limit never wait.
+func (l *llmLimiter) acquire(ctx context.Context, provider string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	l.mu.Lock()
+	slots, ok := l.slots[provider]
+	if !ok {
+		if n := l.limits[provider]; n > 0 {
+			slots = make(chan struct{}, n)
+		}
+		l.slots[provider] = slots
+	}
+	l.mu.Unlock()
+	if slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// modelChain is the configured model of function followed by its fallback
+// models. A model other than the configured one, such as the budget model,
+// is used alone.
+func (s *LLMService) modelChain(function, model string) []string {
+	chain := []string{model}
+	if s.cfg == nil || model != GetModel(function, s.defaultModel, s.models) {
+		return chain
+	}
+	fallbacks, ok := s.cfg.LLM.Fallbacks[function]
+	if !ok {
+		fallbacks = s.cfg.LLM.Fallbacks[fallbackDefaultKey]
+	}
+	for _, fallback := range fallbacks {
+		if fallback != "" && !slices.Contains(chain, fallback) {
+			chain = append(chain, fallback)
+		}
+	}
+	return chain
+}
+
+// send makes the call with the model of req, then with the fallback models
+// of function until one answers. Models whose circuit is open are skipped.
+// It returns the reply and the model that gave it.
+func (s *LLMService) send(ctx context.Context, function string, prompt RenderedPrompt, req ChatRequest) (string, string, error) {
+	chain := s.modelChain(function, req.Model)
+	var errs []error
+	for _, model := range chain {
+		if !s.breaker.allow(model) {
+			errs = append(errs, fmt.Errorf("%s: %w", model, ErrLLMCircuitOpen))
+			continue
+		}
+		req.Model = model
+		content, err := s.sendWithRetries(ctx, function, prompt, req)
+		if err == nil {
+			s.breaker.success(model)
+			if model != chain[0] {
+				log.Printf("%s: answered by fallback model %s", function, model)
+			}
+			return content, model, nil
+		}
+		if ctx.Err() != nil {
+			return "", "", err
+		}
+		// Client errors such as a bad or too long prompt say nothing about
+		// the health of the model.
+		if retryableLLMError(err) && s.breaker.failure(model) {
+			log.Printf("LLM circuit open for %s after repeated failures: %v", model, err)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", model, err))
+	}
+	if len(chain) == 1 && len(errs) == 1 {
+		return "", "", errors.Unwrap(errs[0])
+	}
+	return "", "", errors.Join(errs...)
+}
+
+// sendWithRetries retries rate limits, server errors and network errors on
+// the same model, doubling the wait each time.
+func (s *LLMService) sendWithRetries(ctx context.Context, function string, prompt RenderedPrompt, req ChatRequest) (string, error) {
+	backoff := s.retryBackoff()
+	for retry := 0; ; retry++ {
+		content, err := s.sendOnce(ctx, function, prompt, req)
+		if err == nil || retry >= s.retries() || !retryableLLMError(err) {
+			return content, err
+		}
+
+		wait := backoff
+		var statusErr *LLMStatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > wait {
+			wait = statusErr.RetryAfter
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// sendOnce makes one call within the concurrency limit of the provider and
+// records its usage.
+func (s *LLMService) sendOnce(ctx context.Context, function string, prompt RenderedPrompt, req ChatRequest) (string, error) {
+	release, err := s.limiter.acquire(ctx, s.providerFor(function))
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	start := time.Now()
+	resp, err := s.clientFor(function).Chat(ctx, req)
+	s.recordCall(ctx, function, prompt.ID(), req.Model, resp.Usage, time.Since(start), err == nil)
+	if err != nil {
+		return "", err
+	}
+	return resp.Content, nil
+}
+
+// providerFor is the name of the provider function is sent to.
+func (s *LLMService) providerFor(function string) string {
+	if provider, ok := s.providers[function]; ok {
+		return strings.ToLower(provider)
+	}
+	if s.cfg == nil || s.cfg.LLM.Provider == "" {
+		return ProviderOpenRouter
+	}
+	return strings.ToLower(s.cfg.LLM.Provider)
+}
+
+func (s *LLMService) retries() int {
+	if s.cfg == nil || s.cfg.LLM.Retries < 0 {
+		return 0
+	}
+	return s.cfg.LLM.Retries
+}
+
+func (s *LLMService) retryBackoff() time.Duration {
+	if s.cfg == nil || s.cfg.LLM.RetryBackoff <= 0 {
+		return DefaultLLMRetryBackoff
+	}
+	return s.cfg.LLM.RetryBackoff
+}
@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"begbot/internal/config"
+	"begbot/internal/models"
+)
+
+// flakyClient fails the models in failures with their error and answers
+// with any other model.
+type flakyClient struct {
+	failures map[string]error
+	calls    []string
+}
+
+func (c *flakyClient) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	c.calls = append(c.calls, req.Model)
+	if err, ok := c.failures[req.Model]; ok {
+		return ChatResponse{}, err
+	}
+	return ChatResponse{Content: `{"price": 1500, "confidence": 80}`}, nil
+}
+
+func resilientLLMConfig() *config.Config {
+	return &config.Config{LLM: config.LLMConfig{
+		DefaultModel: "primary",
+		Fallbacks:    map[string][]string{"default": {"backup", "primary", "last"}},
+		Retries:      2,
+		RetryBackoff: time.Millisecond,
+		CacheTTL:     map[string]time.Duration{"NewPrice": time.Hour},
+	}}
+}
+
+func TestLLMFallbackAfterRetries(t *testing.T) {
+	client := &flakyClient{failures: map[string]error{"primary": &LLMStatusError{StatusCode: http.StatusServiceUnavailable}}}
+	s := NewLLMServiceWithClient(resilientLLMConfig(), client)
+	cache := &memoryLLMCache{entries: make(map[string]*models.LLMCacheEntry)}
+	usage := &memoryLLMUsage{}
+	s.cache, s.usage = cache, usage
+
+	var out map[string]interface{}
+	model, err := s.chatJSON(context.Background(), "NewPrice", textPrompt("p"), newPriceSchema, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model != "backup" {
+		t.Errorf("answered by %q, want backup", model)
+	}
+	if want := []string{"primary", "primary", "primary", "backup"}; fmt.Sprint(client.calls) != fmt.Sprint(want) {
+		t.Errorf("calls = %v, want %v", client.calls, want)
+	}
+	if len(usage.calls) != 4 || usage.calls[0].Success || !usage.calls[3].Success || usage.calls[3].Model != "backup" {
+		t.Errorf("every attempt should be recorded with its model, got %d calls", len(usage.calls))
+	}
+	for _, e := range cache.entries {
+		if e.Model != "backup" {
+			t.Errorf("cached model = %q, want the model that answered", e.Model)
+		}
+	}
+
+	client.calls = nil
+	if model, err := s.chatJSON(context.Background(), "NewPrice", textPrompt("p"), newPriceSchema, &out); err != nil || model != "backup" || len(client.calls) != 0 {
+		t.Errorf("cache hit = %q, %v with %d calls, want backup without calls", model, err, len(client.calls))
+	}
+}
+
+func TestLLMFallbackWithoutRetryOnClientError(t *testing.T) {
+	client := &flakyClient{failures: map[string]error{
+		"primary": &LLMStatusError{StatusCode: http.StatusBadRequest},
+		"backup":  &LLMStatusError{StatusCode: http.StatusTooManyRequests},
+		"last":    errors.New("no response from LLM"),
+	}}
+	s := NewLLMServiceWithClient(resilientLLMConfig(), client)
+
+	_, err := s.chat(context.Background(), "GenerateMessage", textPrompt("p"))
+	if err == nil {
+		t.Fatal("expected an error when every model fails")
+	}
+	var statusErr *LLMStatusError
+	if !errors.As(err, &statusErr) {
+		t.Errorf("error should wrap the provider errors: %v", err)
+	}
+	if want := []string{"primary", "backup", "backup", "backup", "last"}; fmt.Sprint(client.calls) != fmt.Sprint(want) {
+		t.Errorf("calls = %v, want %v", client.calls, want)
+	}
+}
+
+func TestLLMBudgetModelHasNoFallbacks(t *testing.T) {
+	s := NewLLMServiceWithClient(resilientLLMConfig(), &flakyClient{})
+	if chain := s.modelChain("NewPrice", "cheap"); len(chain) != 1 {
+		t.Errorf("chain = %v, want only the budget model", chain)
+	}
+	if chain := s.modelChain("NewPrice", "primary"); fmt.Sprint(chain) != "[primary backup last]" {
+		t.Errorf("chain = %v", chain)
+	}
+}
+
+func TestLLMCircuitBreaker(t *testing.T) {
+	cfg := resilientLLMConfig()
+	cfg.LLM.Retries = 0
+	cfg.LLM.CircuitThreshold = 2
+	cfg.LLM.CircuitCooldown = time.Minute
+	client := &flakyClient{failures: map[string]error{"primary": &LLMStatusError{StatusCode: http.StatusBadGateway}}}
+	s := NewLLMServiceWithClient(cfg, client)
+	now := time.Now()
+	s.breaker.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.chat(context.Background(), "GenerateMessage", textPrompt("p")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"primary", "backup", "primary", "backup", "backup"}; fmt.Sprint(client.calls) != fmt.Sprint(want) {
+		t.Errorf("calls = %v, want primary skipped once its circuit is open", client.calls)
+	}
+
+	now = now.Add(time.Minute)
+	delete(client.failures, "primary")
+	client.calls = nil
+	if _, err := s.chat(context.Background(), "GenerateMessage", textPrompt("p")); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.calls) != 1 || client.calls[0] != "primary" {
+		t.Errorf("after the cooldown primary should be tried again, calls = %v", client.calls)
+	}
+}
+
+func TestLLMCircuitBreakerIgnoresClientErrors(t *testing.T) {
+	cfg := resilientLLMConfig()
+	cfg.LLM.Retries = 0
+	cfg.LLM.CircuitThreshold = 2
+	client := &flakyClient{failures: map[string]error{"primary": &LLMStatusError{StatusCode: http.StatusBadRequest}}}
+	s := NewLLMServiceWithClient(cfg, client)
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.chat(context.Background(), "GenerateMessage", textPrompt("p")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !s.breaker.allow("primary") {
+		t.Error("bad requests should not open the circuit")
+	}
+}
+
+func TestLLMCircuitBreakerHalfOpen(t *testing.T) {
+	b := newLLMCircuitBreaker(2, time.Minute)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	b.failure("primary")
+	b.failure("primary")
+	if b.allow("primary") {
+		t.Fatal("open circuit should skip the model")
+	}
+
+	now = now.Add(time.Minute)
+	if !b.allow("primary") {
+		t.Fatal("half open circuit should let one probe through")
+	}
+	if b.allow("primary") {
+		t.Error("half open circuit should skip calls while the probe runs")
+	}
+	if !b.failure("primary") || b.allow("primary") {
+		t.Error("failed probe should open the circuit again")
+	}
+
+	now = now.Add(time.Minute)
+	if !b.allow("primary") {
+		t.Fatal("half open circuit should let a new probe through")
+	}
+	b.success("primary")
+	if !b.allow("primary") || !b.allow("primary") {
+		t.Error("successful probe should close the circuit")
+	}
+}
+
+func TestLLMLimiter(t *testing.T) {
+	l := newLLMLimiter(map[string]int{"openrouter": 1})
+	release, err := l.acquire(context.Background(), "openrouter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(ctx, "openrouter"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("second call should wait for the slot, err = %v", err)
+	}
+	if _, err := l.acquire(ctx, "ollama"); err != nil {
+		t.Errorf("unlimited provider should not wait: %v", err)
+	}
+
+	release()
+	if _, err := l.acquire(context.Background(), "openrouter"); err != nil {
+		t.Errorf("released slot should be free: %v", err)
+	}
+}
+
+func TestLLMStatusErrorFromProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client := NewOpenAICompatibleClient(server.URL, "", time.Second)
+	_, err := client.Chat(context.Background(), userChat("m", "hi"))
+	var statusErr *LLMStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusTooManyRequests || statusErr.RetryAfter != 3*time.Second {
+		t.Fatalf("error = %#v", err)
+	}
+	if !retryableLLMError(err) {
+		t.Error("rate limits should be retried")
+	}
+	if retryableLLMError(&LLMStatusError{StatusCode: http.StatusUnauthorized}) {
+		t.Error("client errors should not be retried")
+	}
+}
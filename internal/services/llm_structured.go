@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultLLMMaxTokens   = 1024
+	DefaultLLMMaxAttempts = 3
+)
+
+// OutputSchema is a JSON schema for the output of an LLM function. Only the
+// subset used by our schemas is validated locally: type, properties,
+// required, enum, minimum and maximum.
+type OutputSchema struct {
+	Name   string
+	Schema map[string]interface{}
+}
+
+// LLMOutputError is returned when a function did not get valid output from
+// the model within its attempts.
+type LLMOutputError struct {
+	Function string
+	Attempts int
+	// Raw is the last reply from the model.
+	Raw string
+	Err error
+}
+
+func (e *LLMOutputError) Error() string {
+	return fmt.Sprintf("%s: invalid LLM output after %d attempts: %v", e.Function, e.Attempts, e.Err)
+}
+
+func (e *LLMOutputError) Unwrap() error {
+	return e.Err
+}
+
+// chatJSON asks for output matching schema and decodes it into out. Replies
+// that are not valid JSON or do not match the schema are sent back with the
+// validation error so the model can repair them, up to MaxAttempts times.
+// Errors from the client itself are returned as is. Only valid output is
+// cached. It returns the model that answered.
+func (s *LLMService) chatJSON(ctx context.Context, function string, prompt RenderedPrompt, schema *OutputSchema, out interface{}) (string, error) {
+	req := s.request(function, prompt)
+	req.Schema = schema
+	if err := s.applyBudget(ctx, function, &req); err != nil {
+		return "", err
+	}
+
+	key, cached := s.cachedResponse(ctx, function, prompt, req)
+	if cached != nil && decodeStructuredOutput(cached.Response, schema, out) == nil {
+		return cached.Model, nil
+	}
+
+	attempts := s.maxAttempts()
+	var content string
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		reply, model, err := s.send(ctx, function, prompt, req)
+		if err != nil {
+			return "", fmt.Errorf("LLM API error: %w", err)
+		}
+		content = cleanupMarkdownJSON(reply)
+
+		lastErr = decodeStructuredOutput(content, schema, out)
+		if lastErr == nil {
+			s.storeResponse(ctx, key, function, prompt, model, content)
+			return model, nil
+		}
+
+		req.Messages = append(req.Messages,
+			ChatMessage{Role: "assistant", Content: reply},
+			ChatMessage{Role: "user", Content: fmt.Sprintf("Your answer was invalid: %v. Return ONLY the corrected JSON object.", lastErr)},
+		)
+	}
+	return "", &LLMOutputError{Function: function, Attempts: attempts, Raw: content, Err: lastErr}
+}
+
+func decodeStructuredOutput(content string, schema *OutputSchema, out interface{}) error {
+	var value interface{}
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		return fmt.Errorf("not valid JSON: %w", err)
+	}
+	if schema != nil {
+		if err := validateSchema(value, schema.Schema, "$"); err != nil {
+			return err
+		}
+	}
+	if err := json.Unmarshal([]byte(content), out); err != nil {
+		return fmt.Errorf("does not match the expected fields: %w", err)
+	}
+	return nil
+}
+
+// validateSchema checks value against a JSON schema.
+func validateSchema(value interface{}, schema map[string]interface{}, path string) error {
+	if t, ok := schema["type"]; ok && !matchesSchemaType(value, t) {
+		return fmt.Errorf("%s: expected %v, got %s", path, t, jsonTypeName(value))
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if e == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", path, value, enum)
+		}
+	}
+
+	if n, ok := value.(float64); ok {
+		if min, ok := schemaNumber(schema["minimum"]); ok && n < min {
+			return fmt.Errorf("%s: %v is less than %v", path, n, min)
+		}
+		if max, ok := schemaNumber(schema["maximum"]); ok && n > max {
+			return fmt.Errorf("%s: %v is greater than %v", path, n, max)
+		}
+	}
+
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if required, ok := schema["required"].([]string); ok {
+		for _, key := range required {
+			if _, exists := obj[key]; !exists {
+				return fmt.Errorf("%s: missing required field %q", path, key)
+			}
+		}
+	}
+	properties, _ := schema["properties"].(map[string]interface{})
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		v, exists := obj[key]
+		propSchema, ok := properties[key].(map[string]interface{})
+		if !exists || !ok {
+			continue
+		}
+		if err := validateSchema(v, propSchema, path+"."+key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchesSchemaType(value interface{}, t interface{}) bool {
+	switch t := t.(type) {
+	case string:
+		return matchesJSONType(value, t)
+	case []string:
+		for _, name := range t {
+			if matchesJSONType(value, name) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesJSONType(value interface{}, name string) bool {
+	switch name {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return jsonTypeName(value) == name
+	}
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func schemaNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// productCategories are the categories ExtractProductInfo may return.
+var productCategories = []interface{}{"phone", "tablet", "watch", "headphones", "case", "charger", "accessory", "computer", "component", "other"}
+
+var productInfoSchema = &OutputSchema{
+	Name: "product_info",
+	Schema: map[string]interface{}{
+		"type":     "object",
+		"required": []string{"manufacturer", "model", "category"},
+		"properties": map[string]interface{}{
+			"manufacturer":  map[string]interface{}{"type": "string"},
+			"model":         map[string]interface{}{"type": "string"},
+			"category":      map[string]interface{}{"type": "string", "enum": productCategories},
+			"storage":       map[string]interface{}{"type": []string{"string", "null"}},
+			"condition":     map[string]interface{}{"type": []string{"string", "null"}},
+			"shipping_cost": map[string]interface{}{"type": []string{"number", "null"}, "minimum": 0},
+			"confidence":    map[string]interface{}{"type": []string{"number", "null"}, "minimum": 0, "maximum": 100},
+		},
+	},
+}
+
+var compiledValuationSchema = &OutputSchema{
+	Name: "compiled_valuation",
+	Schema: map[string]interface{}{
+		"type":     "object",
+		"required": []string{"recommended_price"},
+		"properties": map[string]interface{}{
+			"recommended_price": map[string]interface{}{"type": "number", "minimum": 0},
+			"safety_margin":     map[string]interface{}{"type": "number", "minimum": 0, "maximum": 100},
+			"reasoning":         map[string]interface{}{"type": "string"},
+		},
+	},
+}
+
+var newPriceSchema = &OutputSchema{
+	Name: "new_price",
+	Schema: map[string]interface{}{
+		"type":     "object",
+		"required": []string{"price"},
+		"properties": map[string]interface{}{
+			"price":      map[string]interface{}{"type": "integer", "minimum": 0},
+			"confidence": map[string]interface{}{"type": "number", "minimum": 0, "maximum": 100},
+			"reasoning":  map[string]interface{}{"type": "string"},
+		},
+	},
+}
+
+// describeCategories lists the allowed categories for prompts.
+func describeCategories() string {
+	names := make([]string, len(productCategories))
+	for i, c := range productCategories {
+		names[i] = c.(string)
+	}
+	return strings.Join(names, ", ")
+}
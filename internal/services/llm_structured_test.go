@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"begbot/internal/config"
+)
+
+func TestValidateSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"valid", `{"manufacturer": "Apple", "model": "iPhone 13", "category": "phone", "storage": null, "shipping_cost": 59}`, ""},
+		{"missing field", `{"manufacturer": "Apple", "category": "phone"}`, `missing required field "model"`},
+		{"bad enum", `{"manufacturer": "Apple", "model": "iPhone 13", "category": "telefon"}`, "is not one of"},
+		{"wrong type", `{"manufacturer": "Apple", "model": 13, "category": "phone"}`, "$.model: expected string"},
+		{"below minimum", `{"manufacturer": "Apple", "model": "iPhone 13", "category": "phone", "shipping_cost": -1}`, "less than"},
+		{"not json", `{"manufacturer": "Apple", "model": "iPh`, "not valid JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out extractedProduct
+			err := decodeStructuredOutput(tt.content, productInfoSchema, &out)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("decodeStructuredOutput() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("decodeStructuredOutput() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateSchemaInteger(t *testing.T) {
+	var out map[string]interface{}
+	if err := decodeStructuredOutput(`{"price": 1500.5}`, newPriceSchema, &out); err == nil {
+		t.Error("fractional price should not validate as integer")
+	}
+	if err := decodeStructuredOutput(`{"price": 1500}`, newPriceSchema, &out); err != nil {
+		t.Errorf("decodeStructuredOutput() error = %v", err)
+	}
+}
+
+func TestExtractProductInfoRepairsInvalidOutput(t *testing.T) {
+	var requests []ChatRequest
+	client := LLMClientFunc(func(ctx context.Context, req ChatRequest) (string, error) {
+		requests = append(requests, req)
+		if len(requests) == 1 {
+			return `{"manufacturer": "Apple", "model": "iPhone 13"}`, nil
+		}
+		return "```json\n{\"manufacturer\": \"Apple\", \"model\": \"iPhone 13\", \"category\": \"phone\", \"shipping_cost\": 59}\n```", nil
+	})
+	cfg := &config.Config{LLM: config.LLMConfig{MaxTokens: map[string]int{"ExtractProductInfo": 600}}}
+	s := NewLLMServiceWithClient(cfg, client)
+
+	info, err := s.ExtractProductInfo(context.Background(), "Säljer iPhone 13", "")
+	if err != nil {
+		t.Fatalf("ExtractProductInfo() error = %v", err)
+	}
+	if info.Category != "phone" || info.ShippingCost != 59 || info.AdText != "Säljer iPhone 13" {
+		t.Errorf("ExtractProductInfo() = %+v", info)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("requests = %d, want 2", len(requests))
+	}
+	retry := requests[1]
+	if retry.MaxTokens != 600 {
+		t.Errorf("MaxTokens = %d, want 600", retry.MaxTokens)
+	}
+	if retry.Schema != productInfoSchema {
+		t.Error("request should carry the output schema")
+	}
+	if len(retry.Messages) != 3 || !strings.Contains(retry.Prompt(), `missing required field "category"`) {
+		t.Errorf("retry should feed the validation error back, got %+v", retry.Messages)
+	}
+}
+
+func TestExtractProductInfoGivesUp(t *testing.T) {
+	calls := 0
+	client := LLMClientFunc(func(ctx context.Context, req ChatRequest) (string, error) {
+		calls++
+		return "Jag vet inte", nil
+	})
+	s := NewLLMServiceWithClient(&config.Config{LLM: config.LLMConfig{MaxAttempts: 2}}, client)
+
+	_, err := s.ExtractProductInfo(context.Background(), "Säljer något", "")
+	var outputErr *LLMOutputError
+	if !errors.As(err, &outputErr) {
+		t.Fatalf("ExtractProductInfo() error = %v, want *LLMOutputError", err)
+	}
+	if outputErr.Attempts != 2 || calls != 2 || outputErr.Raw != "Jag vet inte" {
+		t.Errorf("LLMOutputError = %+v, calls = %d", outputErr, calls)
+	}
+}
+
+func TestExtractProductInfoClientError(t *testing.T) {
+	client := LLMClientFunc(func(ctx context.Context, req ChatRequest) (string, error) {
+		return "", errors.New("timeout")
+	})
+	s := NewLLMServiceWithClient(nil, client)
+
+	_, err := s.ExtractProductInfo(context.Background(), "Säljer något", "")
+	var outputErr *LLMOutputError
+	if err == nil || errors.As(err, &outputErr) {
+		t.Errorf("ExtractProductInfo() error = %v, want client error", err)
+	}
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"begbot/internal/models"
+)
+
+// ErrLLMBudgetExceeded is returned by optional LLM functions once the daily
+// budget is spent and no budget model is configured.
+var ErrLLMBudgetExceeded = errors.New("daily LLM budget exceeded")
+
+// optionalLLMFunctions are skipped when the daily budget is spent. The bot
+// works without them, only with less information.
+var optionalLLMFunctions = map[string]bool{
+	"NewPrice":          true,
+	"CompileValuations": true,
+	"AnalyzeImages":     true,
+}
+
+// budgetCheckInterval is how often the spend is re-read from the database.
+// Calls made by this process are added to it in between.
+const budgetCheckInterval = time.Minute
+
+// llmUsageStore records LLM calls. *db.Postgres implements it.
+type llmUsageStore interface {
+	SaveLLMCall(ctx context.Context, c *models.LLMCall) error
+	GetLLMSpend(ctx context.Context, since time.Time) (float64, error)
+}
+
+type llmBudget struct {
+	mu        sync.Mutex
+	day       string
+	spent     float64
+	checkedAt time.Time
+}
+
+type llmRunIDKey struct{}
+
+// WithLLMRunID returns a context whose LLM calls are recorded against a
+// scraping run.
+func WithLLMRunID(ctx context.Context, runID int64) context.Context {
+	return context.WithValue(ctx, llmRunIDKey{}, runID)
+}
+
+// LLMCallStats sums the LLM calls made with a context from
+// WithLLMCallStats. It is safe for concurrent use.
+type LLMCallStats struct {
+	mu               sync.Mutex
+	calls            int
+	promptTokens     int
+	completionTokens int
+	costUSD          float64
+	latency          time.Duration
+}
+
+type llmCallStatsKey struct{}
+
+// WithLLMCallStats returns a context whose LLM calls are added to stats.
+func WithLLMCallStats(ctx context.Context, stats *LLMCallStats) context.Context {
+	return context.WithValue(ctx, llmCallStatsKey{}, stats)
+}
+
+func (st *LLMCallStats) add(usage LLMUsage, cost float64, latency time.Duration) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.calls++
+	st.promptTokens += usage.PromptTokens
+	st.completionTokens += usage.CompletionTokens
+	st.costUSD += cost
+	st.latency += latency
+}
+
+// Calls returns the number of calls made.
+func (st *LLMCallStats) Calls() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.calls
+}
+
+// Tokens returns the prompt and completion tokens used.
+func (st *LLMCallStats) Tokens() (int, int) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.promptTokens, st.completionTokens
+}
+
+// CostUSD returns the cost of the calls.
+func (st *LLMCallStats) CostUSD() float64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.costUSD
+}
+
+// Latency returns the time spent waiting for the calls.
+func (st *LLMCallStats) Latency() time.Duration {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.latency
+}
+
+// callCost is the cost reported by the provider, or the cost from the
+// local price table when none was reported.
+func (s *LLMService) callCost(model string, usage LLMUsage) float64 {
+	if usage.CostUSD > 0 || s.cfg == nil {
+		return usage.CostUSD
+	}
+	price, ok := s.cfg.LLM.Prices[model]
+	if !ok {
+		return 0
+	}
+	return (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1e6
+}
+
+func (s *LLMService) recordCall(ctx context.Context, function, promptVersion, model string, usage LLMUsage, latency time.Duration, success bool) {
+	cost := s.callCost(model, usage)
+	if stats, ok := ctx.Value(llmCallStatsKey{}).(*LLMCallStats); ok {
+		stats.add(usage, cost, latency)
+	}
+
+	s.budget.mu.Lock()
+	if s.budget.day == budgetDay(time.Now()) {
+		s.budget.spent += cost
+	}
+	s.budget.mu.Unlock()
+
+	if s.usage == nil {
+		return
+	}
+	call := &models.LLMCall{
+		Function:         function,
+		Model:            model,
+		PromptVersion:    promptVersion,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		LatencyMs:        int(latency.Milliseconds()),
+		CostUSD:          cost,
+		Success:          success,
+	}
+	if runID, ok := ctx.Value(llmRunIDKey{}).(int64); ok && runID > 0 {
+		call.ScrapingRunID = &runID
+	}
+	// Record even when the caller's context is done, the call was made.
+	if err := s.usage.SaveLLMCall(context.WithoutCancel(ctx), call); err != nil {
+		log.Printf("Failed to record LLM call for %s: %v", function, err)
+	}
+}
+
+// OverBudget reports whether today's LLM spend has reached the daily
+// budget.
+func (s *LLMService) OverBudget(ctx context.Context) bool {
+	if s.cfg == nil || s.cfg.LLM.DailyBudgetUSD <= 0 || s.usage == nil {
+		return false
+	}
+
+	s.budget.mu.Lock()
+	defer s.budget.mu.Unlock()
+
+	now := time.Now()
+	today := budgetDay(now)
+	if s.budget.day != today || now.Sub(s.budget.checkedAt) >= budgetCheckInterval {
+		y, m, d := now.Date()
+		spent, err := s.usage.GetLLMSpend(ctx, time.Date(y, m, d, 0, 0, 0, 0, now.Location()))
+		if err != nil {
+			log.Printf("Failed to read LLM spend: %v", err)
+		} else {
+			s.budget.day = today
+			s.budget.spent = spent
+			s.budget.checkedAt = now
+		}
+	}
+	return s.budget.spent >= s.cfg.LLM.DailyBudgetUSD
+}
+
+// applyBudget switches req to the budget model once the daily budget is
+// spent. Without a budget model optional functions are refused instead.
+func (s *LLMService) applyBudget(ctx context.Context, function string, req *ChatRequest) error {
+	if !s.OverBudget(ctx) {
+		return nil
+	}
+	if s.cfg.LLM.BudgetModel != "" {
+		req.Model = s.cfg.LLM.BudgetModel
+		return nil
+	}
+	if optionalLLMFunctions[function] {
+		return ErrLLMBudgetExceeded
+	}
+	return nil
+}
+
+func budgetDay(t time.Time) string {
+	return t.Format("2006-01-02")
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"begbot/internal/config"
+	"begbot/internal/models"
+)
+
+type memoryLLMUsage struct {
+	calls []*models.LLMCall
+	spent float64
+}
+
+func (m *memoryLLMUsage) SaveLLMCall(ctx context.Context, c *models.LLMCall) error {
+	m.calls = append(m.calls, c)
+	return nil
+}
+
+func (m *memoryLLMUsage) GetLLMSpend(ctx context.Context, since time.Time) (float64, error) {
+	return m.spent, nil
+}
+
+type usageClient struct {
+	usage LLMUsage
+	model string
+}
+
+func (c *usageClient) Chat(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	c.model = req.Model
+	return ChatResponse{Content: `{"price": 1500, "confidence": 80}`, Usage: c.usage}, nil
+}
+
+func TestLLMCallCost(t *testing.T) {
+	cfg := &config.Config{LLM: config.LLMConfig{Prices: map[string]config.LLMPrice{
+		"cheap": {Prompt: 0.5, Completion: 2},
+	}}}
+	s := NewLLMServiceWithClient(cfg, nil)
+
+	if got := s.callCost("cheap", LLMUsage{PromptTokens: 1000, CompletionTokens: 500}); math.Abs(got-0.0015) > 1e-9 {
+		t.Errorf("callCost() from price table = %v, want 0.0015", got)
+	}
+	if got := s.callCost("cheap", LLMUsage{PromptTokens: 1000, CostUSD: 0.01}); got != 0.01 {
+		t.Errorf("callCost() should prefer reported cost, got %v", got)
+	}
+	if got := s.callCost("unknown", LLMUsage{PromptTokens: 1000}); got != 0 {
+		t.Errorf("callCost() for unknown model = %v, want 0", got)
+	}
+}
+
+func TestLLMCallsAreRecorded(t *testing.T) {
+	client := &usageClient{usage: LLMUsage{PromptTokens: 120, CompletionTokens: 30, CostUSD: 0.002}}
+	s := NewLLMServiceWithClient(&config.Config{LLM: config.LLMConfig{DefaultModel: "model-a"}}, client)
+	store := &memoryLLMUsage{}
+	s.usage = store
+
+	var out map[string]interface{}
+	ctx := WithLLMRunID(context.Background(), 42)
+	if _, err := s.chatJSON(ctx, "NewPrice", textPrompt("Vad kostar en iPhone 13?"), newPriceSchema, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.calls) != 1 {
+		t.Fatalf("recorded calls = %d, want 1", len(store.calls))
+	}
+	call := store.calls[0]
+	if call.Function != "NewPrice" || call.Model != "model-a" || call.PromptTokens != 120 || call.CompletionTokens != 30 || call.CostUSD != 0.002 || !call.Success {
+		t.Errorf("call = %+v", call)
+	}
+	if call.PromptVersion != "test@v1" {
+		t.Errorf("PromptVersion = %q, want test@v1", call.PromptVersion)
+	}
+	if call.ScrapingRunID == nil || *call.ScrapingRunID != 42 {
+		t.Errorf("ScrapingRunID = %v, want 42", call.ScrapingRunID)
+	}
+}
+
+func TestLLMBudget(t *testing.T) {
+	client := &usageClient{}
+	cfg := &config.Config{LLM: config.LLMConfig{DefaultModel: "model-a", DailyBudgetUSD: 1}}
+	s := NewLLMServiceWithClient(cfg, client)
+	store := &memoryLLMUsage{spent: 0.5}
+	s.usage = store
+
+	var out map[string]interface{}
+	if _, err := s.chatJSON(context.Background(), "NewPrice", textPrompt("p"), newPriceSchema, &out); err != nil {
+		t.Fatalf("under budget: %v", err)
+	}
+
+	store.spent = 1.5
+	s.budget.checkedAt = time.Time{}
+	if _, err := s.chatJSON(context.Background(), "NewPrice", textPrompt("p"), newPriceSchema, &out); !errors.Is(err, ErrLLMBudgetExceeded) {
+		t.Errorf("optional function over budget: err = %v, want ErrLLMBudgetExceeded", err)
+	}
+	if _, err := s.chat(context.Background(), "ExtractProductInfo", textPrompt("p")); err != nil {
+		t.Errorf("required function over budget: %v", err)
+	}
+	if client.model != "model-a" {
+		t.Errorf("model = %q, want model-a", client.model)
+	}
+
+	cfg.LLM.BudgetModel = "cheap"
+	if _, err := s.chatJSON(context.Background(), "NewPrice", textPrompt("p"), newPriceSchema, &out); err != nil {
+		t.Fatalf("with budget model: %v", err)
+	}
+	if client.model != "cheap" {
+		t.Errorf("model = %q, want the budget model", client.model)
+	}
+}
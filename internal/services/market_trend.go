@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"begbot/internal/models"
+)
+
+const (
+	DefaultTrendWindowDays      = 90
+	DefaultMaxDailyDepreciation = 0.01
+	minTrendPoints              = 3
+	minTrendSpanDays            = 7
+)
+
+// observationWeights sets how much each kind of market observation counts
+// towards the daily market value. Realized sales are the best evidence,
+// asking prices the weakest.
+var observationWeights = map[string]float64{
+	"sale":      1.0,
+	"valuation": 0.7,
+	"ask":       0.4,
+}
+
+// MarketPoint is the compiled market value of a product on one day.
+type MarketPoint struct {
+	Date    time.Time `json:"date"`
+	Value   float64   `json:"value"`
+	Samples int       `json:"samples"`
+	weight  float64
+}
+
+// DepreciationModel is an exponential decay fitted to a product's market
+// series: value(t) = value(0) * exp(-DailyRate * t).
+type DepreciationModel struct {
+	DailyRate float64 `json:"daily_rate"`
+	Points    int     `json:"points"`
+	SpanDays  int     `json:"span_days"`
+}
+
+// Forecast returns the value days ahead. A nil model forecasts no change.
+func (m *DepreciationModel) Forecast(value float64, days int) float64 {
+	if m == nil || days <= 0 {
+		return value
+	}
+	return value * math.Exp(-m.DailyRate*float64(days))
+}
+
+// MarketTrend is the market series of a product with its fitted model.
+type MarketTrend struct {
+	ProductID int64              `json:"product_id"`
+	Series    []MarketPoint      `json:"series"`
+	Model     *DepreciationModel `json:"model,omitempty"`
+}
+
+// BuildMarketSeries compiles observations into one weighted average value
+// per day, oldest first.
+func BuildMarketSeries(observations []models.MarketObservation) []MarketPoint {
+	byDay := make(map[time.Time]*MarketPoint)
+	for _, o := range observations {
+		w, ok := observationWeights[o.Source]
+		if !ok || o.Price <= 0 {
+			continue
+		}
+		day := o.ObservedAt.UTC().Truncate(24 * time.Hour)
+		p, exists := byDay[day]
+		if !exists {
+			p = &MarketPoint{Date: day}
+			byDay[day] = p
+		}
+		p.Value += float64(o.Price) * w
+		p.weight += w
+		p.Samples++
+	}
+
+	series := make([]MarketPoint, 0, len(byDay))
+	for _, p := range byDay {
+		p.Value /= p.weight
+		series = append(series, *p)
+	}
+	sort.Slice(series, func(i, j int) bool {
+		return series[i].Date.Before(series[j].Date)
+	})
+	return series
+}
+
+// FitDepreciation fits an exponential decay to the series with weighted
+// least squares on log values. Nil is returned when the series is too short
+// to say anything. Rising markets are treated as flat and the rate is capped
+// at maxDailyRate.
+func FitDepreciation(series []MarketPoint, maxDailyRate float64) *DepreciationModel {
+	if len(series) < minTrendPoints {
+		return nil
+	}
+	first := series[0].Date
+	span := int(series[len(series)-1].Date.Sub(first).Hours() / 24)
+	if span < minTrendSpanDays {
+		return nil
+	}
+
+	var sumW, sumX, sumY, sumXY, sumX2 float64
+	for _, p := range series {
+		if p.Value <= 0 {
+			continue
+		}
+		w := p.weight
+		if w <= 0 {
+			w = 1
+		}
+		x := p.Date.Sub(first).Hours() / 24
+		y := math.Log(p.Value)
+		sumW += w
+		sumX += w * x
+		sumY += w * y
+		sumXY += w * x * y
+		sumX2 += w * x * x
+	}
+	denominator := sumW*sumX2 - sumX*sumX
+	if sumW == 0 || denominator == 0 {
+		return nil
+	}
+	slope := (sumW*sumXY - sumX*sumY) / denominator
+
+	rate := math.Max(0, -slope)
+	if maxDailyRate > 0 {
+		rate = math.Min(rate, maxDailyRate)
+	}
+	return &DepreciationModel{DailyRate: rate, Points: len(series), SpanDays: span}
+}
+
+// MarketTrend builds the market series of a product over the configured
+// window and fits its depreciation model.
+func (s *ValuationService) MarketTrend(ctx context.Context, productID int64) (*MarketTrend, error) {
+	windowDays := DefaultTrendWindowDays
+	maxRate := DefaultMaxDailyDepreciation
+	if s.cfg != nil {
+		if s.cfg.Valuation.TrendWindowDays > 0 {
+			windowDays = s.cfg.Valuation.TrendWindowDays
+		}
+		if s.cfg.Valuation.MaxDailyDepreciation > 0 {
+			maxRate = s.cfg.Valuation.MaxDailyDepreciation
+		}
+	}
+
+	since := time.Now().AddDate(0, 0, -windowDays)
+	observations, err := s.database.GetMarketObservations(ctx, productID, since)
+	if err != nil {
+		return nil, err
+	}
+
+	series := BuildMarketSeries(observations)
+	return &MarketTrend{
+		ProductID: productID,
+		Series:    series,
+		Model:     FitDepreciation(series, maxRate),
+	}, nil
+}
+
+// ForecastValue returns what value is expected to be worth days from now
+// given the product's depreciation. Without enough market data the value is
+// returned unchanged.
+func (s *ValuationService) ForecastValue(ctx context.Context, productID int64, value float64, days int) float64 {
+	if s.database == nil {
+		return value
+	}
+	trend, err := s.MarketTrend(ctx, productID)
+	if err != nil {
+		return value
+	}
+	return trend.Model.Forecast(value, days)
+}
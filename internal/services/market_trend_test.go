@@ -0,0 +1,82 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"begbot/internal/models"
+)
+
+func TestBuildMarketSeries(t *testing.T) {
+	day := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	observations := []models.MarketObservation{
+		{ObservedAt: day, Price: 1000, Source: "sale"},
+		{ObservedAt: day.Add(2 * time.Hour), Price: 1400, Source: "ask"},
+		{ObservedAt: day.AddDate(0, 0, 1), Price: 900, Source: "valuation"},
+		{ObservedAt: day, Price: 5000, Source: "unknown"},
+	}
+
+	series := BuildMarketSeries(observations)
+
+	if len(series) != 2 {
+		t.Fatalf("Expected 2 days, got %d", len(series))
+	}
+	want := (1000*1.0 + 1400*0.4) / 1.4
+	if math.Abs(series[0].Value-want) > 0.001 || series[0].Samples != 2 {
+		t.Errorf("Expected first day %.2f from 2 samples, got %.2f from %d", want, series[0].Value, series[0].Samples)
+	}
+	if math.Abs(series[1].Value-900) > 0.001 {
+		t.Errorf("Expected second day 900, got %.2f", series[1].Value)
+	}
+}
+
+func TestFitDepreciation(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rate := 0.005
+	var series []MarketPoint
+	for d := 0; d <= 30; d += 5 {
+		series = append(series, MarketPoint{
+			Date:   start.AddDate(0, 0, d),
+			Value:  2000 * math.Exp(-rate*float64(d)),
+			weight: 1,
+		})
+	}
+
+	model := FitDepreciation(series, 0.05)
+	if model == nil {
+		t.Fatal("Expected a model")
+	}
+	if math.Abs(model.DailyRate-rate) > 1e-6 {
+		t.Errorf("Expected daily rate %f, got %f", rate, model.DailyRate)
+	}
+	if got, want := model.Forecast(1000, 14), 1000*math.Exp(-rate*14); math.Abs(got-want) > 0.01 {
+		t.Errorf("Expected forecast %.2f, got %.2f", want, got)
+	}
+
+	capped := FitDepreciation(series, 0.001)
+	if capped.DailyRate != 0.001 {
+		t.Errorf("Expected rate capped at 0.001, got %f", capped.DailyRate)
+	}
+}
+
+func TestFitDepreciation_RisingOrShortSeries(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rising := []MarketPoint{
+		{Date: start, Value: 1000, weight: 1},
+		{Date: start.AddDate(0, 0, 10), Value: 1100, weight: 1},
+		{Date: start.AddDate(0, 0, 20), Value: 1200, weight: 1},
+	}
+	if model := FitDepreciation(rising, 0.01); model == nil || model.DailyRate != 0 {
+		t.Errorf("Expected flat model for rising market, got %+v", model)
+	}
+
+	if model := FitDepreciation(rising[:2], 0.01); model != nil {
+		t.Errorf("Expected nil model for too few points, got %+v", model)
+	}
+
+	var nilModel *DepreciationModel
+	if got := nilModel.Forecast(1000, 30); got != 1000 {
+		t.Errorf("Expected nil model to forecast no change, got %.2f", got)
+	}
+}
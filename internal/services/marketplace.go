@@ -29,14 +29,18 @@ func NewMarketplaceService(cfg *config.Config) *MarketplaceService {
 }
 
 type RawAd struct {
-	Link         string
-	Title        string
-	Price        float64
-	AdText       string
-	ImageURLs    []string
-	AdDate       time.Time
-	Marketplace  string
-	ShippingCost *float64 // NULL if unknown, 0 if free, positive value if specified
+	Link         string    `json:"link"`
+	Title        string    `json:"title"`
+	Price        float64   `json:"price"`
+	AdText       string    `json:"ad_text"`
+	ImageURLs    []string  `json:"image_urls,omitempty"`
+	AdDate       time.Time `json:"ad_date"`
+	Marketplace  string    `json:"marketplace"`
+	ShippingCost *float64  `json:"shipping_cost"` // NULL if unknown, 0 if free, positive value if specified
+	// SearchTermID is the search term the ad was found with, 0 if unknown.
+	SearchTermID int64 `json:"search_term_id,omitempty"`
+	// MarketplaceID is the marketplace the ad was found on, 0 if unknown.
+	MarketplaceID int64 `json:"marketplace_id,omitempty"`
 }
 
 // FetchAdDetails fetches detailed information from an individual ad page
@@ -275,6 +279,7 @@ func (s *MarketplaceService) fetchBlocketAdsFromURL(ctx context.Context, searchU
 			apiAd, err := s.fetchBlocketAdFromAPI(ctx, adID)
 			if err == nil && apiAd != nil {
 				ads[i].AdText = apiAd.AdText
+				ads[i].ImageURLs = apiAd.Images
 			}
 		}
 	}
@@ -33,90 +33,28 @@ type MessageGenerationInput struct {
 }
 
 func (s *MessagingService) GenerateMessage(ctx context.Context, input MessageGenerationInput) (string, error) {
-	var prompt string
+	content, _, err := s.generateMessage(ctx, input)
+	return content, err
+}
 
+// generateMessage also returns the ID of the prompt template version used.
+func (s *MessagingService) generateMessage(ctx context.Context, input MessageGenerationInput) (string, string, error) {
+	name := PromptMessageReply
 	if input.MessageType == "initial" {
-		prompt = s.buildInitialMessagePrompt(input)
-	} else {
-		prompt = s.buildReplyMessagePrompt(input)
+		name = PromptMessageInitial
 	}
 
-	model := s.llmService.client.GetModel("GenerateMessage", s.llmService.defaultModel, s.llmService.models)
-
-	content, err := s.llmService.client.Chat(ctx, model, prompt)
+	prompt, err := s.llmService.prompts.Render(ctx, name, input)
 	if err != nil {
-		return "", fmt.Errorf("LLM API error: %w", err)
+		return "", "", err
 	}
 
-	return content, nil
-}
-
-func (s *MessagingService) buildInitialMessagePrompt(input MessageGenerationInput) string {
-	prompt := fmt.Sprintf(`Du är en hjälpsam köpare som är intresserad av att köpa en vara på en svensk marknadsplats.
-
-Annonsdetaljer:
-- Titel: %s
-- Pris: %d kr
-- Beskrivning: %s
-
-Din värdering: %d kr (vad du är villig att betala)
-
-Skriv ett kort, vänligt och naturligt meddelande på svenska för att visa intresse för varan. 
-Meddelandet ska:
-- Vara naturligt och personligt
-- Inte nämna din maxpris direkt i första meddelandet
-- Vara kortfattat (max 2-3 meningar)
-- Fråga om varan fortfarande är till salu
-- Eventuellt ställa en relevant fråga om skick eller användning
-
-Returnera ENDAST meddelandet, ingen extra text eller förklaring.`,
-		input.ListingTitle,
-		input.ListingPrice,
-		input.ListingDescription,
-		input.Valuation,
-	)
-	return prompt
-}
-
-func (s *MessagingService) buildReplyMessagePrompt(input MessageGenerationInput) string {
-	conversationHistory := ""
-	for _, msg := range input.ConversationHistory {
-		sender := "Säljare"
-		if msg.Direction == "outgoing" {
-			sender = "Du"
-		}
-		conversationHistory += fmt.Sprintf("%s: %s\n", sender, msg.Content)
+	content, err := s.llmService.chat(ctx, "GenerateMessage", prompt)
+	if err != nil {
+		return "", "", fmt.Errorf("LLM API error: %w", err)
 	}
 
-	prompt := fmt.Sprintf(`Du är en köpare som förhandlar om att köpa en vara på en svensk marknadsplats.
-
-Annonsdetaljer:
-- Titel: %s
-- Pris: %d kr
-- Beskrivning: %s
-
-Din värdering: %d kr (maxpris du är villig att betala)
-
-Konversationshistorik:
-%s
-
-Baserat på konversationen ovan, skriv ett lämpligt svar på svenska.
-Meddelandet ska:
-- Vara naturligt och personligt
-- Fortsätta förhandlingen på ett artigt sätt
-- Inte överskrida din värdering (%d kr)
-- Vara kortfattat (max 2-3 meningar)
-- Anpassa dig till tonaliteten i konversationen
-
-Returnera ENDAST meddelandet, ingen extra text eller förklaring.`,
-		input.ListingTitle,
-		input.ListingPrice,
-		input.ListingDescription,
-		input.Valuation,
-		conversationHistory,
-		input.Valuation,
-	)
-	return prompt
+	return content, prompt.ID(), nil
 }
 
 func (s *MessagingService) CreateConversation(ctx context.Context, listingID, marketplaceID int64) (*models.Conversation, error) {
@@ -177,7 +115,7 @@ func (s *MessagingService) GenerateInitialMessage(ctx context.Context, listingID
 		MessageType:        "initial",
 	}
 
-	content, err := s.GenerateMessage(ctx, input)
+	content, promptVersion, err := s.generateMessage(ctx, input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate message: %w", err)
 	}
@@ -188,6 +126,7 @@ func (s *MessagingService) GenerateInitialMessage(ctx context.Context, listingID
 		Direction:      "outgoing",
 		Content:        content,
 		Status:         "pending",
+		PromptVersion:  &promptVersion,
 	}
 
 	if err := s.db.CreateMessage(ctx, msg); err != nil {
@@ -242,7 +181,7 @@ func (s *MessagingService) GenerateReplyMessage(ctx context.Context, conversatio
 		MessageType:         "reply",
 	}
 
-	content, err := s.GenerateMessage(ctx, input)
+	content, promptVersion, err := s.generateMessage(ctx, input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate message: %w", err)
 	}
@@ -253,6 +192,7 @@ func (s *MessagingService) GenerateReplyMessage(ctx context.Context, conversatio
 		Direction:      "outgoing",
 		Content:        content,
 		Status:         "pending",
+		PromptVersion:  &promptVersion,
 	}
 
 	if err := s.db.CreateMessage(ctx, msg); err != nil {
@@ -14,22 +14,53 @@ import (
 )
 
 type OpenRouterClient struct {
-	apiKey   string
-	siteURL  string
-	siteName string
-	baseURL  string
+	apiKey           string
+	siteURL          string
+	siteName         string
+	baseURL          string
+	structuredOutput bool
 }
 
+// openRouterRequest is an OpenAI-style chat completion request.
 type openRouterRequest struct {
-	Model       string              `json:"model"`
-	Messages    []openRouterMessage `json:"messages"`
-	MaxTokens   int                 `json:"max_tokens,omitempty"`
-	Temperature float64             `json:"temperature,omitempty"`
+	Model          string          `json:"model"`
+	Messages       []ChatMessage   `json:"messages"`
+	MaxTokens      int             `json:"max_tokens,omitempty"`
+	Temperature    float64         `json:"temperature,omitempty"`
+	ResponseFormat *responseFormat `json:"response_format,omitempty"`
+	Usage          *usageOption    `json:"usage,omitempty"`
 }
 
-type openRouterMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+// usageOption asks OpenRouter to include the cost in the usage field.
+type usageOption struct {
+	Include bool `json:"include"`
+}
+
+type responseFormat struct {
+	Type       string          `json:"type"`
+	JSONSchema *responseSchema `json:"json_schema,omitempty"`
+}
+
+type responseSchema struct {
+	Name   string                 `json:"name"`
+	Strict bool                   `json:"strict"`
+	Schema map[string]interface{} `json:"schema"`
+}
+
+func newChatCompletionRequest(req ChatRequest, structuredOutput bool) openRouterRequest {
+	body := openRouterRequest{
+		Model:       req.Model,
+		Messages:    req.Messages,
+		MaxTokens:   req.MaxTokens,
+		Temperature: 0.1,
+	}
+	if structuredOutput && req.Schema != nil {
+		body.ResponseFormat = &responseFormat{
+			Type:       "json_schema",
+			JSONSchema: &responseSchema{Name: req.Schema.Name, Schema: req.Schema.Schema},
+		}
+	}
+	return body
 }
 
 type openRouterResponse struct {
@@ -38,38 +69,52 @@ type openRouterResponse struct {
 			Content string `json:"content"`
 		} `json:"message"`
 	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int     `json:"prompt_tokens"`
+		CompletionTokens int     `json:"completion_tokens"`
+		Cost             float64 `json:"cost"`
+	} `json:"usage"`
 	Error *struct {
 		Message string `json:"message"`
 	} `json:"error"`
 }
 
+func (r openRouterResponse) chatResponse() ChatResponse {
+	resp := ChatResponse{Content: r.Choices[0].Message.Content}
+	if r.Usage != nil {
+		resp.Usage = LLMUsage{
+			PromptTokens:     r.Usage.PromptTokens,
+			CompletionTokens: r.Usage.CompletionTokens,
+			CostUSD:          r.Usage.Cost,
+		}
+	}
+	return resp
+}
+
 func NewOpenRouterClient(apiKey, siteURL, siteName string) *OpenRouterClient {
 	return &OpenRouterClient{
 		apiKey:   apiKey,
 		siteURL:  siteURL,
 		siteName: siteName,
 		baseURL:  "https://openrouter.ai/api/v1/chat/completions",
+
+		structuredOutput: true,
 	}
 }
 
-func (c *OpenRouterClient) Chat(ctx context.Context, model, prompt string) (string, error) {
-	reqBody := openRouterRequest{
-		Model: model,
-		Messages: []openRouterMessage{
-			{Role: "user", Content: prompt},
-		},
-		MaxTokens:   200,
-		Temperature: 0.1,
-	}
+func (c *OpenRouterClient) Chat(ctx context.Context, chatReq ChatRequest) (ChatResponse, error) {
+	model := chatReq.Model
+	reqBody := newChatCompletionRequest(chatReq, c.structuredOutput)
+	reqBody.Usage = &usageOption{Include: true}
 
 	body, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return ChatResponse{}, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL, bytes.NewReader(body))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return ChatResponse{}, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -89,7 +134,7 @@ func (c *OpenRouterClient) Chat(ctx context.Context, model, prompt string) (stri
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("request failed: %w", err)
+		return ChatResponse{}, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -99,28 +144,29 @@ func (c *OpenRouterClient) Chat(ctx context.Context, model, prompt string) (stri
 			respBody = respBody[:500]
 		}
 		log.Printf("OpenRouter error: %s", string(respBody))
-		return "", fmt.Errorf("API request failed with status %d", resp.StatusCode)
+		return ChatResponse{}, newLLMStatusError(resp, nil)
 	}
 
 	respBody, _ := io.ReadAll(resp.Body)
 
 	var result openRouterResponse
 	if err := json.NewDecoder(bytes.NewReader(respBody)).Decode(&result); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+		return ChatResponse{}, fmt.Errorf("failed to decode response: %w", err)
 	}
 
 	if result.Error != nil {
-		return "", fmt.Errorf("OpenRouter error: %s", result.Error.Message)
+		return ChatResponse{}, fmt.Errorf("OpenRouter error: %s", result.Error.Message)
 	}
 
 	if len(result.Choices) == 0 {
-		return "", fmt.Errorf("no response from OpenRouter")
+		return ChatResponse{}, fmt.Errorf("no response from OpenRouter")
 	}
 
-	return result.Choices[0].Message.Content, nil
+	return result.chatResponse(), nil
 }
 
-func (c *OpenRouterClient) GetModel(functionName string, defaultModel string, models map[string]string) string {
+// GetModel returns the model configured for functionName, or defaultModel.
+func GetModel(functionName string, defaultModel string, models map[string]string) string {
 	if model, ok := models[functionName]; ok && model != "" {
 		return model
 	}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+
+	"begbot/internal/db"
+	"begbot/internal/models"
+)
+
+// PotentialListings returns the stored listings whose trading rule verdict
+// passes, with the figures of the verdict, ranked by db.RankByExposure.
+// Listings are checked exactly as the bot checks new ones, from the
+// valuation and image analysis saved with them. Rule sets, exposure, FX
+// rates, products and market trends are loaded once for all of them.
+func (s *BotService) PotentialListings(ctx context.Context) ([]db.ListingWithProfit, error) {
+	listings, err := s.database.GetListingsWithProfit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	env := s.newTradingRuleEnv(ctx)
+	for _, l := range listings {
+		if l.Product != nil {
+			env.products[l.Product.ID] = l.Product
+		}
+	}
+
+	result := make([]db.ListingWithProfit, 0, len(listings))
+	for _, l := range listings {
+		if l.Listing.Price == nil || l.ComputedValuation <= 0 {
+			continue
+		}
+		verdict := s.evaluateTradingRules(ctx, env, &l.Listing, s.storedValuationOutput(ctx, l.Listing.ID), s.storedImageAnalysis(ctx, l.Listing.ID))
+		if !verdict.Passed {
+			continue
+		}
+		l.ComputedValuation = verdict.Valuation
+		l.PotentialProfit = verdict.Profit
+		l.DiscountPercent = verdict.DiscountPercent
+		l.Profit = verdict.Costs
+		if verdict.Supply != nil {
+			l.Supply = verdict.Supply
+		}
+		l.MatchedRule = models.SelectTradingRule(env.ruleSets, db.TradingRuleScopeFor(&l.Listing, l.Product))
+		if l.Listing.OpportunityScore == nil && verdict.Score != nil {
+			l.Listing.OpportunityScore = &verdict.Score.Score
+		}
+		result = append(result, l)
+	}
+	if err := s.database.RankByExposure(ctx, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,342 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"begbot/internal/models"
+)
+
+// Prompt template names.
+const (
+	PromptExtractProductInfo = "extract_product_info"
+	PromptCompileValuations  = "compile_valuations"
+	PromptNewPrice           = "new_price"
+	PromptMessageInitial     = "message_initial"
+	PromptMessageReply       = "message_reply"
+	PromptImageAnalysis      = "image_analysis"
+	PromptPreFilter          = "prefilter"
+)
+
+// embeddedPrompts are the prompt templates shipped with the binary, named
+// <name>.v<version>.tmpl.
+//
+//go:embed prompts/*.tmpl
+var embeddedPrompts embed.FS
+
+var promptFileName = regexp.MustCompile(`^([a-z0-9_]+)\.v([0-9]+)\.tmpl$`)
+
+// promptRefreshInterval is how often templates and active versions are
+// re-read from the database, so a new version or a rollback is picked up
+// without a restart.
+const promptRefreshInterval = time.Minute
+
+// promptStore persists prompt template versions. *db.Postgres implements it.
+type promptStore interface {
+	GetPromptTemplates(ctx context.Context) ([]models.PromptTemplate, map[string]int, error)
+	SavePromptTemplate(ctx context.Context, t *models.PromptTemplate) error
+	SetActivePromptVersion(ctx context.Context, name string, version int) error
+}
+
+// RenderedPrompt is a prompt rendered from a template version. Images are
+// sent along with the text.
+type RenderedPrompt struct {
+	Name    string   `json:"name"`
+	Version int      `json:"version"`
+	Text    string   `json:"text"`
+	Images  []string `json:"images,omitempty"`
+}
+
+// ID identifies the template version, e.g. new_price@v2. It is recorded
+// with every LLM output.
+func (p RenderedPrompt) ID() string {
+	if p.Name == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s@v%d", p.Name, p.Version)
+}
+
+// PromptRegistry holds every version of the prompt templates. Templates are
+// embedded in the binary, may be overridden or extended from a directory,
+// and new versions can be stored in the database. Unless another version is
+// activated, the latest file version is used.
+type PromptRegistry struct {
+	mu       sync.Mutex
+	files    map[string]map[int]models.PromptTemplate
+	stored   map[string]map[int]models.PromptTemplate
+	active   map[string]int
+	parsed   map[string]*template.Template
+	store    promptStore
+	loadedAt time.Time
+}
+
+// NewPromptRegistry loads the embedded templates and then those in dir, if
+// set. A file in dir replaces the embedded template with the same name and
+// version.
+func NewPromptRegistry(dir string) (*PromptRegistry, error) {
+	r := &PromptRegistry{
+		files:  make(map[string]map[int]models.PromptTemplate),
+		stored: make(map[string]map[int]models.PromptTemplate),
+		active: make(map[string]int),
+		parsed: make(map[string]*template.Template),
+	}
+	sub, err := fs.Sub(embeddedPrompts, "prompts")
+	if err != nil {
+		return nil, err
+	}
+	if err := r.loadFiles(sub, "embedded"); err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if err := r.loadFiles(os.DirFS(dir), "file"); err != nil {
+			return nil, fmt.Errorf("failed to load prompts from %s: %w", dir, err)
+		}
+	}
+	return r, nil
+}
+
+func (r *PromptRegistry) loadFiles(fsys fs.FS, source string) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		m := promptFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || m == nil {
+			continue
+		}
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return err
+		}
+		version, _ := strconv.Atoi(m[2])
+		t := models.PromptTemplate{
+			Name:    m[1],
+			Version: version,
+			Body:    strings.TrimSuffix(string(body), "\n"),
+			Source:  source,
+		}
+		if _, err := parsePrompt(t); err != nil {
+			return fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		if r.files[t.Name] == nil {
+			r.files[t.Name] = make(map[int]models.PromptTemplate)
+		}
+		r.files[t.Name][version] = t
+	}
+	return nil
+}
+
+// UseStore reads template versions and the active selection from store.
+func (r *PromptRegistry) UseStore(store promptStore) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.store = store
+	r.loadedAt = time.Time{}
+}
+
+// refresh re-reads the store when the last read is older than the refresh
+// interval. The caller holds r.mu. Failures are logged and the previous
+// state kept.
+func (r *PromptRegistry) refresh(ctx context.Context, force bool) {
+	if r.store == nil || (!force && time.Since(r.loadedAt) < promptRefreshInterval) {
+		return
+	}
+	templates, active, err := r.store.GetPromptTemplates(ctx)
+	if err != nil {
+		log.Printf("Failed to load prompt templates: %v", err)
+		return
+	}
+	stored := make(map[string]map[int]models.PromptTemplate)
+	for _, t := range templates {
+		if stored[t.Name] == nil {
+			stored[t.Name] = make(map[int]models.PromptTemplate)
+		}
+		stored[t.Name][t.Version] = t
+	}
+	r.stored = stored
+	r.active = active
+	r.loadedAt = time.Now()
+}
+
+// lookup returns the template version, or the current one when version is
+// zero. The caller holds r.mu.
+func (r *PromptRegistry) lookup(name string, version int) (models.PromptTemplate, error) {
+	if version == 0 {
+		version = r.active[name]
+	}
+	if version == 0 {
+		for v := range r.files[name] {
+			if v > version {
+				version = v
+			}
+		}
+	}
+	if t, ok := r.stored[name][version]; ok {
+		return t, nil
+	}
+	if t, ok := r.files[name][version]; ok {
+		return t, nil
+	}
+	return models.PromptTemplate{}, fmt.Errorf("unknown prompt template %s@v%d", name, version)
+}
+
+// Render renders the current version of the named template with data.
+func (r *PromptRegistry) Render(ctx context.Context, name string, data interface{}) (RenderedPrompt, error) {
+	return r.RenderVersion(ctx, name, 0, data)
+}
+
+// RenderVersion renders a given version of the named template, or the
+// current one when version is zero.
+func (r *PromptRegistry) RenderVersion(ctx context.Context, name string, version int, data interface{}) (RenderedPrompt, error) {
+	r.mu.Lock()
+	r.refresh(ctx, false)
+	t, err := r.lookup(name, version)
+	if err != nil {
+		r.mu.Unlock()
+		return RenderedPrompt{}, err
+	}
+	key := t.Source + ":" + RenderedPrompt{Name: t.Name, Version: t.Version}.ID()
+	tmpl, ok := r.parsed[key]
+	if !ok {
+		tmpl, err = parsePrompt(t)
+		if err != nil {
+			r.mu.Unlock()
+			return RenderedPrompt{}, err
+		}
+		r.parsed[key] = tmpl
+	}
+	r.mu.Unlock()
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return RenderedPrompt{}, fmt.Errorf("failed to render prompt %s@v%d: %w", t.Name, t.Version, err)
+	}
+	return RenderedPrompt{Name: t.Name, Version: t.Version, Text: buf.String()}, nil
+}
+
+// List returns every template version, marking the ones in use.
+func (r *PromptRegistry) List(ctx context.Context) []models.PromptTemplate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refresh(ctx, false)
+
+	var list []models.PromptTemplate
+	current := make(map[string]int)
+	for _, versions := range []map[string]map[int]models.PromptTemplate{r.files, r.stored} {
+		for name, byVersion := range versions {
+			for _, t := range byVersion {
+				list = append(list, t)
+			}
+			if _, ok := current[name]; !ok {
+				if t, err := r.lookup(name, 0); err == nil {
+					current[name] = t.Version
+				}
+			}
+		}
+	}
+	for i := range list {
+		list[i].Active = current[list[i].Name] == list[i].Version
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Name != list[j].Name {
+			return list[i].Name < list[j].Name
+		}
+		return list[i].Version < list[j].Version
+	})
+	return list
+}
+
+// Create stores body as the next version of the named template. It is not
+// used until activated.
+func (r *PromptRegistry) Create(ctx context.Context, name, body string) (*models.PromptTemplate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.store == nil {
+		return nil, fmt.Errorf("no prompt template store configured")
+	}
+	r.refresh(ctx, true)
+
+	t := &models.PromptTemplate{Name: name, Body: body, Source: "db"}
+	for _, versions := range []map[int]models.PromptTemplate{r.files[name], r.stored[name]} {
+		for v := range versions {
+			if v > t.Version {
+				t.Version = v
+			}
+		}
+	}
+	if t.Version == 0 {
+		return nil, fmt.Errorf("unknown prompt template %s", name)
+	}
+	t.Version++
+	if _, err := parsePrompt(*t); err != nil {
+		return nil, err
+	}
+	if err := r.store.SavePromptTemplate(ctx, t); err != nil {
+		return nil, err
+	}
+	r.loadedAt = time.Time{}
+	return t, nil
+}
+
+// Activate selects the version of the named template to use, which is also
+// how a change is rolled back. Version zero returns to the latest file
+// version.
+func (r *PromptRegistry) Activate(ctx context.Context, name string, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.store == nil {
+		return fmt.Errorf("no prompt template store configured")
+	}
+	r.refresh(ctx, true)
+	if _, ok := r.files[name]; !ok {
+		if _, ok := r.stored[name]; !ok {
+			return fmt.Errorf("unknown prompt template %s", name)
+		}
+	}
+	if version != 0 {
+		if _, err := r.lookup(name, version); err != nil {
+			return err
+		}
+	}
+	if err := r.store.SetActivePromptVersion(ctx, name, version); err != nil {
+		return err
+	}
+	r.loadedAt = time.Time{}
+	return nil
+}
+
+func parsePrompt(t models.PromptTemplate) (*template.Template, error) {
+	tmpl, err := template.New(t.Name).Option("missingkey=error").Parse(t.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prompt template %s@v%d: %w", t.Name, t.Version, err)
+	}
+	return tmpl, nil
+}
+
+// defaultPromptRegistry is used when the configured prompt directory cannot
+// be loaded. The embedded templates always parse.
+func defaultPromptRegistry(dir string) *PromptRegistry {
+	r, err := NewPromptRegistry(dir)
+	if err == nil {
+		return r
+	}
+	log.Printf("Failed to load prompt templates, using the embedded ones: %v", err)
+	r, err = NewPromptRegistry("")
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
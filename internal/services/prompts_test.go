@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"begbot/internal/models"
+)
+
+// textPrompt is a prompt that did not come from a template, for tests that
+// only care about the call.
+func textPrompt(text string) RenderedPrompt {
+	return RenderedPrompt{Name: "test", Version: 1, Text: text}
+}
+
+type memoryPromptStore struct {
+	templates []models.PromptTemplate
+	active    map[string]int
+}
+
+func (m *memoryPromptStore) GetPromptTemplates(ctx context.Context) ([]models.PromptTemplate, map[string]int, error) {
+	active := make(map[string]int)
+	for name, version := range m.active {
+		active[name] = version
+	}
+	return m.templates, active, nil
+}
+
+func (m *memoryPromptStore) SavePromptTemplate(ctx context.Context, t *models.PromptTemplate) error {
+	m.templates = append(m.templates, *t)
+	return nil
+}
+
+func (m *memoryPromptStore) SetActivePromptVersion(ctx context.Context, name string, version int) error {
+	if version == 0 {
+		delete(m.active, name)
+	} else {
+		m.active[name] = version
+	}
+	return nil
+}
+
+func TestPromptRegistryRendersEmbeddedTemplates(t *testing.T) {
+	r, err := NewPromptRegistry("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.Render(context.Background(), PromptMessageReply, MessageGenerationInput{
+		ListingTitle: "iPhone 13",
+		ListingPrice: 4000,
+		Valuation:    3500,
+		ConversationHistory: []models.Message{
+			{Direction: "outgoing", Content: "Hej! Finns den kvar?"},
+			{Direction: "incoming", Content: "Ja, den finns."},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID() != "message_reply@v1" {
+		t.Errorf("ID() = %q, want message_reply@v1", got.ID())
+	}
+	want := "Konversationshistorik:\nDu: Hej! Finns den kvar?\nSäljare: Ja, den finns.\n\n\nBaserat på konversationen ovan"
+	if !strings.Contains(got.Text, want) {
+		t.Errorf("history not rendered as expected:\n%s", got.Text)
+	}
+	if !strings.Contains(got.Text, "Inte överskrida din värdering (3500 kr)") || strings.HasSuffix(got.Text, "\n") {
+		t.Errorf("unexpected text:\n%q", got.Text)
+	}
+
+	for _, name := range []string{PromptExtractProductInfo, PromptCompileValuations, PromptNewPrice, PromptMessageInitial} {
+		if _, err := r.Render(context.Background(), name, nil); err == nil {
+			t.Errorf("%s: rendering without data should fail on missing fields", name)
+		}
+	}
+}
+
+func TestPromptRegistryVersions(t *testing.T) {
+	r, err := NewPromptRegistry("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memoryPromptStore{active: make(map[string]int)}
+	r.UseStore(store)
+	ctx := context.Background()
+	data := map[string]interface{}{"ProductName": "iPhone 13", "Valuations": "- Tradera: 3 000 kr\n"}
+
+	created, err := r.Create(ctx, PromptCompileValuations, `Värdera "{{.ProductName}}":
+{{.Valuations}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Version != 2 {
+		t.Errorf("created version = %d, want 2", created.Version)
+	}
+	if _, err := r.Create(ctx, PromptCompileValuations, "{{.ProductName"); err == nil {
+		t.Error("a template that does not parse should be rejected")
+	}
+	if _, err := r.Create(ctx, "unknown", "text"); err == nil {
+		t.Error("creating a version of an unknown template should fail")
+	}
+
+	got, err := r.Render(ctx, PromptCompileValuations, data)
+	if err != nil || got.Version != 1 {
+		t.Fatalf("new versions should not be used before activation, got %+v, %v", got, err)
+	}
+
+	if err := r.Activate(ctx, PromptCompileValuations, 2); err != nil {
+		t.Fatal(err)
+	}
+	got, err = r.Render(ctx, PromptCompileValuations, data)
+	if err != nil || got.ID() != "compile_valuations@v2" || !strings.HasPrefix(got.Text, `Värdera "iPhone 13"`) {
+		t.Fatalf("after activation got %+v, %v", got, err)
+	}
+
+	preview, err := r.RenderVersion(ctx, PromptCompileValuations, 1, data)
+	if err != nil || preview.Version != 1 {
+		t.Errorf("RenderVersion(1) = %+v, %v", preview, err)
+	}
+
+	if err := r.Activate(ctx, PromptCompileValuations, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := r.Render(ctx, PromptCompileValuations, data); got.Version != 1 {
+		t.Errorf("rollback should use v1 again, got v%d", got.Version)
+	}
+	if err := r.Activate(ctx, PromptCompileValuations, 9); err == nil {
+		t.Error("activating a missing version should fail")
+	}
+
+	active := 0
+	for _, tmpl := range r.List(ctx) {
+		if tmpl.Name == PromptCompileValuations && tmpl.Active {
+			active = tmpl.Version
+		}
+	}
+	if active != 1 {
+		t.Errorf("List() active version = %d, want 1", active)
+	}
+}
+
+func TestPromptRegistryDirectory(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "new_price.v2.tmpl"), []byte("Nypris för {{.Model}}?\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewPromptRegistry(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := r.Render(context.Background(), PromptNewPrice, ProductInfo{Model: "iPhone 13"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID() != "new_price@v2" || got.Text != "Nypris för iPhone 13?" {
+		t.Errorf("Render() = %+v, want the latest file version", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "new_price.v3.tmpl"), []byte("{{.Model"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPromptRegistry(dir); err == nil {
+		t.Error("an invalid template file should fail loading")
+	}
+}
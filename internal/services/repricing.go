@@ -0,0 +1,402 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"begbot/internal/config"
+	"begbot/internal/db"
+	"begbot/internal/models"
+)
+
+const (
+	DefaultInitialMarkup = 0.05
+	DefaultCutAfterDays  = 7
+	DefaultCutPercent    = 0.05
+)
+
+// RepricingInput is what the engine needs to price one item. Amounts are in
+// SEK. TotalCost is zero when the cost of the item is unknown. MinPrice is
+// the lowest price that leaves the minimum profit after selling fees; when
+// it is zero the floor is TotalCost plus the minimum profit.
+type RepricingInput struct {
+	Kind         string
+	ID           int64
+	ProductID    *int64
+	Title        string
+	CurrentPrice int
+	MarketValue  int
+	TotalCost    int
+	MinPrice     int
+	ListedAt     time.Time
+}
+
+// PriceSuggestion is the recommended price for one of my listings or an
+// item in stock.
+type PriceSuggestion struct {
+	Kind           string     `json:"kind"`
+	ID             int64      `json:"id"`
+	ProductID      *int64     `json:"product_id,omitempty"`
+	Title          string     `json:"title"`
+	CurrentPrice   int        `json:"current_price"`
+	MarketValue    int        `json:"market_value"`
+	InitialAsk     int        `json:"initial_ask"`
+	SuggestedPrice int        `json:"suggested_price"`
+	Floor          int        `json:"floor"`
+	DaysListed     int        `json:"days_listed"`
+	CutsApplied    int        `json:"cuts_applied"`
+	NextCutAt      *time.Time `json:"next_cut_at,omitempty"`
+	AtFloor        bool       `json:"at_floor"`
+	Reason         string     `json:"reason"`
+}
+
+// NeedsChange reports whether the suggested price differs from the price
+// the item is listed at. Items that are not listed yet always need a price.
+func (p PriceSuggestion) NeedsChange() bool {
+	return p.SuggestedPrice > 0 && p.SuggestedPrice != p.CurrentPrice
+}
+
+// RepricingSettings are the resolved repricing parameters.
+type RepricingSettings struct {
+	InitialMarkup float64
+	CutAfterDays  int
+	CutPercent    float64
+	MinProfitSEK  int
+}
+
+func repricingSettings(cfg *config.Config, rules *models.Economics) RepricingSettings {
+	settings := RepricingSettings{
+		InitialMarkup: DefaultInitialMarkup,
+		CutAfterDays:  DefaultCutAfterDays,
+		CutPercent:    DefaultCutPercent,
+	}
+	if cfg != nil {
+		if cfg.Repricing.InitialMarkup > 0 {
+			settings.InitialMarkup = cfg.Repricing.InitialMarkup
+		}
+		if cfg.Repricing.CutAfterDays > 0 {
+			settings.CutAfterDays = cfg.Repricing.CutAfterDays
+		}
+		if cfg.Repricing.CutPercent > 0 {
+			settings.CutPercent = cfg.Repricing.CutPercent
+		}
+		settings.MinProfitSEK = cfg.Repricing.MinProfitSEK
+	}
+	if settings.MinProfitSEK == 0 && rules != nil {
+		settings.MinProfitSEK = ptrVal(rules.MinProfitSEK)
+	}
+	return settings
+}
+
+// SuggestPrice computes the ask for an item: the market value plus the
+// initial markup, cut by CutPercent of that ask for every CutAfterDays the
+// item has gone unsold, but never below total cost, selling fees and minimum
+// profit.
+func SuggestPrice(in RepricingInput, settings RepricingSettings, now time.Time) PriceSuggestion {
+	suggestion := PriceSuggestion{
+		Kind:         in.Kind,
+		ID:           in.ID,
+		ProductID:    in.ProductID,
+		Title:        in.Title,
+		CurrentPrice: in.CurrentPrice,
+		MarketValue:  in.MarketValue,
+	}
+
+	switch {
+	case in.MinPrice > 0:
+		suggestion.Floor = in.MinPrice
+	case in.TotalCost > 0:
+		suggestion.Floor = in.TotalCost + settings.MinProfitSEK
+	}
+	if in.MarketValue <= 0 {
+		suggestion.SuggestedPrice = suggestion.Floor
+		suggestion.AtFloor = suggestion.Floor > 0
+		suggestion.Reason = "saknar marknadsvärdering"
+		return suggestion
+	}
+
+	initialAsk := roundPrice(float64(in.MarketValue) * (1 + settings.InitialMarkup))
+	suggestion.InitialAsk = initialAsk
+
+	if !in.ListedAt.IsZero() && now.After(in.ListedAt) {
+		suggestion.DaysListed = int(now.Sub(in.ListedAt).Hours() / 24)
+	}
+	if settings.CutAfterDays > 0 {
+		suggestion.CutsApplied = suggestion.DaysListed / settings.CutAfterDays
+	}
+
+	price := roundPrice(float64(initialAsk) * (1 - settings.CutPercent*float64(suggestion.CutsApplied)))
+	if price <= suggestion.Floor {
+		price = suggestion.Floor
+		suggestion.AtFloor = true
+	}
+	suggestion.SuggestedPrice = price
+
+	switch {
+	case suggestion.AtFloor && in.MinPrice > 0:
+		suggestion.Reason = fmt.Sprintf("golvpris: kostnad %d kr + avgifter + minsta vinst %d kr", in.TotalCost, settings.MinProfitSEK)
+	case suggestion.AtFloor:
+		suggestion.Reason = fmt.Sprintf("golvpris: kostnad %d kr + minsta vinst %d kr", in.TotalCost, settings.MinProfitSEK)
+	case suggestion.CutsApplied > 0:
+		suggestion.Reason = fmt.Sprintf("osåld i %d dagar, %d prissänkningar à %.0f%%", suggestion.DaysListed, suggestion.CutsApplied, settings.CutPercent*100)
+	default:
+		suggestion.Reason = fmt.Sprintf("marknadsvärde %d kr + %.0f%% påslag", in.MarketValue, settings.InitialMarkup*100)
+	}
+
+	if !suggestion.AtFloor && settings.CutAfterDays > 0 && !in.ListedAt.IsZero() {
+		next := in.ListedAt.AddDate(0, 0, (suggestion.CutsApplied+1)*settings.CutAfterDays)
+		suggestion.NextCutAt = &next
+	}
+
+	return suggestion
+}
+
+// roundPrice rounds to whole tens of kronor, as asking prices usually are.
+func roundPrice(v float64) int {
+	return int(math.Round(v/10) * 10)
+}
+
+// RepricingService suggests prices for my listings and for items in stock.
+type RepricingService struct {
+	cfg              *config.Config
+	database         *db.Postgres
+	valuationService *ValuationService
+}
+
+func NewRepricingService(cfg *config.Config, database *db.Postgres, valuationService *ValuationService) *RepricingService {
+	return &RepricingService{
+		cfg:              cfg,
+		database:         database,
+		valuationService: valuationService,
+	}
+}
+
+// Suggestions returns a price suggestion for every active listing of mine
+// and every unsold item in stock that is not listed by me yet. Each is
+// priced with the trading rule set that applies to it.
+func (s *RepricingService) Suggestions(ctx context.Context) ([]PriceSuggestion, error) {
+	ruleSets, err := s.database.GetTradingRuleSets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	listings, err := s.database.GetAllListings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.database.GetUnsoldTradedItems(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	listingByID := make(map[int64]*models.Listing)
+	myListings := make(map[int64]bool)
+	for i, l := range listings {
+		listingByID[l.ID] = &listings[i]
+		if l.IsMyListing && l.Status == "active" {
+			myListings[l.ID] = true
+		}
+	}
+	itemByListing := make(map[int64]models.TradedItem)
+	for _, item := range items {
+		if item.ListingID != nil && myListings[*item.ListingID] {
+			itemByListing[*item.ListingID] = item
+		}
+	}
+
+	now := time.Now()
+	marketValues := make(map[int64]int)
+	products := make(map[int64]*models.Product)
+	var suggestions []PriceSuggestion
+
+	for i := range listings {
+		l := &listings[i]
+		if !myListings[l.ID] {
+			continue
+		}
+		scope := db.TradingRuleScopeFor(l, s.product(ctx, l.ProductID, products))
+		settings := repricingSettings(s.cfg, models.SelectTradingRule(ruleSets, scope))
+		in := RepricingInput{
+			Kind:        "listing",
+			ID:          l.ID,
+			ProductID:   l.ProductID,
+			Title:       l.Title,
+			MarketValue: s.marketValue(ctx, l.ProductID, marketValues),
+			ListedAt:    l.CreatedAt,
+		}
+		if l.Price != nil {
+			in.CurrentPrice = *l.Price
+		}
+		if l.PublicationDate != nil {
+			in.ListedAt = *l.PublicationDate
+		}
+		if item, ok := itemByListing[l.ID]; ok {
+			in.TotalCost = tradedItemCost(item)
+			in.MinPrice = s.minPrice(item, settings)
+		}
+		suggestions = append(suggestions, SuggestPrice(in, settings, now))
+	}
+
+	for _, item := range items {
+		if item.ListingID != nil && myListings[*item.ListingID] {
+			continue
+		}
+		product := s.product(ctx, item.ProductID, products)
+		// An item bought from a listing gets the rules of that listing.
+		listing := &models.Listing{ProductID: item.ProductID}
+		if item.ListingID != nil && listingByID[*item.ListingID] != nil {
+			listing = listingByID[*item.ListingID]
+		}
+		scope := db.TradingRuleScopeFor(listing, product)
+		settings := repricingSettings(s.cfg, models.SelectTradingRule(ruleSets, scope))
+		in := RepricingInput{
+			Kind:        "item",
+			ID:          item.ID,
+			ProductID:   item.ProductID,
+			Title:       item.SourceLink,
+			MarketValue: s.marketValue(ctx, item.ProductID, marketValues),
+			TotalCost:   tradedItemCost(item),
+			MinPrice:    s.minPrice(item, settings),
+		}
+		suggestions = append(suggestions, SuggestPrice(in, settings, now))
+	}
+
+	return suggestions, nil
+}
+
+// product returns a catalog product, memoized per call of Suggestions. It
+// is nil when productID is nil or the product could not be loaded.
+func (s *RepricingService) product(ctx context.Context, productID *int64, cache map[int64]*models.Product) *models.Product {
+	if productID == nil {
+		return nil
+	}
+	if p, ok := cache[*productID]; ok {
+		return p
+	}
+	p, err := s.database.GetProductByID(ctx, *productID)
+	if err != nil {
+		log.Printf("Failed to get product %d for repricing: %v", *productID, err)
+	}
+	cache[*productID] = p
+	return p
+}
+
+// marketValue returns the compiled valuation of a product forecast to the
+// target sell date, memoized per call of Suggestions.
+func (s *RepricingService) marketValue(ctx context.Context, productID *int64, cache map[int64]int) int {
+	if productID == nil {
+		return 0
+	}
+	if v, ok := cache[*productID]; ok {
+		return v
+	}
+	v, err := s.database.ComputeWeightedValuationForProduct(ctx, *productID)
+	if err != nil {
+		log.Printf("Failed to compute valuation for product %d: %v", *productID, err)
+	}
+	if v > 0 && s.valuationService != nil && s.cfg != nil {
+		v = int(math.Round(s.valuationService.ForecastValue(ctx, *productID, float64(v), s.cfg.Valuation.TargetSellDays)))
+	}
+	cache[*productID] = v
+	return v
+}
+
+// tradedItemCost returns what an item has cost including the cost of
+// shipping it to the buyer, in SEK. Traded item amounts are stored in öre.
+func tradedItemCost(item models.TradedItem) int {
+	total := item.BuyPrice + item.BuyShippingCost + ptrVal(item.SellPackagingCost) + ptrVal(item.SellPostageCost)
+	return models.Ore(total).MajorInt()
+}
+
+// minPrice returns the lowest price an item can be sold at for the minimum
+// profit after the fees of the cost model, or 0 when its cost is unknown.
+func (s *RepricingService) minPrice(item models.TradedItem, settings RepricingSettings) int {
+	if tradedItemCost(item) == 0 {
+		return 0
+	}
+	var costs models.CostModel
+	if s.cfg != nil {
+		costs = s.cfg.Costs
+	}
+	return costs.MinSellPrice(models.TradedItemInput(&item, 0), settings.MinProfitSEK)
+}
+
+// NotifyDue emails the suggestions that call for a price change and have
+// not been emailed at that price before. It returns the number of
+// suggestions included.
+func (s *RepricingService) NotifyDue(ctx context.Context) (int, error) {
+	suggestions, err := s.Suggestions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	notified, err := s.database.GetRepricingNotifications(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	due := newPriceSuggestions(suggestions, notified)
+	if len(due) == 0 {
+		return 0, nil
+	}
+
+	emailCfg := EmailConfig{
+		SMTPHost:     s.cfg.Email.SMTPHost,
+		SMTPPort:     s.cfg.Email.SMTPPort,
+		SMTPUsername: s.cfg.Email.SMTPUsername,
+		SMTPPassword: s.cfg.Email.SMTPPassword,
+		From:         s.cfg.Email.From,
+		Recipients:   s.cfg.Email.Recipients,
+	}
+	subject := fmt.Sprintf("Prisförslag för %d annonser", len(due))
+	if err := SendEmail(emailCfg, s.cfg.Email.Recipients, subject, formatPriceSuggestionsHTML(due)); err != nil {
+		return 0, err
+	}
+	for _, p := range due {
+		n := models.RepricingNotification{Kind: p.Kind, ItemID: p.ID, SuggestedPrice: p.SuggestedPrice}
+		if err := s.database.SaveRepricingNotification(ctx, n); err != nil {
+			log.Printf("Failed to record repricing notification for %s %d: %v", p.Kind, p.ID, err)
+		}
+	}
+	return len(due), nil
+}
+
+// newPriceSuggestions returns the suggestions that call for a price change
+// at a price other than the one last emailed for the same listing or item.
+func newPriceSuggestions(suggestions []PriceSuggestion, notified []models.RepricingNotification) []PriceSuggestion {
+	type key struct {
+		kind string
+		id   int64
+	}
+	last := make(map[key]int, len(notified))
+	for _, n := range notified {
+		last[key{n.Kind, n.ItemID}] = n.SuggestedPrice
+	}
+
+	var due []PriceSuggestion
+	for _, p := range suggestions {
+		if !p.NeedsChange() {
+			continue
+		}
+		if price, ok := last[key{p.Kind, p.ID}]; ok && price == p.SuggestedPrice {
+			continue
+		}
+		due = append(due, p)
+	}
+	return due
+}
+
+func formatPriceSuggestionsHTML(suggestions []PriceSuggestion) string {
+	var b strings.Builder
+	b.WriteString("<table><tr><th>Annons</th><th>Nuvarande pris</th><th>Föreslaget pris</th><th>Golv</th><th>Anledning</th></tr>")
+	for _, p := range suggestions {
+		fmt.Fprintf(&b, "<tr><td>%s</td><td>%d kr</td><td>%d kr</td><td>%d kr</td><td>%s</td></tr>",
+			html.EscapeString(p.Title), p.CurrentPrice, p.SuggestedPrice, p.Floor, html.EscapeString(p.Reason))
+	}
+	b.WriteString("</table>")
+	return b.String()
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"begbot/internal/config"
+	"begbot/internal/models"
+)
+
+func TestSuggestPrice(t *testing.T) {
+	now := time.Date(2024, 5, 20, 12, 0, 0, 0, time.UTC)
+	settings := RepricingSettings{InitialMarkup: 0.1, CutAfterDays: 7, CutPercent: 0.05, MinProfitSEK: 200}
+
+	tests := []struct {
+		name      string
+		in        RepricingInput
+		wantPrice int
+		wantCuts  int
+		wantFloor bool
+	}{
+		{
+			name:      "new listing gets initial ask",
+			in:        RepricingInput{MarketValue: 2000, TotalCost: 1000, ListedAt: now},
+			wantPrice: 2200,
+		},
+		{
+			name:      "two cuts after fifteen days",
+			in:        RepricingInput{MarketValue: 2000, TotalCost: 1000, ListedAt: now.AddDate(0, 0, -15)},
+			wantPrice: 1980,
+			wantCuts:  2,
+		},
+		{
+			name:      "cuts stop at floor",
+			in:        RepricingInput{MarketValue: 2000, TotalCost: 1500, ListedAt: now.AddDate(0, 0, -60)},
+			wantPrice: 1700,
+			wantCuts:  8,
+			wantFloor: true,
+		},
+		{
+			name:      "no valuation falls back to floor",
+			in:        RepricingInput{TotalCost: 800},
+			wantPrice: 1000,
+			wantFloor: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SuggestPrice(tt.in, settings, now)
+			if got.SuggestedPrice != tt.wantPrice {
+				t.Errorf("Expected price %d, got %d (%s)", tt.wantPrice, got.SuggestedPrice, got.Reason)
+			}
+			if got.CutsApplied != tt.wantCuts {
+				t.Errorf("Expected %d cuts, got %d", tt.wantCuts, got.CutsApplied)
+			}
+			if got.AtFloor != tt.wantFloor {
+				t.Errorf("Expected AtFloor=%v, got %v", tt.wantFloor, got.AtFloor)
+			}
+		})
+	}
+}
+
+func TestSuggestPrice_NextCut(t *testing.T) {
+	listed := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	now := listed.AddDate(0, 0, 10)
+	got := SuggestPrice(RepricingInput{MarketValue: 1000, ListedAt: listed}, RepricingSettings{CutAfterDays: 7, CutPercent: 0.1}, now)
+
+	if got.NextCutAt == nil || !got.NextCutAt.Equal(listed.AddDate(0, 0, 14)) {
+		t.Errorf("Expected next cut at day 14, got %v", got.NextCutAt)
+	}
+	if !got.NeedsChange() {
+		t.Error("Expected unlisted price to need a change")
+	}
+}
+
+func TestTradedItemCost(t *testing.T) {
+	item := models.TradedItem{BuyPrice: 100000, BuyShippingCost: 5000, SellPackagingCost: intPtr(1000), SellPostageCost: intPtr(6000)}
+	if got := tradedItemCost(item); got != 1120 {
+		t.Errorf("Expected 1120 SEK, got %d", got)
+	}
+}
+
+func TestRepricingSettings_UsesTradingRuleMinProfit(t *testing.T) {
+	rules := &models.Economics{MinProfitSEK: intPtr(300)}
+	if got := repricingSettings(&config.Config{}, rules).MinProfitSEK; got != 300 {
+		t.Errorf("Expected trading rule min profit 300, got %d", got)
+	}
+
+	cfg := &config.Config{Repricing: config.RepricingConfig{MinProfitSEK: 150}}
+	if got := repricingSettings(cfg, rules).MinProfitSEK; got != 150 {
+		t.Errorf("Expected configured min profit 150, got %d", got)
+	}
+}
+
+func TestNewPriceSuggestions(t *testing.T) {
+	suggestions := []PriceSuggestion{
+		{Kind: "listing", ID: 1, CurrentPrice: 2000, SuggestedPrice: 1900},
+		{Kind: "listing", ID: 2, CurrentPrice: 2000, SuggestedPrice: 1800},
+		{Kind: "item", ID: 1, SuggestedPrice: 1500},
+		{Kind: "listing", ID: 3, CurrentPrice: 1000, SuggestedPrice: 1000},
+	}
+	notified := []models.RepricingNotification{
+		{Kind: "listing", ItemID: 1, SuggestedPrice: 1900},
+		{Kind: "listing", ItemID: 2, SuggestedPrice: 1900},
+	}
+
+	due := newPriceSuggestions(suggestions, notified)
+	if len(due) != 2 || due[0].Kind != "listing" || due[0].ID != 2 || due[1].Kind != "item" || due[1].ID != 1 {
+		t.Errorf("Expected the changed listing and the new item, got %+v", due)
+	}
+}
@@ -0,0 +1,314 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"begbot/internal/config"
+	"begbot/internal/models"
+)
+
+// Why an ad was parked for review.
+const (
+	ReviewReasonLowConfidence  = "low_confidence"
+	ReviewReasonUncertainMatch = "uncertain_match"
+)
+
+const (
+	ReviewStatusPending   = "pending"
+	ReviewStatusConfirmed = "confirmed"
+	ReviewStatusRejected  = "rejected"
+
+	defaultReviewCandidates = 5
+
+	// reviewEvalSource marks labelled examples made from reviews.
+	reviewEvalSource = "review"
+)
+
+var (
+	ErrReviewNotFound = errors.New("review item not found")
+	ErrReviewResolved = errors.New("review item already resolved")
+	ErrReviewProduct  = errors.New("product not found")
+)
+
+// ReviewCorrection is a reviewer's answer: the catalog product the ad is
+// for and any extracted fields that were wrong.
+type ReviewCorrection struct {
+	ProductID    int64   `json:"product_id"`
+	Manufacturer *string `json:"manufacturer,omitempty"`
+	Model        *string `json:"model,omitempty"`
+	Category     *string `json:"category,omitempty"`
+	Condition    *string `json:"condition,omitempty"`
+}
+
+// apply corrects info. Fields the reviewer left out keep the extracted
+// value, except the product fields, which default to the chosen product.
+func (c ReviewCorrection) apply(info *ProductInfo, product *models.Product) {
+	set := func(field *string, correction, fromProduct *string) {
+		switch {
+		case correction != nil:
+			*field = strings.TrimSpace(*correction)
+		case fromProduct != nil && *fromProduct != "":
+			*field = *fromProduct
+		}
+	}
+	set(&info.Manufacturer, c.Manufacturer, product.Brand)
+	set(&info.Model, c.Model, product.Name)
+	set(&info.Category, c.Category, product.Category)
+	set(&info.Condition, c.Condition, nil)
+	info.ProductID = product.ID
+	info.Confidence = floatPtr(1)
+}
+
+// lowConfidence reports whether the extraction is less sure than min. An
+// unknown confidence is not low: prompt versions without one would
+// otherwise send every ad to review.
+func (info *ProductInfo) lowConfidence(min float64) bool {
+	return info.Confidence != nil && *info.Confidence < min
+}
+
+// reviewReason decides whether an ad goes to the review queue: a matched
+// product extracted with low confidence, or no exact match but candidate
+// products. An empty reason means the ad is handled as usual.
+func reviewReason(cfg config.ReviewConfig, info *ProductInfo, product *models.Product, candidates []models.ReviewCandidate) string {
+	if !cfg.Enabled || info == nil {
+		return ""
+	}
+	if product != nil {
+		if info.lowConfidence(cfg.MinExtractionConfidence) {
+			return ReviewReasonLowConfidence
+		}
+		return ""
+	}
+	if len(candidates) > 0 {
+		return ReviewReasonUncertainMatch
+	}
+	return ""
+}
+
+// reviewCandidates ranks the products by how well they match the extracted
+// info, keeping at most max with a score of at least minScore.
+func reviewCandidates(info *ProductInfo, products []models.Product, minScore float64, max int) []models.ReviewCandidate {
+	if max <= 0 {
+		max = defaultReviewCandidates
+	}
+	var candidates []models.ReviewCandidate
+	for _, p := range products {
+		score := productMatchScore(info, p)
+		if score > 0 && score >= minScore {
+			candidates = append(candidates, models.ReviewCandidate{ProductID: p.ID, Name: productDisplayName(p), Score: score})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Score > candidates[j].Score })
+	if len(candidates) > max {
+		candidates = candidates[:max]
+	}
+	return candidates
+}
+
+// productMatchScore is the share of words the extracted manufacturer and
+// model have in common with the brand and name of p, from 0 to 1. It is
+// halved when the categories differ.
+func productMatchScore(info *ProductInfo, p models.Product) float64 {
+	var brand, name, category string
+	if p.Brand != nil {
+		brand = *p.Brand
+	}
+	if p.Name != nil {
+		name = *p.Name
+	}
+	if p.Category != nil {
+		category = *p.Category
+	}
+
+	extracted := matchWords(info.Manufacturer + " " + info.Model)
+	catalog := matchWords(brand + " " + name)
+	if len(extracted) == 0 || len(catalog) == 0 {
+		return 0
+	}
+	common := 0
+	for word := range extracted {
+		if catalog[word] {
+			common++
+		}
+	}
+	score := 2 * float64(common) / float64(len(extracted)+len(catalog))
+	if info.Category != "" && category != "" && !strings.EqualFold(info.Category, category) {
+		score /= 2
+	}
+	return score
+}
+
+func matchWords(s string) map[string]bool {
+	words := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		words[w] = true
+	}
+	return words
+}
+
+// extractedFields is how the extraction of an ad under review is stored.
+func extractedFields(info *ProductInfo) extractedProduct {
+	var confidence *float64
+	if info.Confidence != nil {
+		confidence = floatPtr(*info.Confidence * 100)
+	}
+	return extractedProduct{
+		Manufacturer:  info.Manufacturer,
+		Model:         info.Model,
+		Category:      info.Category,
+		Storage:       info.Storage,
+		Condition:     info.Condition,
+		ShippingCost:  info.ShippingCost,
+		Confidence:    confidence,
+		PromptVersion: info.PromptVersion,
+		LLMModel:      info.LLMModel,
+	}
+}
+
+// parkForReview puts the ad in the review queue when its extraction or
+// product match is uncertain, and reports whether it did.
+func (s *BotService) parkForReview(ctx context.Context, ad RawAd, info *ProductInfo, product *models.Product) (bool, error) {
+	if s.cfg == nil || !s.cfg.Review.Enabled {
+		return false, nil
+	}
+	cfg := s.cfg.Review
+
+	var candidates []models.ReviewCandidate
+	if product == nil || info.lowConfidence(cfg.MinExtractionConfidence) {
+		products, err := s.database.GetEnabledProducts(ctx)
+		if err != nil {
+			return false, err
+		}
+		candidates = reviewCandidates(info, products, cfg.MinMatchScore, cfg.MaxCandidates)
+	}
+	reason := reviewReason(cfg, info, product, candidates)
+	if reason == "" {
+		return false, nil
+	}
+
+	adJSON, err := json.Marshal(ad)
+	if err != nil {
+		return false, err
+	}
+	extracted, err := json.Marshal(extractedFields(info))
+	if err != nil {
+		return false, err
+	}
+	item := &models.ReviewItem{
+		Link:       ad.Link,
+		Reason:     reason,
+		Ad:         adJSON,
+		Extracted:  extracted,
+		Candidates: candidates,
+	}
+	if info.Confidence != nil {
+		item.Confidence = *info.Confidence
+	}
+	if info.PromptVersion != "" {
+		item.PromptVersion = &info.PromptVersion
+	}
+	if info.LLMModel != "" {
+		item.LLMModel = &info.LLMModel
+	}
+	if err := s.database.SaveReviewItem(ctx, item); err != nil {
+		return false, err
+	}
+	s.log(LogLevelInfo, "Parked for review (%s): %s %s with %d candidates - %s", reason, info.Manufacturer, info.Model, len(candidates), ad.Link)
+	return true, nil
+}
+
+// ConfirmReview saves a reviewed ad as a listing of the chosen product,
+// valued and checked against the trading rules like a scraped one. The
+// corrected fields are kept as a labelled example for prompts and
+// evaluation.
+func (s *BotService) ConfirmReview(ctx context.Context, id int64, correction ReviewCorrection) (*models.Listing, error) {
+	item, err := s.pendingReviewItem(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	product, err := s.database.GetProductByID(ctx, correction.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	if product == nil {
+		return nil, ErrReviewProduct
+	}
+
+	var ad RawAd
+	if err := json.Unmarshal(item.Ad, &ad); err != nil {
+		return nil, fmt.Errorf("failed to decode ad: %w", err)
+	}
+	var extracted extractedProduct
+	if err := json.Unmarshal(item.Extracted, &extracted); err != nil {
+		return nil, fmt.Errorf("failed to decode extraction: %w", err)
+	}
+	info := extracted.productInfo(ad.AdText)
+	correction.apply(info, product)
+
+	tradedItem := s.marketplaceService.ConvertToPotentialItem(ad)
+	tradedItem.BuyShippingCost = int(info.ShippingCost)
+	listing, err := s.valueAndSaveListing(ctx, ad, tradedItem, info, product)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved, err := s.database.ResolveReviewItem(ctx, id, ReviewStatusConfirmed, &product.ID, &listing.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !resolved {
+		return nil, ErrReviewResolved
+	}
+
+	label := &models.LLMEvalCase{
+		Source:       reviewEvalSource,
+		SourceRef:    strconv.FormatInt(id, 10),
+		AdText:       ad.AdText,
+		Manufacturer: info.Manufacturer,
+		Model:        info.Model,
+		Category:     info.Category,
+		Condition:    info.Condition,
+	}
+	if err := s.database.SaveLLMEvalCase(ctx, label); err != nil {
+		s.log(LogLevelWarning, "Failed to save review %d as a labelled example: %v", id, err)
+	}
+	return listing, nil
+}
+
+// RejectReview drops an ad from the queue without saving it.
+func (s *BotService) RejectReview(ctx context.Context, id int64) error {
+	if _, err := s.pendingReviewItem(ctx, id); err != nil {
+		return err
+	}
+	resolved, err := s.database.ResolveReviewItem(ctx, id, ReviewStatusRejected, nil, nil)
+	if err != nil {
+		return err
+	}
+	if !resolved {
+		return ErrReviewResolved
+	}
+	return nil
+}
+
+func (s *BotService) pendingReviewItem(ctx context.Context, id int64) (*models.ReviewItem, error) {
+	item, err := s.database.GetReviewItem(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, ErrReviewNotFound
+	}
+	if item.Status != ReviewStatusPending {
+		return nil, ErrReviewResolved
+	}
+	return item, nil
+}
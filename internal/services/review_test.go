@@ -0,0 +1,102 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"begbot/internal/config"
+	"begbot/internal/models"
+)
+
+func TestReviewCandidates(t *testing.T) {
+	products := cascadeProducts()
+	samsung, s22, phone := "Samsung", "Galaxy S22", "phone"
+	products = append(products, models.Product{ID: 3, Brand: &samsung, Name: &s22, Category: &phone})
+
+	info := &ProductInfo{Manufacturer: "Apple", Model: "iPhone 13 Pro Max", Category: "phone"}
+	candidates := reviewCandidates(info, products, 0.3, 5)
+	if len(candidates) != 2 {
+		t.Fatalf("candidates = %+v, want the two iPhones", candidates)
+	}
+	if candidates[0].ProductID != 2 || candidates[0].Name != "Apple iPhone 13 Pro (phone)" || candidates[0].Score <= candidates[1].Score {
+		t.Errorf("best candidate = %+v, want iPhone 13 Pro first", candidates[0])
+	}
+	if limited := reviewCandidates(info, products, 0.3, 1); len(limited) != 1 {
+		t.Errorf("max candidates not applied: %+v", limited)
+	}
+
+	exact := productMatchScore(&ProductInfo{Manufacturer: "apple", Model: "iPhone-13", Category: "phone"}, products[0])
+	if exact != 1 {
+		t.Errorf("exact score = %v, want 1", exact)
+	}
+	otherCategory := productMatchScore(&ProductInfo{Manufacturer: "Apple", Model: "iPhone 13", Category: "tablet"}, products[0])
+	if otherCategory != 0.5 {
+		t.Errorf("score with another category = %v, want 0.5", otherCategory)
+	}
+}
+
+func TestReviewReason(t *testing.T) {
+	cfg := config.ReviewConfig{Enabled: true, MinExtractionConfidence: 0.7}
+	product := &cascadeProducts()[0]
+	candidates := []models.ReviewCandidate{{ProductID: 1, Score: 0.8}}
+
+	tests := []struct {
+		name       string
+		confidence float64
+		product    *models.Product
+		candidates []models.ReviewCandidate
+		want       string
+	}{
+		{"confident match", 0.9, product, nil, ""},
+		{"unsure match", 0.5, product, candidates, ReviewReasonLowConfidence},
+		{"no match with candidates", 0.9, nil, candidates, ReviewReasonUncertainMatch},
+		{"no match at all", 0.9, nil, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := reviewReason(cfg, &ProductInfo{Confidence: floatPtr(tt.confidence)}, tt.product, tt.candidates)
+			if got != tt.want {
+				t.Errorf("reviewReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := reviewReason(cfg, &ProductInfo{}, product, candidates); got != "" {
+		t.Errorf("unknown confidence parked a matched ad: %q", got)
+	}
+
+	cfg.Enabled = false
+	if got := reviewReason(cfg, &ProductInfo{Confidence: floatPtr(0.1)}, product, candidates); got != "" {
+		t.Errorf("disabled review parked an ad: %q", got)
+	}
+}
+
+func TestReviewCorrection(t *testing.T) {
+	stored, err := json.Marshal(extractedFields(&ProductInfo{Manufacturer: "Apple", Model: "iPhone", Category: "phone", Condition: "Bra", ShippingCost: 59, Confidence: floatPtr(0.4), PromptVersion: "extract_product_info@v3", LLMModel: "backup"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var extracted extractedProduct
+	if err := json.Unmarshal(stored, &extracted); err != nil {
+		t.Fatal(err)
+	}
+	info := extracted.productInfo("Säljer min iPhone")
+	if info.Confidence == nil || *info.Confidence != 0.4 || info.ShippingCost != 59 || info.AdText != "Säljer min iPhone" || info.PromptVersion != "extract_product_info@v3" || info.LLMModel != "backup" {
+		t.Errorf("stored extraction = %+v", info)
+	}
+
+	condition := "Mycket bra"
+	product := cascadeProducts()[1]
+	ReviewCorrection{ProductID: product.ID, Condition: &condition}.apply(info, &product)
+	if info.Model != "iPhone 13 Pro" || info.Manufacturer != "Apple" || info.Condition != "Mycket bra" || info.ProductID != 2 || *info.Confidence != 1 {
+		t.Errorf("corrected info = %+v", info)
+	}
+
+	if got := (extractedProduct{Manufacturer: "Apple"}).productInfo(""); got.Confidence != nil {
+		t.Errorf("missing confidence = %v, want unknown", *got.Confidence)
+	}
+	low := 1.0
+	if got := (extractedProduct{Manufacturer: "Apple", Confidence: &low}).productInfo(""); got.Confidence == nil || *got.Confidence != 0.01 {
+		t.Errorf("confidence 1 of 100 = %v, want 0.01", got.Confidence)
+	}
+}
@@ -46,6 +46,10 @@ func (s *Scheduler) Start(ctx context.Context) error {
 		}
 	}
 
+	if err := s.scheduleRepricingNotifications(); err != nil {
+		log.Printf("Failed to schedule repricing notifications: %v", err)
+	}
+
 	s.cron.Start()
 	log.Printf("Scheduler started with %d jobs", len(jobs))
 	return nil
@@ -67,6 +71,33 @@ func (s *Scheduler) scheduleJob(job models.CronJob) error {
 	return nil
 }
 
+// scheduleRepricingNotifications emails due price cuts on the schedule in
+// repricing.notify_cron, if set.
+func (s *Scheduler) scheduleRepricingNotifications() error {
+	if s.cfg == nil || s.cfg.Repricing.NotifyCron == "" {
+		return nil
+	}
+	var valuationService *ValuationService
+	if s.botService != nil {
+		valuationService = s.botService.valuationService
+	}
+	repricing := NewRepricingService(s.cfg, s.db, valuationService)
+
+	_, err := s.cron.AddFunc(s.cfg.Repricing.NotifyCron, func() {
+		sent, err := repricing.NotifyDue(context.Background())
+		if err != nil {
+			log.Printf("Repricing notification failed: %v", err)
+			return
+		}
+		log.Printf("Repricing notification sent for %d items", sent)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add repricing cron: %w", err)
+	}
+	log.Printf("Scheduled repricing notifications (%s)", s.cfg.Repricing.NotifyCron)
+	return nil
+}
+
 func (s *Scheduler) runJob(job models.CronJob) {
 	if s.running[job.ID] {
 		log.Printf("Job %d (%s) is already running, skipping", job.ID, job.Name)
@@ -155,6 +186,10 @@ func (s *Scheduler) RefreshJobs(ctx context.Context) error {
 		}
 	}
 
+	if err := s.scheduleRepricingNotifications(); err != nil {
+		log.Printf("Failed to schedule repricing notifications: %v", err)
+	}
+
 	s.cron.Start()
 	log.Printf("Refreshed %d jobs", len(jobs))
 	return nil
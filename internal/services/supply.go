@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"begbot/internal/db"
+	"begbot/internal/models"
+)
+
+// minSupplyListings is the number of competing listings needed before the
+// asking prices are used as a valuation.
+const minSupplyListings = 3
+
+// SupplySnapshot summarizes the competing listings of a product, leaving
+// out the listing excludeListingID. Listings priced below sellPrice are
+// counted separately.
+func (s *ValuationService) SupplySnapshot(ctx context.Context, productID int64, sellPrice int, excludeListingID int64) (*models.SupplySnapshot, error) {
+	window := db.SupplyWindow
+	if s.cfg != nil && s.cfg.Valuation.SupplyWindowDays > 0 {
+		window = time.Duration(s.cfg.Valuation.SupplyWindowDays) * 24 * time.Hour
+	}
+	return s.database.GetSupplySnapshot(ctx, productID, sellPrice, time.Now().Add(-window), excludeListingID)
+}
+
+// SupplySignal describes the competition for a listing in a short sentence
+// for trading rule reasons and emails.
+func SupplySignal(snapshot *models.SupplySnapshot) string {
+	if snapshot == nil {
+		return ""
+	}
+	if snapshot.ActiveCount == 0 {
+		return "inga konkurrerande annonser"
+	}
+	signal := fmt.Sprintf("%d konkurrerande annonser (median %.0f kr)", snapshot.ActiveCount, snapshot.P50)
+	if snapshot.SellPrice > 0 {
+		signal += fmt.Sprintf(", %d under vårt säljpris %d kr", snapshot.BelowSell, snapshot.SellPrice)
+	}
+	return signal
+}
+
+// SupplyValuationMethod values a product from the asking prices of other
+// sellers' active listings. Asking prices overstate what items sell for, so
+// its confidence is kept low.
+type SupplyValuationMethod struct {
+	svc *ValuationService
+}
+
+func (m *SupplyValuationMethod) Key() string {
+	return ValuationKeySupply
+}
+
+func (m *SupplyValuationMethod) Name() string {
+	return ValuationTypeSupply
+}
+
+func (m *SupplyValuationMethod) Priority() int {
+	return 5
+}
+
+func (m *SupplyValuationMethod) Valuate(ctx context.Context, productInfo ProductInfo) (*ValuationInput, error) {
+	if m.svc.database == nil || productInfo.ProductID == 0 {
+		return nil, nil
+	}
+
+	snapshot, err := m.svc.SupplySnapshot(ctx, productInfo.ProductID, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get supply snapshot: %w", err)
+	}
+	return supplyValuationInput(m.Name(), snapshot), nil
+}
+
+func supplyValuationInput(name string, snapshot *models.SupplySnapshot) *ValuationInput {
+	if snapshot == nil || snapshot.ActiveCount < minSupplyListings {
+		return nil
+	}
+
+	confidence := math.Min(0.5, 0.3+0.02*float64(snapshot.ActiveCount))
+
+	return &ValuationInput{
+		Type:       name,
+		Value:      int(math.Round(snapshot.P50)),
+		Confidence: confidence,
+		Metadata: map[string]interface{}{
+			"active_count": snapshot.ActiveCount,
+			"marketplaces": snapshot.Marketplaces,
+			"min_price":    snapshot.MinPrice,
+			"p25":          snapshot.P25,
+			"p75":          snapshot.P75,
+			"max_price":    snapshot.MaxPrice,
+		},
+		CollectedAt: time.Now(),
+		Distribution: &PriceDistribution{
+			Min:        snapshot.MinPrice,
+			P25:        snapshot.P25,
+			P50:        snapshot.P50,
+			P75:        snapshot.P75,
+			Max:        snapshot.MaxPrice,
+			SampleSize: snapshot.ActiveCount,
+		},
+	}
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"begbot/internal/models"
+)
+
+func TestSupplyValuationInput(t *testing.T) {
+	snapshot := &models.SupplySnapshot{
+		ActiveCount: 8,
+		MinPrice:    900,
+		P25:         1100,
+		P50:         1250,
+		P75:         1400,
+		MaxPrice:    2000,
+	}
+
+	input := supplyValuationInput(ValuationTypeSupply, snapshot)
+	if input == nil {
+		t.Fatal("Expected a valuation input")
+	}
+	if input.Value != 1250 {
+		t.Errorf("Expected median ask 1250, got %d", input.Value)
+	}
+	if input.Confidence > 0.5 || input.Confidence < 0.3 {
+		t.Errorf("Expected low confidence, got %f", input.Confidence)
+	}
+	if input.Distribution.Percentile(25) != 1100 {
+		t.Errorf("Expected p25 1100 in distribution, got %f", input.Distribution.Percentile(25))
+	}
+
+	if supplyValuationInput(ValuationTypeSupply, &models.SupplySnapshot{ActiveCount: 2, P50: 1000}) != nil {
+		t.Error("Expected no input with fewer than minSupplyListings listings")
+	}
+}
+
+func TestSupplySignal(t *testing.T) {
+	signal := SupplySignal(&models.SupplySnapshot{ActiveCount: 5, P50: 1200, SellPrice: 1300, BelowSell: 3})
+	if !strings.Contains(signal, "5 konkurrerande") || !strings.Contains(signal, "3 under") {
+		t.Errorf("Unexpected signal: %q", signal)
+	}
+	if SupplySignal(&models.SupplySnapshot{}) != "inga konkurrerande annonser" {
+		t.Errorf("Unexpected signal for no supply")
+	}
+	if SupplySignal(nil) != "" {
+		t.Errorf("Expected empty signal for nil snapshot")
+	}
+}
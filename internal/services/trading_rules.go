@@ -0,0 +1,331 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"begbot/internal/db"
+	"begbot/internal/models"
+	"begbot/internal/ruleexpr"
+)
+
+// TradingRuleVerdict is the outcome of checking a listing against the
+// trading rules, with the figures the decision was based on.
+type TradingRuleVerdict struct {
+	Passed          bool    `json:"passed"`
+	Valuation       int     `json:"valuation"`
+	Price           int     `json:"price"`
+	Profit          int     `json:"profit"`
+	DiscountPercent float64 `json:"discount_percent"`
+	MinProfitSEK    int     `json:"min_profit_sek"`
+	MinDiscount     int     `json:"min_discount"`
+	// Costs is how Profit was computed, fees and costs included.
+	Costs *models.ProfitBreakdown `json:"costs,omitempty"`
+	// RuleID and RuleName identify the trading rule set that was applied.
+	// RuleID is 0 for the built-in defaults.
+	RuleID   int64    `json:"rule_id,omitempty"`
+	RuleName string   `json:"rule_name,omitempty"`
+	Reasons  []string `json:"reasons,omitempty"`
+	// Supply is the competing supply at the time of the decision. It is a
+	// signal only and does not affect Passed.
+	Supply *models.SupplySnapshot `json:"supply,omitempty"`
+	// Expression is the rule set's expression, when it has one. Facts are
+	// the values expressions are evaluated with; they are nil when the
+	// listing could not be checked, e.g. for lack of a price.
+	Expression string          `json:"expression,omitempty"`
+	Facts      *ruleexpr.Facts `json:"facts,omitempty"`
+	// ExposureViolations are the exposure limits buying the listing would
+	// break. The listing still passes but is flagged.
+	ExposureViolations []string `json:"exposure_violations,omitempty"`
+	// Score ranks the listing as a buy. It does not affect Passed but
+	// gates the notification.
+	Score *models.OpportunityScore `json:"score,omitempty"`
+}
+
+// evaluateTradingRules checks a listing against the most specific trading
+// rule set that matches it, falling back to the global one. output is the
+// valuation compiled for the listing when it was scraped, when there is
+// one; it gives the valuation, the price distribution and the confidence.
+// See listingValuation for how the valuation is chosen. Prices in other
+// currencies are converted to SEK before they are compared. The image
+// analysis, when there is one, adds its risks. env holds what is shared
+// between listings; see newTradingRuleEnv.
+func (s *BotService) evaluateTradingRules(ctx context.Context, env *tradingRuleEnv, listing *models.Listing, output *ValuationOutput, images *ImageAnalysis) *TradingRuleVerdict {
+	tradingRules, product := s.tradingRulesFor(ctx, env, listing)
+	var dist *PriceDistribution
+	if output != nil {
+		dist = output.Distribution
+	}
+	verdict := &TradingRuleVerdict{
+		MinProfitSEK: ptrVal(tradingRules.MinProfitSEK),
+		MinDiscount:  ptrVal(tradingRules.MinDiscount),
+		RuleID:       tradingRules.ID,
+		RuleName:     tradingRules.Describe(),
+	}
+
+	verdict.Valuation = s.listingValuation(ctx, env, listing, output, images)
+
+	if listing.Price == nil {
+		verdict.Reasons = append(verdict.Reasons, "annonsen saknar pris")
+		return verdict
+	}
+	verdict.Price = *listing.Price
+	if price, ok := listing.PriceMoney(); ok && price.Currency != models.CurrencySEK {
+		sek, err := env.fx.Convert(ctx, price, models.CurrencySEK)
+		if err != nil {
+			verdict.Reasons = append(verdict.Reasons, fmt.Sprintf("kan inte växla %s till SEK: %v", price, err))
+			return verdict
+		}
+		verdict.Price = sek.MajorInt()
+	}
+	profitInput := models.ListingProfitInput(listing, product, verdict.Valuation)
+	profitInput.BuyPrice = verdict.Price
+	if shipping, ok := listing.ShippingMoney(); ok && shipping.Currency != models.CurrencySEK {
+		sek, err := env.fx.Convert(ctx, shipping, models.CurrencySEK)
+		if err != nil {
+			verdict.Reasons = append(verdict.Reasons, fmt.Sprintf("kan inte växla %s till SEK: %v", shipping, err))
+			return verdict
+		}
+		profitInput.BuyShipping = sek.MajorInt()
+	}
+	costs := s.costModel().Calculate(profitInput)
+	verdict.Costs = &costs
+	verdict.Profit = costs.NetProfit
+	verdict.DiscountPercent = costs.ProfitPercent()
+	facts := s.tradingRuleFacts(listing, product, verdict, output, images)
+	verdict.Facts = &facts
+	score := s.scoringModel().Score(models.ScoreInput{
+		NetProfit:  costs.NetProfit,
+		Capital:    costs.BuyPrice + costs.BuyShipping,
+		Confidence: facts.Confidence,
+		DaysToSell: facts.DaysToSell,
+		Risk:       facts.Risk,
+	})
+	verdict.Score = &score
+
+	verdict.Passed = true
+	if verdict.Profit <= verdict.MinProfitSEK {
+		verdict.Passed = false
+		verdict.Reasons = append(verdict.Reasons, fmt.Sprintf("vinst %d kr (kräver >%d kr)", verdict.Profit, verdict.MinProfitSEK))
+	}
+	if verdict.DiscountPercent <= float64(verdict.MinDiscount) {
+		verdict.Passed = false
+		verdict.Reasons = append(verdict.Reasons, fmt.Sprintf("rabatt %.2f%% (kräver >%d%%)", verdict.DiscountPercent, verdict.MinDiscount))
+	}
+	if ok, reason := CheckPercentileProfit(tradingRules, dist, s.costModel(), profitInput); reason != "" {
+		if !ok {
+			verdict.Passed = false
+		}
+		verdict.Reasons = append(verdict.Reasons, reason)
+	}
+	if tradingRules.HasExpression() {
+		verdict.Expression = *tradingRules.Expression
+		if ok, err := ruleexpr.Check(verdict.Expression, facts); err != nil {
+			verdict.Passed = false
+			verdict.Reasons = append(verdict.Reasons, fmt.Sprintf("uttrycket kunde inte utvärderas: %v", err))
+		} else if !ok {
+			verdict.Passed = false
+			verdict.Reasons = append(verdict.Reasons, fmt.Sprintf("uttrycket är inte uppfyllt: %s", verdict.Expression))
+		}
+	}
+	if images.Blocking() {
+		verdict.Passed = false
+	}
+	verdict.Reasons = append(verdict.Reasons, images.Risks()...)
+
+	if verdict.Passed && env.exposure != nil {
+		verdict.ExposureViolations = exposureViolations(env.exposure, listing, product, costs)
+		for _, v := range verdict.ExposureViolations {
+			verdict.Reasons = append(verdict.Reasons, "över gräns: "+v)
+		}
+	}
+
+	if listing.ProductID != nil && s.database != nil && s.valuationService != nil {
+		supply, err := s.valuationService.SupplySnapshot(ctx, *listing.ProductID, verdict.Valuation, listing.ID)
+		if err != nil {
+			s.log(LogLevelWarning, "Failed to get supply snapshot: %v", err)
+		} else {
+			verdict.Supply = supply
+			verdict.Reasons = append(verdict.Reasons, SupplySignal(supply))
+		}
+	}
+
+	return verdict
+}
+
+// TradingRuleFacts returns the values trading rule expressions see for a
+// stored listing, gathered exactly as when the bot checks it. It fails
+// with the reasons when the listing cannot be checked.
+func (s *BotService) TradingRuleFacts(ctx context.Context, listing *models.Listing) (*ruleexpr.Facts, error) {
+	verdict := s.evaluateTradingRules(ctx, s.newTradingRuleEnv(ctx), listing, s.storedValuationOutput(ctx, listing.ID), s.storedImageAnalysis(ctx, listing.ID))
+	if verdict.Facts == nil {
+		return nil, errors.New(strings.Join(verdict.Reasons, "; "))
+	}
+	return verdict.Facts, nil
+}
+
+// listingValuation returns the valuation a listing is judged on: the
+// valuation compiled for it when it was scraped, forecast to the target
+// sell date and lowered for the condition in the photos. The newest
+// product-level valuation is used the same way only when the listing has no
+// compiled valuation, and listing.Valuation, which already includes the
+// condition, when there is neither.
+func (s *BotService) listingValuation(ctx context.Context, env *tradingRuleEnv, listing *models.Listing, output *ValuationOutput, images *ImageAnalysis) int {
+	if listing.ProductID == nil {
+		return listing.Valuation
+	}
+	value := 0
+	if output != nil && output.RecommendedPrice > 0 {
+		value = int(output.RecommendedPrice)
+	} else if s.database != nil {
+		if cv, err := s.database.ComputeWeightedValuationForProduct(ctx, *listing.ProductID); err == nil && cv > 0 {
+			value = cv
+		}
+	}
+	if value <= 0 {
+		return listing.Valuation
+	}
+	adjusted, _ := images.AdjustValuation(s.forecastValuation(ctx, env, *listing.ProductID, value))
+	return adjusted
+}
+
+// forecastValuation returns what a product valued at value today is
+// expected to be worth at the target sell date. The market trend of each
+// product is loaded once per env.
+func (s *BotService) forecastValuation(ctx context.Context, env *tradingRuleEnv, productID int64, value int) int {
+	if s.valuationService == nil || s.database == nil || s.cfg == nil {
+		return value
+	}
+	trend, ok := env.trends[productID]
+	if !ok {
+		var err error
+		if trend, err = s.valuationService.MarketTrend(ctx, productID); err != nil {
+			s.log(LogLevelWarning, "Failed to get market trend of product %d: %v", productID, err)
+		}
+		env.trends[productID] = trend
+	}
+	if trend == nil {
+		return value
+	}
+	return int(math.Round(trend.Model.Forecast(float64(value), s.cfg.Valuation.TargetSellDays)))
+}
+
+// scoringModel returns the configured scoring model, or the defaults.
+func (s *BotService) scoringModel() models.ScoringModel {
+	if s.cfg == nil {
+		return models.ScoringModel{}
+	}
+	return s.cfg.Scoring
+}
+
+// exposureViolations returns the exposure limits buying a listing at the
+// cost in costs would break.
+func exposureViolations(exposure *models.Exposure, listing *models.Listing, product *models.Product, costs models.ProfitBreakdown) []string {
+	category := ""
+	if product != nil && product.Category != nil {
+		category = *product.Category
+	}
+	return exposure.Violations(listing.ProductID, category, costs.BuyPrice+costs.BuyShipping)
+}
+
+// tradingRuleEnv is what checking listings against the trading rules needs
+// besides the listings themselves: the rule sets, the current exposure, FX
+// rates, products and market trends. It is loaded once and shared when many
+// listings are checked, so each check does not query it again.
+type tradingRuleEnv struct {
+	ruleSets []models.Economics
+	// exposure is nil when it could not be loaded; exposure limits are
+	// then not checked.
+	exposure *models.Exposure
+	fx       *FXService
+	products map[int64]*models.Product
+	trends   map[int64]*MarketTrend
+}
+
+// newTradingRuleEnv loads the rule sets and the exposure. Products, market
+// trends and FX rates are loaded as listings need them and kept.
+func (s *BotService) newTradingRuleEnv(ctx context.Context) *tradingRuleEnv {
+	env := &tradingRuleEnv{
+		fx:       NewFXService(s.database),
+		products: make(map[int64]*models.Product),
+		trends:   make(map[int64]*MarketTrend),
+	}
+	if s.database == nil {
+		return env
+	}
+	var err error
+	if env.ruleSets, err = s.database.GetTradingRuleSets(ctx); err != nil {
+		s.log(LogLevelWarning, "Failed to get trading rules: %v", err)
+	}
+	if env.exposure, err = s.database.GetExposure(ctx); err != nil {
+		s.log(LogLevelWarning, "Failed to get exposure: %v", err)
+	}
+	return env
+}
+
+// product returns a catalog product, or nil when it could not be loaded.
+func (s *BotService) product(ctx context.Context, env *tradingRuleEnv, productID int64) *models.Product {
+	if product, ok := env.products[productID]; ok {
+		return product
+	}
+	var product *models.Product
+	if s.database != nil {
+		var err error
+		if product, err = s.database.GetProductByID(ctx, productID); err != nil {
+			s.log(LogLevelWarning, "Failed to get product %d for trading rules: %v", productID, err)
+		}
+	}
+	env.products[productID] = product
+	return product
+}
+
+// tradingRulesFor returns the trading rule set for a listing, or zero
+// thresholds when there is none, and the listing's product when it could
+// be loaded. The product gives the rule scope and the cost of shipping the
+// item on.
+func (s *BotService) tradingRulesFor(ctx context.Context, env *tradingRuleEnv, listing *models.Listing) (*models.Economics, *models.Product) {
+	var product *models.Product
+	if listing.ProductID != nil {
+		product = s.product(ctx, env, *listing.ProductID)
+	}
+	if rules := models.SelectTradingRule(env.ruleSets, db.TradingRuleScopeFor(listing, product)); rules != nil {
+		return rules, product
+	}
+	return &models.Economics{
+		MinProfitSEK: intPtr(0),
+		MinDiscount:  intPtr(0),
+	}, product
+}
+
+// tradingRuleFacts is what trading rule expressions see of a listing: the
+// figures of the verdict so far, the valuation and the photo analysis.
+func (s *BotService) tradingRuleFacts(listing *models.Listing, product *models.Product, verdict *TradingRuleVerdict, output *ValuationOutput, images *ImageAnalysis) ruleexpr.Facts {
+	facts := ruleexpr.Facts{
+		Price:       verdict.Price,
+		Valuation:   verdict.Valuation,
+		Profit:      verdict.Profit,
+		Discount:    verdict.DiscountPercent,
+		Condition:   "unknown",
+		Risk:        images.RiskScore(),
+		Marketplace: s.getMarketplaceName(listing.MarketplaceID),
+		Shipping:    (listing.EligibleForShipping != nil && *listing.EligibleForShipping) || listing.ShippingCost != nil,
+	}
+	if images != nil && images.Condition != "" {
+		facts.Condition = images.Condition
+	}
+	if product != nil && product.Category != nil {
+		facts.Category = *product.Category
+	}
+	if output != nil {
+		facts.Confidence = output.Confidence
+		for _, in := range output.IndividualVals {
+			if in.DaysToSell > 0 && (facts.DaysToSell == 0 || in.DaysToSell < facts.DaysToSell) {
+				facts.DaysToSell = in.DaysToSell
+			}
+		}
+	}
+	return facts
+}
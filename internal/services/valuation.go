@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -21,20 +22,24 @@ import (
 )
 
 const (
-	OrenToKronorFactor       = 100
 	MaxValuationRatio        = 10.0
 	ValuationTypeDatabase    = "Egen databas"
 	ValuationTypeTradera     = "Tradera"
 	ValuationTypeMarketplace = "eBay/Marknadsplatser"
 	ValuationTypeLLMNewPrice = "Nypris (LLM)"
+	ValuationTypeSupply      = "Utbud (aktiva annonser)"
 )
 
 type ValuationMethod interface {
+	// Key is the stable registry key stored in valuation_types.key.
+	Key() string
 	Name() string
 	Priority() int
 	Valuate(ctx context.Context, productInfo ProductInfo) (*ValuationInput, error)
 }
 
+// ValuationInput is one method's valuation of a product. Value is in whole
+// SEK.
 type ValuationInput struct {
 	Type        string                 `json:"type"`
 	Value       int                    `json:"value"`
@@ -44,53 +49,51 @@ type ValuationInput struct {
 	CollectedAt time.Time              `json:"collected_at"`
 	SoldCount   int                    `json:"sold_count,omitempty"`
 	DaysToSell  int                    `json:"days_to_sell,omitempty"`
+	// Distribution is set by methods whose source exposes the price spread.
+	Distribution *PriceDistribution `json:"distribution,omitempty"`
 }
 
-type ValuationOutput struct {
-	RecommendedPrice float64          `json:"recommended_price"`
-	Confidence       float64          `json:"confidence"`
-	Reasoning        string           `json:"reasoning"`
-	IndividualVals   []ValuationInput `json:"individual_vals"`
-	Valuations       []ValuationInput `json:"valuations,omitempty"`
+// Price returns the valuation as money.
+func (v ValuationInput) Price() models.Money {
+	return models.SEK(v.Value)
 }
 
-type ValuationService struct {
-	cfg          *config.Config
-	database     *db.Postgres
-	llmSvc       *LLMService
-	methods      []ValuationMethod
-	compiler     *ValuationCompiler
-	defaultModel string
-	models       map[string]string
+type ValuationOutput struct {
+	RecommendedPrice float64              `json:"recommended_price"`
+	Confidence       float64              `json:"confidence"`
+	Reasoning        string               `json:"reasoning"`
+	IndividualVals   []ValuationInput     `json:"individual_vals"`
+	Valuations       []ValuationInput     `json:"valuations,omitempty"`
+	Strategy         string               `json:"strategy,omitempty"`
+	Discarded        []DiscardedValuation `json:"discarded,omitempty"`
+	Distribution     *PriceDistribution   `json:"distribution,omitempty"`
+	// PromptVersion is the prompt template used when the LLM compiled the
+	// valuation.
+	PromptVersion string `json:"prompt_version,omitempty"`
+	// LLMModel is the model that compiled the valuation.
+	LLMModel string `json:"llm_model,omitempty"`
 }
 
-// Simple in-memory cache for Tradera responses
-var traderaCache = struct {
-	mu sync.RWMutex
-	m  map[string]cacheEntry
-}{m: make(map[string]cacheEntry)}
+type ValuationService struct {
+	cfg      *config.Config
+	database *db.Postgres
+	llmSvc   *LLMService
+	methods  []ValuationMethod
+	compiler *ValuationCompiler
 
-type cacheEntry struct {
-	Val       ValuationInput
-	Collected time.Time
+	sourceCache     *ValuationSourceCache
+	sourceCacheOnce sync.Once
+	registryMu      sync.RWMutex
+	registry        map[string]ValuationMethodSettings
+	registryLoaded  bool
 }
 
 func NewValuationService(cfg *config.Config, database *db.Postgres, llmSvc *LLMService) *ValuationService {
-	var defaultModel string
-	var models map[string]string
-
-	if cfg != nil {
-		defaultModel = cfg.LLM.DefaultModel
-		models = cfg.LLM.Models
-	}
-
 	svc := &ValuationService{
-		cfg:          cfg,
-		database:     database,
-		llmSvc:       llmSvc,
-		methods:      make([]ValuationMethod, 0),
-		defaultModel: defaultModel,
-		models:       models,
+		cfg:      cfg,
+		database: database,
+		llmSvc:   llmSvc,
+		methods:  make([]ValuationMethod, 0),
 	}
 
 	svc.compiler = NewValuationCompiler(cfg, llmSvc)
@@ -98,11 +101,18 @@ func NewValuationService(cfg *config.Config, database *db.Postgres, llmSvc *LLMS
 	svc.RegisterMethod(&LLMNewPriceMethod{svc: svc})
 	svc.RegisterMethod(&TraderaValuationMethod{svc: svc})
 	svc.RegisterMethod(&SoldAdsValuationMethod{svc: svc})
+	svc.RegisterMethod(&SupplyValuationMethod{svc: svc})
 
 	return svc
 }
 
+// RegisterMethod adds a valuation method. It is registered against the
+// valuation_types table by its key the next time valuations are collected.
 func (s *ValuationService) RegisterMethod(m ValuationMethod) {
+	s.registryMu.Lock()
+	s.registryLoaded = false
+	s.registryMu.Unlock()
+
 	s.methods = append(s.methods, m)
 	sort.Slice(s.methods, func(i, j int) bool {
 		return s.methods[i].Priority() < s.methods[j].Priority()
@@ -110,6 +120,9 @@ func (s *ValuationService) RegisterMethod(m ValuationMethod) {
 }
 
 func (s *ValuationService) CollectAll(ctx context.Context, productID string, productInfo ProductInfo) ([]ValuationInput, error) {
+	if id, err := strconv.ParseInt(productID, 10, 64); err == nil {
+		productInfo.ProductID = id
+	}
 	inputs, _ := s.CollectAllWithErrors(ctx, productInfo)
 	return inputs, nil
 }
@@ -123,6 +136,8 @@ func (s *ValuationService) CollectAllWithErrors(ctx context.Context, productInfo
 	var inputs []ValuationInput
 	var results []CollectResult
 
+	s.loadRegistry(ctx)
+
 	for _, method := range s.methods {
 		select {
 		case <-ctx.Done():
@@ -130,7 +145,12 @@ func (s *ValuationService) CollectAllWithErrors(ctx context.Context, productInfo
 		default:
 		}
 
-		input, err := method.Valuate(ctx, productInfo)
+		settings := s.MethodSettings(method.Key())
+		if !settings.Enabled {
+			continue
+		}
+
+		input, err := s.valuate(ctx, method, settings, productInfo)
 		if err != nil {
 			log.Printf("Valuation method %s failed: %v", method.Name(), err)
 			results = append(results, CollectResult{Input: &ValuationInput{Type: method.Name()}, Error: err.Error()})
@@ -146,11 +166,52 @@ func (s *ValuationService) CollectAllWithErrors(ctx context.Context, productInfo
 	return inputs, results
 }
 
+func (s *ValuationService) valuate(ctx context.Context, method ValuationMethod, settings ValuationMethodSettings, productInfo ProductInfo) (*ValuationInput, error) {
+	if settings.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, settings.Timeout)
+		defer cancel()
+	}
+	return method.Valuate(ctx, productInfo)
+}
+
+// InvalidateSourceCache drops cached valuation source responses. See
+// ValuationSourceCache.Invalidate.
+func (s *ValuationService) InvalidateSourceCache(ctx context.Context, methodKey, key string) (int64, error) {
+	return s.cache().Invalidate(ctx, methodKey, key)
+}
+
+func (s *ValuationService) cache() *ValuationSourceCache {
+	s.sourceCacheOnce.Do(func() {
+		if s.sourceCache == nil {
+			s.sourceCache = NewValuationSourceCache(s.database)
+		}
+	})
+	return s.sourceCache
+}
+
 func (s *ValuationService) Compile(ctx context.Context, inputs []ValuationInput) (*ValuationOutput, error) {
 	return s.compiler.Compile(ctx, inputs)
 }
 
+// CompileForProduct compiles inputs using the product's own compile strategy
+// and its catalog new price as the sanity reference when available.
+func (s *ValuationService) CompileForProduct(ctx context.Context, product *models.Product, inputs []ValuationInput) (*ValuationOutput, error) {
+	opts := CompileOptions{}
+	if product != nil {
+		if product.ValuationStrategy != nil {
+			opts.Strategy = CompileStrategy(*product.ValuationStrategy)
+		}
+		if product.NewPrice != nil {
+			opts.NewPrice = float64(*product.NewPrice)
+		}
+	}
+	return s.compiler.CompileWithOptions(ctx, inputs, opts)
+}
+
 func (s *ValuationService) SaveValuations(ctx context.Context, productID string, inputs []ValuationInput) error {
+	s.loadRegistry(ctx)
+
 	var firstErr error
 	for _, input := range inputs {
 		metadataJSON, err := json.Marshal(input.Metadata)
@@ -168,7 +229,7 @@ func (s *ValuationService) SaveValuations(ctx context.Context, productID string,
 
 		vid := s.getValuationTypeID(input.Type)
 		if vid == 0 {
-			log.Printf("Unknown valuation type: %s, skipping", input.Type)
+			log.Printf("No registered valuation type for %s, skipping", input.Type)
 			continue
 		}
 
@@ -189,21 +250,6 @@ func (s *ValuationService) SaveValuations(ctx context.Context, productID string,
 	return firstErr
 }
 
-func (s *ValuationService) getValuationTypeID(typeName string) int16 {
-	switch typeName {
-	case ValuationTypeDatabase:
-		return 1
-	case ValuationTypeTradera:
-		return 2
-	case ValuationTypeMarketplace:
-		return 3
-	case ValuationTypeLLMNewPrice:
-		return 4
-	default:
-		return 0
-	}
-}
-
 func (s *ValuationService) GetHistoricalValuation(ctx context.Context, productID string) (*HistoricalValuation, error) {
 	items, err := s.database.GetSoldTradedItems(ctx, 100)
 	if err != nil {
@@ -229,7 +275,7 @@ func (s *ValuationService) GetHistoricalValuation(ctx context.Context, productID
 			daysOnMarket = 7
 		}
 		x := float64(daysOnMarket)
-		y := float64(*item.SellPrice) / OrenToKronorFactor
+		y := models.Ore(*item.SellPrice).Major()
 
 		sumX += x
 		sumY += y
@@ -264,8 +310,19 @@ func (s *ValuationService) CalculatePriceForDays(targetDays int, valuation *Hist
 	return valuation.Intercept + valuation.KValue*float64(targetDays)
 }
 
+// CalculateProfit returns the net profit of buying at buyPrice plus
+// shippingCost and selling at estimatedSellPrice, after the fees and costs
+// of the configured cost model.
 func (s *ValuationService) CalculateProfit(buyPrice, shippingCost, estimatedSellPrice float64) float64 {
-	return estimatedSellPrice - buyPrice - shippingCost
+	var costs models.CostModel
+	if s.cfg != nil {
+		costs = s.cfg.Costs
+	}
+	return float64(costs.Calculate(models.ProfitInput{
+		BuyPrice:    int(math.Round(buyPrice)),
+		BuyShipping: int(math.Round(shippingCost)),
+		SellPrice:   int(math.Round(estimatedSellPrice)),
+	}).NetProfit)
 }
 
 func (s *ValuationService) CalculateProfitMargin(profit, buyPrice, shippingCost float64) float64 {
@@ -301,6 +358,10 @@ type DatabaseValuationMethod struct {
 	svc *ValuationService
 }
 
+func (m *DatabaseValuationMethod) Key() string {
+	return ValuationKeyDatabase
+}
+
 func (m *DatabaseValuationMethod) Name() string {
 	return ValuationTypeDatabase
 }
@@ -320,6 +381,7 @@ func (m *DatabaseValuationMethod) Valuate(ctx context.Context, productInfo Produ
 	}
 
 	var sumPrice, sumWeight float64
+	var prices []float64
 	for _, item := range soldItems {
 		if item.SellPrice == nil {
 			continue
@@ -327,18 +389,20 @@ func (m *DatabaseValuationMethod) Valuate(ctx context.Context, productInfo Produ
 		weight := m.calculateWeight(item)
 		sumPrice += float64(*item.SellPrice) * weight
 		sumWeight += weight
+		prices = append(prices, models.Ore(*item.SellPrice).Major())
 	}
 
-	estimatedPrice := (sumPrice / sumWeight) / OrenToKronorFactor
+	estimatedPrice := models.Ore(int(math.Round(sumPrice / sumWeight))).Major()
 	confidence := m.calculateConfidence(soldItems)
 
 	return &ValuationInput{
-		Type:        m.Name(),
-		Value:       int(estimatedPrice),
-		Confidence:  confidence,
-		SourceURL:   "",
-		Metadata:    map[string]interface{}{"data_points": len(soldItems)},
-		CollectedAt: time.Now(),
+		Type:         m.Name(),
+		Value:        int(estimatedPrice),
+		Confidence:   confidence,
+		SourceURL:    "",
+		Metadata:     map[string]interface{}{"data_points": len(soldItems)},
+		CollectedAt:  time.Now(),
+		Distribution: NewPriceDistribution(prices),
 	}, nil
 }
 
@@ -398,6 +462,10 @@ type LLMNewPriceMethod struct {
 	svc *ValuationService
 }
 
+func (m *LLMNewPriceMethod) Key() string {
+	return ValuationKeyLLMNewPrice
+}
+
 func (m *LLMNewPriceMethod) Name() string {
 	return ValuationTypeLLMNewPrice
 }
@@ -411,54 +479,33 @@ func (m *LLMNewPriceMethod) Valuate(ctx context.Context, productInfo ProductInfo
 		return nil, fmt.Errorf("no product info available for LLM valuation")
 	}
 
-	prompt := fmt.Sprintf(`Estimate the NEW retail price in Swedish kronor (SEK) for this product:
-
-Product information:
-- Manufacturer: %s
-- Model: %s
-- Category: %s
-- Condition: %s
-- Storage: %s
-
-Ad description: %s
-
-Consider:
-- Current market conditions
-- Brand and model reputation
-- Product age and condition
-- Storage capacity (if applicable)
-
-Return ONLY a JSON object:
-{"price": 1500, "confidence": 75, "reasoning": "..."}
-
-JSON output:`, productInfo.Manufacturer, productInfo.Model, productInfo.Category, productInfo.Condition, productInfo.Storage, productInfo.AdText)
-
-	model := m.svc.llmSvc.client.GetModel("NewPrice", m.svc.defaultModel, m.svc.models)
-
-	content, err := m.svc.llmSvc.client.Chat(ctx, model, prompt)
+	prompt, err := m.svc.llmSvc.prompts.Render(ctx, PromptNewPrice, productInfo)
 	if err != nil {
-		return nil, fmt.Errorf("LLM API error: %w", err)
+		return nil, err
 	}
 
-	content = cleanupMarkdownJSON(content)
-
 	type LLMResponse struct {
-		Price      int    `json:"price"`
-		Confidence int    `json:"confidence"`
-		Reasoning  string `json:"reasoning"`
+		Price      int     `json:"price"`
+		Confidence float64 `json:"confidence"`
+		Reasoning  string  `json:"reasoning"`
 	}
 
 	var response LLMResponse
-	if err := json.Unmarshal([]byte(content), &response); err != nil {
-		return nil, fmt.Errorf("failed to parse LLM response: %w", err)
+	model, err := m.svc.llmSvc.chatJSON(ctx, "NewPrice", prompt, newPriceSchema, &response)
+	if err != nil {
+		if errors.Is(err, ErrLLMBudgetExceeded) {
+			log.Printf("Skipping %s: %v", m.Name(), err)
+			return nil, nil
+		}
+		return nil, err
 	}
 
 	return &ValuationInput{
 		Type:        m.Name(),
 		Value:       response.Price,
-		Confidence:  float64(response.Confidence),
+		Confidence:  percentConfidence(response.Confidence),
 		SourceURL:   "",
-		Metadata:    map[string]interface{}{"reasoning": response.Reasoning},
+		Metadata:    map[string]interface{}{"reasoning": response.Reasoning, "prompt_version": prompt.ID(), "llm_model": model},
 		CollectedAt: time.Now(),
 	}, nil
 }
@@ -467,6 +514,10 @@ type TraderaValuationMethod struct {
 	svc *ValuationService
 }
 
+func (m *TraderaValuationMethod) Key() string {
+	return ValuationKeyTradera
+}
+
 func (m *TraderaValuationMethod) Name() string {
 	return ValuationTypeTradera
 }
@@ -553,176 +604,128 @@ func (m *TraderaValuationMethod) Valuate(ctx context.Context, productInfo Produc
 	averages := make(map[string]int)
 	bestCats := make(map[string]map[string]interface{})
 	confidences := make(map[string]float64)
+	distributions := make(map[string]*PriceDistribution)
 
-	cacheTTL := 5 * time.Minute
-
-	// Fetch page/cookies only if we need to call Tradera API for any non-cached query
-	needFetch := false
-	for _, q := range queries {
-		cacheKey := "tradera-valuation:" + q
-		traderaCache.mu.RLock()
-		if e, ok := traderaCache.m[cacheKey]; ok {
-			if time.Since(e.Collected) < cacheTTL {
-				cached := e.Val
-				prices[q] = cached.Value
-
-				// Try to extract count/median/average from cached metadata
-				if cached.Metadata != nil {
-					if v, ok := cached.Metadata["count"]; ok {
-						switch t := v.(type) {
-						case int:
-							counts[q] = t
-						case float64:
-							counts[q] = int(t)
-						case int64:
-							counts[q] = int(t)
-						default:
-							counts[q] = 1
-						}
-					} else {
-						counts[q] = 1
-					}
-
-					if v, ok := cached.Metadata["median_price"]; ok {
-						switch t := v.(type) {
-						case int:
-							medians[q] = t
-						case float64:
-							medians[q] = int(t)
-						default:
-							medians[q] = cached.Value
-						}
-					} else {
-						medians[q] = cached.Value
-					}
-
-					if v, ok := cached.Metadata["average_price"]; ok {
-						switch t := v.(type) {
-						case int:
-							averages[q] = t
-						case float64:
-							averages[q] = int(t)
-						default:
-							averages[q] = cached.Value
-						}
-					} else {
-						averages[q] = cached.Value
-					}
-
-					// Confidence stored on the cached ValuationInput
-					confidences[q] = cached.Confidence
-				} else {
-					counts[q] = 1
-					medians[q] = cached.Value
-					averages[q] = cached.Value
-					confidences[q] = cached.Confidence
-				}
-
-				traderaCache.mu.RUnlock()
-				continue
-			}
-		}
-		traderaCache.mu.RUnlock()
-		needFetch = true
-	}
+	settings := m.svc.MethodSettings(m.Key())
+	cacheStatus := make(map[string]CacheStatus)
 
+	// The valuation page is only fetched for cookies when some query is not
+	// served from the cache.
+	var sessionMu sync.Mutex
 	var pageCookies []*http.Cookie
 	var apiBaseURL string
-	if needFetch {
+	openSession := func(ctx context.Context) (string, []*http.Cookie, error) {
+		sessionMu.Lock()
+		defer sessionMu.Unlock()
+		if apiBaseURL != "" {
+			return apiBaseURL, pageCookies, nil
+		}
+
 		pageReq, err := http.NewRequestWithContext(ctx, "GET", basePageURL, nil)
 		if err != nil {
-			return nil, fmt.Errorf("failed to build tradera page request: %w", err)
+			return "", nil, fmt.Errorf("failed to build tradera page request: %w", err)
 		}
 		pageReq.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
 
 		pageResp, err := client.Do(pageReq)
 		if err != nil {
-			return nil, fmt.Errorf("tradera page request failed: %w", err)
+			return "", nil, fmt.Errorf("tradera page request failed: %w", err)
 		}
 		io.Copy(io.Discard, pageResp.Body)
-		pageCookies = pageResp.Cookies()
+		cookies := pageResp.Cookies()
 		pageResp.Body.Close()
 
 		parsedBase, err := url.Parse(basePageURL)
 		if err != nil {
-			return nil, fmt.Errorf("invalid base url: %w", err)
+			return "", nil, fmt.Errorf("invalid base url: %w", err)
 		}
 		apiBaseURL = fmt.Sprintf("%s://%s/valuationsearch", parsedBase.Scheme, parsedBase.Host)
-	} else {
-		// still need apiBaseURL for constructing source URLs
-		parsedBase, _ := url.Parse(basePageURL)
-		apiBaseURL = fmt.Sprintf("%s://%s/valuationsearch", parsedBase.Scheme, parsedBase.Host)
+		pageCookies = cookies
+		return apiBaseURL, pageCookies, nil
 	}
 
-	// For each query not cached, call Tradera API
 	for _, q := range queries {
-		if _, ok := prices[q]; ok {
-			continue
-		}
-		result, bestCategoryID, bestCategoryName, err := getResultForQuery(ctx, client, apiBaseURL, pageCookies, q)
+		q := q
+		fetch := func(ctx context.Context) (*ValuationInput, error) {
+			base, cookies, err := openSession(ctx)
+			if err != nil {
+				return nil, err
+			}
+			result, bestCategoryID, bestCategoryName, err := getResultForQuery(ctx, client, base, cookies, q)
+			if err != nil {
+				return nil, err
+			}
+
+			// result prices are floats; pick median then average and convert to int
+			var priceFloat float64
+			if result.MedianPrice > 0 {
+				priceFloat = result.MedianPrice
+			} else if result.AveragePrice > 0 {
+				priceFloat = result.AveragePrice
+			}
+			price := int(math.Round(priceFloat))
+			if price <= 0 {
+				return nil, fmt.Errorf("inga priser hittades för sökning: %s - response: %+v", q, result)
+			}
+
+			confidence := 0.5
+			if result.Count >= 10 {
+				confidence = 0.7
+			}
+			if result.Count >= 50 {
+				confidence = 0.85
+			}
+
+			valuationURL := fmt.Sprintf("https://www.tradera.com/valuation?query=%s", url.QueryEscape(q))
+			if bestCategoryID > 0 {
+				valuationURL += fmt.Sprintf("&categoryId=%d", bestCategoryID)
+			}
+
+			return &ValuationInput{
+				Type:       m.Name(),
+				Value:      price,
+				Confidence: confidence,
+				SourceURL:  valuationURL,
+				Metadata: map[string]interface{}{
+					"query":         q,
+					"category_id":   bestCategoryID,
+					"category_name": bestCategoryName,
+					"count":         result.Count,
+					"lowest_price":  result.LowestPrice,
+					"highest_price": result.HighestPrice,
+					"median_price":  result.MedianPrice,
+					"average_price": result.AveragePrice,
+				},
+				CollectedAt: time.Now(),
+				Distribution: &PriceDistribution{
+					Min:        result.LowestPrice,
+					P50:        priceFloat,
+					Max:        result.HighestPrice,
+					SampleSize: result.Count,
+				},
+			}, nil
+		}
+
+		vi, status, err := m.svc.cache().GetOrFetch(ctx, m.Key(), "tradera-valuation:"+q, settings, fetch)
 		if err != nil {
 			// don't fail the whole Valuate if one query fails; log and continue
 			log.Printf("Tradera valuation for query %q failed: %v", q, err)
 			continue
 		}
-
-		// result prices are floats; pick median then average and convert to int
-		var priceFloat float64
-		if result.MedianPrice > 0 {
-			priceFloat = result.MedianPrice
-		} else if result.AveragePrice > 0 {
-			priceFloat = result.AveragePrice
-		}
-		price := int(math.Round(priceFloat))
-		if price <= 0 {
-			log.Printf("Tradera: inga priser hittades för sökning: %s - response: %+v", q, result)
+		if vi == nil {
 			continue
 		}
 
-		confidence := 0.5
-		if result.Count >= 10 {
-			confidence = 0.7
-		}
-		if result.Count >= 50 {
-			confidence = 0.85
-		}
-
-		// Build ValuationInput for caching
-		valuationURL := fmt.Sprintf("https://www.tradera.com/valuation?query=%s", url.QueryEscape(q))
-		if bestCategoryID > 0 {
-			valuationURL += fmt.Sprintf("&categoryId=%d", bestCategoryID)
-		}
-
-		vi := ValuationInput{
-			Type:       m.Name(),
-			Value:      price,
-			Confidence: confidence,
-			SourceURL:  valuationURL,
-			Metadata: map[string]interface{}{
-				"query":         q,
-				"category_id":   bestCategoryID,
-				"category_name": bestCategoryName,
-				"count":         result.Count,
-				"lowest_price":  result.LowestPrice,
-				"highest_price": result.HighestPrice,
-				"median_price":  result.MedianPrice,
-				"average_price": result.AveragePrice,
-			},
-			CollectedAt: time.Now(),
+		prices[q] = vi.Value
+		counts[q] = metadataInt(vi.Metadata, "count", 1)
+		medians[q] = metadataInt(vi.Metadata, "median_price", vi.Value)
+		averages[q] = metadataInt(vi.Metadata, "average_price", vi.Value)
+		confidences[q] = vi.Confidence
+		distributions[q] = vi.Distribution
+		cacheStatus[q] = status
+		if categoryID := metadataInt(vi.Metadata, "category_id", 0); categoryID > 0 {
+			bestCats[q] = map[string]interface{}{"category_id": categoryID, "category_name": vi.Metadata["category_name"]}
 		}
-
-		// Cache the individual query result
-		cacheKey := "tradera-valuation:" + q
-		traderaCache.mu.Lock()
-		traderaCache.m[cacheKey] = cacheEntry{Val: vi, Collected: time.Now()}
-		traderaCache.mu.Unlock()
-
-		prices[q] = price
-		counts[q] = result.Count
-		medians[q] = int(math.Round(result.MedianPrice))
-		averages[q] = int(math.Round(result.AveragePrice))
-		confidences[q] = confidence
-		bestCats[q] = map[string]interface{}{"category_id": bestCategoryID, "category_name": bestCategoryName}
 	}
 
 	// If we have multiple prices, compute weighted average by counts when available
@@ -791,21 +794,36 @@ func (m *TraderaValuationMethod) Valuate(ctx context.Context, productInfo Produc
 			"average_price": averages[q],
 			"count":         counts[q],
 			"source_url":    srcURL,
+			"cache":         cacheStatus[q],
 		}
 		if bc, ok := bestCats[q]; ok {
 			breakdown[q].(map[string]interface{})["category"] = bc
 		}
 	}
 	metadata["breakdown"] = breakdown
+	cacheHits := 0
+	for _, status := range cacheStatus {
+		if status != CacheMiss {
+			cacheHits++
+		}
+	}
+	metadata["cache_hits"] = cacheHits
+
+	// Merge per-query distributions, weighted by each query's confidence
+	queryInputs := make([]ValuationInput, 0, len(distributions))
+	for q, d := range distributions {
+		queryInputs = append(queryInputs, ValuationInput{Confidence: confidences[q], Distribution: d})
+	}
 
 	// Construct combined ValuationInput (average saved to DB)
 	vi := ValuationInput{
-		Type:        m.Name(),
-		Value:       avg,
-		Confidence:  combinedConfidence, // combined confidence from queries
-		SourceURL:   "",
-		Metadata:    metadata,
-		CollectedAt: time.Now(),
+		Type:         m.Name(),
+		Value:        avg,
+		Confidence:   combinedConfidence, // combined confidence from queries
+		SourceURL:    "",
+		Metadata:     metadata,
+		CollectedAt:  time.Now(),
+		Distribution: combineDistributions(queryInputs),
 	}
 
 	return &vi, nil
@@ -878,6 +896,10 @@ type SoldAdsValuationMethod struct {
 	svc *ValuationService
 }
 
+func (m *SoldAdsValuationMethod) Key() string {
+	return ValuationKeyMarketplace
+}
+
 func (m *SoldAdsValuationMethod) Name() string {
 	return ValuationTypeMarketplace
 }
@@ -928,107 +950,17 @@ func (m *SoldAdsValuationMethod) Valuate(ctx context.Context, productInfo Produc
 	}, nil
 }
 
-type ValuationCompiler struct {
-	cfg    *config.Config
-	llmSvc *LLMService
-}
-
-func NewValuationCompiler(cfg *config.Config, llmSvc *LLMService) *ValuationCompiler {
-	return &ValuationCompiler{
-		cfg:    cfg,
-		llmSvc: llmSvc,
-	}
-}
-
-func (c *ValuationCompiler) Compile(ctx context.Context, inputs []ValuationInput) (*ValuationOutput, error) {
-	if len(inputs) == 0 {
-		return &ValuationOutput{
-			RecommendedPrice: 0,
-			Confidence:       0,
-			Reasoning:        "Inga värderingsmetoder tillgängliga",
-			IndividualVals:   []ValuationInput{},
-		}, nil
-	}
-
-	validInputs := make([]ValuationInput, 0)
-	for _, input := range inputs {
-		if input.Value > 0 && input.Confidence > 0 {
-			validInputs = append(validInputs, input)
-		}
-	}
-
-	if len(validInputs) == 0 {
-		return &ValuationOutput{
-			RecommendedPrice: 0,
-			Confidence:       0,
-			Reasoning:        "Inga giltiga värderingar tillgängliga",
-			IndividualVals:   inputs,
-		}, nil
-	}
-
-	if c.llmSvc != nil && len(validInputs) >= 2 {
-		return c.compileWithLLM(ctx, validInputs, inputs)
-	}
-
-	return c.compileWeightedAverage(validInputs, inputs)
-}
-
-func (c *ValuationCompiler) compileWithLLM(ctx context.Context, validInputs []ValuationInput, allInputs []ValuationInput) (*ValuationOutput, error) {
-	result, err := c.llmSvc.CompileValuations(ctx, validInputs, "")
-	if err != nil {
-		log.Printf("LLM compilation failed, falling back to weighted average: %v", err)
-		return c.compileWeightedAverage(validInputs, allInputs)
-	}
-
-	result.IndividualVals = allInputs
-	return result, nil
-}
-
-func (c *ValuationCompiler) compileWeightedAverage(inputs []ValuationInput, allInputs []ValuationInput) (*ValuationOutput, error) {
-	var sumPrice, sumConfidence, totalWeight float64
-
-	newPrice := c.extractLLMNewPrice(inputs)
-
-	for _, input := range inputs {
-		weight := input.Confidence * input.Confidence
-		sumPrice += float64(input.Value) * weight
-		sumConfidence += input.Confidence * weight
-		totalWeight += weight
-
-		if newPrice > 0 && input.Type == ValuationTypeDatabase {
-			ratio := float64(input.Value) / newPrice
-			if ratio > MaxValuationRatio {
-				log.Printf("WARNING: Valuation for '%s' is %.0fx above new price (value=%d, newPrice=%.0f)",
-					input.Type, ratio, input.Value, newPrice)
-			}
-		}
-	}
-
-	if totalWeight == 0 {
-		return &ValuationOutput{
-			RecommendedPrice: 0,
-			Confidence:       0,
-			Reasoning:        "Kan inte beräkna viktat genomsnitt",
-			IndividualVals:   allInputs,
-		}, nil
-	}
-
-	recommendedPrice := sumPrice / totalWeight
-	avgConfidence := sumConfidence / totalWeight
-
-	return &ValuationOutput{
-		RecommendedPrice: recommendedPrice,
-		Confidence:       avgConfidence,
-		Reasoning:        fmt.Sprintf("Viktat genomsnitt baserat på %d metoder", len(inputs)),
-		IndividualVals:   allInputs,
-	}, nil
-}
-
-func (c *ValuationCompiler) extractLLMNewPrice(inputs []ValuationInput) float64 {
-	for _, input := range inputs {
-		if input.Type == ValuationTypeLLMNewPrice && input.Value > 0 {
-			return float64(input.Value)
-		}
+// metadataInt reads a numeric metadata value, which may have been decoded
+// from JSON as float64.
+func metadataInt(metadata map[string]interface{}, key string, def int) int {
+	switch v := metadata[key].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(math.Round(v))
+	default:
+		return def
 	}
-	return 0
 }
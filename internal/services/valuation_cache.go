@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"begbot/internal/db"
+	"begbot/internal/models"
+)
+
+// CacheStatus tells where a valuation source response came from. It is
+// recorded in the valuation metadata under "cache".
+type CacheStatus string
+
+const (
+	CacheMiss  CacheStatus = "miss"
+	CacheHit   CacheStatus = "hit"
+	CacheStale CacheStatus = "stale"
+)
+
+const (
+	defaultSourceCacheTTL = 5 * time.Minute
+	sourceRefreshTimeout  = 2 * time.Minute
+)
+
+type sourceCacheEntry struct {
+	methodKey string
+	input     ValuationInput
+	fetchedAt time.Time
+	expiresAt time.Time
+}
+
+// ValuationSourceCache caches responses from valuation sources. Entries are
+// kept in memory and in the valuation_cache table, so the API server, the
+// scheduler and cmd/fetchads share them. Entries older than the method's
+// cache TTL but within its stale TTL are served while a background refresh
+// runs.
+type ValuationSourceCache struct {
+	database   *db.Postgres
+	mu         sync.Mutex
+	local      map[string]sourceCacheEntry
+	refreshing map[string]bool
+	now        func() time.Time
+}
+
+func NewValuationSourceCache(database *db.Postgres) *ValuationSourceCache {
+	return &ValuationSourceCache{
+		database:   database,
+		local:      make(map[string]sourceCacheEntry),
+		refreshing: make(map[string]bool),
+		now:        time.Now,
+	}
+}
+
+// GetOrFetch returns the cached input for key, calling fetch when there is
+// no usable entry. A nil cache always fetches.
+func (c *ValuationSourceCache) GetOrFetch(ctx context.Context, methodKey, key string, settings ValuationMethodSettings, fetch func(ctx context.Context) (*ValuationInput, error)) (*ValuationInput, CacheStatus, error) {
+	if c == nil {
+		input, err := fetch(ctx)
+		return input, CacheMiss, err
+	}
+
+	ttl := settings.CacheTTL
+	if ttl <= 0 {
+		ttl = defaultSourceCacheTTL
+	}
+
+	if entry, ok := c.lookup(ctx, key); ok {
+		age := c.now().Sub(entry.fetchedAt)
+		if age < ttl {
+			input := entry.input
+			return &input, CacheHit, nil
+		}
+		if age < ttl+settings.StaleTTL {
+			c.refreshInBackground(methodKey, key, settings, fetch)
+			input := entry.input
+			return &input, CacheStale, nil
+		}
+	}
+
+	input, err := fetch(ctx)
+	if err != nil {
+		return nil, CacheMiss, err
+	}
+	if input != nil {
+		c.store(ctx, methodKey, key, *input, settings)
+	}
+	return input, CacheMiss, nil
+}
+
+// Invalidate removes cached entries. An empty methodKey matches all methods
+// and an empty key all keys of the method.
+func (c *ValuationSourceCache) Invalidate(ctx context.Context, methodKey, key string) (int64, error) {
+	var removed int64
+	c.mu.Lock()
+	for k, e := range c.local {
+		if (methodKey == "" || e.methodKey == methodKey) && (key == "" || k == key) {
+			delete(c.local, k)
+			removed++
+		}
+	}
+	c.mu.Unlock()
+
+	if c.database == nil {
+		return removed, nil
+	}
+	return c.database.DeleteValuationCache(ctx, methodKey, key)
+}
+
+func (c *ValuationSourceCache) lookup(ctx context.Context, key string) (sourceCacheEntry, bool) {
+	c.mu.Lock()
+	entry, ok := c.local[key]
+	c.mu.Unlock()
+	if ok && c.now().Before(entry.expiresAt) {
+		return entry, true
+	}
+
+	if c.database == nil {
+		return sourceCacheEntry{}, false
+	}
+	row, err := c.database.GetValuationCacheEntry(ctx, key)
+	if err != nil {
+		log.Printf("Failed to read valuation cache %s: %v", key, err)
+		return sourceCacheEntry{}, false
+	}
+	if row == nil {
+		return sourceCacheEntry{}, false
+	}
+	var input ValuationInput
+	if err := json.Unmarshal(row.Payload, &input); err != nil {
+		log.Printf("Invalid valuation cache entry %s: %v", key, err)
+		return sourceCacheEntry{}, false
+	}
+	entry = sourceCacheEntry{methodKey: row.MethodKey, input: input, fetchedAt: row.FetchedAt, expiresAt: row.ExpiresAt}
+
+	c.mu.Lock()
+	c.local[key] = entry
+	c.mu.Unlock()
+	return entry, true
+}
+
+func (c *ValuationSourceCache) store(ctx context.Context, methodKey, key string, input ValuationInput, settings ValuationMethodSettings) {
+	ttl := settings.CacheTTL
+	if ttl <= 0 {
+		ttl = defaultSourceCacheTTL
+	}
+	now := c.now()
+	entry := sourceCacheEntry{
+		methodKey: methodKey,
+		input:     input,
+		fetchedAt: now,
+		expiresAt: now.Add(ttl + settings.StaleTTL),
+	}
+
+	c.mu.Lock()
+	c.local[key] = entry
+	c.mu.Unlock()
+
+	if c.database == nil {
+		return
+	}
+	payload, err := json.Marshal(input)
+	if err != nil {
+		log.Printf("Failed to encode valuation cache entry %s: %v", key, err)
+		return
+	}
+	err = c.database.SetValuationCacheEntry(ctx, &models.ValuationCacheEntry{
+		CacheKey:  key,
+		MethodKey: methodKey,
+		Payload:   payload,
+		FetchedAt: entry.fetchedAt,
+		ExpiresAt: entry.expiresAt,
+	})
+	if err != nil {
+		log.Printf("Failed to write valuation cache %s: %v", key, err)
+	}
+}
+
+// refreshInBackground refetches a stale entry unless a refresh of the same
+// key is already running.
+func (c *ValuationSourceCache) refreshInBackground(methodKey, key string, settings ValuationMethodSettings, fetch func(ctx context.Context) (*ValuationInput, error)) {
+	c.mu.Lock()
+	if c.refreshing[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.refreshing[key] = true
+	c.mu.Unlock()
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.refreshing, key)
+			c.mu.Unlock()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), sourceRefreshTimeout)
+		defer cancel()
+
+		input, err := fetch(ctx)
+		if err != nil {
+			log.Printf("Background refresh of %s failed: %v", key, err)
+			return
+		}
+		if input != nil {
+			c.store(ctx, methodKey, key, *input, settings)
+		}
+	}()
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestValuationSourceCache_GetOrFetch(t *testing.T) {
+	cache := NewValuationSourceCache(nil)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	settings := ValuationMethodSettings{CacheTTL: time.Hour, StaleTTL: time.Hour}
+	calls := 0
+	refreshed := make(chan struct{}, 1)
+	fetch := func(ctx context.Context) (*ValuationInput, error) {
+		calls++
+		if calls > 1 {
+			defer func() { refreshed <- struct{}{} }()
+		}
+		return &ValuationInput{Type: "Test", Value: 1000 * calls}, nil
+	}
+
+	v, status, err := cache.GetOrFetch(context.Background(), "test", "k", settings, fetch)
+	if err != nil || status != CacheMiss || v.Value != 1000 {
+		t.Fatalf("Expected miss with 1000, got %v %s %v", v, status, err)
+	}
+
+	now = now.Add(30 * time.Minute)
+	v, status, _ = cache.GetOrFetch(context.Background(), "test", "k", settings, fetch)
+	if status != CacheHit || v.Value != 1000 || calls != 1 {
+		t.Fatalf("Expected fresh hit, got %s value=%d calls=%d", status, v.Value, calls)
+	}
+
+	now = now.Add(time.Hour)
+	v, status, _ = cache.GetOrFetch(context.Background(), "test", "k", settings, fetch)
+	if status != CacheStale || v.Value != 1000 {
+		t.Fatalf("Expected stale value 1000, got %s value=%d", status, v.Value)
+	}
+
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected background refresh")
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	v, status, _ = cache.GetOrFetch(context.Background(), "test", "k", settings, fetch)
+	if status != CacheHit || v.Value != 2000 {
+		t.Fatalf("Expected refreshed value 2000, got %s value=%d", status, v.Value)
+	}
+}
+
+func TestValuationSourceCache_Invalidate(t *testing.T) {
+	cache := NewValuationSourceCache(nil)
+	settings := ValuationMethodSettings{CacheTTL: time.Hour}
+	fetch := func(ctx context.Context) (*ValuationInput, error) {
+		return &ValuationInput{Value: 1}, nil
+	}
+
+	cache.GetOrFetch(context.Background(), "a", "a:1", settings, fetch)
+	cache.GetOrFetch(context.Background(), "b", "b:1", settings, fetch)
+
+	removed, err := cache.Invalidate(context.Background(), "a", "")
+	if err != nil || removed != 1 {
+		t.Fatalf("Expected 1 removed entry, got %d (%v)", removed, err)
+	}
+	if _, status, _ := cache.GetOrFetch(context.Background(), "a", "a:1", settings, fetch); status != CacheMiss {
+		t.Errorf("Expected miss after invalidation, got %s", status)
+	}
+	if _, status, _ := cache.GetOrFetch(context.Background(), "b", "b:1", settings, fetch); status != CacheHit {
+		t.Errorf("Expected other method to stay cached, got %s", status)
+	}
+}
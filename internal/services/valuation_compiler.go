@@ -0,0 +1,400 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+
+	"begbot/internal/config"
+)
+
+// CompileStrategy selects how the surviving valuation inputs are combined
+// into a single recommended price.
+type CompileStrategy string
+
+const (
+	CompileStrategyWeighted    CompileStrategy = "weighted"
+	CompileStrategyMedian      CompileStrategy = "median"
+	CompileStrategyTrimmedMean CompileStrategy = "trimmed_mean"
+	CompileStrategyLLM         CompileStrategy = "llm"
+
+	DefaultOutlierThreshold = 3.5
+	DefaultTrimFraction     = 0.2
+	MinValuationRatio       = 0.05
+
+	// madScale makes the median absolute deviation comparable to a standard
+	// deviation for normally distributed data.
+	madScale = 1.4826
+	// relativeScale is used instead of MAD when more than half of the inputs
+	// agree exactly and MAD is zero: one unit of deviation is then 10% of the
+	// median.
+	relativeScale = 0.1
+)
+
+// IsValidCompileStrategy reports whether s names a known strategy.
+func IsValidCompileStrategy(s string) bool {
+	switch CompileStrategy(s) {
+	case CompileStrategyWeighted, CompileStrategyMedian, CompileStrategyTrimmedMean, CompileStrategyLLM:
+		return true
+	}
+	return false
+}
+
+// DiscardedValuation records an input that was left out of the compiled
+// valuation and why.
+type DiscardedValuation struct {
+	Type   string `json:"type"`
+	Value  int    `json:"value"`
+	Reason string `json:"reason"`
+}
+
+// CompileOptions overrides the compiler defaults for a single compilation.
+type CompileOptions struct {
+	Strategy CompileStrategy
+	// NewPrice is the reference new price used for sanity bounds. When zero,
+	// the LLM new price input is used if present.
+	NewPrice float64
+}
+
+type ValuationCompiler struct {
+	cfg    *config.Config
+	llmSvc *LLMService
+}
+
+func NewValuationCompiler(cfg *config.Config, llmSvc *LLMService) *ValuationCompiler {
+	return &ValuationCompiler{
+		cfg:    cfg,
+		llmSvc: llmSvc,
+	}
+}
+
+func (c *ValuationCompiler) Compile(ctx context.Context, inputs []ValuationInput) (*ValuationOutput, error) {
+	return c.CompileWithOptions(ctx, inputs, CompileOptions{})
+}
+
+func (c *ValuationCompiler) CompileWithOptions(ctx context.Context, inputs []ValuationInput, opts CompileOptions) (*ValuationOutput, error) {
+	if len(inputs) == 0 {
+		return &ValuationOutput{
+			RecommendedPrice: 0,
+			Confidence:       0,
+			Reasoning:        "Inga värderingsmetoder tillgängliga",
+			IndividualVals:   []ValuationInput{},
+		}, nil
+	}
+
+	validInputs := make([]ValuationInput, 0, len(inputs))
+	for _, input := range inputs {
+		input.Confidence = normalizeConfidence(input.Confidence)
+		if input.Value > 0 && input.Confidence > 0 {
+			validInputs = append(validInputs, input)
+		}
+	}
+
+	if len(validInputs) == 0 {
+		return &ValuationOutput{
+			RecommendedPrice: 0,
+			Confidence:       0,
+			Reasoning:        "Inga giltiga värderingar tillgängliga",
+			IndividualVals:   inputs,
+		}, nil
+	}
+
+	newPrice := opts.NewPrice
+	if newPrice <= 0 {
+		newPrice = c.extractLLMNewPrice(validInputs)
+	}
+
+	var discarded []DiscardedValuation
+	validInputs, rejected := c.applyNewPriceBounds(validInputs, newPrice)
+	discarded = append(discarded, rejected...)
+	validInputs, rejected = c.rejectOutliers(validInputs)
+	discarded = append(discarded, rejected...)
+
+	if len(validInputs) == 0 {
+		return &ValuationOutput{
+			RecommendedPrice: 0,
+			Confidence:       0,
+			Reasoning:        "Alla värderingar förkastades" + formatDiscarded(discarded),
+			IndividualVals:   inputs,
+			Discarded:        discarded,
+		}, nil
+	}
+
+	strategy := c.resolveStrategy(opts.Strategy)
+
+	var output *ValuationOutput
+	var err error
+	switch strategy {
+	case CompileStrategyLLM:
+		if c.llmSvc != nil && len(validInputs) >= 2 {
+			output, err = c.compileWithLLM(ctx, validInputs, inputs)
+		} else {
+			strategy = CompileStrategyWeighted
+			output, err = c.compileWeightedAverage(validInputs, inputs)
+		}
+	case CompileStrategyMedian:
+		output, err = c.compileMedian(validInputs, inputs)
+	case CompileStrategyTrimmedMean:
+		output, err = c.compileTrimmedMean(validInputs, inputs)
+	default:
+		output, err = c.compileWeightedAverage(validInputs, inputs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	output.Strategy = string(strategy)
+	output.Discarded = discarded
+	output.Distribution = combineDistributions(validInputs)
+	output.Reasoning += formatDiscarded(discarded)
+	return output, nil
+}
+
+func (c *ValuationCompiler) resolveStrategy(s CompileStrategy) CompileStrategy {
+	if s == "" && c.cfg != nil {
+		s = CompileStrategy(c.cfg.Valuation.CompileStrategy)
+	}
+	if !IsValidCompileStrategy(string(s)) {
+		return CompileStrategyWeighted
+	}
+	return s
+}
+
+// applyNewPriceBounds discards inputs that are implausible relative to the
+// reference new price. The new price input itself is never discarded here.
+func (c *ValuationCompiler) applyNewPriceBounds(inputs []ValuationInput, newPrice float64) ([]ValuationInput, []DiscardedValuation) {
+	if newPrice <= 0 {
+		return inputs, nil
+	}
+
+	minRatio, maxRatio := MinValuationRatio, MaxValuationRatio
+	if c.cfg != nil {
+		if c.cfg.Valuation.MinNewPriceRatio > 0 {
+			minRatio = c.cfg.Valuation.MinNewPriceRatio
+		}
+		if c.cfg.Valuation.MaxNewPriceRatio > 0 {
+			maxRatio = c.cfg.Valuation.MaxNewPriceRatio
+		}
+	}
+
+	kept := make([]ValuationInput, 0, len(inputs))
+	var discarded []DiscardedValuation
+	for _, input := range inputs {
+		if input.Type == ValuationTypeLLMNewPrice {
+			kept = append(kept, input)
+			continue
+		}
+		ratio := float64(input.Value) / newPrice
+		switch {
+		case ratio > maxRatio:
+			log.Printf("WARNING: Valuation for '%s' is %.1fx above new price (value=%d, newPrice=%.0f), discarding",
+				input.Type, ratio, input.Value, newPrice)
+			discarded = append(discarded, DiscardedValuation{
+				Type:   input.Type,
+				Value:  input.Value,
+				Reason: fmt.Sprintf("%.1fx över nypris %.0f kr (max %.1fx)", ratio, newPrice, maxRatio),
+			})
+		case ratio < minRatio:
+			discarded = append(discarded, DiscardedValuation{
+				Type:   input.Type,
+				Value:  input.Value,
+				Reason: fmt.Sprintf("under %.0f%% av nypris %.0f kr", minRatio*100, newPrice),
+			})
+		default:
+			kept = append(kept, input)
+		}
+	}
+	return kept, discarded
+}
+
+// rejectOutliers discards inputs whose modified z-score, based on the median
+// absolute deviation, exceeds the configured threshold. At least three inputs
+// are required since a median of two values cannot single out an outlier.
+func (c *ValuationCompiler) rejectOutliers(inputs []ValuationInput) ([]ValuationInput, []DiscardedValuation) {
+	if len(inputs) < 3 {
+		return inputs, nil
+	}
+
+	threshold := DefaultOutlierThreshold
+	if c.cfg != nil && c.cfg.Valuation.OutlierThreshold > 0 {
+		threshold = c.cfg.Valuation.OutlierThreshold
+	}
+
+	values := make([]float64, len(inputs))
+	for i, input := range inputs {
+		values[i] = float64(input.Value)
+	}
+	med := median(values)
+
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = math.Abs(v - med)
+	}
+
+	scale := madScale * median(deviations)
+	if scale == 0 {
+		scale = relativeScale * med
+	}
+	if scale == 0 {
+		return inputs, nil
+	}
+
+	kept := make([]ValuationInput, 0, len(inputs))
+	var discarded []DiscardedValuation
+	for i, input := range inputs {
+		score := deviations[i] / scale
+		if score > threshold {
+			discarded = append(discarded, DiscardedValuation{
+				Type:   input.Type,
+				Value:  input.Value,
+				Reason: fmt.Sprintf("avviker från medianen %.0f kr (avvikelsepoäng %.1f > %.1f)", med, score, threshold),
+			})
+			continue
+		}
+		kept = append(kept, input)
+	}
+	return kept, discarded
+}
+
+func (c *ValuationCompiler) compileWithLLM(ctx context.Context, validInputs []ValuationInput, allInputs []ValuationInput) (*ValuationOutput, error) {
+	result, err := c.llmSvc.CompileValuations(ctx, validInputs, "")
+	if err != nil {
+		log.Printf("LLM compilation failed, falling back to weighted average: %v", err)
+		return c.compileWeightedAverage(validInputs, allInputs)
+	}
+
+	result.IndividualVals = allInputs
+	return result, nil
+}
+
+func (c *ValuationCompiler) compileWeightedAverage(inputs []ValuationInput, allInputs []ValuationInput) (*ValuationOutput, error) {
+	var sumPrice, sumConfidence, totalWeight float64
+
+	for _, input := range inputs {
+		weight := normalizeConfidence(input.Confidence)
+		sumPrice += float64(input.Value) * weight
+		sumConfidence += weight * weight
+		totalWeight += weight
+	}
+
+	if totalWeight == 0 {
+		return &ValuationOutput{
+			RecommendedPrice: 0,
+			Confidence:       0,
+			Reasoning:        "Kan inte beräkna viktat genomsnitt",
+			IndividualVals:   allInputs,
+		}, nil
+	}
+
+	return &ValuationOutput{
+		RecommendedPrice: sumPrice / totalWeight,
+		Confidence:       sumConfidence / totalWeight,
+		Reasoning:        fmt.Sprintf("Viktat genomsnitt baserat på %d metoder", len(inputs)),
+		IndividualVals:   allInputs,
+	}, nil
+}
+
+func (c *ValuationCompiler) compileMedian(inputs []ValuationInput, allInputs []ValuationInput) (*ValuationOutput, error) {
+	values := make([]float64, len(inputs))
+	for i, input := range inputs {
+		values[i] = float64(input.Value)
+	}
+
+	return &ValuationOutput{
+		RecommendedPrice: median(values),
+		Confidence:       meanConfidence(inputs),
+		Reasoning:        fmt.Sprintf("Median baserad på %d metoder", len(inputs)),
+		IndividualVals:   allInputs,
+	}, nil
+}
+
+// compileTrimmedMean drops the configured fraction of the lowest and highest
+// values before averaging. With fewer than three inputs nothing is trimmed.
+func (c *ValuationCompiler) compileTrimmedMean(inputs []ValuationInput, allInputs []ValuationInput) (*ValuationOutput, error) {
+	fraction := DefaultTrimFraction
+	if c.cfg != nil && c.cfg.Valuation.TrimFraction > 0 && c.cfg.Valuation.TrimFraction < 0.5 {
+		fraction = c.cfg.Valuation.TrimFraction
+	}
+
+	sorted := make([]ValuationInput, len(inputs))
+	copy(sorted, inputs)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Value < sorted[j].Value })
+
+	trim := int(math.Floor(float64(len(sorted)) * fraction))
+	if len(sorted) < 3 {
+		trim = 0
+	}
+	kept := sorted[trim : len(sorted)-trim]
+
+	var sum float64
+	for _, input := range kept {
+		sum += float64(input.Value)
+	}
+
+	return &ValuationOutput{
+		RecommendedPrice: sum / float64(len(kept)),
+		Confidence:       meanConfidence(kept),
+		Reasoning:        fmt.Sprintf("Trimmat medelvärde av %d metoder (%d lägsta och %d högsta borttagna)", len(kept), trim, trim),
+		IndividualVals:   allInputs,
+	}, nil
+}
+
+func (c *ValuationCompiler) extractLLMNewPrice(inputs []ValuationInput) float64 {
+	for _, input := range inputs {
+		if input.Type == ValuationTypeLLMNewPrice && input.Value > 0 {
+			return float64(input.Value)
+		}
+	}
+	return 0
+}
+
+// normalizeConfidence clamps a confidence to 0-1. Methods that are given a
+// confidence on another scale convert it themselves, see percentConfidence.
+func normalizeConfidence(c float64) float64 {
+	return math.Max(0, math.Min(c, 1))
+}
+
+// percentConfidence converts a confidence from 0 to 100, as the LLM prompts
+// ask for, to 0-1.
+func percentConfidence(c float64) float64 {
+	return normalizeConfidence(c / 100)
+}
+
+func meanConfidence(inputs []ValuationInput) float64 {
+	if len(inputs) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, input := range inputs {
+		sum += normalizeConfidence(input.Confidence)
+	}
+	return sum / float64(len(inputs))
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+func formatDiscarded(discarded []DiscardedValuation) string {
+	if len(discarded) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(discarded))
+	for _, d := range discarded {
+		parts = append(parts, fmt.Sprintf("%s (%d kr): %s", d.Type, d.Value, d.Reason))
+	}
+	return ". Förkastade: " + strings.Join(parts, "; ")
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"begbot/internal/config"
+)
+
+func TestNormalizeConfidence(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want float64
+	}{
+		{0.75, 0.75},
+		{1, 1},
+		{1.5, 1},
+		{-0.2, 0},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeConfidence(tt.in); got != tt.want {
+			t.Errorf("normalizeConfidence(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	if got := percentConfidence(75); got != 0.75 {
+		t.Errorf("percentConfidence(75) = %v, want 0.75", got)
+	}
+	if got := percentConfidence(150); got != 1 {
+		t.Errorf("percentConfidence(150) = %v, want 1", got)
+	}
+}
+
+func TestValuationCompiler_Compile_ClampsConfidence(t *testing.T) {
+	compiler := &ValuationCompiler{}
+
+	inputs := []ValuationInput{
+		{Type: ValuationTypeLLMNewPrice, Value: 2000, Confidence: 1.5},
+		{Type: ValuationTypeTradera, Value: 1000, Confidence: 1},
+	}
+
+	result, err := compiler.Compile(context.Background(), inputs)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	if math.Abs(result.RecommendedPrice-1500) > 0.001 {
+		t.Errorf("Expected equal weights to give 1500, got %f", result.RecommendedPrice)
+	}
+	if math.Abs(result.Confidence-1) > 0.001 {
+		t.Errorf("Expected confidence 1, got %f", result.Confidence)
+	}
+}
+
+func TestValuationCompiler_Compile_RejectsMADOutlier(t *testing.T) {
+	compiler := &ValuationCompiler{}
+
+	inputs := []ValuationInput{
+		{Type: ValuationTypeDatabase, Value: 150000, Confidence: 0.7},
+		{Type: ValuationTypeTradera, Value: 1500, Confidence: 0.7},
+		{Type: ValuationTypeMarketplace, Value: 1400, Confidence: 0.7},
+		{Type: "Extra", Value: 1600, Confidence: 0.7},
+	}
+
+	result, err := compiler.Compile(context.Background(), inputs)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	if len(result.Discarded) != 1 || result.Discarded[0].Type != ValuationTypeDatabase {
+		t.Fatalf("Expected database valuation to be discarded, got %+v", result.Discarded)
+	}
+	if math.Abs(result.RecommendedPrice-1500) > 0.001 {
+		t.Errorf("Expected 1500 after outlier rejection, got %f", result.RecommendedPrice)
+	}
+	if !strings.Contains(result.Reasoning, "Förkastade") || !strings.Contains(result.Reasoning, ValuationTypeDatabase) {
+		t.Errorf("Expected reasoning to mention discarded input, got %q", result.Reasoning)
+	}
+	if len(result.IndividualVals) != len(inputs) {
+		t.Errorf("Expected all inputs to be kept in IndividualVals, got %d", len(result.IndividualVals))
+	}
+}
+
+func TestValuationCompiler_Compile_MADZeroFallsBackToRelativeScale(t *testing.T) {
+	compiler := &ValuationCompiler{}
+
+	inputs := []ValuationInput{
+		{Type: "A", Value: 1000, Confidence: 0.5},
+		{Type: "B", Value: 1000, Confidence: 0.5},
+		{Type: "C", Value: 50000, Confidence: 0.5},
+	}
+
+	result, err := compiler.Compile(context.Background(), inputs)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	if len(result.Discarded) != 1 || result.Discarded[0].Type != "C" {
+		t.Fatalf("Expected C to be discarded, got %+v", result.Discarded)
+	}
+	if math.Abs(result.RecommendedPrice-1000) > 0.001 {
+		t.Errorf("Expected 1000, got %f", result.RecommendedPrice)
+	}
+}
+
+func TestValuationCompiler_Compile_NewPriceBounds(t *testing.T) {
+	compiler := &ValuationCompiler{}
+
+	inputs := []ValuationInput{
+		{Type: ValuationTypeLLMNewPrice, Value: 2000, Confidence: 0.8},
+		{Type: ValuationTypeDatabase, Value: 25000, Confidence: 0.7},
+	}
+
+	result, err := compiler.Compile(context.Background(), inputs)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	if len(result.Discarded) != 1 || result.Discarded[0].Type != ValuationTypeDatabase {
+		t.Fatalf("Expected value above %.0fx new price to be discarded, got %+v", MaxValuationRatio, result.Discarded)
+	}
+	if math.Abs(result.RecommendedPrice-2000) > 0.001 {
+		t.Errorf("Expected 2000, got %f", result.RecommendedPrice)
+	}
+}
+
+func TestValuationCompiler_CompileWithOptions_CatalogNewPrice(t *testing.T) {
+	cfg := &config.Config{Valuation: config.ValuationConfig{MaxNewPriceRatio: 1.2}}
+	compiler := NewValuationCompiler(cfg, nil)
+
+	inputs := []ValuationInput{
+		{Type: ValuationTypeTradera, Value: 1800, Confidence: 0.7},
+		{Type: ValuationTypeDatabase, Value: 2600, Confidence: 0.7},
+	}
+
+	result, err := compiler.CompileWithOptions(context.Background(), inputs, CompileOptions{NewPrice: 2000})
+	if err != nil {
+		t.Fatalf("CompileWithOptions failed: %v", err)
+	}
+
+	if len(result.Discarded) != 1 || result.Discarded[0].Value != 2600 {
+		t.Fatalf("Expected 2600 to be discarded with max ratio 1.2, got %+v", result.Discarded)
+	}
+	if math.Abs(result.RecommendedPrice-1800) > 0.001 {
+		t.Errorf("Expected 1800, got %f", result.RecommendedPrice)
+	}
+}
+
+func TestValuationCompiler_Compile_AllDiscarded(t *testing.T) {
+	compiler := &ValuationCompiler{}
+
+	inputs := []ValuationInput{
+		{Type: ValuationTypeTradera, Value: 50, Confidence: 0.7},
+	}
+
+	result, err := compiler.CompileWithOptions(context.Background(), inputs, CompileOptions{NewPrice: 10000})
+	if err != nil {
+		t.Fatalf("CompileWithOptions failed: %v", err)
+	}
+
+	if result.RecommendedPrice != 0 {
+		t.Errorf("Expected 0 when every input is discarded, got %f", result.RecommendedPrice)
+	}
+	if len(result.Discarded) != 1 {
+		t.Errorf("Expected 1 discarded input, got %d", len(result.Discarded))
+	}
+}
+
+func TestValuationCompiler_Strategies(t *testing.T) {
+	inputs := []ValuationInput{
+		{Type: "A", Value: 1000, Confidence: 0.9},
+		{Type: "B", Value: 1100, Confidence: 0.5},
+		{Type: "C", Value: 1200, Confidence: 0.5},
+		{Type: "D", Value: 1300, Confidence: 0.5},
+		{Type: "E", Value: 1500, Confidence: 0.5},
+	}
+
+	tests := []struct {
+		strategy CompileStrategy
+		want     float64
+	}{
+		{CompileStrategyMedian, 1200},
+		{CompileStrategyTrimmedMean, 1200},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			compiler := &ValuationCompiler{}
+			result, err := compiler.CompileWithOptions(context.Background(), inputs, CompileOptions{Strategy: tt.strategy})
+			if err != nil {
+				t.Fatalf("CompileWithOptions failed: %v", err)
+			}
+			if math.Abs(result.RecommendedPrice-tt.want) > 0.001 {
+				t.Errorf("Expected %f, got %f", tt.want, result.RecommendedPrice)
+			}
+			if result.Strategy != string(tt.strategy) {
+				t.Errorf("Expected strategy %s, got %s", tt.strategy, result.Strategy)
+			}
+		})
+	}
+}
+
+func TestValuationCompiler_ResolveStrategy(t *testing.T) {
+	cfg := &config.Config{Valuation: config.ValuationConfig{CompileStrategy: "median"}}
+	compiler := NewValuationCompiler(cfg, nil)
+
+	if got := compiler.resolveStrategy(""); got != CompileStrategyMedian {
+		t.Errorf("Expected config default median, got %s", got)
+	}
+	if got := compiler.resolveStrategy(CompileStrategyTrimmedMean); got != CompileStrategyTrimmedMean {
+		t.Errorf("Expected explicit trimmed_mean, got %s", got)
+	}
+	if got := compiler.resolveStrategy("bogus"); got != CompileStrategyWeighted {
+		t.Errorf("Expected unknown strategy to fall back to weighted, got %s", got)
+	}
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"begbot/internal/models"
+)
+
+// PriceDistribution describes the spread of observed prices behind a
+// valuation. Percentiles that a source cannot provide are left at zero and
+// are interpolated from the known points by Percentile.
+type PriceDistribution struct {
+	Min        float64 `json:"min,omitempty"`
+	P10        float64 `json:"p10,omitempty"`
+	P25        float64 `json:"p25,omitempty"`
+	P50        float64 `json:"p50,omitempty"`
+	P75        float64 `json:"p75,omitempty"`
+	P90        float64 `json:"p90,omitempty"`
+	Max        float64 `json:"max,omitempty"`
+	SampleSize int     `json:"sample_size,omitempty"`
+}
+
+type percentilePoint struct {
+	p float64
+	v float64
+}
+
+func (d *PriceDistribution) points() []percentilePoint {
+	all := []percentilePoint{
+		{0, d.Min}, {10, d.P10}, {25, d.P25}, {50, d.P50}, {75, d.P75}, {90, d.P90}, {100, d.Max},
+	}
+	known := make([]percentilePoint, 0, len(all))
+	for _, pt := range all {
+		if pt.v > 0 {
+			known = append(known, pt)
+		}
+	}
+	return known
+}
+
+// Percentile returns the price at percentile p (0-100), interpolating
+// linearly between the known points. Outside the known range the nearest
+// known value is returned. Zero is returned when nothing is known.
+func (d *PriceDistribution) Percentile(p float64) float64 {
+	if d == nil {
+		return 0
+	}
+	known := d.points()
+	if len(known) == 0 {
+		return 0
+	}
+	if p <= known[0].p {
+		return known[0].v
+	}
+	for i := 1; i < len(known); i++ {
+		if p <= known[i].p {
+			lo, hi := known[i-1], known[i]
+			return lo.v + (hi.v-lo.v)*(p-lo.p)/(hi.p-lo.p)
+		}
+	}
+	return known[len(known)-1].v
+}
+
+// Spread returns (p90-p10)/p50, a unitless measure of how wide the market is.
+func (d *PriceDistribution) Spread() float64 {
+	mid := d.Percentile(50)
+	if mid == 0 {
+		return 0
+	}
+	return (d.Percentile(90) - d.Percentile(10)) / mid
+}
+
+// NewPriceDistribution computes the distribution of a set of observed prices.
+func NewPriceDistribution(prices []float64) *PriceDistribution {
+	if len(prices) == 0 {
+		return nil
+	}
+	sorted := make([]float64, len(prices))
+	copy(sorted, prices)
+	sort.Float64s(sorted)
+
+	return &PriceDistribution{
+		Min:        sorted[0],
+		P10:        quantile(sorted, 0.10),
+		P25:        quantile(sorted, 0.25),
+		P50:        quantile(sorted, 0.50),
+		P75:        quantile(sorted, 0.75),
+		P90:        quantile(sorted, 0.90),
+		Max:        sorted[len(sorted)-1],
+		SampleSize: len(sorted),
+	}
+}
+
+// quantile returns the q-quantile of already sorted values using linear
+// interpolation between closest ranks.
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	pos := q * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+// combineDistributions merges the distributions of the given inputs into one
+// by averaging each percentile weighted by input confidence. Inputs without a
+// distribution are ignored; nil is returned if none has one.
+func combineDistributions(inputs []ValuationInput) *PriceDistribution {
+	var combined PriceDistribution
+	var totalWeight float64
+	for _, input := range inputs {
+		if input.Distribution == nil {
+			continue
+		}
+		w := normalizeConfidence(input.Confidence)
+		if w == 0 {
+			continue
+		}
+		d := input.Distribution
+		combined.Min += d.Percentile(0) * w
+		combined.P10 += d.Percentile(10) * w
+		combined.P25 += d.Percentile(25) * w
+		combined.P50 += d.Percentile(50) * w
+		combined.P75 += d.Percentile(75) * w
+		combined.P90 += d.Percentile(90) * w
+		combined.Max += d.Percentile(100) * w
+		combined.SampleSize += d.SampleSize
+		totalWeight += w
+	}
+	if totalWeight == 0 {
+		return nil
+	}
+	combined.Min /= totalWeight
+	combined.P10 /= totalWeight
+	combined.P25 /= totalWeight
+	combined.P50 /= totalWeight
+	combined.P75 /= totalWeight
+	combined.P90 /= totalWeight
+	combined.Max /= totalWeight
+	return &combined
+}
+
+// CheckPercentileProfit applies the percentile rule of the trading rules:
+// selling at the price at ProfitPercentile must still leave a net profit of
+// MinPercentileProfitSEK on the buy described by in. Rules without a percentile
+// requirement always pass. Without a distribution the rule cannot be
+// verified and fails.
+func CheckPercentileProfit(rules *models.Economics, dist *PriceDistribution, costs models.CostModel, in models.ProfitInput) (bool, string) {
+	if rules == nil || rules.ProfitPercentile == nil || *rules.ProfitPercentile <= 0 {
+		return true, ""
+	}
+	minProfit := 0
+	if rules.MinPercentileProfitSEK != nil {
+		minProfit = *rules.MinPercentileProfitSEK
+	}
+	p := *rules.ProfitPercentile
+	if dist == nil || len(dist.points()) == 0 {
+		return false, fmt.Sprintf("p%d saknas: ingen prisfördelning tillgänglig", p)
+	}
+	sellPrice := dist.Percentile(float64(p))
+	in.SellPrice = int(math.Round(sellPrice))
+	profit := costs.Calculate(in).NetProfit
+	if profit < minProfit {
+		return false, fmt.Sprintf("vinst vid p%d (%.0f kr) är %d kr, kräver %d kr", p, sellPrice, profit, minProfit)
+	}
+	return true, fmt.Sprintf("vinst vid p%d (%.0f kr) är %d kr", p, sellPrice, profit)
+}
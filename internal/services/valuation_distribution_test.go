@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"begbot/internal/models"
+)
+
+func TestNewPriceDistribution(t *testing.T) {
+	dist := NewPriceDistribution([]float64{500, 100, 400, 200, 300})
+
+	if dist.Min != 100 || dist.Max != 500 {
+		t.Errorf("Expected min 100 and max 500, got %f and %f", dist.Min, dist.Max)
+	}
+	if dist.P50 != 300 {
+		t.Errorf("Expected p50 300, got %f", dist.P50)
+	}
+	if dist.P25 != 200 {
+		t.Errorf("Expected p25 200, got %f", dist.P25)
+	}
+	if dist.SampleSize != 5 {
+		t.Errorf("Expected sample size 5, got %d", dist.SampleSize)
+	}
+
+	if NewPriceDistribution(nil) != nil {
+		t.Error("Expected nil distribution for no prices")
+	}
+}
+
+func TestPriceDistribution_PercentileInterpolatesSparsePoints(t *testing.T) {
+	// Tradera only exposes lowest, median and highest
+	dist := &PriceDistribution{Min: 1000, P50: 2000, Max: 4000, SampleSize: 30}
+
+	tests := []struct {
+		p    float64
+		want float64
+	}{
+		{0, 1000},
+		{25, 1500},
+		{50, 2000},
+		{75, 3000},
+		{100, 4000},
+	}
+	for _, tt := range tests {
+		if got := dist.Percentile(tt.p); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("Percentile(%v) = %f, want %f", tt.p, got, tt.want)
+		}
+	}
+
+	var empty *PriceDistribution
+	if empty.Percentile(50) != 0 {
+		t.Error("Expected 0 for nil distribution")
+	}
+}
+
+func TestValuationCompiler_Compile_PropagatesDistribution(t *testing.T) {
+	compiler := &ValuationCompiler{}
+
+	inputs := []ValuationInput{
+		{Type: ValuationTypeTradera, Value: 2000, Confidence: 0.5, Distribution: &PriceDistribution{Min: 1000, P50: 2000, Max: 3000, SampleSize: 20}},
+		{Type: ValuationTypeDatabase, Value: 2200, Confidence: 0.5, Distribution: &PriceDistribution{Min: 1400, P50: 2200, Max: 3400, SampleSize: 5}},
+		{Type: ValuationTypeLLMNewPrice, Value: 4000, Confidence: 0.5},
+	}
+
+	result, err := compiler.Compile(context.Background(), inputs)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	if result.Distribution == nil {
+		t.Fatal("Expected compiled distribution")
+	}
+	if result.Distribution.SampleSize != 25 {
+		t.Errorf("Expected combined sample size 25, got %d", result.Distribution.SampleSize)
+	}
+	if math.Abs(result.Distribution.P50-2100) > 0.001 {
+		t.Errorf("Expected combined p50 2100, got %f", result.Distribution.P50)
+	}
+}
+
+func TestCheckPercentileProfit(t *testing.T) {
+	dist := &PriceDistribution{Min: 1000, P50: 2000, Max: 3000}
+
+	tests := []struct {
+		name  string
+		rules *models.Economics
+		dist  *PriceDistribution
+		cost  int
+		want  bool
+	}{
+		{"no percentile rule", &models.Economics{}, nil, 1000, true},
+		{"p25 profitable", &models.Economics{ProfitPercentile: ptr(25), MinPercentileProfitSEK: ptr(300)}, dist, 1000, true},
+		{"p25 not profitable enough", &models.Economics{ProfitPercentile: ptr(25), MinPercentileProfitSEK: ptr(600)}, dist, 1000, false},
+		{"missing distribution", &models.Economics{ProfitPercentile: ptr(25)}, nil, 1000, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := CheckPercentileProfit(tt.rules, tt.dist, models.CostModel{}, models.ProfitInput{BuyPrice: tt.cost})
+			if got != tt.want {
+				t.Errorf("CheckPercentileProfit() = %v (%s), want %v", got, reason, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"begbot/internal/models"
+)
+
+// Registry keys for the built-in valuation methods. They match the key
+// column of valuation_types.
+const (
+	ValuationKeyDatabase    = "database"
+	ValuationKeyTradera     = "tradera"
+	ValuationKeyMarketplace = "marketplace"
+	ValuationKeyLLMNewPrice = "llm_new_price"
+	ValuationKeySupply      = "supply"
+)
+
+// ValuationMethodSettings is the effective configuration of a registered
+// valuation method after merging config.yaml with its valuation_types row.
+type ValuationMethodSettings struct {
+	TypeID   int16
+	Enabled  bool
+	Timeout  time.Duration
+	CacheTTL time.Duration
+	StaleTTL time.Duration
+	Params   map[string]interface{}
+}
+
+// Param returns a method parameter, or nil if it is not set.
+func (m ValuationMethodSettings) Param(name string) interface{} {
+	if m.Params == nil {
+		return nil
+	}
+	return m.Params[name]
+}
+
+// valuationTypeConfig is the JSON stored in valuation_types.config.
+type valuationTypeConfig struct {
+	Timeout  string                 `json:"timeout,omitempty"`
+	CacheTTL string                 `json:"cache_ttl,omitempty"`
+	StaleTTL string                 `json:"stale_ttl,omitempty"`
+	Params   map[string]interface{} `json:"params,omitempty"`
+}
+
+// ParseValuationTypeConfig parses the config JSON of a valuation type. Only
+// the fields present in the JSON are set on the returned settings.
+func ParseValuationTypeConfig(raw json.RawMessage) (ValuationMethodSettings, error) {
+	var settings ValuationMethodSettings
+	if len(raw) == 0 {
+		return settings, nil
+	}
+	var c valuationTypeConfig
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return settings, err
+	}
+	durations := []struct {
+		value string
+		dst   *time.Duration
+	}{
+		{c.Timeout, &settings.Timeout},
+		{c.CacheTTL, &settings.CacheTTL},
+		{c.StaleTTL, &settings.StaleTTL},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return settings, err
+		}
+		*d.dst = parsed
+	}
+	settings.Params = c.Params
+	return settings, nil
+}
+
+// defaultMethodSettings returns the settings for a method from config.yaml.
+func (s *ValuationService) defaultMethodSettings(key string) ValuationMethodSettings {
+	settings := ValuationMethodSettings{Enabled: true}
+	if s.cfg == nil {
+		return settings
+	}
+	mc, ok := s.cfg.Valuation.Methods[key]
+	if !ok {
+		return settings
+	}
+	if mc.Enabled != nil {
+		settings.Enabled = *mc.Enabled
+	}
+	settings.Timeout = mc.Timeout
+	settings.CacheTTL = mc.CacheTTL
+	settings.StaleTTL = mc.StaleTTL
+	settings.Params = mc.Params
+	return settings
+}
+
+// mergeValuationType applies a valuation_types row on top of the defaults.
+// A method is only enabled if both config.yaml and the row allow it.
+func mergeValuationType(settings ValuationMethodSettings, vt *models.ValuationType) ValuationMethodSettings {
+	settings.TypeID = vt.ID
+	settings.Enabled = settings.Enabled && vt.Enabled
+
+	override, err := ParseValuationTypeConfig(vt.Config)
+	if err != nil {
+		log.Printf("Invalid config for valuation type %d: %v", vt.ID, err)
+		return settings
+	}
+	if override.Timeout > 0 {
+		settings.Timeout = override.Timeout
+	}
+	if override.CacheTTL > 0 {
+		settings.CacheTTL = override.CacheTTL
+	}
+	if override.StaleTTL > 0 {
+		settings.StaleTTL = override.StaleTTL
+	}
+	if params := override.Params; len(params) > 0 {
+		merged := make(map[string]interface{}, len(settings.Params)+len(params))
+		for k, v := range settings.Params {
+			merged[k] = v
+		}
+		for k, v := range params {
+			merged[k] = v
+		}
+		settings.Params = merged
+	}
+	return settings
+}
+
+// loadRegistry registers every method against valuation_types and caches
+// the resulting settings. Without a database only config.yaml is used. A
+// failed load is retried on the next call.
+func (s *ValuationService) loadRegistry(ctx context.Context) {
+	s.registryMu.Lock()
+	defer s.registryMu.Unlock()
+	if s.registryLoaded {
+		return
+	}
+
+	settings := make(map[string]ValuationMethodSettings, len(s.methods))
+	loaded := true
+	for _, m := range s.methods {
+		ms := s.defaultMethodSettings(m.Key())
+		if s.database != nil {
+			vt, err := s.database.RegisterValuationType(ctx, m.Key(), m.Name())
+			if err != nil {
+				log.Printf("Failed to register valuation type %s: %v", m.Key(), err)
+				loaded = false
+			} else {
+				ms = mergeValuationType(ms, vt)
+			}
+		}
+		settings[m.Key()] = ms
+	}
+
+	s.registry = settings
+	s.registryLoaded = loaded
+}
+
+// ReloadRegistry drops the cached method settings so that changes to
+// valuation_types are picked up on the next valuation.
+func (s *ValuationService) ReloadRegistry() {
+	s.registryMu.Lock()
+	s.registryLoaded = false
+	s.registryMu.Unlock()
+}
+
+// MethodSettings returns the effective settings for the method with the
+// given registry key.
+func (s *ValuationService) MethodSettings(key string) ValuationMethodSettings {
+	s.registryMu.RLock()
+	ms, ok := s.registry[key]
+	s.registryMu.RUnlock()
+	if !ok {
+		return s.defaultMethodSettings(key)
+	}
+	return ms
+}
+
+// getValuationTypeID returns the valuation_types ID for an input type, or 0
+// if no registered method produces it.
+func (s *ValuationService) getValuationTypeID(typeName string) int16 {
+	for _, m := range s.methods {
+		if m.Name() == typeName {
+			return s.MethodSettings(m.Key()).TypeID
+		}
+	}
+	return 0
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"begbot/internal/config"
+	"begbot/internal/models"
+)
+
+type stubValuationMethod struct {
+	key   string
+	value int
+	calls int
+}
+
+func (m *stubValuationMethod) Key() string   { return m.key }
+func (m *stubValuationMethod) Name() string  { return "Stub " + m.key }
+func (m *stubValuationMethod) Priority() int { return 1 }
+
+func (m *stubValuationMethod) Valuate(ctx context.Context, productInfo ProductInfo) (*ValuationInput, error) {
+	m.calls++
+	return &ValuationInput{Type: m.Name(), Value: m.value, Confidence: 0.5}, nil
+}
+
+func TestValuationService_DisabledMethodIsSkipped(t *testing.T) {
+	disabled := false
+	cfg := &config.Config{Valuation: config.ValuationConfig{
+		Methods: map[string]config.ValuationMethodConfig{"off": {Enabled: &disabled}},
+	}}
+	svc := &ValuationService{cfg: cfg}
+	on := &stubValuationMethod{key: "on", value: 100}
+	off := &stubValuationMethod{key: "off", value: 200}
+	svc.RegisterMethod(on)
+	svc.RegisterMethod(off)
+
+	inputs, _ := svc.CollectAllWithErrors(context.Background(), ProductInfo{})
+
+	if len(inputs) != 1 || inputs[0].Value != 100 {
+		t.Fatalf("Expected only the enabled method to run, got %+v", inputs)
+	}
+	if off.calls != 0 {
+		t.Errorf("Disabled method was called %d times", off.calls)
+	}
+}
+
+func TestMergeValuationType(t *testing.T) {
+	key := "tradera"
+	defaults := ValuationMethodSettings{
+		Enabled:  true,
+		Timeout:  10 * time.Second,
+		CacheTTL: time.Minute,
+		Params:   map[string]interface{}{"a": 1},
+	}
+	vt := &models.ValuationType{
+		ID:      7,
+		Key:     &key,
+		Enabled: true,
+		Config:  json.RawMessage(`{"cache_ttl":"1h","params":{"b":2}}`),
+	}
+
+	got := mergeValuationType(defaults, vt)
+
+	if got.TypeID != 7 || !got.Enabled {
+		t.Errorf("Unexpected type ID or enabled flag: %+v", got)
+	}
+	if got.Timeout != 10*time.Second {
+		t.Errorf("Expected config timeout to be kept, got %v", got.Timeout)
+	}
+	if got.CacheTTL != time.Hour {
+		t.Errorf("Expected DB cache TTL to win, got %v", got.CacheTTL)
+	}
+	if got.Param("a") == nil || got.Param("b") == nil {
+		t.Errorf("Expected params to be merged, got %+v", got.Params)
+	}
+
+	vt.Enabled = false
+	if mergeValuationType(defaults, vt).Enabled {
+		t.Error("Expected method disabled in DB to be disabled")
+	}
+}
+
+func TestParseValuationTypeConfig_InvalidDuration(t *testing.T) {
+	if _, err := ParseValuationTypeConfig(json.RawMessage(`{"timeout":"soon"}`)); err == nil {
+		t.Error("Expected error for invalid timeout")
+	}
+}